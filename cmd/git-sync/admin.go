@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"k8s.io/git-sync/pkg/gitsync"
+)
+
+// serveAdmin serves the admin API for manager on --admin-addr, and over
+// gRPC on --admin-grpc-addr, with TLS if --admin-tls-cert is set, requiring
+// client certificates if --admin-client-ca is set.  Repos added through it
+// default to the flags.
+func serveAdmin(manager *gitsync.Manager) error {
+	config, err := adminTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	if adminAddr != "" {
+		server := &http.Server{
+			Handler: gitsync.AdminHandler(manager, cliOpts, adminToken),
+		}
+		ln, err := net.Listen("tcp", adminAddr)
+		if err != nil {
+			return fmt.Errorf("can't listen on %s: %v", adminAddr, err)
+		}
+		if config != nil {
+			ln = tls.NewListener(ln, config)
+		}
+		go func() {
+			log.Errorf("admin API failed: %v", server.Serve(ln))
+			os.Exit(1)
+		}()
+		log.V(0).Infof("serving the admin API on %s", ln.Addr())
+	}
+
+	if adminGRPCAddr != "" {
+		opts := []grpc.ServerOption{}
+		if config != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+		}
+		server := gitsync.NewGRPCAdminServer(manager, cliOpts, adminToken, opts...)
+		ln, err := net.Listen("tcp", adminGRPCAddr)
+		if err != nil {
+			return fmt.Errorf("can't listen on %s: %v", adminGRPCAddr, err)
+		}
+		go func() {
+			log.Errorf("gRPC admin API failed: %v", server.Serve(ln))
+			os.Exit(1)
+		}()
+		log.V(0).Infof("serving the gRPC admin API on %s", ln.Addr())
+	}
+	return nil
+}
+
+// adminTLSConfig returns the TLS config of the admin API, or nil if
+// --admin-tls-cert isn't set.
+func adminTLSConfig() (*tls.Config, error) {
+	if adminTLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(adminTLSCert, adminTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading admin TLS certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if adminClientCA != "" {
+		pem, err := ioutil.ReadFile(adminClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading admin client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", adminClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// newRootCommand returns the git-sync command and its subcommands.  Without
+// a subcommand, git-sync runs "run", so that existing command lines still
+// work.
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "git-sync",
+		Short: "Sync a git repo to a local directory",
+		Long: "git-sync clones a git repo and keeps it in sync with the remote, for\n" +
+			"sharing it with other containers (see README).  Without a command, it\n" +
+			"runs \"run\".",
+		Run: func(cmd *cobra.Command, args []string) { runSync(cmd, false) },
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// glog's flags are set through pflag, but glog complains
+			// unless the standard flags have been parsed.
+			flag.CommandLine.Parse(nil)
+		},
+	}
+	root.Flags().AddFlagSet(syncFlags)
+
+	run := &cobra.Command{
+		Use:   "run",
+		Short: "Sync until stopped (the default)",
+		Args:  cobra.NoArgs,
+		Run:   func(cmd *cobra.Command, args []string) { runSync(cmd, false) },
+	}
+	once := &cobra.Command{
+		Use:   "once",
+		Short: "Sync once and exit, like --one-time",
+		Args:  cobra.NoArgs,
+		Run:   func(cmd *cobra.Command, args []string) { runSync(cmd, true) },
+	}
+	verify := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the flags, credentials, remote and rev without syncing",
+		Args:  cobra.NoArgs,
+		Run:   func(cmd *cobra.Command, args []string) { runVerify(cmd) },
+	}
+	for _, c := range []*cobra.Command{run, once, verify} {
+		c.Flags().AddFlagSet(syncFlags)
+	}
+	root.AddCommand(run, once, verify, newWaitCommand(), newStatusCommand())
+	return root
+}
+
+// longFlags returns args with the long flags of root's commands given with
+// one dash, as the standard flag package allowed, e.g. -repo, given with
+// two.
+func longFlags(root *cobra.Command, args []string) []string {
+	commands := append([]*cobra.Command{root}, root.Commands()...)
+	long := []string{}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			name := strings.SplitN(arg[1:], "=", 2)[0]
+			for _, c := range commands {
+				if len(name) > 1 && c.Flags().Lookup(name) != nil {
+					arg = "-" + arg
+					break
+				}
+			}
+		}
+		long = append(long, arg)
+	}
+	return append(long, args[len(long):]...)
+}
+
+// deprecatedEnvs maps environment variables that have been renamed to their
+// new names, which take precedence.
+var deprecatedEnvs = map[string]string{
+	"GIT_SYNC_PERMISSIONS": "GIT_SYNC_CHANGE_PERMISSIONS",
+}
+
+// warnDeprecated prints a notice for each deprecated setting in use.
+func warnDeprecated() {
+	for old, replacement := range deprecatedEnvs {
+		if os.Getenv(old) != "" {
+			fmt.Fprintf(os.Stderr, "WARNING: $%s is deprecated, use $%s\n", old, replacement)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCommand(t *testing.T) {
+	cases := []struct {
+		args    []string
+		command string
+		err     bool
+	}{
+		{[]string{}, "git-sync", false},
+		{[]string{"--repo", "https://a/a"}, "git-sync", false},
+		{[]string{"-v", "5", "--one-time"}, "git-sync", false},
+		{[]string{"run", "--repo", "https://a/a"}, "run", false},
+		{[]string{"once", "--repo", "https://a/a"}, "once", false},
+		{[]string{"wait", "--timeout", "5"}, "wait", false},
+		{[]string{"status"}, "status", false},
+		{[]string{"nope", "--x"}, "", true},
+	}
+
+	for _, testCase := range cases {
+		cmd, _, err := newRootCommand().Find(testCase.args)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%q: expected error %v but got %v", testCase.args, testCase.err, err)
+		}
+		if err == nil && cmd.Name() != testCase.command {
+			t.Fatalf("%q: expected %s but %s returned", testCase.args, testCase.command, cmd.Name())
+		}
+	}
+}
+
+func TestLongFlags(t *testing.T) {
+	cases := []struct {
+		args []string
+		exp  []string
+	}{
+		{[]string{"-repo", "https://a/a", "--wait=5"}, []string{"--repo", "https://a/a", "--wait=5"}},
+		{[]string{"-repo=https://a/a", "-v", "5"}, []string{"--repo=https://a/a", "-v", "5"}},
+		{[]string{"once", "-one-time", "-nope"}, []string{"once", "--one-time", "-nope"}},
+		{[]string{"status", "-output", "json"}, []string{"status", "--output", "json"}},
+		{[]string{"--", "-repo"}, []string{"--", "-repo"}},
+	}
+
+	root := newRootCommand()
+	for _, testCase := range cases {
+		if got := longFlags(root, testCase.args); !reflect.DeepEqual(got, testCase.exp) {
+			t.Fatalf("%q: expected %q but %q returned", testCase.args, testCase.exp, got)
+		}
+	}
+}
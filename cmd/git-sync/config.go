@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+
+	"k8s.io/git-sync/pkg/gitsync"
+)
+
+// config is the --config file, listing repos to sync from one process.
+type config struct {
+	// Defaults holds the gitsync.Options fields common to all repos, in
+	// JSON.
+	Defaults json.RawMessage `json:"defaults"`
+	// Repos holds one gitsync.Options per repo, in JSON.
+	Repos []json.RawMessage `json:"repos"`
+}
+
+// loadConfig reads the config file at path.  It returns the defaults for
+// repos, i.e. flags overridden by the fields the file's defaults set, and
+// the repos, each overriding the fields it sets in turn.
+func loadConfig(path string, flags gitsync.Options) (gitsync.Options, []gitsync.Options, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return flags, nil, fmt.Errorf("error reading config: %v", err)
+	}
+	return parseConfig(data, flags)
+}
+
+// parseConfig parses the contents of a config file; see loadConfig.
+func parseConfig(data []byte, flags gitsync.Options) (gitsync.Options, []gitsync.Options, error) {
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return flags, nil, fmt.Errorf("error parsing config: %v", err)
+	}
+	if len(c.Repos) == 0 {
+		return flags, nil, fmt.Errorf("config lists no repos")
+	}
+	defaults := flags
+	if len(c.Defaults) > 0 {
+		var err error
+		if defaults, err = flags.Override(c.Defaults); err != nil {
+			return flags, nil, fmt.Errorf("error parsing defaults: %v", err)
+		}
+	}
+
+	all := []gitsync.Options{}
+	roots := map[string]int{}
+	statusFiles := map[string]int{}
+	for i, raw := range c.Repos {
+		o, err := defaults.Override(raw)
+		if err != nil {
+			return flags, nil, fmt.Errorf("error parsing repos[%d]: %v", i, err)
+		}
+		if err := o.Validate(); err != nil {
+			return flags, nil, fmt.Errorf("repos[%d]: %v", i, err)
+		}
+
+		root := filepath.Clean(o.Root)
+		if j, found := roots[root]; found {
+			return flags, nil, fmt.Errorf("repos[%d]: root %s is also used by repos[%d]", i, o.Root, j)
+		}
+		roots[root] = i
+		if o.StatusFile != "" {
+			statusFile := filepath.Clean(o.StatusFile)
+			if j, found := statusFiles[statusFile]; found {
+				return flags, nil, fmt.Errorf("repos[%d]: status file %s is also used by repos[%d]", i, o.StatusFile, j)
+			}
+			statusFiles[statusFile] = i
+		}
+		all = append(all, o)
+	}
+	if err := checkNetrcHosts(all); err != nil {
+		return flags, nil, err
+	}
+	return defaults, all, nil
+}
+
+// httpCredentials are the options a repo authenticates with over HTTP(S).
+type httpCredentials struct {
+	username, password                                            string
+	githubToken, gitlabJobToken, azureDevOpsPAT                   string
+	gitlabDeployTokenUsername, gitlabDeployToken                  string
+	bitbucketUsername, bitbucketAppPassword, bitbucketAccessToken string
+	askpassURL, oauth2TokenURL, oauth2ClientID, stsURL            string
+	vaultAddr, vaultSecretPath                                    string
+	codeCommit, gcpMetadataToken, azureManagedIdentity            bool
+}
+
+// credentialsOf returns the httpCredentials of o.
+func credentialsOf(o gitsync.Options) httpCredentials {
+	return httpCredentials{
+		username:                  o.Username,
+		password:                  o.Password,
+		githubToken:               o.GitHubToken,
+		gitlabJobToken:            o.GitLabJobToken,
+		azureDevOpsPAT:            o.AzureDevOpsPAT,
+		gitlabDeployTokenUsername: o.GitLabDeployTokenUsername,
+		gitlabDeployToken:         o.GitLabDeployToken,
+		bitbucketUsername:         o.BitbucketUsername,
+		bitbucketAppPassword:      o.BitbucketAppPassword,
+		bitbucketAccessToken:      o.BitbucketAccessToken,
+		askpassURL:                o.AskpassURL,
+		oauth2TokenURL:            o.OAuth2TokenURL,
+		oauth2ClientID:            o.OAuth2ClientID,
+		stsURL:                    o.STSURL,
+		vaultAddr:                 o.VaultAddr,
+		vaultSecretPath:           o.VaultSecretPath,
+		codeCommit:                o.CodeCommit,
+		gcpMetadataToken:          o.GCPMetadataToken,
+		azureManagedIdentity:      o.AzureManagedIdentity,
+	}
+}
+
+// checkNetrcHosts returns an error if a repo with --netrc shares its host
+// with a repo that authenticates differently.  git-sync passes each repo's
+// credentials to its own git commands only, but ~/.netrc keeps them per
+// host, and git uses them for every repo on that host.
+func checkNetrcHosts(all []gitsync.Options) error {
+	for i, o := range all {
+		host := httpHost(o.Repo)
+		if !o.Netrc || host == "" {
+			continue
+		}
+		creds := credentialsOf(o)
+		for j, other := range all {
+			if j == i || httpHost(other.Repo) != host {
+				continue
+			}
+			if c := credentialsOf(other); c != (httpCredentials{}) && c != creds {
+				return fmt.Errorf("repos[%d]: credentials for %s are also stored in ~/.netrc by repos[%d], which git would use instead", j, host, i)
+			}
+		}
+	}
+	return nil
+}
+
+// httpHost returns the host of repo, or "" if it isn't cloned over HTTP(S).
+func httpHost(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.Host
+}
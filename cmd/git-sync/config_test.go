@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/git-sync/pkg/gitsync"
+)
+
+func TestParseConfig(t *testing.T) {
+	defaults := gitsync.Options{
+		Branch:      "master",
+		Rev:         "HEAD",
+		Wait:        10,
+		SSHKeyFiles: []string{"/etc/git-secret/ssh"},
+	}
+
+	cases := []struct {
+		config string
+		repos  []string
+		roots  []string
+		waits  []float64
+		err    bool
+	}{
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a"}, {"repo": "https://b/b", "root": "/git/b", "wait": 60}]}`,
+			[]string{"https://a/a", "https://b/b"}, []string{"/git/a", "/git/b"}, []float64{10, 60}, false},
+		{`{"defaults": {"wait": 30}, "repos": [{"repo": "https://a/a", "root": "/git/a"}, {"repo": "https://b/b", "root": "/git/b", "wait": 60}]}`,
+			[]string{"https://a/a", "https://b/b"}, []string{"/git/a", "/git/b"}, []float64{30, 60}, false},
+		{`{"defaults": {"wait": "soon"}, "repos": [{"repo": "https://a/a", "root": "/git/a"}]}`, nil, nil, nil, true},
+		{`{"repos": []}`, nil, nil, nil, true},
+		{`{"repos": [{"root": "/git/a"}]}`, nil, nil, nil, true},
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a"}, {"repo": "https://b/b", "root": "/git/a/"}]}`, nil, nil, nil, true},
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a", "wait": "soon"}]}`, nil, nil, nil, true},
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a", "statusFile": "/tmp/s"}, {"repo": "https://b/b", "root": "/git/b", "statusFile": "/tmp/s"}]}`, nil, nil, nil, true},
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a", "credentialHelper": "/bin/a"}, {"repo": "https://a/b", "root": "/git/b", "credentialHelper": "/bin/b"}]}`,
+			[]string{"https://a/a", "https://a/b"}, []string{"/git/a", "/git/b"}, []float64{10, 10}, false},
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a", "gcpMetadataToken": true}, {"repo": "https://a/b", "root": "/git/b", "username": "u", "password": "p"}]}`,
+			[]string{"https://a/a", "https://a/b"}, []string{"/git/a", "/git/b"}, []float64{10, 10}, false},
+		{`{"defaults": {"netrc": true, "username": "u", "password": "p"}, "repos": [{"repo": "https://a/a", "root": "/git/a"}, {"repo": "https://a/b", "root": "/git/b"}]}`,
+			[]string{"https://a/a", "https://a/b"}, []string{"/git/a", "/git/b"}, []float64{10, 10}, false},
+		{`{"defaults": {"netrc": true}, "repos": [{"repo": "https://a/a", "root": "/git/a", "username": "u", "password": "p"}, {"repo": "https://a/b", "root": "/git/b", "username": "v", "password": "q"}]}`, nil, nil, nil, true},
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a", "askpassURL": "http://localhost/creds"}, {"repo": "https://a/b", "root": "/git/b", "netrc": true, "username": "u", "password": "p"}]}`, nil, nil, nil, true},
+		{`{"repos": [{"repo": "https://a/a", "root": "/git/a", "netrc": true, "username": "u", "password": "p"}, {"repo": "https://b/b", "root": "/git/b", "username": "v", "password": "q"}, {"repo": "https://a/c", "root": "/git/c"}]}`,
+			[]string{"https://a/a", "https://b/b", "https://a/c"}, []string{"/git/a", "/git/b", "/git/c"}, []float64{10, 10, 10}, false},
+		{`not json`, nil, nil, nil, true},
+	}
+
+	for _, testCase := range cases {
+		_, all, err := parseConfig([]byte(testCase.config), defaults)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s: expected error %v but got %v", testCase.config, testCase.err, err)
+		}
+		repos, roots, waits := []string(nil), []string(nil), []float64(nil)
+		for _, o := range all {
+			repos = append(repos, o.Repo)
+			roots = append(roots, o.Root)
+			waits = append(waits, o.Wait)
+			if o.Branch != "master" || !reflect.DeepEqual(o.SSHKeyFiles, defaults.SSHKeyFiles) {
+				t.Fatalf("%s: expected defaults to be kept but got %+v", testCase.config, o)
+			}
+		}
+		if !reflect.DeepEqual(repos, testCase.repos) || !reflect.DeepEqual(roots, testCase.roots) || !reflect.DeepEqual(waits, testCase.waits) {
+			t.Fatalf("%s: expected %v %v %v but %v %v %v returned", testCase.config, testCase.repos, testCase.roots, testCase.waits, repos, roots, waits)
+		}
+	}
+}
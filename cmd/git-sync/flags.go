@@ -4,82 +4,472 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/git-sync/pkg/gitsync"
 )
 
 var (
 	log = newLoggerOrDie()
 
-	cliOpts = SyncOption{}
+	// syncFlags are the flags of the run, once and verify commands, which
+	// git-sync without a command takes too.
+	syncFlags = pflag.NewFlagSet("git-sync", pflag.ContinueOnError)
+
+	cliOpts = gitsync.Options{}
+
+	configFile         string
+	maxConcurrentSyncs int
+	bandwidthBudget    int64
+	metricsTextfileDir string
+	statsdAddr         string
+	statsdPrefix       string
+	statsdDogStatsD    bool
+
+	adminAddr     string
+	adminGRPCAddr string
+	adminToken    string
+	adminTLSCert  string
+	adminTLSKey   string
+	adminClientCA string
 )
 
 func init() {
-	flag.StringVar(&cliOpts.Repo, "repo", envString("GIT_SYNC_REPO", ""),
+	syncFlags.StringVar(&configFile, "config", envString("GIT_SYNC_CONFIG", ""),
+		"a JSON file listing several repos to sync, in place of --repo; other flags give their defaults (see README)")
+	syncFlags.IntVar(&maxConcurrentSyncs, "max-concurrent-syncs", envInt("GIT_SYNC_MAX_CONCURRENT_SYNCS", 0),
+		"the most repos to sync at once, with --config or the admin API (0 for no limit)")
+	syncFlags.Var(newByteSizeValue(envByteSize("GIT_SYNC_BANDWIDTH_BUDGET", 0), &bandwidthBudget), "bandwidth-budget",
+		"the bytes per second, with a K, M or G suffix, that the repos may fetch between them, with --config or the admin API, the least recently synced first (0 for no limit)")
+	syncFlags.StringVar(&metricsTextfileDir, "metrics-textfile-dir", envString("GIT_SYNC_METRICS_TEXTFILE_DIR", ""),
+		"a directory (e.g. node-exporter's --collector.textfile.directory) in which to write the metrics of every repo, as git-sync.prom, after every sync")
+	syncFlags.StringVar(&statsdAddr, "statsd-addr", envString("GIT_SYNC_STATSD_ADDR", ""),
+		"the address (host:port) of a StatsD or DogStatsD agent to which to send the metrics of a repo after each of its syncs")
+	syncFlags.StringVar(&statsdPrefix, "statsd-prefix", envString("GIT_SYNC_STATSD_PREFIX", "git_sync"),
+		"the prefix of the names of the metrics sent to --statsd-addr")
+	syncFlags.BoolVar(&statsdDogStatsD, "statsd-dogstatsd", envBool("GIT_SYNC_STATSD_DOGSTATSD", false),
+		"send the repo name and outcome to --statsd-addr as DogStatsD tags, rather than in the metric names")
+	syncFlags.StringVar(&adminAddr, "admin-addr", envString("GIT_SYNC_ADMIN_ADDR", ""),
+		"the address (e.g. \":8443\") on which to serve the REST admin API for listing, adding, removing, syncing and pausing repos (see README)")
+	syncFlags.StringVar(&adminGRPCAddr, "admin-grpc-addr", envString("GIT_SYNC_ADMIN_GRPC_ADDR", ""),
+		"the address (e.g. \":8444\") on which to serve the admin API over gRPC, as defined in pkg/adminpb/admin.proto")
+	syncFlags.StringVar(&adminToken, "admin-token", envString("GIT_SYNC_ADMIN_TOKEN", ""),
+		"the bearer token that admin API requests must send")
+	syncFlags.StringVar(&adminTLSCert, "admin-tls-cert", envString("GIT_SYNC_ADMIN_TLS_CERT", ""),
+		"the PEM certificate with which to serve the admin API over HTTPS, and over gRPC with TLS")
+	syncFlags.StringVar(&adminTLSKey, "admin-tls-key", envString("GIT_SYNC_ADMIN_TLS_KEY", ""),
+		"the PEM private key of --admin-tls-cert")
+	syncFlags.StringVar(&adminClientCA, "admin-client-ca", envString("GIT_SYNC_ADMIN_CLIENT_CA", ""),
+		"a PEM CA bundle; if set, admin API clients must present a certificate signed by it (mTLS)")
+	syncFlags.StringVar(&cliOpts.Repo, "repo", envString("GIT_SYNC_REPO", ""),
 		"the git repository to clone")
-	flag.StringVar(&cliOpts.Branch, "branch", envString("GIT_SYNC_BRANCH", "master"),
+	syncFlags.StringVar(&cliOpts.Backend, "backend", envString("GIT_SYNC_BACKEND", gitsync.BackendExec),
+		"what syncs the repo: exec (the git CLI) or gogit (go-git, in process, needing no git binary but supporting only the basic options)")
+	syncFlags.StringVar(&cliOpts.Branch, "branch", envString("GIT_SYNC_BRANCH", "master"),
 		"the git branch to check out")
-	flag.StringVar(&cliOpts.Rev, "rev", envString("GIT_SYNC_REV", "HEAD"),
+	syncFlags.StringVar(&cliOpts.Rev, "rev", envString("GIT_SYNC_REV", "HEAD"),
 		"the git revision (tag or hash) to check out")
-	flag.IntVar(&cliOpts.Depth, "depth", envInt("GIT_SYNC_DEPTH", 0),
+	syncFlags.IntVar(&cliOpts.Depth, "depth", envInt("GIT_SYNC_DEPTH", 0),
 		"use a shallow clone with a history truncated to the specified number of commits")
+	syncFlags.StringVar(&cliOpts.ShallowSince, "shallow-since", envString("GIT_SYNC_SHALLOW_SINCE", ""),
+		"use a shallow clone with the history since this date, e.g. 2023-01-01 or \"6 months ago\" (in place of --depth)")
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_SHALLOW_EXCLUDE", nil), &cliOpts.ShallowExclude), "shallow-exclude",
+		"use a shallow clone without the history reachable from this branch or tag, e.g. the previous release (may be repeated; in place of --depth)")
+	syncFlags.BoolVar(&cliOpts.AutoDepth, "auto-depth", envBool("GIT_SYNC_AUTO_DEPTH", false),
+		"clone only the latest commit, and deepen the clone only when --rev isn't in it (in place of --depth)")
+	syncFlags.StringVar(&cliOpts.CloneCheck, "clone-check", envString("GIT_SYNC_CLONE_CHECK", gitsync.CloneCheckWarn),
+		"what to do if an existing clone in --root doesn't match --repo, --branch or the shallow flags: fail, warn or reconcile (change the clone to match)")
+	syncFlags.BoolVar(&cliOpts.CloneCheckEverySync, "clone-check-every-sync", envBool("GIT_SYNC_CLONE_CHECK_EVERY_SYNC", false),
+		"run --clone-check before every sync, not just the first")
+	syncFlags.StringVar(&cliOpts.MissingRef, "missing-ref", envString("GIT_SYNC_MISSING_REF", gitsync.MissingRefFail),
+		"what to do if the remote no longer has --branch, or the tag --rev: fail, keep (serving the published revision) or fallback (to --fallback-ref)")
+	syncFlags.StringVar(&cliOpts.FallbackRef, "fallback-ref", envString("GIT_SYNC_FALLBACK_REF", ""),
+		"the branch or tag to sync while --branch or --rev is missing, with --missing-ref=fallback")
+	syncFlags.BoolVar(&cliOpts.ImmutableTags, "immutable-tags", envBool("GIT_SYNC_IMMUTABLE_TAGS", false),
+		"sync the tag --rev only once, rather than again whenever it is moved to another commit")
+	syncFlags.Float64Var(&cliOpts.PruneInterval, "prune-interval", envFloat("GIT_SYNC_PRUNE_INTERVAL", 0),
+		"the number of seconds between deletions of the objects that old revisions left behind, after a sync (0 to never delete them)")
+	syncFlags.BoolVar(&cliOpts.Submodules, "submodules", envBool("GIT_SYNC_SUBMODULES", false),
+		"check out the submodules of every commit, recursively")
+	syncFlags.IntVar(&cliOpts.SubmoduleJobs, "submodule-jobs", envInt("GIT_SYNC_SUBMODULE_JOBS", 0),
+		"the number of submodules to fetch at once, for --submodules (0 for git's submodule.fetchJobs)")
+	syncFlags.StringVar(&cliOpts.CacheDir, "cache-dir", envString("GIT_SYNC_CACHE_DIR", ""),
+		"a directory, e.g. on a volume that survives restarts, in which to keep a clone of the repo that is checked, updated and cloned locally at startup")
+	syncFlags.BoolVar(&cliOpts.PartialClone, "partial-clone", envBool("GIT_SYNC_PARTIAL_CLONE", false),
+		"clone without file contents, which are fetched only as they are checked out (needs git 2.19 or later on both ends)")
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_SPARSE_PATH", nil), &cliOpts.SparsePaths), "sparse-path",
+		"a pattern, as in .gitignore, of the files to check out, leaving out the rest (may be repeated; with --partial-clone, only they are fetched)")
+	syncFlags.IntVar(&cliOpts.PackThreads, "pack-threads", envInt("GIT_SYNC_PACK_THREADS", 0),
+		"the most threads git may use to pack and index objects, e.g. the container's CPU limit (0 for one per CPU of the node)")
+	syncFlags.IntVar(&cliOpts.IndexThreads, "index-threads", envInt("GIT_SYNC_INDEX_THREADS", 0),
+		"the most threads git may use to read the index of a checkout (0 for git's default)")
+	syncFlags.Var(newOptionalIntValue(envOptionalInt("GIT_SYNC_COMPRESSION_LEVEL"), &cliOpts.CompressionLevel), "compression-level",
+		"git's core.compression, the zlib level of the objects git writes, from 0 (none) and 1 (fastest) to 9 (smallest), or -1 for zlib's default (unset for git's default)")
+	syncFlags.IntVar(&cliOpts.CheckoutWorkers, "checkout-workers", envInt("GIT_SYNC_CHECKOUT_WORKERS", 0),
+		"the number of files git writes at once when checking out a revision (0 for git's default of one; needs git 2.32 or later)")
+	syncFlags.IntVar(&cliOpts.CheckoutParallelThreshold, "checkout-parallel-threshold", envInt("GIT_SYNC_CHECKOUT_PARALLEL_THRESHOLD", 0),
+		"the fewest files a checkout must write for --checkout-workers to be used (0 for git's default of 100)")
+	syncFlags.BoolVar(&cliOpts.HardlinkWorktrees, "hardlink-worktrees", envBool("GIT_SYNC_HARDLINK_WORKTREES", false),
+		"create the worktree of every revision with hard links to the published revision's unchanged files, rather than copies (hooks must not rewrite files in place)")
+	syncFlags.BoolVar(&cliOpts.ReflinkWorktrees, "reflink-worktrees", envBool("GIT_SYNC_REFLINK_WORKTREES", false),
+		"create the worktree of every revision with copy-on-write clones of the published revision's unchanged files, on filesystems that support them (e.g. XFS and btrfs)")
 
-	flag.StringVar(&cliOpts.Root, "root", envString("GIT_SYNC_ROOT", "/git"),
+	syncFlags.StringVar(&cliOpts.Root, "root", envString("GIT_SYNC_ROOT", "/git"),
 		"the root directory for git operations")
-	flag.StringVar(&cliOpts.Dest, "dest", envString("GIT_SYNC_DEST", ""),
+	syncFlags.StringVar(&cliOpts.Dest, "dest", envString("GIT_SYNC_DEST", ""),
 		"the name at which to publish the checked-out files under --root (&defaults to leaf dir of --root)")
-	flag.Float64Var(&cliOpts.Wait, "wait", envFloat("GIT_SYNC_WAIT", 0),
+	syncFlags.Float64Var(&cliOpts.Wait, "wait", envFloat("GIT_SYNC_WAIT", 0),
 		"the number of seconds between syncs")
-	flag.BoolVar(&cliOpts.OneTime, "one-time", envBool("GIT_SYNC_ONE_TIME", false),
+	syncFlags.Float64Var(&cliOpts.Timeout, "timeout", envFloat("GIT_SYNC_TIMEOUT", 120),
+		"the max number of seconds allowed for a complete sync, after which its git commands are killed (0 for no limit)")
+	syncFlags.BoolVar(&cliOpts.OneTime, "one-time", envBool("GIT_SYNC_ONE_TIME", false),
 		"exit after the initial checkout")
-	flag.IntVar(&cliOpts.MaxSyncFailures, "max-sync-failures", envInt("GIT_SYNC_MAX_SYNC_FAILURES", 0),
+	syncFlags.IntVar(&cliOpts.MaxSyncFailures, "max-sync-failures", envInt("GIT_SYNC_MAX_SYNC_FAILURES", 0),
 		"the number of consecutive failures allowed before aborting (&the first pull must succeed)")
-	flag.IntVar(&cliOpts.Chmod, "change-permissions", envInt("GIT_SYNC_PERMISSIONS", 0),
+	syncFlags.StringVar(&cliOpts.CanaryLink, "canary-link", envString("GIT_SYNC_CANARY_LINK", ""),
+		"the name of a symlink under --root to publish every new revision to first, for canaries, before --dest")
+	syncFlags.Float64Var(&cliOpts.CanarySoak, "canary-soak", envFloat("GIT_SYNC_CANARY_SOAK", 0),
+		"the number of seconds a revision stays in --canary-link before it is published in --dest (0 to wait for approval through the admin API)")
+	syncFlags.BoolVar(&cliOpts.ServeStale, "serve-stale", envBool("GIT_SYNC_SERVE_STALE", false),
+		"if the remote can't be reached but a revision is published (e.g. after a restart), keep serving it and retry, without counting it as a failure")
+	syncFlags.IntVar(&cliOpts.Chmod, "change-permissions", envInt("GIT_SYNC_CHANGE_PERMISSIONS", envInt("GIT_SYNC_PERMISSIONS", 0)),
 		"the file permissions to apply to the checked-out files")
+	syncFlags.StringVar(&cliOpts.PublishStrategy, "publish-strategy", envString("GIT_SYNC_PUBLISH_STRATEGY", gitsync.PublishSymlink),
+		"how to publish each revision in --dest: symlink, rename (for volumes without symlinks), copy (into a directory that stays put), pointer (a file naming the revision's directory) or in-place (one checkout, reset in place)")
+
+	syncFlags.StringVar(&cliOpts.StatusFile, "status-file", envString("GIT_SYNC_STATUS_FILE", ""),
+		"a file to which to write the repo's status, as JSON, after every sync (see `git-sync wait`)")
+	syncFlags.BoolVar(&cliOpts.ProfileSyncs, "profile-syncs", envBool("GIT_SYNC_PROFILE_SYNCS", false),
+		"log how long each step of every sync (resolve, fetch, checkout, chmod, swap, cleanup) takes and roughly how many bytes it fetched, and expose them in the status and metrics")
+	syncFlags.StringVar(&cliOpts.AuditLog, "audit-log", envString("GIT_SYNC_AUDIT_LOG", ""),
+		"a file to which to append a JSON line for every git command run, with its arguments (credentials redacted), exit code, duration and output size")
+
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_HOOK_PLUGIN", nil), &cliOpts.HookPlugins), "hook-plugin",
+		"a Go plugin exporting a gitsync.Hook named Hook, to run in-process before --hook-command (may be repeated; needs a cgo build)")
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_HOOK_COMMAND", nil), &cliOpts.HookCommands), "hook-command",
+		"a command to run, with a JSON description of the sync on stdin, before every fetch and after every checkout and publish (may be repeated; see README)")
+	syncFlags.StringVar(&cliOpts.RenderDest, "render-dest", envString("GIT_SYNC_RENDER_DEST", ""),
+		"the name of a symlink, under --root, to which to publish a rendered copy of every revision, with placeholders substituted per --render-mode")
+	syncFlags.StringVar(&cliOpts.RenderMode, "render-mode", envString("GIT_SYNC_RENDER_MODE", gitsync.RenderEnvsubst),
+		"how to render --render-dest: envsubst (replace $VAR and ${VAR} with environment variables) or template (Go templates; see README)")
+	syncFlags.StringVar(&cliOpts.RenderPattern, "render-pattern", envString("GIT_SYNC_RENDER_PATTERN", "*"),
+		"the file names to render for --render-dest; other files are copied as they are")
+	syncFlags.StringVar(&cliOpts.GitsignIdentity, "verify-gitsign-identity", envString("GIT_SYNC_VERIFY_GITSIGN_IDENTITY", ""),
+		"refuse commits unless signed with gitsign by this identity (e.g. an email address), with the signature in Rekor")
+	syncFlags.StringVar(&cliOpts.GitsignIssuer, "verify-gitsign-issuer", envString("GIT_SYNC_VERIFY_GITSIGN_ISSUER", ""),
+		"the OIDC issuer (e.g. https://github.com/login/oauth) that must have vouched for --verify-gitsign-identity")
+	syncFlags.StringVar(&cliOpts.PolicyFile, "policy-file", envString("GIT_SYNC_POLICY_FILE", ""),
+		"a Rego policy file, or directory of them, that opa must find allows every new commit, given its metadata and changed files, before it is published")
+	syncFlags.StringVar(&cliOpts.PolicyQuery, "policy-query", envString("GIT_SYNC_POLICY_QUERY", "data.gitsync.deny"),
+		"the query of --policy-file whose result, if true or a non-empty set of messages, denies a commit")
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_REQUIRE_PATH", nil), &cliOpts.RequirePaths), "require-path",
+		"a path or glob, relative to the repo, that must exist in a commit for it to be published, e.g. kustomization.yaml (may be repeated)")
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_FORBID_PATH", nil), &cliOpts.ForbidPaths), "forbid-path",
+		"a glob that nothing in a commit may match for it to be published; without a slash, it matches file names at any depth (may be repeated)")
+	syncFlags.BoolVar(&cliOpts.AllowEscapingSymlinks, "allow-escaping-symlinks", envBool("GIT_SYNC_ALLOW_ESCAPING_SYMLINKS", false),
+		"publish commits with absolute symlinks, or symlinks that resolve outside of the repo, which are refused by default")
+	syncFlags.Var(newByteSizeValue(envByteSize("GIT_SYNC_MAX_FILE_SIZE", 0), &cliOpts.MaxFileSize), "max-file-size",
+		"refuse to publish commits with a file bigger than this, in bytes or with a K, M or G suffix (0 for no limit)")
+	syncFlags.Var(newByteSizeValue(envByteSize("GIT_SYNC_MAX_TOTAL_SIZE", 0), &cliOpts.MaxTotalSize), "max-total-size",
+		"refuse to publish commits whose files are bigger than this in all, in bytes or with a K, M or G suffix (0 for no limit)")
+	syncFlags.StringVar(&cliOpts.SecretScan, "secret-scan", envString("GIT_SYNC_SECRET_SCAN", ""),
+		"scan the files changed in every new commit for secrets, and either warn about them or block publishing (\"warn\" or \"block\")")
+	syncFlags.StringVar(&cliOpts.SecretScanRules, "secret-scan-rules", envString("GIT_SYNC_SECRET_SCAN_RULES", ""),
+		"a file of secret scanning rules, each a rule ID and a regex per line, or a gitleaks config if it ends in .toml, in place of the built-in rules")
+	syncFlags.StringVar(&cliOpts.DecryptKeyFile, "decrypt-key-file", envString("GIT_SYNC_DECRYPT_KEY_FILE", ""),
+		"an age identity file (e.g. from a mounted secret) with which to decrypt SOPS and age encrypted files in every new worktree before publishing it")
+	syncFlags.StringVar(&cliOpts.DecryptSOPSPattern, "decrypt-sops-pattern", envString("GIT_SYNC_DECRYPT_SOPS_PATTERN", "*.sops.*"),
+		"the file names to decrypt in place with sops, for --decrypt-key-file")
+	syncFlags.StringVar(&cliOpts.DecryptAgePattern, "decrypt-age-pattern", envString("GIT_SYNC_DECRYPT_AGE_PATTERN", "*.age"),
+		"the file names to decrypt with age, less their extension, for --decrypt-key-file")
+	syncFlags.BoolVar(&cliOpts.AllowRepoHooks, "allow-repo-hooks", envBool("GIT_SYNC_ALLOW_REPO_HOOKS", false),
+		"run the synced repo's own .git-sync/post-checkout script, if any, in every new worktree before publishing it (only for trusted repos)")
+	syncFlags.Float64Var(&cliOpts.RepoHookTimeout, "repo-hook-timeout", envFloat("GIT_SYNC_REPO_HOOK_TIMEOUT", 60),
+		"the number of seconds allowed for the repo's .git-sync/post-checkout script")
+	syncFlags.StringVar(&cliOpts.ManifestFile, "manifest-file", envString("GIT_SYNC_MANIFEST_FILE", ""),
+		"a file to which to write a JSON manifest of every newly published revision, with the SHA-256 of every file")
+	syncFlags.StringVar(&cliOpts.ManifestCosignKey, "manifest-cosign-key", envString("GIT_SYNC_MANIFEST_COSIGN_KEY", ""),
+		"a cosign private key with which to sign --manifest-file, into --manifest-file.sig (its password is read from $COSIGN_PASSWORD)")
+	syncFlags.BoolVar(&cliOpts.ManifestCosignKeyless, "manifest-cosign-keyless", envBool("GIT_SYNC_MANIFEST_COSIGN_KEYLESS", false),
+		"sign --manifest-file with cosign's keyless signing, through OIDC, into --manifest-file.sig and .pem")
+	syncFlags.StringVar(&cliOpts.PresyncCommand, "presync-command", envString("GIT_SYNC_PRESYNC_COMMAND", ""),
+		"a command to run before every sync, e.g. to refresh credentials or check for maintenance; if it exits non-zero, the sync is skipped until the next --wait")
+	syncFlags.StringVar(&cliOpts.ExechookCommand, "exechook-command", envString("GIT_SYNC_EXECHOOK_COMMAND", ""),
+		"a command to run in the new worktree after every new revision is published, with the old and new hashes in $GIT_SYNC_OLD_HASH and $GIT_SYNC_NEW_HASH")
+	syncFlags.Float64Var(&cliOpts.ExechookTimeout, "exechook-timeout", envFloat("GIT_SYNC_EXECHOOK_TIMEOUT", 30),
+		"the number of seconds allowed for each run of --exechook-command")
+	syncFlags.IntVar(&cliOpts.ExechookRetries, "exechook-retries", envInt("GIT_SYNC_EXECHOOK_RETRIES", 3),
+		"the number of times to retry a failed --exechook-command")
+	syncFlags.Float64Var(&cliOpts.ExechookBackoff, "exechook-backoff", envFloat("GIT_SYNC_EXECHOOK_BACKOFF", 3),
+		"the number of seconds to wait before retrying a failed --exechook-command, doubled after every retry")
+
+	syncFlags.StringVar(&cliOpts.NATSURL, "nats-url", envString("GIT_SYNC_NATS_URL", ""),
+		"the NATS server (nats://[user:password@]host[:port], or tls://... for TLS) to which to publish an event every time a new revision is published")
+	syncFlags.StringVar(&cliOpts.NATSSubject, "nats-subject", envString("GIT_SYNC_NATS_SUBJECT", "git-sync"),
+		"the NATS subject on which to publish events")
+	syncFlags.StringVar(&cliOpts.KafkaRESTURL, "kafka-rest-url", envString("GIT_SYNC_KAFKA_REST_URL", ""),
+		"the Kafka REST proxy (http(s)://[user:password@]host[:port]) through which to produce an event every time a new revision is published")
+	syncFlags.StringVar(&cliOpts.KafkaTopic, "kafka-topic", envString("GIT_SYNC_KAFKA_TOPIC", "git-sync"),
+		"the Kafka topic to which to produce events")
+	syncFlags.StringVar(&cliOpts.KafkaKey, "kafka-key", envString("GIT_SYNC_KAFKA_KEY", "repo"),
+		"the event field to use as the Kafka record key: name, repo or none")
+	syncFlags.StringVar(&cliOpts.SQSQueueURL, "sqs-queue-url", envString("GIT_SYNC_SQS_QUEUE_URL", ""),
+		"the AWS SQS queue to which to send an event every time a new revision is published, using the pod's AWS credentials")
+	syncFlags.StringVar(&cliOpts.SNSTopicARN, "sns-topic-arn", envString("GIT_SYNC_SNS_TOPIC_ARN", ""),
+		"the AWS SNS topic to which to publish an event every time a new revision is published, using the pod's AWS credentials")
+	syncFlags.StringVar(&cliOpts.PubSubTopic, "pubsub-topic", envString("GIT_SYNC_PUBSUB_TOPIC", ""),
+		"the Google Cloud Pub/Sub topic (projects/PROJECT/topics/TOPIC) to which to publish an event every time a new revision is published, as --gcp-service-account")
+	syncFlags.StringVar(&cliOpts.CloudEventsSink, "cloudevents-sink", envString("GIT_SYNC_CLOUDEVENTS_SINK", ""),
+		"a CloudEvents sink (e.g. a Knative broker or Argo Events webhook) to which to send a CloudEvent every time a new revision is published")
+	syncFlags.StringVar(&cliOpts.GitHubStatusContext, "github-status-context", envString("GIT_SYNC_GITHUB_STATUS_CONTEXT", ""),
+		"the context (name) of a GitHub commit status to set, with --github-token, on every revision published, e.g. deploy/cluster-x")
+	syncFlags.StringVar(&cliOpts.GitHubStatusDescription, "github-status-description", envString("GIT_SYNC_GITHUB_STATUS_DESCRIPTION", "Synced by git-sync"),
+		"the description of the --github-status-context commit status")
+	syncFlags.StringVar(&cliOpts.GitHubStatusTargetURL, "github-status-target-url", envString("GIT_SYNC_GITHUB_STATUS_TARGET_URL", ""),
+		"a URL to which the --github-status-context commit status links")
+	syncFlags.StringVar(&cliOpts.GitHubAPIURL, "github-api-url", envString("GIT_SYNC_GITHUB_API_URL", ""),
+		"the GitHub API, for --github-status-context (default https://api.github.com, or https://HOST/api/v3 for GitHub Enterprise)")
+	syncFlags.StringVar(&cliOpts.WebhookURL, "webhook-url", envString("GIT_SYNC_WEBHOOK_URL", ""),
+		"a URL to call with an event, as JSON, every time a new revision is published")
+	syncFlags.StringVar(&cliOpts.WebhookMethod, "webhook-method", envString("GIT_SYNC_WEBHOOK_METHOD", "POST"),
+		"the HTTP method with which to call --webhook-url: POST, PUT or PATCH")
+	syncFlags.Float64Var(&cliOpts.WebhookTimeout, "webhook-timeout", envFloat("GIT_SYNC_WEBHOOK_TIMEOUT", 10),
+		"the number of seconds allowed for calling --webhook-url")
+	syncFlags.StringVar(&cliOpts.WebhookTemplate, "webhook-template", envString("GIT_SYNC_WEBHOOK_TEMPLATE", ""),
+		"a Go text/template for the --webhook-url request body, executed with the event (see README)")
+	syncFlags.Var(newIntListValue(envIntList("GIT_SYNC_WEBHOOK_SUCCESS_CODES", nil), &cliOpts.WebhookSuccessCodes), "webhook-success-codes",
+		"the comma-separated HTTP status codes with which --webhook-url may reply to a successful call (default any 2xx)")
+	syncFlags.IntVar(&cliOpts.WebhookRetries, "webhook-retries", envInt("GIT_SYNC_WEBHOOK_RETRIES", 3),
+		"the number of times to retry a failed call to --webhook-url")
+	syncFlags.Float64Var(&cliOpts.WebhookBackoff, "webhook-backoff", envFloat("GIT_SYNC_WEBHOOK_BACKOFF", 1),
+		"the number of seconds to wait before retrying a failed call to --webhook-url, doubled after every retry")
+	syncFlags.StringVar(&cliOpts.ReloadURL, "reload-url", envString("GIT_SYNC_RELOAD_URL", ""),
+		"a URL to call, with no body, every time a new revision is published, e.g. http://localhost:9090/-/reload to make Prometheus reload its config")
+	syncFlags.StringVar(&cliOpts.ReloadMethod, "reload-method", envString("GIT_SYNC_RELOAD_METHOD", "POST"),
+		"the HTTP method with which to call --reload-url: GET or POST")
+	syncFlags.Float64Var(&cliOpts.ReloadTimeout, "reload-timeout", envFloat("GIT_SYNC_RELOAD_TIMEOUT", 10),
+		"the number of seconds allowed for calling --reload-url")
+	syncFlags.Var(newIntListValue(envIntList("GIT_SYNC_RELOAD_SUCCESS_CODES", nil), &cliOpts.ReloadSuccessCodes), "reload-success-codes",
+		"the comma-separated HTTP status codes with which --reload-url may reply to a successful call (default any 2xx)")
+	syncFlags.StringVar(&cliOpts.NotifyURL, "notify-url", envString("GIT_SYNC_NOTIFY_URL", ""),
+		"a Slack or Microsoft Teams incoming webhook to which to post a message every time a new revision is published or syncing starts failing")
+	syncFlags.StringVar(&cliOpts.NotifyFormat, "notify-format", envString("GIT_SYNC_NOTIFY_FORMAT", gitsync.NotifySlack),
+		"the kind of --notify-url: slack or teams")
+	syncFlags.StringVar(&cliOpts.NotifyCommitURL, "notify-commit-url", envString("GIT_SYNC_NOTIFY_COMMIT_URL", ""),
+		"the URL which, followed by a commit hash, links to the commit in notifications (default guessed for GitHub, GitLab and Bitbucket)")
+
+	syncFlags.BoolVar(&cliOpts.AddUser, "add-user", envBool("GIT_SYNC_ADD_USER", false),
+		"add an /etc/passwd entry and a writable $HOME for the current UID, and trust repos owned by other UIDs (for arbitrary UIDs, e.g. on OpenShift)")
 
-	flag.StringVar(&cliOpts.Username, "username", envString("GIT_SYNC_USERNAME", ""),
+	syncFlags.StringVar(&cliOpts.Username, "username", envString("GIT_SYNC_USERNAME", ""),
 		"the username to use")
-	flag.StringVar(&cliOpts.Password, "password", envString("GIT_SYNC_PASSWORD", ""),
+	syncFlags.StringVar(&cliOpts.Password, "password", envString("GIT_SYNC_PASSWORD", ""),
 		"the password to use")
+	syncFlags.BoolVar(&cliOpts.Netrc, "netrc", envBool("GIT_SYNC_NETRC", false),
+		"store HTTPS credentials in ~/.netrc, for every repo on the same host, rather than passing them to this repo's git commands only")
+	syncFlags.StringVar(&cliOpts.GitHubToken, "github-token", envString("GIT_SYNC_GITHUB_TOKEN", ""),
+		"the GitHub (or GitHub Enterprise) token to use for HTTPS auth, in place of --username and --password")
+	syncFlags.StringVar(&cliOpts.GitLabDeployTokenUsername, "gitlab-deploy-token-username", envString("GIT_SYNC_GITLAB_DEPLOY_TOKEN_USERNAME", ""),
+		"the username of the GitLab deploy token given in --gitlab-deploy-token")
+	syncFlags.StringVar(&cliOpts.GitLabDeployToken, "gitlab-deploy-token", envString("GIT_SYNC_GITLAB_DEPLOY_TOKEN", ""),
+		"the GitLab deploy token to use for HTTPS auth, in place of --username and --password")
+	syncFlags.StringVar(&cliOpts.GitLabJobToken, "gitlab-job-token", envString("GIT_SYNC_GITLAB_JOB_TOKEN", ""),
+		"the GitLab CI job token (CI_JOB_TOKEN) to use for HTTPS auth, in place of --username and --password")
+	syncFlags.StringVar(&cliOpts.BitbucketUsername, "bitbucket-username", envString("GIT_SYNC_BITBUCKET_USERNAME", ""),
+		"the Bitbucket username (not email address) that owns --bitbucket-app-password")
+	syncFlags.StringVar(&cliOpts.BitbucketAppPassword, "bitbucket-app-password", envString("GIT_SYNC_BITBUCKET_APP_PASSWORD", ""),
+		"the Bitbucket Cloud app password to use for HTTPS auth, in place of --username and --password")
+	syncFlags.StringVar(&cliOpts.BitbucketAccessToken, "bitbucket-access-token", envString("GIT_SYNC_BITBUCKET_ACCESS_TOKEN", ""),
+		"the Bitbucket Cloud repository or workspace access token to use for HTTPS auth, in place of --username and --password")
+	syncFlags.StringVar(&cliOpts.AzureDevOpsPAT, "azure-devops-pat", envString("GIT_SYNC_AZURE_DEVOPS_PAT", ""),
+		"the Azure DevOps personal access token to use for HTTPS auth, in place of --username and --password")
+	syncFlags.StringVar(&cliOpts.OAuth2TokenURL, "oauth2-token-url", envString("GIT_SYNC_OAUTH2_TOKEN_URL", ""),
+		"the OAuth2 token endpoint from which to obtain a bearer token for git (client-credentials flow)")
+	syncFlags.StringVar(&cliOpts.OAuth2ClientID, "oauth2-client-id", envString("GIT_SYNC_OAUTH2_CLIENT_ID", ""),
+		"the OAuth2 client ID to use with --oauth2-token-url")
+	syncFlags.StringVar(&cliOpts.OAuth2ClientSecret, "oauth2-client-secret", envString("GIT_SYNC_OAUTH2_CLIENT_SECRET", ""),
+		"the OAuth2 client secret to use with --oauth2-token-url")
+	syncFlags.StringVar(&cliOpts.OAuth2Scope, "oauth2-scope", envString("GIT_SYNC_OAUTH2_SCOPE", ""),
+		"the space-separated OAuth2 scopes to request with --oauth2-token-url")
+	syncFlags.StringVar(&cliOpts.STSURL, "sts-url", envString("GIT_SYNC_STS_URL", ""),
+		"the security token service at which to exchange the workload's OIDC token for a git access token")
+	syncFlags.StringVar(&cliOpts.STSAudience, "sts-audience", envString("GIT_SYNC_STS_AUDIENCE", ""),
+		"the audience to request from --sts-url")
+	syncFlags.StringVar(&cliOpts.STSScope, "sts-scope", envString("GIT_SYNC_STS_SCOPE", ""),
+		"the space-separated scopes to request from --sts-url")
+	syncFlags.StringVar(&cliOpts.STSSubjectTokenFile, "sts-subject-token-file", envString("GIT_SYNC_STS_SUBJECT_TOKEN_FILE", "/var/run/secrets/kubernetes.io/serviceaccount/token"),
+		"the OIDC token (e.g. a projected service account token) to exchange at --sts-url")
+	syncFlags.StringVar(&cliOpts.STSTokenUsername, "sts-token-username", envString("GIT_SYNC_STS_TOKEN_USERNAME", ""),
+		"if set, send the exchanged token as the password for this username instead of as a bearer token")
+	syncFlags.BoolVar(&cliOpts.AzureManagedIdentity, "azure-managed-identity", envBool("GIT_SYNC_AZURE_MANAGED_IDENTITY", false),
+		"authenticate to Azure DevOps with a managed identity token from the Azure instance metadata service")
+	syncFlags.StringVar(&cliOpts.AzureManagedIdentityClientID, "azure-managed-identity-client-id", envString("GIT_SYNC_AZURE_MANAGED_IDENTITY_CLIENT_ID", ""),
+		"the client ID of the user-assigned managed identity to use with --azure-managed-identity")
+	syncFlags.BoolVar(&cliOpts.CodeCommit, "codecommit", envBool("GIT_SYNC_CODECOMMIT", false),
+		"authenticate to an HTTPS AWS CodeCommit --repo with the pod's IAM role (IRSA) or AWS_* credentials")
+	syncFlags.BoolVar(&cliOpts.GCPMetadataToken, "gcp-metadata-token", envBool("GIT_SYNC_GCP_METADATA_TOKEN", false),
+		"authenticate with an access token from the GCE/GKE metadata server (e.g. for Cloud Source Repositories)")
+	syncFlags.StringVar(&cliOpts.GCPServiceAccount, "gcp-service-account", envString("GIT_SYNC_GCP_SERVICE_ACCOUNT", "default"),
+		"the GCP service account whose token --gcp-metadata-token fetches")
+	syncFlags.StringVar(&cliOpts.VaultAddr, "vault-addr", envString("GIT_SYNC_VAULT_ADDR", ""),
+		"the address of the Vault server from which to read git credentials")
+	syncFlags.StringVar(&cliOpts.VaultRole, "vault-role", envString("GIT_SYNC_VAULT_ROLE", ""),
+		"the Vault Kubernetes auth role to log in as")
+	syncFlags.StringVar(&cliOpts.VaultAuthMount, "vault-auth-mount", envString("GIT_SYNC_VAULT_AUTH_MOUNT", "kubernetes"),
+		"the path at which the Vault Kubernetes auth method is mounted")
+	syncFlags.StringVar(&cliOpts.VaultSecretPath, "vault-secret-path", envString("GIT_SYNC_VAULT_SECRET_PATH", ""),
+		"the Vault path of the secret holding git credentials (keys: username and password, and/or ssh), e.g. secret/data/git")
+	syncFlags.StringVar(&cliOpts.VaultSATokenFile, "vault-sa-token-file", envString("GIT_SYNC_VAULT_SA_TOKEN_FILE", "/var/run/secrets/kubernetes.io/serviceaccount/token"),
+		"the service account token to log in to Vault with")
+	syncFlags.Float64Var(&cliOpts.VaultRefresh, "vault-refresh", envFloat("GIT_SYNC_VAULT_REFRESH", 300),
+		"the number of seconds between reads of the Vault secret, to pick up rotated credentials")
+	syncFlags.StringVar(&cliOpts.CredentialHelper, "credential-helper", envString("GIT_SYNC_CREDENTIAL_HELPER", ""),
+		"the absolute path (plus optional arguments) of a git credential helper to get credentials from")
+	syncFlags.StringVar(&cliOpts.AskpassURL, "askpass-url", envString("GIT_SYNC_ASKPASS_URL", ""),
+		"the URL to fetch git credentials from before each sync (served as username=, password= or token= lines)")
 
-	flag.BoolVar(&cliOpts.SSH, "ssh", envBool("GIT_SYNC_SSH", false),
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_HTTP_HEADER", nil), &cliOpts.HTTPHeaders), "http-header",
+		"an extra \"Name: value\" HTTP header to send with git requests (may be repeated; newline-separated in $GIT_SYNC_HTTP_HEADER)")
+	syncFlags.StringVar(&cliOpts.TLSClientCert, "tls-client-cert", envString("GIT_SYNC_TLS_CLIENT_CERT", ""),
+		"the PEM TLS client certificate to present to the git server")
+	syncFlags.StringVar(&cliOpts.TLSClientKey, "tls-client-key", envString("GIT_SYNC_TLS_CLIENT_KEY", ""),
+		"the PEM private key of --tls-client-cert")
+	syncFlags.StringVar(&cliOpts.CACertFile, "ca-cert-file", envString("GIT_SYNC_CA_CERT_FILE", ""),
+		"a PEM CA bundle to verify HTTPS git servers against, e.g. for a private CA")
+	syncFlags.BoolVar(&cliOpts.InsecureSkipTLSVerify, "insecure-skip-tls-verify", envBool("GIT_SYNC_INSECURE_SKIP_TLS_VERIFY", false),
+		"don't verify HTTPS git servers' certificates (INSECURE: for lab environments only)")
+	syncFlags.StringVar(&cliOpts.HTTPUserAgent, "http-user-agent", envString("GIT_SYNC_HTTP_USER_AGENT", ""),
+		"the HTTP user agent for git requests (defaults to git's own, plus the git-sync version)")
+	syncFlags.IntVar(&cliOpts.HTTPLowSpeedLimit, "http-low-speed-limit", envInt("GIT_SYNC_HTTP_LOW_SPEED_LIMIT", 0),
+		"abort HTTP transfers slower than this many bytes per second for --http-low-speed-time seconds, so that stalled fetches fail and are retried (0 to never)")
+	syncFlags.IntVar(&cliOpts.HTTPLowSpeedTime, "http-low-speed-time", envInt("GIT_SYNC_HTTP_LOW_SPEED_TIME", 0),
+		"how many seconds an HTTP transfer may stay below --http-low-speed-limit")
+
+	syncFlags.BoolVar(&cliOpts.SSH, "ssh", envBool("GIT_SYNC_SSH", false),
 		"use SSH for git operations")
+	syncFlags.BoolVar(&cliOpts.SSHKnownHosts, "ssh-known-hosts", envBool("GIT_SYNC_SSH_KNOWN_HOSTS", true),
+		"verify SSH host keys against --ssh-known-hosts-file (disabling this allows man-in-the-middle attacks)")
+	syncFlags.StringVar(&cliOpts.SSHKnownHostsFile, "ssh-known-hosts-file", envString("GIT_SYNC_SSH_KNOWN_HOSTS_FILE", gitsync.DefaultSSHKnownHostsFile),
+		"the known_hosts file to verify SSH host keys against")
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_SSH_KEY_FILE", []string{gitsync.DefaultSSHKeyFile}), &cliOpts.SSHKeyFiles), "ssh-key-file",
+		"an SSH private key to offer (may be repeated; newline-separated in $GIT_SYNC_SSH_KEY_FILE)")
+	syncFlags.StringVar(&cliOpts.SSHAuthSock, "ssh-auth-sock", envString("GIT_SYNC_SSH_AUTH_SOCK", ""),
+		"the socket of an ssh-agent holding the SSH keys, in place of --ssh-key-file")
+	syncFlags.StringVar(&cliOpts.SSHKeyPassphrase, "ssh-key-passphrase", envString("GIT_SYNC_SSH_KEY_PASSPHRASE", ""),
+		"the passphrase of the SSH private key(s)")
+	syncFlags.StringVar(&cliOpts.SSHKeyPassphraseFile, "ssh-key-passphrase-file", envString("GIT_SYNC_SSH_KEY_PASSPHRASE_FILE", ""),
+		"a file holding the passphrase of the SSH private key(s), in place of --ssh-key-passphrase")
+	syncFlags.StringVar(&cliOpts.SSHConfigFile, "ssh-config-file", envString("GIT_SYNC_SSH_CONFIG_FILE", ""),
+		"an ssh_config file for git's SSH connections, for per-host keys, ports or jump hosts")
+	syncFlags.StringVar(&cliOpts.SSHProxyCommand, "ssh-proxy-command", envString("GIT_SYNC_SSH_PROXY_COMMAND", ""),
+		"an ssh ProxyCommand for reaching the git server, e.g. \"nc -X connect -x proxy:3128 %h %p\" for an HTTP proxy")
+	syncFlags.StringVar(&cliOpts.SSHProxyJump, "ssh-proxy-jump", envString("GIT_SYNC_SSH_PROXY_JUMP", ""),
+		"an ssh jump host ([user@]host[:port]) for reaching the git server")
+	syncFlags.Float64Var(&cliOpts.SSHControlPersist, "ssh-control-persist", envFloat("GIT_SYNC_SSH_CONTROL_PERSIST", 0),
+		"the number of seconds to keep an SSH connection open after its last use, for later fetches (even by later syncs, if longer than --wait) to reuse (0 to connect every time)")
+	syncFlags.Var(newStringListValue(envStringList("GIT_SYNC_SSH_HOST_FINGERPRINT", nil), &cliOpts.SSHHostFingerprints), "ssh-host-fingerprint",
+		"an SSH host key fingerprint (SHA256:...) to accept, in place of --ssh-known-hosts-file (may be repeated; newline-separated in $GIT_SYNC_SSH_HOST_FINGERPRINT)")
 
 	setFlagDefaults()
+	// glog's flags, such as -v, are standard ones.
+	syncFlags.AddGoFlagSet(flag.CommandLine)
+}
 
-	flag.Parse()
-	if cliOpts.Repo == "" {
-		fmt.Fprintf(os.Stderr, "ERROR: --repo or $GIT_SYNC_REPO must be provided\n")
-		flag.Usage()
+// syncOptions validates the flags of cmd, which have been parsed, and
+// returns the options for each repo to sync.  oneTime overrides
+// --one-time.
+func syncOptions(cmd *cobra.Command, oneTime bool) []gitsync.Options {
+	warnDeprecated()
+	if err := validateEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		cmd.Usage()
 		os.Exit(1)
 	}
-	if cliOpts.Dest == "" {
-		parts := strings.Split(strings.Trim(cliOpts.Repo, "/"), "/")
-		cliOpts.Dest = parts[len(parts)-1]
+	cliOpts.Logger = log
+	if oneTime {
+		cliOpts.OneTime = true
 	}
-	if strings.Contains(cliOpts.Dest, "/") {
-		fmt.Fprintf(os.Stderr, "ERROR: --dest must be a bare name\n")
-		flag.Usage()
+	if err := validateAdminFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		cmd.Usage()
 		os.Exit(1)
 	}
-	if _, err := exec.LookPath("git"); err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: git executable not found: %v\n", err)
+
+	if maxConcurrentSyncs < 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: --max-concurrent-syncs must not be negative\n")
+		cmd.Usage()
 		os.Exit(1)
 	}
-
-	if cliOpts.Username != "" && cliOpts.Password != "" {
-		if err := setupGitAuth(cliOpts.Username, cliOpts.Password, cliOpts.Repo); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: can't create .netrc file: %v\n", err)
+	if bandwidthBudget < 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: --bandwidth-budget must not be negative\n")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	if metricsTextfileDir != "" {
+		if fi, err := os.Stat(metricsTextfileDir); err != nil || !fi.IsDir() {
+			fmt.Fprintf(os.Stderr, "ERROR: --metrics-textfile-dir must be an existing directory\n")
+			cmd.Usage()
 			os.Exit(1)
 		}
 	}
 
-	if cliOpts.SSH {
-		if err := setupGitSSH(); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: can't configure SSH: %v\n", err)
+	if configFile != "" {
+		if cliOpts.Repo != "" {
+			fmt.Fprintf(os.Stderr, "ERROR: --config and --repo are mutually exclusive\n")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		defaults, all, err := loadConfig(configFile, cliOpts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			cmd.Usage()
 			os.Exit(1)
 		}
+		// Repos added through the admin API get the same defaults.
+		cliOpts = defaults
+		return all
+	}
+	if cliOpts.Repo == "" && (adminAddr != "" || adminGRPCAddr != "") {
+		// Repos will be added through the admin API.
+		return nil
+	}
+
+	if err := cliOpts.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+	return []gitsync.Options{cliOpts}
+}
+
+// validateEnv returns an error for invalid or unknown GIT_SYNC_* variables,
+// which would otherwise be silently ignored.
+func validateEnv() error {
+	if len(envErrors) > 0 {
+		return envErrors[0]
+	}
+	if unknown := unknownEnvs(os.Environ()); len(unknown) > 0 {
+		return fmt.Errorf("unknown environment variables %s: each flag is set by GIT_SYNC_ and its name, e.g. $GIT_SYNC_REPO for --repo", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func validateAdminFlags() error {
+	if adminAddr == "" && adminGRPCAddr == "" {
+		return nil
+	}
+	if adminToken == "" && adminClientCA == "" {
+		return fmt.Errorf("--admin-addr and --admin-grpc-addr require --admin-token or --admin-client-ca")
+	}
+	if (adminTLSCert == "") != (adminTLSKey == "") {
+		return fmt.Errorf("--admin-tls-cert and --admin-tls-key must be given together")
+	}
+	if adminClientCA != "" && adminTLSCert == "" {
+		return fmt.Errorf("--admin-client-ca requires --admin-tls-cert and --admin-tls-key")
 	}
+	return nil
 }
 
 func setFlagDefaults() {
@@ -19,21 +19,20 @@ limitations under the License.
 package main // import "k8s.io/git-sync/cmd/git-sync"
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/signal"
-	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"syscall"
 
+	"github.com/spf13/cobra"
 	"github.com/thockin/glogr"
 	"github.com/thockin/logr"
+	"k8s.io/git-sync/pkg/gitsync"
 )
 
 func newLoggerOrDie() logr.Logger {
@@ -45,7 +44,16 @@ func newLoggerOrDie() logr.Logger {
 	return g
 }
 
+// knownEnvs are the environment variables read by the env* functions.
+var knownEnvs = map[string]bool{}
+
+// envErrors are the invalid values found by the env* functions, which
+// return the default for them.  syncOptions reports them through
+// validateEnv.
+var envErrors []error
+
 func envString(key, def string) string {
+	knownEnvs[key] = true
 	if env := os.Getenv(key); env != "" {
 		return env
 	}
@@ -53,9 +61,11 @@ func envString(key, def string) string {
 }
 
 func envBool(key string, def bool) bool {
+	knownEnvs[key] = true
 	if env := os.Getenv(key); env != "" {
 		res, err := strconv.ParseBool(env)
 		if err != nil {
+			envErrors = append(envErrors, fmt.Errorf("invalid value for $%s: %q is not a bool", key, env))
 			return def
 		}
 
@@ -65,10 +75,11 @@ func envBool(key string, def bool) bool {
 }
 
 func envInt(key string, def int) int {
+	knownEnvs[key] = true
 	if env := os.Getenv(key); env != "" {
 		val, err := strconv.Atoi(env)
 		if err != nil {
-			log.Errorf("invalid value for %q: using default: %v", key, def)
+			envErrors = append(envErrors, fmt.Errorf("invalid value for $%s: %q is not an integer", key, env))
 			return def
 		}
 		return val
@@ -77,10 +88,11 @@ func envInt(key string, def int) int {
 }
 
 func envFloat(key string, def float64) float64 {
+	knownEnvs[key] = true
 	if env := os.Getenv(key); env != "" {
 		val, err := strconv.ParseFloat(env, 64)
 		if err != nil {
-			log.Errorf("invalid value for %q: using default: %v", key, def)
+			envErrors = append(envErrors, fmt.Errorf("invalid value for $%s: %q is not a number", key, env))
 			return def
 		}
 		return val
@@ -88,230 +100,324 @@ func envFloat(key string, def float64) float64 {
 	return def
 }
 
-func main() {
-
-	// From here on, output goes through logging.
-	log.V(0).Infof("starting up: %q", os.Args)
-
-	initialSync := true
-	failCount := 0
-	for {
-		if err := cliOpts.sync(); err != nil {
-			if initialSync || failCount >= cliOpts.MaxSyncFailures {
-				log.Errorf("error syncing repo: %v", err)
-				os.Exit(1)
-			}
-
-			failCount++
-			log.Errorf("unexpected error syncing repo: %v", err)
-			log.V(0).Infof("waiting %v before retrying", waitTime(cliOpts.Wait))
-			time.Sleep(waitTime(cliOpts.Wait))
-			continue
-		}
-		if initialSync {
-			if isHash, err := cliOpts.revIsHash(cliOpts.Rev); err != nil {
-				log.Errorf("can't tell if rev %s is a git hash, exiting", cliOpts.Rev)
-				os.Exit(1)
-			} else if isHash {
-				log.V(0).Infof("rev %s appears to be a git hash, no further sync needed", cliOpts.Rev)
-				sleepForever()
-			}
-			if cliOpts.OneTime {
-				os.Exit(0)
-			}
-			initialSync = false
+// envStringList returns the newline-separated values of key, or def.
+func envStringList(key string, def []string) []string {
+	knownEnvs[key] = true
+	env := os.Getenv(key)
+	if env == "" {
+		return def
+	}
+	values := []string{}
+	for _, v := range strings.Split(env, "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
 		}
-
-		failCount = 0
-		log.V(1).Infof("next sync in %v", waitTime(cliOpts.Wait))
-		time.Sleep(waitTime(cliOpts.Wait))
 	}
+	return values
 }
 
-func waitTime(seconds float64) time.Duration {
-	return time.Duration(int(seconds*1000)) * time.Millisecond
+// envIntList returns the comma-separated integers in key, or def.
+func envIntList(key string, def []int) []int {
+	knownEnvs[key] = true
+	env := os.Getenv(key)
+	if env == "" {
+		return def
+	}
+	values, err := parseIntList(env)
+	if err != nil {
+		envErrors = append(envErrors, fmt.Errorf("invalid value for $%s: %v", key, err))
+		return def
+	}
+	return values
 }
 
-// Do no work, but don't do something that triggers go's runtime into thinking
-// it is deadlocked.
-func sleepForever() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
-	<-c
-	os.Exit(0)
+// parseIntList parses comma-separated integers.
+func parseIntList(s string) ([]int, error) {
+	values := []int{}
+	for _, v := range strings.Split(s, ",") {
+		val, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", v)
+		}
+		values = append(values, val)
+	}
+	return values, nil
 }
 
-// updateSymlink atomically swaps the symlink to point at the specified directory and cleans up the previous worktree.
-func updateSymlink(gitRoot, link, newDir string) error {
-	// Get currently-linked repo directory (to be removed), unless it doesn't exist
-	currentDir, err := filepath.EvalSymlinks(path.Join(gitRoot, link))
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("error accessing symlink: %v", err)
+// envOptionalInt returns the integer in key, or nil if key is unset.
+func envOptionalInt(key string) *int {
+	knownEnvs[key] = true
+	env := os.Getenv(key)
+	if env == "" {
+		return nil
 	}
-
-	// newDir is /git/rev-..., we need to change it to relative path.
-	// Volume in other container may not be mounted at /git, so the symlink can't point to /git.
-	newDirRelative, err := filepath.Rel(gitRoot, newDir)
+	val, err := strconv.Atoi(env)
 	if err != nil {
-		return fmt.Errorf("error converting to relative path: %v", err)
+		envErrors = append(envErrors, fmt.Errorf("invalid value for $%s: %q is not an integer", key, env))
+		return nil
 	}
+	return &val
+}
 
-	if _, err := runCommand(gitRoot, "ln", "-snf", newDirRelative, "tmp-link"); err != nil {
-		return fmt.Errorf("error creating symlink: %v", err)
+// envByteSize returns the size in key, as for parseByteSize, or def.
+func envByteSize(key string, def int64) int64 {
+	knownEnvs[key] = true
+	env := os.Getenv(key)
+	if env == "" {
+		return def
 	}
-	log.V(1).Infof("created symlink %s -> %s", "tmp-link", newDirRelative)
-
-	if _, err := runCommand(gitRoot, "mv", "-T", "tmp-link", link); err != nil {
-		return fmt.Errorf("error replacing symlink: %v", err)
+	val, err := parseByteSize(env)
+	if err != nil {
+		envErrors = append(envErrors, fmt.Errorf("invalid value for $%s: %v", key, err))
+		return def
 	}
-	log.V(1).Infof("renamed symlink %s to %s", "tmp-link", link)
+	return val
+}
 
-	// Clean up previous worktree
-	if len(currentDir) > 0 {
-		if err = os.RemoveAll(currentDir); err != nil {
-			return fmt.Errorf("error removing directory: %v", err)
+// parseByteSize parses a number of bytes, optionally followed by K, M or G
+// (or Ki, Mi or Gi) for multiples of 1024.
+func parseByteSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}} {
+		if strings.HasSuffix(num, unit.suffix) || strings.HasSuffix(num, unit.suffix+"i") {
+			num = strings.TrimSuffix(strings.TrimSuffix(num, "i"), unit.suffix)
+			mult = unit.mult
+			break
 		}
+	}
+	val, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return val * mult, nil
+}
 
-		log.V(1).Infof("removed %s", currentDir)
-
-		_, err := runCommand(gitRoot, "git", "worktree", "prune")
-		if err != nil {
-			return err
+// unknownEnvs returns the GIT_SYNC_* variables in environ, a list of
+// "KEY=value" strings, that no flag reads, e.g. misspelled ones.
+func unknownEnvs(environ []string) []string {
+	unknown := []string{}
+	for _, kv := range environ {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if !strings.HasPrefix(key, "GIT_SYNC_") || knownEnvs[key] {
+			continue
 		}
-
-		log.V(1).Infof("pruned old worktrees")
+		// Kubernetes sets these for a Service named git-sync, and git-sync
+		// sets GIT_SYNC_ASKPASS_* for its own children.
+		if key == "GIT_SYNC_PORT" || strings.HasPrefix(key, "GIT_SYNC_PORT_") ||
+			strings.HasPrefix(key, "GIT_SYNC_SERVICE_") || strings.HasPrefix(key, "GIT_SYNC_ASKPASS_") {
+			continue
+		}
+		unknown = append(unknown, key)
 	}
+	sort.Strings(unknown)
+	return unknown
+}
 
-	return nil
+// stringListValue is a repeatable string flag.  Values given on the command
+// line replace, rather than add to, the default.
+type stringListValue struct {
+	list *[]string
+	set  bool
 }
 
-// addWorktreeAndSwap creates a new worktree and calls updateSymlink to swap the symlink to point to the new worktree
-func (o *SyncOption) addWorktreeAndSwap(hash string) error {
-	log.V(0).Infof("syncing to %s (%s)", o.Rev, hash)
+func newStringListValue(def []string, p *[]string) *stringListValue {
+	*p = def
+	return &stringListValue{list: p}
+}
 
-	// Update from the remote.
-	if _, err := runCommand(o.Root, "git", "fetch", "--tags", "origin", o.Branch); err != nil {
-		return err
+func (v *stringListValue) String() string {
+	if v.list == nil {
+		return ""
 	}
+	return strings.Join(*v.list, ",")
+}
 
-	// Make a worktree for this exact git hash.
-	worktreePath := path.Join(o.Root, "rev-"+hash)
-	_, err := runCommand(o.Root, "git", "worktree", "add", worktreePath, "origin/"+o.Branch)
-	if err != nil {
-		return err
+func (v *stringListValue) Type() string {
+	return "strings"
+}
+
+func (v *stringListValue) Set(s string) error {
+	if !v.set {
+		*v.list = nil
+		v.set = true
 	}
-	log.V(0).Infof("added worktree %s for origin/%s", worktreePath, o.Branch)
+	*v.list = append(*v.list, s)
+	return nil
+}
 
-	// The .git file in the worktree directory holds a reference to
-	// /git/.git/worktrees/<worktree-dir-name>. Replace it with a reference
-	// using relative paths, so that other containers can use a different volume
-	// mount name.
-	worktreePathRelative, err := filepath.Rel(o.Root, worktreePath)
-	if err != nil {
-		return err
+// intListValue is a repeatable flag of comma-separated integers.  Values
+// given on the command line replace, rather than add to, the default.
+type intListValue struct {
+	list *[]int
+	set  bool
+}
+
+func newIntListValue(def []int, p *[]int) *intListValue {
+	*p = def
+	return &intListValue{list: p}
+}
+
+func (v *intListValue) String() string {
+	if v.list == nil {
+		return ""
 	}
-	gitDirRef := []byte(path.Join("gitdir: ../.git/worktrees", worktreePathRelative) + "\n")
-	if err = ioutil.WriteFile(path.Join(worktreePath, ".git"), gitDirRef, 0644); err != nil {
-		return err
+	strs := []string{}
+	for _, i := range *v.list {
+		strs = append(strs, strconv.Itoa(i))
 	}
+	return strings.Join(strs, ",")
+}
 
-	// Reset the worktree's working copy to the specific rev.
-	_, err = runCommand(worktreePath, "git", "reset", "--hard", hash)
+func (v *intListValue) Type() string {
+	return "ints"
+}
+
+func (v *intListValue) Set(s string) error {
+	values, err := parseIntList(s)
 	if err != nil {
 		return err
 	}
-	log.V(0).Infof("reset worktree %s to %s", worktreePath, hash)
-
-	if o.Chmod != 0 {
-		// set file permissions
-		_, err = runCommand("", "chmod", "-R", strconv.Itoa(o.Chmod), worktreePath)
-		if err != nil {
-			return err
-		}
+	if !v.set {
+		*v.list = nil
+		v.set = true
 	}
+	*v.list = append(*v.list, values...)
+	return nil
+}
 
-	return updateSymlink(o.Root, o.Dest, worktreePath)
+// optionalIntValue is a flag holding an integer that is nil until set, for
+// options whose 0 means something other than unset.
+type optionalIntValue struct {
+	value **int
 }
 
-func remoteHashForRef(ref, gitRoot string) (string, error) {
-	output, err := runCommand(gitRoot, "git", "ls-remote", "-q", "origin", ref)
-	if err != nil {
-		return "", err
-	}
-	parts := strings.Split(string(output), "\t")
-	return parts[0], nil
+func newOptionalIntValue(def *int, p **int) *optionalIntValue {
+	*p = def
+	return &optionalIntValue{value: p}
 }
 
-func cmdForLog(command string, args ...string) string {
-	if strings.ContainsAny(command, " \t\n") {
-		command = fmt.Sprintf("%q", command)
+func (v *optionalIntValue) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
 	}
-	for i := range args {
-		if strings.ContainsAny(args[i], " \t\n") {
-			args[i] = fmt.Sprintf("%q", args[i])
-		}
-	}
-	return command + " " + strings.Join(args, " ")
+	return strconv.Itoa(**v.value)
 }
 
-func runCommand(cwd, command string, args ...string) (string, error) {
-	log.V(5).Infof("run(%q): %s", cwd, cmdForLog(command, args...))
+func (v *optionalIntValue) Type() string {
+	return "int"
+}
 
-	cmd := exec.Command(command, args...)
-	if cwd != "" {
-		cmd.Dir = cwd
-	}
-	output, err := cmd.CombinedOutput()
+func (v *optionalIntValue) Set(s string) error {
+	val, err := strconv.Atoi(s)
 	if err != nil {
-		return "", fmt.Errorf("error running command: %v: %q", err, string(output))
+		return err
 	}
+	*v.value = &val
+	return nil
+}
 
-	return string(output), nil
+// byteSizeValue is a flag holding a number of bytes, as for parseByteSize.
+type byteSizeValue struct {
+	size *int64
 }
 
-func setupGitAuth(username, password, gitURL string) error {
-	log.V(1).Infof("setting up the git credential cache")
-	cmd := exec.Command("git", "config", "--global", "credential.helper", "cache")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("error setting up git credentials %v: %s", err, string(output))
+func newByteSizeValue(def int64, p *int64) *byteSizeValue {
+	*p = def
+	return &byteSizeValue{size: p}
+}
+
+func (v *byteSizeValue) String() string {
+	if v.size == nil {
+		return ""
 	}
+	return strconv.FormatInt(*v.size, 10)
+}
+
+func (v *byteSizeValue) Type() string {
+	return "size"
+}
 
-	cmd = exec.Command("git", "credential", "approve")
-	stdin, err := cmd.StdinPipe()
+func (v *byteSizeValue) Set(s string) error {
+	val, err := parseByteSize(s)
 	if err != nil {
 		return err
 	}
-	creds := fmt.Sprintf("url=%v\nusername=%v\npassword=%v\n", gitURL, username, password)
-	io.Copy(stdin, bytes.NewBufferString(creds))
-	stdin.Close()
-	output, err = cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("error setting up git credentials %v: %s", err, string(output))
-	}
-
+	*v.size = val
 	return nil
 }
 
-func setupGitSSH() error {
-	log.V(1).Infof("setting up git SSH credentials")
+func main() {
+	if gitsync.IsAskpassInvocation() {
+		gitsync.RunAskpass()
+	}
+	root := newRootCommand()
+	root.SetArgs(longFlags(root, os.Args[1:]))
+	if err := root.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
 
-	var pathToSSHSecret = "/etc/git-secret/ssh"
+// runSync implements the run and once commands.
+func runSync(cmd *cobra.Command, oneTime bool) {
+	all := syncOptions(cmd, oneTime)
 
-	fileInfo, err := os.Stat(pathToSSHSecret)
-	if err != nil {
-		return fmt.Errorf("error: could not find SSH key Secret: %v", err)
+	for _, opts := range all {
+		if opts.AddUser {
+			if err := setupUser(); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: can't add user: %v\n", err)
+				os.Exit(1)
+			}
+			break
+		}
 	}
 
-	if fileInfo.Mode() != 0400 {
-		return fmt.Errorf("Permissions %s for SSH key are too open. It is recommended to mount secret volume with `defaultMode: 256` (decimal number for octal 0400).", fileInfo.Mode())
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		log.V(0).Infof("caught %v, exiting", sig)
+		cancel()
+	}()
+
+	// Each repo syncs on its own schedule.  If one fails for good, exit, so
+	// that the failure isn't hidden by a process that is still running.
+	manager := gitsync.NewManager(ctx)
+	manager.SetMaxConcurrentSyncs(maxConcurrentSyncs)
+	manager.SetBandwidthBudget(bandwidthBudget)
+	if metricsTextfileDir != "" {
+		manager.SetMetricsTextfile(filepath.Join(metricsTextfileDir, "git-sync.prom"))
 	}
-
-	//set env variable GIT_SSH_COMMAND to force git use customized ssh command
-	err = os.Setenv("GIT_SSH_COMMAND", fmt.Sprintf("ssh -q -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -i %s", pathToSSHSecret))
-	if err != nil {
-		return fmt.Errorf("Failed to set the GIT_SSH_COMMAND env var: %v", err)
+	if err := manager.SetStatsd(statsdAddr, statsdPrefix, statsdDogStatsD); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: --statsd-addr: %v\n", err)
+		os.Exit(1)
+	}
+	for _, opts := range all {
+		if err := manager.Add(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s: %v\n", opts.Repo, err)
+			os.Exit(1)
+		}
 	}
 
-	return nil
+	// From here on, output goes through logging.
+	log.V(0).Infof("starting up: %q", os.Args)
+
+	if adminAddr != "" || adminGRPCAddr != "" {
+		if err := serveAdmin(manager); err != nil {
+			log.Errorf("%v", err)
+			os.Exit(1)
+		}
+		// Repos come and go through the API, and their failures show in
+		// their status, so run until told to stop.
+		<-ctx.Done()
+		return
+	}
+	if err := manager.Wait(); err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
 }
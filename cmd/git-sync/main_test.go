@@ -18,6 +18,7 @@ package main
 
 import (
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -98,3 +99,126 @@ func TestEnvInt(t *testing.T) {
 		}
 	}
 }
+
+func TestEnvStringList(t *testing.T) {
+	cases := []struct {
+		value string
+		def   []string
+		exp   []string
+	}{
+		{"", nil, nil},
+		{"", []string{"a"}, []string{"a"}},
+		{"b", []string{"a"}, []string{"b"}},
+		{"b\nc", nil, []string{"b", "c"}},
+		{" b \n\n c\n", nil, []string{"b", "c"}},
+	}
+
+	for _, testCase := range cases {
+		os.Setenv(testKey, testCase.value)
+		val := envStringList(testKey, testCase.def)
+		if !reflect.DeepEqual(val, testCase.exp) {
+			t.Fatalf("expected %v but %v returned", testCase.exp, val)
+		}
+	}
+}
+
+func TestEnvIntList(t *testing.T) {
+	cases := []struct {
+		value string
+		def   []int
+		exp   []int
+	}{
+		{"", nil, nil},
+		{"", []int{200}, []int{200}},
+		{"204", []int{200}, []int{204}},
+		{"200, 202,204", nil, []int{200, 202, 204}},
+		{"200,abc", []int{200}, []int{200}},
+	}
+
+	for _, testCase := range cases {
+		os.Setenv(testKey, testCase.value)
+		val := envIntList(testKey, testCase.def)
+		if !reflect.DeepEqual(val, testCase.exp) {
+			t.Fatalf("expected %v but %v returned", testCase.exp, val)
+		}
+	}
+	os.Setenv(testKey, "")
+	envErrors = nil
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		value string
+		exp   int64
+		err   bool
+	}{
+		{"0", 0, false},
+		{"1500", 1500, false},
+		{"10K", 10 << 10, false},
+		{"100Mi", 100 << 20, false},
+		{" 2G ", 2 << 30, false},
+		{"", 0, true},
+		{"5i", 0, true},
+		{"-1", 0, true},
+		{"1T", 0, true},
+	}
+
+	for _, testCase := range cases {
+		val, err := parseByteSize(testCase.value)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%q: expected error %v but got %v", testCase.value, testCase.err, err)
+		}
+		if val != testCase.exp {
+			t.Fatalf("%q: expected %d but %d returned", testCase.value, testCase.exp, val)
+		}
+	}
+}
+
+func TestUnknownEnvs(t *testing.T) {
+	knownEnvs["GIT_SYNC_TEST_KNOWN"] = true
+	environ := []string{
+		"HOME=/root",
+		"GIT_SYNC_TEST_KNOWN=1",
+		"GIT_SYNC_REOP=https://a/a",
+		"GIT_SYNC_SERVICE_HOST=10.0.0.1",
+		"GIT_SYNC_PORT_8080_TCP=tcp://10.0.0.1:8080",
+		"GIT_SYNC_ASKPASS_PASSWORD=x",
+		"GIT_SYNC_BRANC=main",
+	}
+	expected := []string{"GIT_SYNC_BRANC", "GIT_SYNC_REOP"}
+	if unknown := unknownEnvs(environ); !reflect.DeepEqual(unknown, expected) {
+		t.Fatalf("expected %v but %v returned", expected, unknown)
+	}
+}
+
+func TestEnvErrors(t *testing.T) {
+	envErrors = nil
+	os.Setenv(testKey, "abcd")
+	envInt(testKey, 1)
+	envFloat(testKey, 1)
+	envBool(testKey, true)
+	os.Setenv(testKey, "")
+	if len(envErrors) != 3 {
+		t.Fatalf("expected 3 errors but %d were recorded: %v", len(envErrors), envErrors)
+	}
+	envErrors = nil
+}
+
+func TestHasPasswdEntry(t *testing.T) {
+	passwd := "root:x:0:0:root:/root:/bin/ash\nnobody:x:65534:65534:nobody:/:/sbin/nologin\n"
+	cases := []struct {
+		uid int
+		exp bool
+	}{
+		{0, true},
+		{65534, true},
+		{1000, false},
+	}
+
+	for _, testCase := range cases {
+		val := hasPasswdEntry(passwd, testCase.uid)
+		if val != testCase.exp {
+			t.Fatalf("uid %d: expected %v but %v returned", testCase.uid, testCase.exp, val)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/git-sync/pkg/gitsync"
+)
+
+// statusFlags locate the status of a running git-sync, for the wait and
+// status commands.
+type statusFlags struct {
+	file  *string
+	url   *string
+	token *string
+}
+
+func addStatusFlags(fs *pflag.FlagSet) statusFlags {
+	return statusFlags{
+		file: fs.String("status-file", envString("GIT_SYNC_STATUS_FILE", ""),
+			"the --status-file of the git-sync"),
+		url: fs.String("url", "",
+			"the admin API URL of the repo, e.g. http://localhost:8443/repos/NAME"),
+		token: fs.String("token", envString("GIT_SYNC_ADMIN_TOKEN", ""),
+			"the bearer token to send to --url"),
+	}
+}
+
+// reader returns a function that reads the status, or an error if the
+// flags don't say where from.
+func (f statusFlags) reader() (func() (gitsync.Status, error), error) {
+	if (*f.file == "") == (*f.url == "") {
+		return nil, fmt.Errorf("exactly one of --status-file and --url must be provided")
+	}
+	if *f.url != "" {
+		return func() (gitsync.Status, error) {
+			return fetchStatus(*f.url, *f.token)
+		}, nil
+	}
+	return func() (gitsync.Status, error) {
+		return gitsync.ReadStatusFile(*f.file)
+	}, nil
+}
+
+// fetchStatus gets a repo's status from the admin API.
+func fetchStatus(url, token string) (gitsync.Status, error) {
+	var st gitsync.Status
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return st, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return st, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return st, fmt.Errorf("error parsing status: %v", err)
+	}
+	return st, nil
+}
+
+// newStatusCommand returns `git-sync status`, which prints a running
+// git-sync's status, for debugging.  It exits 1 if the status can't be read.
+func newStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Print the status of a running git-sync",
+		Args:  cobra.NoArgs,
+	}
+	source := addStatusFlags(cmd.Flags())
+	output := cmd.Flags().String("output", "text",
+		"the output format: text or json")
+	cmd.Run = func(cmd *cobra.Command, args []string) { runStatus(cmd, source, *output) }
+	return cmd
+}
+
+func runStatus(cmd *cobra.Command, source statusFlags, output string) {
+	read, err := source.reader()
+	if err == nil && output != "text" && output != "json" {
+		err = fmt.Errorf("--output must be text or json")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	st, err := read()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: can't read status: %v\n", err)
+		os.Exit(1)
+	}
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(st)
+		return
+	}
+	printStatus(os.Stdout, st, time.Now())
+}
+
+// printStatus writes st for people, with times relative to now.
+func printStatus(w io.Writer, st gitsync.Status, now time.Time) {
+	ago := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%v ago)", t.Format(time.RFC3339), now.Sub(t)/time.Second*time.Second)
+	}
+	orNone := func(s string) string {
+		if s == "" {
+			return "none"
+		}
+		return s
+	}
+	state := "syncing"
+	switch {
+	case st.Stopped:
+		state = "stopped"
+	case st.Paused:
+		state = "paused"
+	case st.Stale:
+		state = "stale (remote unreachable)"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s\n", st.Name)
+	fmt.Fprintf(tw, "Repo:\t%s\n", st.Repo)
+	fmt.Fprintf(tw, "Rev:\t%s\n", st.Rev)
+	fmt.Fprintf(tw, "Hash:\t%s\n", orNone(st.Hash))
+	if st.Staged != "" {
+		fmt.Fprintf(tw, "Staged:\t%s\n", st.Staged)
+	}
+	fmt.Fprintf(tw, "State:\t%s\n", state)
+	fmt.Fprintf(tw, "Last sync:\t%s\n", ago(st.LastSync))
+	fmt.Fprintf(tw, "Last success:\t%s\n", ago(st.LastSuccess))
+	fmt.Fprintf(tw, "Last error:\t%s\n", orNone(st.LastError))
+	fmt.Fprintf(tw, "Consecutive failures:\t%d\n", st.ConsecutiveFail)
+	if p := st.Transfer; p != nil {
+		fmt.Fprintf(tw, "Transfer:\t%s %d%% (%d/%d), %d bytes at %d bytes/s, last progress %s\n",
+			p.Stage, p.Percent, p.Objects, p.Total, p.Bytes, p.BytesPerSecond, ago(p.Updated))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/git-sync/pkg/gitsync"
+)
+
+func TestPrintStatus(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	synced := gitsync.Status{
+		Name:        "a",
+		Repo:        "https://a/a",
+		Rev:         "HEAD",
+		Hash:        "abc",
+		LastSync:    now.Add(-1500 * time.Millisecond),
+		LastSuccess: now.Add(-90 * time.Second),
+		LastError:   "no route to host",
+	}
+
+	cases := []struct {
+		status   gitsync.Status
+		expected []string
+	}{
+		{synced, []string{
+			"Hash:                  abc\n",
+			"State:                 syncing\n",
+			"Last sync:             2020-01-02T03:04:03Z (1s ago)\n",
+			"Last success:          2020-01-02T03:02:35Z (1m30s ago)\n",
+			"Last error:            no route to host\n",
+		}},
+		{gitsync.Status{Name: "a", Paused: true}, []string{
+			"Hash:                  none\n",
+			"State:                 paused\n",
+			"Last success:          never\n",
+		}},
+	}
+
+	for _, testCase := range cases {
+		var buf bytes.Buffer
+		printStatus(&buf, testCase.status, now)
+		for _, line := range testCase.expected {
+			if !strings.Contains(buf.String(), line) {
+				t.Fatalf("expected %q in output but got:\n%s", line, buf.String())
+			}
+		}
+	}
+}
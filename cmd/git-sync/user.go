@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const passwdFile = "/etc/passwd"
+
+// hasPasswdEntry returns true if passwd has an entry for uid.
+func hasPasswdEntry(passwd string, uid int) bool {
+	for _, line := range strings.Split(passwd, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) >= 3 && fields[2] == strconv.Itoa(uid) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWritableDir returns true if files can be created in dir.
+func isWritableDir(dir string) bool {
+	f, err := ioutil.TempFile(dir, ".git-sync-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// setupUser makes git and ssh work when running as an arbitrary UID (e.g.
+// on OpenShift): it adds an /etc/passwd entry for the UID if there is none,
+// makes sure $HOME is writable, and tells git to trust the repository even
+// though its files may be owned by another UID.
+func setupUser() error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("--add-user is not supported on Windows")
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	home := os.TempDir()
+
+	passwd, err := ioutil.ReadFile(passwdFile)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", passwdFile, err)
+	}
+	if !hasPasswdEntry(string(passwd), uid) {
+		log.V(1).Infof("adding UID %d to %s", uid, passwdFile)
+		f, err := os.OpenFile(passwdFile, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("can't add UID %d to %s (it must be writable): %v", uid, passwdFile, err)
+		}
+		entry := fmt.Sprintf("git-sync:x:%d:%d::%s:/sbin/nologin\n", uid, gid, home)
+		if !strings.HasSuffix(string(passwd), "\n") && len(passwd) > 0 {
+			entry = "\n" + entry
+		}
+		_, err = f.WriteString(entry)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error writing %s: %v", passwdFile, err)
+		}
+	}
+
+	if h := os.Getenv("HOME"); h == "" || h == "/" || !isWritableDir(h) {
+		log.V(1).Infof("setting HOME to %s", home)
+		if err := os.Setenv("HOME", home); err != nil {
+			return fmt.Errorf("Failed to set the HOME env var: %v", err)
+		}
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		// An image without git can only sync with --backend=gogit, which
+		// doesn't check the owner of the clone.
+		return nil
+	}
+	cmd := exec.Command("git", "config", "--global", "--replace-all", "safe.directory", "*", `^\*$`)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error setting up git safe.directory %v: %s", err, string(output))
+	}
+	return nil
+}
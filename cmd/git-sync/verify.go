@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/git-sync/pkg/gitsync"
+)
+
+// runVerify implements `git-sync verify`, which checks the configuration
+// of every repo without syncing, e.g. in CI.  It takes the flags of run, and
+// exits 1 if any check fails.
+func runVerify(cmd *cobra.Command) {
+	all := syncOptions(cmd, false)
+	ctx := context.Background()
+
+	failed := false
+	for _, opts := range all {
+		s, err := gitsync.New(ctx, opts)
+		if err != nil {
+			fmt.Printf("FAIL  %s: setup: %v\n", opts.Repo, err)
+			failed = true
+			continue
+		}
+		for _, c := range s.Verify(ctx) {
+			if c.Err != nil {
+				fmt.Printf("FAIL  %s: %s: %v\n", opts.Repo, c.Name, c.Err)
+				failed = true
+				continue
+			}
+			if c.Detail != "" {
+				fmt.Printf("ok    %s: %s: %s\n", opts.Repo, c.Name, c.Detail)
+			} else {
+				fmt.Printf("ok    %s: %s\n", opts.Repo, c.Name)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newWaitCommand returns `git-sync wait`, which blocks until a repo has
+// synced, for use as an exec readiness probe or in an initContainer.  It
+// exits 0 once the repo has synced, and 1 on timeout or bad usage.
+func newWaitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "wait",
+		Short: "Wait for a running git-sync to sync",
+		Args:  cobra.NoArgs,
+	}
+	source := addStatusFlags(cmd.Flags())
+	rev := cmd.Flags().String("rev", "",
+		"the rev or hash that must have been synced (defaults to any)")
+	timeout := cmd.Flags().Float64("timeout", 0,
+		"the max number of seconds to wait (0 for no limit)")
+	interval := cmd.Flags().Float64("interval", 1,
+		"the number of seconds between checks")
+	cmd.Run = func(cmd *cobra.Command, args []string) { runWait(cmd, source, *rev, *timeout, *interval) }
+	return cmd
+}
+
+func runWait(cmd *cobra.Command, source statusFlags, rev string, timeout, interval float64) {
+	read, err := source.reader()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(time.Duration(timeout * float64(time.Second)))
+	}
+	for {
+		st, err := read()
+		if err == nil && st.Synced(rev) {
+			return
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			if err == nil {
+				err = fmt.Errorf("%s has published %q (last error: %q)", st.Name, st.Hash, st.LastError)
+			}
+			fmt.Fprintf(os.Stderr, "ERROR: timed out: %v\n", err)
+			os.Exit(1)
+		}
+		time.Sleep(time.Duration(interval * float64(time.Second)))
+	}
+}
@@ -0,0 +1,591 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: admin.proto
+
+/*
+Package adminpb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	admin.proto
+
+It has these top-level messages:
+
+	ListReposRequest
+	ListReposResponse
+	RepoRequest
+	PutRepoRequest
+	PutRepoResponse
+	RemoveRepoResponse
+	RepoStatus
+*/
+package adminpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type ListReposRequest struct {
+}
+
+func (m *ListReposRequest) Reset()                    { *m = ListReposRequest{} }
+func (m *ListReposRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListReposRequest) ProtoMessage()               {}
+func (*ListReposRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type ListReposResponse struct {
+	Repos []*RepoStatus `protobuf:"bytes,1,rep,name=repos" json:"repos,omitempty"`
+}
+
+func (m *ListReposResponse) Reset()                    { *m = ListReposResponse{} }
+func (m *ListReposResponse) String() string            { return proto.CompactTextString(m) }
+func (*ListReposResponse) ProtoMessage()               {}
+func (*ListReposResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *ListReposResponse) GetRepos() []*RepoStatus {
+	if m != nil {
+		return m.Repos
+	}
+	return nil
+}
+
+type RepoRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *RepoRequest) Reset()                    { *m = RepoRequest{} }
+func (m *RepoRequest) String() string            { return proto.CompactTextString(m) }
+func (*RepoRequest) ProtoMessage()               {}
+func (*RepoRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *RepoRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type PutRepoRequest struct {
+	Name        string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	OptionsJson string `protobuf:"bytes,2,opt,name=options_json,json=optionsJson" json:"options_json,omitempty"`
+}
+
+func (m *PutRepoRequest) Reset()                    { *m = PutRepoRequest{} }
+func (m *PutRepoRequest) String() string            { return proto.CompactTextString(m) }
+func (*PutRepoRequest) ProtoMessage()               {}
+func (*PutRepoRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *PutRepoRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PutRepoRequest) GetOptionsJson() string {
+	if m != nil {
+		return m.OptionsJson
+	}
+	return ""
+}
+
+type PutRepoResponse struct {
+	Created bool        `protobuf:"varint,1,opt,name=created" json:"created,omitempty"`
+	Status  *RepoStatus `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
+}
+
+func (m *PutRepoResponse) Reset()                    { *m = PutRepoResponse{} }
+func (m *PutRepoResponse) String() string            { return proto.CompactTextString(m) }
+func (*PutRepoResponse) ProtoMessage()               {}
+func (*PutRepoResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *PutRepoResponse) GetCreated() bool {
+	if m != nil {
+		return m.Created
+	}
+	return false
+}
+
+func (m *PutRepoResponse) GetStatus() *RepoStatus {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+type RemoveRepoResponse struct {
+}
+
+func (m *RemoveRepoResponse) Reset()                    { *m = RemoveRepoResponse{} }
+func (m *RemoveRepoResponse) String() string            { return proto.CompactTextString(m) }
+func (*RemoveRepoResponse) ProtoMessage()               {}
+func (*RemoveRepoResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type RepoStatus struct {
+	Name                string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Repo                string `protobuf:"bytes,2,opt,name=repo" json:"repo,omitempty"`
+	Rev                 string `protobuf:"bytes,3,opt,name=rev" json:"rev,omitempty"`
+	Hash                string `protobuf:"bytes,4,opt,name=hash" json:"hash,omitempty"`
+	Staged              string `protobuf:"bytes,5,opt,name=staged" json:"staged,omitempty"`
+	LastSync            int64  `protobuf:"varint,6,opt,name=last_sync,json=lastSync" json:"last_sync,omitempty"`
+	LastSuccess         int64  `protobuf:"varint,7,opt,name=last_success,json=lastSuccess" json:"last_success,omitempty"`
+	LastError           string `protobuf:"bytes,8,opt,name=last_error,json=lastError" json:"last_error,omitempty"`
+	ConsecutiveFailures int32  `protobuf:"varint,9,opt,name=consecutive_failures,json=consecutiveFailures" json:"consecutive_failures,omitempty"`
+	Stale               bool   `protobuf:"varint,10,opt,name=stale" json:"stale,omitempty"`
+	Paused              bool   `protobuf:"varint,11,opt,name=paused" json:"paused,omitempty"`
+	Stopped             bool   `protobuf:"varint,12,opt,name=stopped" json:"stopped,omitempty"`
+}
+
+func (m *RepoStatus) Reset()                    { *m = RepoStatus{} }
+func (m *RepoStatus) String() string            { return proto.CompactTextString(m) }
+func (*RepoStatus) ProtoMessage()               {}
+func (*RepoStatus) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *RepoStatus) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RepoStatus) GetRepo() string {
+	if m != nil {
+		return m.Repo
+	}
+	return ""
+}
+
+func (m *RepoStatus) GetRev() string {
+	if m != nil {
+		return m.Rev
+	}
+	return ""
+}
+
+func (m *RepoStatus) GetHash() string {
+	if m != nil {
+		return m.Hash
+	}
+	return ""
+}
+
+func (m *RepoStatus) GetStaged() string {
+	if m != nil {
+		return m.Staged
+	}
+	return ""
+}
+
+func (m *RepoStatus) GetLastSync() int64 {
+	if m != nil {
+		return m.LastSync
+	}
+	return 0
+}
+
+func (m *RepoStatus) GetLastSuccess() int64 {
+	if m != nil {
+		return m.LastSuccess
+	}
+	return 0
+}
+
+func (m *RepoStatus) GetLastError() string {
+	if m != nil {
+		return m.LastError
+	}
+	return ""
+}
+
+func (m *RepoStatus) GetConsecutiveFailures() int32 {
+	if m != nil {
+		return m.ConsecutiveFailures
+	}
+	return 0
+}
+
+func (m *RepoStatus) GetStale() bool {
+	if m != nil {
+		return m.Stale
+	}
+	return false
+}
+
+func (m *RepoStatus) GetPaused() bool {
+	if m != nil {
+		return m.Paused
+	}
+	return false
+}
+
+func (m *RepoStatus) GetStopped() bool {
+	if m != nil {
+		return m.Stopped
+	}
+	return false
+}
+
+func init() {
+	proto.RegisterType((*ListReposRequest)(nil), "gitsync.admin.ListReposRequest")
+	proto.RegisterType((*ListReposResponse)(nil), "gitsync.admin.ListReposResponse")
+	proto.RegisterType((*RepoRequest)(nil), "gitsync.admin.RepoRequest")
+	proto.RegisterType((*PutRepoRequest)(nil), "gitsync.admin.PutRepoRequest")
+	proto.RegisterType((*PutRepoResponse)(nil), "gitsync.admin.PutRepoResponse")
+	proto.RegisterType((*RemoveRepoResponse)(nil), "gitsync.admin.RemoveRepoResponse")
+	proto.RegisterType((*RepoStatus)(nil), "gitsync.admin.RepoStatus")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Admin service
+
+type AdminClient interface {
+	ListRepos(ctx context.Context, in *ListReposRequest, opts ...grpc.CallOption) (*ListReposResponse, error)
+	GetRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error)
+	PutRepo(ctx context.Context, in *PutRepoRequest, opts ...grpc.CallOption) (*PutRepoResponse, error)
+	RemoveRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RemoveRepoResponse, error)
+	SyncRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error)
+	PauseRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error)
+	ResumeRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error)
+	PromoteRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error)
+}
+
+type adminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAdminClient(cc *grpc.ClientConn) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListRepos(ctx context.Context, in *ListReposRequest, opts ...grpc.CallOption) (*ListReposResponse, error) {
+	out := new(ListReposResponse)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/ListRepos", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error) {
+	out := new(RepoStatus)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/GetRepo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) PutRepo(ctx context.Context, in *PutRepoRequest, opts ...grpc.CallOption) (*PutRepoResponse, error) {
+	out := new(PutRepoResponse)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/PutRepo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RemoveRepoResponse, error) {
+	out := new(RemoveRepoResponse)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/RemoveRepo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SyncRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error) {
+	out := new(RepoStatus)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/SyncRepo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) PauseRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error) {
+	out := new(RepoStatus)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/PauseRepo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ResumeRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error) {
+	out := new(RepoStatus)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/ResumeRepo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) PromoteRepo(ctx context.Context, in *RepoRequest, opts ...grpc.CallOption) (*RepoStatus, error) {
+	out := new(RepoStatus)
+	err := grpc.Invoke(ctx, "/gitsync.admin.Admin/PromoteRepo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Admin service
+
+type AdminServer interface {
+	ListRepos(context.Context, *ListReposRequest) (*ListReposResponse, error)
+	GetRepo(context.Context, *RepoRequest) (*RepoStatus, error)
+	PutRepo(context.Context, *PutRepoRequest) (*PutRepoResponse, error)
+	RemoveRepo(context.Context, *RepoRequest) (*RemoveRepoResponse, error)
+	SyncRepo(context.Context, *RepoRequest) (*RepoStatus, error)
+	PauseRepo(context.Context, *RepoRequest) (*RepoStatus, error)
+	ResumeRepo(context.Context, *RepoRequest) (*RepoStatus, error)
+	PromoteRepo(context.Context, *RepoRequest) (*RepoStatus, error)
+}
+
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
+
+func _Admin_ListRepos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListReposRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListRepos(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/ListRepos",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListRepos(ctx, req.(*ListReposRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetRepo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetRepo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/GetRepo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetRepo(ctx, req.(*RepoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_PutRepo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRepoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).PutRepo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/PutRepo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).PutRepo(ctx, req.(*PutRepoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveRepo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveRepo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/RemoveRepo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveRepo(ctx, req.(*RepoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_SyncRepo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).SyncRepo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/SyncRepo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).SyncRepo(ctx, req.(*RepoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_PauseRepo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).PauseRepo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/PauseRepo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).PauseRepo(ctx, req.(*RepoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ResumeRepo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ResumeRepo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/ResumeRepo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ResumeRepo(ctx, req.(*RepoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_PromoteRepo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).PromoteRepo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gitsync.admin.Admin/PromoteRepo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).PromoteRepo(ctx, req.(*RepoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "gitsync.admin.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListRepos",
+			Handler:    _Admin_ListRepos_Handler,
+		},
+		{
+			MethodName: "GetRepo",
+			Handler:    _Admin_GetRepo_Handler,
+		},
+		{
+			MethodName: "PutRepo",
+			Handler:    _Admin_PutRepo_Handler,
+		},
+		{
+			MethodName: "RemoveRepo",
+			Handler:    _Admin_RemoveRepo_Handler,
+		},
+		{
+			MethodName: "SyncRepo",
+			Handler:    _Admin_SyncRepo_Handler,
+		},
+		{
+			MethodName: "PauseRepo",
+			Handler:    _Admin_PauseRepo_Handler,
+		},
+		{
+			MethodName: "ResumeRepo",
+			Handler:    _Admin_ResumeRepo_Handler,
+		},
+		{
+			MethodName: "PromoteRepo",
+			Handler:    _Admin_PromoteRepo_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
+
+func init() { proto.RegisterFile("admin.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 495 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x54, 0xc1, 0x6e, 0xd3, 0x40,
+	0x10, 0x95, 0x9b, 0x38, 0x8e, 0xc7, 0x05, 0xca, 0x10, 0xa1, 0x25, 0xa8, 0xe0, 0xf8, 0x94, 0x53,
+	0x50, 0xcb, 0x0f, 0xd0, 0x52, 0x28, 0x02, 0x84, 0x22, 0xf7, 0xc6, 0x81, 0xc8, 0xb5, 0x97, 0xd6,
+	0x28, 0xf6, 0x1a, 0xcf, 0x3a, 0x52, 0x7f, 0x8f, 0x33, 0x1f, 0x85, 0x76, 0xbc, 0x6d, 0xd3, 0xd0,
+	0xa4, 0x87, 0xdc, 0x76, 0xde, 0x7b, 0x7a, 0x7e, 0xbb, 0xf3, 0x64, 0x08, 0x92, 0xac, 0xc8, 0xcb,
+	0x49, 0x55, 0x2b, 0xad, 0xf0, 0xd1, 0x45, 0xae, 0xe9, 0xaa, 0x4c, 0x27, 0x0c, 0x46, 0x08, 0x7b,
+	0x5f, 0x73, 0xd2, 0xb1, 0xac, 0x14, 0xc5, 0xf2, 0x77, 0x23, 0x49, 0x47, 0x27, 0xf0, 0x74, 0x09,
+	0xa3, 0x4a, 0x95, 0x24, 0xf1, 0x0d, 0xb8, 0xb5, 0x01, 0x84, 0x13, 0x76, 0xc6, 0xc1, 0xe1, 0x8b,
+	0xc9, 0x1d, 0x9f, 0x89, 0x11, 0x9f, 0xe9, 0x44, 0x37, 0x14, 0xb7, 0xba, 0x68, 0x04, 0x81, 0x01,
+	0xad, 0x29, 0x22, 0x74, 0xcb, 0xa4, 0x90, 0xc2, 0x09, 0x9d, 0xb1, 0x1f, 0xf3, 0x39, 0x3a, 0x85,
+	0xc7, 0xd3, 0x46, 0x3f, 0xa0, 0xc2, 0x11, 0xec, 0xaa, 0x4a, 0xe7, 0xaa, 0xa4, 0xd9, 0x2f, 0x52,
+	0xa5, 0xd8, 0x61, 0x2e, 0xb0, 0xd8, 0x67, 0x52, 0x65, 0xf4, 0x03, 0x9e, 0xdc, 0x18, 0xd9, 0xbc,
+	0x02, 0xbc, 0xb4, 0x96, 0x89, 0x96, 0x19, 0x9b, 0xf5, 0xe3, 0xeb, 0x11, 0x0f, 0xa0, 0x47, 0x9c,
+	0x94, 0x9d, 0x36, 0x5e, 0xc5, 0x0a, 0xa3, 0x01, 0x60, 0x2c, 0x0b, 0xb5, 0x90, 0xcb, 0x9f, 0x88,
+	0xfe, 0xee, 0x00, 0xdc, 0x8a, 0xef, 0xcd, 0x8e, 0xd0, 0x35, 0xaf, 0x61, 0x33, 0xf3, 0x19, 0xf7,
+	0xa0, 0x53, 0xcb, 0x85, 0xe8, 0x30, 0x64, 0x8e, 0x46, 0x75, 0x99, 0xd0, 0xa5, 0xe8, 0xb6, 0x2a,
+	0x73, 0xc6, 0xe7, 0x9c, 0xf2, 0x42, 0x66, 0xc2, 0x65, 0xd4, 0x4e, 0xf8, 0x12, 0xfc, 0x79, 0x42,
+	0x7a, 0x66, 0x02, 0x8b, 0x5e, 0xe8, 0x8c, 0x3b, 0x71, 0xdf, 0x00, 0x67, 0x57, 0x65, 0x6a, 0x9e,
+	0xaa, 0x25, 0x9b, 0x34, 0x95, 0x44, 0xc2, 0x63, 0x3e, 0x60, 0xbe, 0x85, 0x70, 0x1f, 0x80, 0x25,
+	0xb2, 0xae, 0x55, 0x2d, 0xfa, 0xec, 0xcd, 0x8e, 0x1f, 0x0c, 0x80, 0x07, 0x30, 0x48, 0xcd, 0xe5,
+	0xd2, 0x46, 0xe7, 0x0b, 0x39, 0xfb, 0x99, 0xe4, 0xf3, 0xa6, 0x96, 0x24, 0xfc, 0xd0, 0x19, 0xbb,
+	0xf1, 0xb3, 0x25, 0xee, 0xa3, 0xa5, 0x70, 0x00, 0x2e, 0xe9, 0x64, 0x2e, 0x05, 0xf0, 0x3b, 0xb7,
+	0x83, 0xc9, 0x5f, 0x25, 0x0d, 0xc9, 0x4c, 0x04, 0x0c, 0xdb, 0xc9, 0xec, 0x85, 0xb4, 0xaa, 0x2a,
+	0x99, 0x89, 0xdd, 0x76, 0x2f, 0x76, 0x3c, 0xfc, 0xd3, 0x05, 0xf7, 0xc8, 0x6c, 0x00, 0xbf, 0x81,
+	0x7f, 0x53, 0x40, 0x7c, 0xbd, 0xb2, 0x9e, 0xd5, 0xba, 0x0e, 0xc3, 0xf5, 0x02, 0xdb, 0x85, 0x77,
+	0xe0, 0x9d, 0x4a, 0xc6, 0x70, 0x78, 0xcf, 0xb2, 0xaf, 0x8d, 0xd6, 0x17, 0x01, 0x3f, 0x81, 0x67,
+	0x0b, 0x86, 0xfb, 0x2b, 0xaa, 0xbb, 0x0d, 0x1e, 0xbe, 0x5a, 0x47, 0xdb, 0x2c, 0x5f, 0x00, 0x6e,
+	0xab, 0xb4, 0x31, 0xce, 0xe8, 0x3f, 0x6e, 0xb5, 0x81, 0x78, 0x04, 0x7d, 0xb3, 0xf7, 0x6d, 0x6e,
+	0x76, 0x0c, 0xfe, 0xd4, 0x6c, 0x66, 0x1b, 0x8f, 0xf7, 0xe6, 0x4e, 0xd4, 0x14, 0x5b, 0x99, 0x9c,
+	0x40, 0x30, 0xad, 0x55, 0xa1, 0xf4, 0x36, 0x2e, 0xc7, 0xfe, 0x77, 0x8f, 0xb1, 0xea, 0xfc, 0xbc,
+	0xc7, 0x3f, 0xbc, 0xb7, 0xff, 0x06, 0x00, 0x4e, 0x74, 0xf1, 0xb8, 0xff, 0x04, 0x00, 0x00,
+}
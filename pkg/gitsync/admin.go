@@ -0,0 +1,241 @@
+package gitsync
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// adminFields are the options, by JSON name, that a PUT may set.  The rest,
+// in particular commands, hooks, plugins and credential helpers, which
+// would let a client run anything it likes, come from the defaults only.
+var adminFields = map[string]bool{
+	"repo":            true,
+	"branch":          true,
+	"rev":             true,
+	"depth":           true,
+	"wait":            true,
+	"timeout":         true,
+	"maxSyncFailures": true,
+	"root":            true,
+	"dest":            true,
+	"shallowSince":    true,
+	"shallowExclude":  true,
+	"autoDepth":       true,
+	"submodules":      true,
+	"submoduleJobs":   true,
+	"partialClone":    true,
+	"sparsePaths":     true,
+	"serveStale":      true,
+	"missingRef":      true,
+	"fallbackRef":     true,
+	"immutableTags":   true,
+}
+
+// adminHandler serves the REST admin API of a Manager.
+type adminHandler struct {
+	m        *Manager
+	defaults Options
+	token    string
+}
+
+// AdminHandler returns the REST admin API for m:
+//
+//	GET    /repos               the status of every repo
+//	GET    /repos/NAME          the status of one repo
+//	PUT    /repos/NAME          add or reconfigure a repo from JSON Options,
+//	                            whose unset fields are taken from defaults
+//	                            (only those in adminFields may be set, and
+//	                            root, by default defaults.Root/NAME, must be
+//	                            under defaults.Root)
+//	DELETE /repos/NAME          stop syncing a repo
+//	POST   /repos/NAME/sync     sync a repo now
+//	POST   /repos/NAME/pause    stop syncing a repo until resumed
+//	POST   /repos/NAME/resume   resume syncing a repo
+//	GET    /metrics             metrics of every repo, for Prometheus
+//
+// If token is set, requests must send it as a bearer token.
+func AdminHandler(m *Manager, defaults Options, token string) http.Handler {
+	return &adminHandler{m: m, defaults: defaults, token: token}
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid bearer token"))
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "metrics" {
+		h.metrics(w, r)
+		return
+	}
+	if parts[0] != "repos" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, h.m.Statuses())
+	case len(parts) == 2 && r.Method == "GET":
+		h.status(w, parts[1])
+	case len(parts) == 2 && r.Method == "PUT":
+		h.put(w, r, parts[1])
+	case len(parts) == 2 && r.Method == "DELETE":
+		if _, err := h.m.Remove(parts[1]); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && r.Method == "POST":
+		h.action(w, parts[1], parts[2])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on %s", r.Method, r.URL.Path))
+	}
+}
+
+func (h *adminHandler) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	h.m.WriteMetrics(w)
+}
+
+func (h *adminHandler) status(w http.ResponseWriter, name string) {
+	st, err := h.m.Status(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *adminHandler) put(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	code, err := h.putRepo(name, body)
+	if err != nil {
+		writeError(w, code, err)
+		return
+	}
+	st, err := h.m.Status(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, code, st)
+}
+
+// putRepo adds or reconfigures the named repo from body, JSON Options, and
+// returns the HTTP status of the outcome: 201 if it was added, 200 if it
+// was reconfigured, or that of the error.
+func (h *adminHandler) putRepo(name string, body []byte) (int, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return http.StatusBadRequest, fmt.Errorf("invalid repo name %q", name)
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid options: %v", err)
+	}
+	if err := checkAdminFields(fields); err != nil {
+		return http.StatusBadRequest, err
+	}
+	opts, err := h.defaults.Override(body)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid options: %v", err)
+	}
+	opts.Name = name
+	parent := filepath.Clean(h.defaults.Root)
+	if _, found := fields["root"]; !found {
+		opts.Root = filepath.Join(parent, name)
+	}
+	if rel, err := filepath.Rel(parent, filepath.Clean(opts.Root)); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return http.StatusBadRequest, fmt.Errorf("root must be a directory under %s", parent)
+	}
+
+	code := http.StatusOK
+	if _, getErr := h.m.Get(name); getErr == nil {
+		err = h.m.Update(opts)
+	} else {
+		code = http.StatusCreated
+		err = h.m.Add(opts)
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return code, nil
+}
+
+// checkAdminFields returns an error if fields, the options of a PUT, set
+// any not in adminFields.
+func checkAdminFields(fields map[string]json.RawMessage) error {
+	denied := []string{}
+	for field := range fields {
+		if !adminFields[field] {
+			denied = append(denied, field)
+		}
+	}
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return fmt.Errorf("can't be set through the admin API: %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+func (h *adminHandler) action(w http.ResponseWriter, name, action string) {
+	if code, err := h.doAction(name, action); err != nil {
+		writeError(w, code, err)
+		return
+	}
+	h.status(w, name)
+}
+
+// doAction applies action, e.g. "sync", to the named repo, and returns the
+// HTTP status of the error, if any.
+func (h *adminHandler) doAction(name, action string) (int, error) {
+	s, err := h.m.Get(name)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	switch action {
+	case "sync":
+		if err := s.SyncNow(); err != nil {
+			return http.StatusConflict, err
+		}
+	case "pause":
+		s.Pause()
+	case "resume":
+		s.Resume()
+	case "promote":
+		if err := s.Promote(); err != nil {
+			return http.StatusConflict, err
+		}
+	default:
+		return http.StatusNotFound, fmt.Errorf("no such action %q", action)
+	}
+	return http.StatusOK, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
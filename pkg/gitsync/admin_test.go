@@ -0,0 +1,85 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx)
+	defaults := Options{
+		Source: &fakeSource{hash: "one"},
+		Rev:    "HEAD",
+		Wait:   60,
+		Root:   dir,
+	}
+	server := httptest.NewServer(AdminHandler(m, defaults, "secret"))
+	defer server.Close()
+
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+		code   int
+	}{
+		{"GET", "/repos", "", "", http.StatusUnauthorized},
+		{"GET", "/repos", "wrong", "", http.StatusUnauthorized},
+		{"GET", "/repos", "secret", "", http.StatusOK},
+		{"GET", "/repos/a", "secret", "", http.StatusNotFound},
+		{"PUT", "/repos/a", "secret", `{"repo": "https://example.com/a", "root": "` + filepath.Join(dir, "a") + `"}`, http.StatusCreated},
+		{"PUT", "/repos/a", "secret", `{"repo": "https://example.com/a", "root": "` + filepath.Join(dir, "a") + `", "wait": 30}`, http.StatusOK},
+		{"PUT", "/repos/b", "secret", `{"root": "` + filepath.Join(dir, "b") + `"}`, http.StatusBadRequest},
+		{"PUT", "/repos/b", "secret", `not json`, http.StatusBadRequest},
+		{"PUT", "/repos/b", "secret", `{"repo": "https://example.com/b", "exechookCommand": "sh -c id"}`, http.StatusBadRequest},
+		{"PUT", "/repos/b", "secret", `{"repo": "https://example.com/b", "hookPlugins": ["/tmp/evil.so"]}`, http.StatusBadRequest},
+		{"PUT", "/repos/b", "secret", `{"repo": "https://example.com/b", "root": "/etc"}`, http.StatusBadRequest},
+		{"PUT", "/repos/b", "secret", `{"repo": "https://example.com/b", "root": "` + filepath.Join(dir, "..", "b") + `"}`, http.StatusBadRequest},
+		{"PUT", "/repos/..", "secret", `{"repo": "https://example.com/b"}`, http.StatusBadRequest},
+		{"PUT", "/repos/c", "secret", `{"repo": "https://example.com/c"}`, http.StatusCreated},
+		{"GET", "/repos/a", "secret", "", http.StatusOK},
+		{"POST", "/repos/a/sync", "secret", "", http.StatusOK},
+		{"POST", "/repos/a/pause", "secret", "", http.StatusOK},
+		{"POST", "/repos/a/sync", "secret", "", http.StatusConflict},
+		{"POST", "/repos/a/resume", "secret", "", http.StatusOK},
+		{"POST", "/repos/a/explode", "secret", "", http.StatusNotFound},
+		{"PATCH", "/repos/a", "secret", "", http.StatusMethodNotAllowed},
+		{"DELETE", "/repos/a", "secret", "", http.StatusNoContent},
+		{"DELETE", "/repos/a", "secret", "", http.StatusNotFound},
+		{"GET", "/metrics", "secret", "", http.StatusOK},
+		{"POST", "/metrics", "secret", "", http.StatusMethodNotAllowed},
+		{"GET", "/other", "secret", "", http.StatusNotFound},
+	}
+
+	for _, testCase := range cases {
+		req, err := http.NewRequest(testCase.method, server.URL+testCase.path, strings.NewReader(testCase.body))
+		if err != nil {
+			t.Fatalf("can't create request: %v", err)
+		}
+		if testCase.token != "" {
+			req.Header.Set("Authorization", "Bearer "+testCase.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error: %v", testCase.method, testCase.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != testCase.code {
+			t.Fatalf("%s %s: expected %d but %d returned", testCase.method, testCase.path, testCase.code, resp.StatusCode)
+		}
+	}
+}
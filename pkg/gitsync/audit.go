@@ -0,0 +1,93 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// auditMu serializes appending to audit logs, which repos may share.
+var auditMu sync.Mutex
+
+// auditRecord is a line of the audit log: one git command that was run.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Name     string    `json:"name"`
+	Dir      string    `json:"dir,omitempty"`
+	Args     []string  `json:"args"`
+	ExitCode int       `json:"exitCode"`
+	Duration float64   `json:"durationSeconds"`
+	Output   int       `json:"outputBytes"`
+}
+
+// combinedOutput runs cmd with runGroup, recording it in Options.AuditLog
+// if it is git.
+func (s *Syncer) combinedOutput(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	start := time.Now()
+	output, err := runGroup(ctx, cmd)
+	if s.opts.AuditLog == "" || filepath.Base(cmd.Args[0]) != "git" {
+		return output, err
+	}
+
+	rec := auditRecord{
+		Time:     start.UTC(),
+		Name:     s.opts.Name,
+		Dir:      cmd.Dir,
+		Args:     redactArgs(cmd.Args),
+		ExitCode: -1,
+		Duration: time.Since(start).Seconds(),
+		Output:   len(output),
+	}
+	if cmd.ProcessState != nil {
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			rec.ExitCode = ws.ExitStatus()
+		}
+	}
+	if auditErr := appendAuditRecord(s.opts.AuditLog, rec); auditErr != nil {
+		s.logger(ctx).Errorf("%v", auditErr)
+	}
+	return output, err
+}
+
+// appendAuditRecord appends rec to the audit log at path, as a line of
+// JSON.  The file is only ever appended to.
+func appendAuditRecord(path string, rec auditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %v", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing audit log: %v", err)
+	}
+	return f.Close()
+}
+
+// redactArgs returns args with credentials hidden: passwords in URLs, and
+// the values of the HTTP headers (e.g. bearer tokens) set in git's config.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && strings.HasSuffix(strings.ToLower(args[i-1]), ".extraheader") {
+			if parts := strings.SplitN(arg, ":", 2); len(parts) == 2 {
+				redacted[i] = parts[0] + ": xxxxx"
+				continue
+			}
+		}
+		redacted[i] = redactURL(arg)
+	}
+	return redacted
+}
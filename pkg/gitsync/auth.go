@@ -0,0 +1,322 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// askpassHelperEnv is set in the environment of git child processes so
+	// that, when git runs this binary as GIT_ASKPASS, it answers the prompt
+	// instead of syncing.
+	askpassHelperEnv   = "GIT_SYNC_ASKPASS_HELPER"
+	askpassUsernameEnv = "GIT_SYNC_ASKPASS_USERNAME"
+	askpassPasswordEnv = "GIT_SYNC_ASKPASS_PASSWORD"
+	// askpassPassphraseEnv holds the SSH key passphrase, for when ssh runs
+	// this binary as SSH_ASKPASS.
+	askpassPassphraseEnv = "GIT_SYNC_ASKPASS_PASSPHRASE"
+
+	askpassTimeout = 10 * time.Second
+
+	// githubTokenUsername is the username GitHub expects alongside a token
+	// in HTTPS basic auth.
+	githubTokenUsername = "x-access-token"
+
+	// gitlabJobTokenUsername is the username GitLab expects alongside a CI
+	// job token.
+	gitlabJobTokenUsername = "gitlab-ci-token"
+
+	// bitbucketAccessTokenUsername is the username Bitbucket Cloud expects
+	// alongside a repository or workspace access token.
+	bitbucketAccessTokenUsername = "x-token-auth"
+
+	// azureDevOpsPATUsername is sent alongside an Azure DevOps personal
+	// access token, which ignores the username but needs a non-empty one.
+	azureDevOpsPATUsername = "pat"
+)
+
+// tokenAuth is a provider-specific token flag and the basic auth username
+// that goes with it.
+type tokenAuth struct {
+	flag         string
+	usernameFlag string
+	username     string
+	token        string
+}
+
+// resolveTokenAuth fills in o.Username and o.Password from whichever
+// provider-specific token flag was set, if any.
+func resolveTokenAuth(o *Options) error {
+	all := []tokenAuth{
+		{"--github-token", "", githubTokenUsername, o.GitHubToken},
+		{"--gitlab-deploy-token", "--gitlab-deploy-token-username", o.GitLabDeployTokenUsername, o.GitLabDeployToken},
+		{"--gitlab-job-token", "", gitlabJobTokenUsername, o.GitLabJobToken},
+		{"--bitbucket-app-password", "--bitbucket-username", o.BitbucketUsername, o.BitbucketAppPassword},
+		{"--bitbucket-access-token", "", bitbucketAccessTokenUsername, o.BitbucketAccessToken},
+		{"--azure-devops-pat", "", azureDevOpsPATUsername, o.AzureDevOpsPAT},
+	}
+
+	set := []tokenAuth{}
+	for _, t := range all {
+		if t.token != "" {
+			set = append(set, t)
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	if len(set) > 1 {
+		return fmt.Errorf("%s and %s are mutually exclusive", set[0].flag, set[1].flag)
+	}
+
+	t := set[0]
+	if o.Username != "" || o.Password != "" {
+		return fmt.Errorf("%s can't be combined with --username or --password", t.flag)
+	}
+	if !isHTTPURL(o.Repo) {
+		return fmt.Errorf("%s requires an HTTP(S) --repo", t.flag)
+	}
+	if t.username == "" {
+		return fmt.Errorf("%s requires %s", t.flag, t.usernameFlag)
+	}
+	o.Username = t.username
+	o.Password = t.token
+	return nil
+}
+
+// isHTTPURL returns true if repo is cloned over HTTP(S), where basic auth
+// credentials apply.
+func isHTTPURL(repo string) bool {
+	return strings.HasPrefix(repo, "https://") || strings.HasPrefix(repo, "http://")
+}
+
+// IsAskpassInvocation returns true if git has run this binary as GIT_ASKPASS.
+// Programs embedding a Syncer must check this first thing in main() and,
+// if it is true, call RunAskpass.
+func IsAskpassInvocation() bool {
+	return os.Getenv(askpassHelperEnv) != "" && len(os.Args) == 2
+}
+
+// RunAskpass answers a single git credential or ssh key passphrase prompt
+// from the environment and exits.
+func RunAskpass() {
+	prompt := strings.ToLower(os.Args[1])
+	if strings.HasPrefix(prompt, "username") {
+		fmt.Println(os.Getenv(askpassUsernameEnv))
+	} else if strings.HasPrefix(prompt, "enter passphrase") {
+		fmt.Println(os.Getenv(askpassPassphraseEnv))
+	} else {
+		fmt.Println(os.Getenv(askpassPasswordEnv))
+	}
+	os.Exit(0)
+}
+
+// fetchAskpassCredentials calls url and parses the "key=value" lines it
+// returns.  A "token" key is accepted in place of "password".
+func fetchAskpassCredentials(ctx context.Context, url string) (string, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req = req.WithContext(ctx)
+
+	client := &http.Client{Timeout: askpassTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("error calling askpass URL: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading askpass URL response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("askpass URL returned status %d: %q", resp.StatusCode, string(body))
+	}
+
+	username, password := "", ""
+	for _, line := range strings.Split(string(body), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			username = kv[1]
+		case "password", "token":
+			password = kv[1]
+		}
+	}
+	if password == "" {
+		return "", "", fmt.Errorf("askpass URL returned no password or token")
+	}
+	return username, password, nil
+}
+
+// setupGitAskpass fetches fresh credentials from url and points GIT_ASKPASS
+// at this binary, so that subsequent git commands use them.
+func (s *Syncer) setupGitAskpass(ctx context.Context, url string) error {
+	s.logger(ctx).V(1).Infof("fetching git credentials from askpass URL")
+
+	username, password, err := fetchAskpassCredentials(ctx, url)
+	if err != nil {
+		return err
+	}
+	return s.setAskpassCredentials(username, password)
+}
+
+// setAskpassCredentials points GIT_ASKPASS at this binary, answering with
+// username and password.
+func (s *Syncer) setAskpassCredentials(username, password string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("can't find git-sync executable: %v", err)
+	}
+
+	env := map[string]string{
+		"GIT_ASKPASS":      self,
+		askpassHelperEnv:   "true",
+		askpassUsernameEnv: username,
+		askpassPasswordEnv: password,
+	}
+	for k, v := range env {
+		s.setEnv(k, v)
+	}
+	return nil
+}
+
+// refreshCredentials renews any short-lived credentials before a sync.
+func (s *Syncer) refreshCredentials(ctx context.Context) error {
+	if err := s.reloadChangedFiles(ctx); err != nil {
+		return err
+	}
+	if s.opts.AskpassURL != "" {
+		if err := s.setupGitAskpass(ctx, s.opts.AskpassURL); err != nil {
+			return err
+		}
+	}
+	if s.opts.OAuth2TokenURL != "" {
+		if err := s.refreshOAuth2Token(ctx); err != nil {
+			return err
+		}
+	}
+	if s.opts.STSURL != "" {
+		if err := s.refreshSTSToken(ctx); err != nil {
+			return err
+		}
+	}
+	if s.opts.GCPMetadataToken {
+		if err := s.refreshGCPToken(ctx); err != nil {
+			return err
+		}
+	}
+	if s.opts.AzureManagedIdentity {
+		if err := s.refreshAzureToken(ctx); err != nil {
+			return err
+		}
+	}
+	if s.opts.CodeCommit {
+		if err := s.refreshCodeCommitCredentials(ctx); err != nil {
+			return err
+		}
+	}
+	if s.opts.VaultAddr != "" {
+		if err := s.refreshVaultCredentials(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeGitCredentials saves username and password for the repo, in ~/.netrc
+// if --netrc was given, and otherwise in this Syncer's environment, for git
+// to get through GIT_ASKPASS, so that other Syncers on the same host don't
+// see them.
+func (s *Syncer) storeGitCredentials(ctx context.Context, username, password string) error {
+	if s.opts.Netrc {
+		s.logger(ctx).V(1).Infof("setting up .netrc")
+		return setupGitNetrc(username, password, s.opts.Repo)
+	}
+	s.logger(ctx).V(1).Infof("setting up git credentials")
+	return s.setAskpassCredentials(username, password)
+}
+
+// netrcEntries splits the contents of a .netrc file into entries, each
+// starting with a "machine" or "default" token.
+func netrcEntries(data string) [][]string {
+	entries := [][]string{}
+	for _, tok := range strings.Fields(data) {
+		if tok == "machine" || tok == "default" || len(entries) == 0 {
+			entries = append(entries, []string{})
+		}
+		entries[len(entries)-1] = append(entries[len(entries)-1], tok)
+	}
+	return entries
+}
+
+// setupGitNetrc writes username and password for the host of gitURL to
+// ~/.netrc, replacing any existing entry for that host, for other programs
+// to use too.  Every repo on that host shares them.
+func setupGitNetrc(username, password, gitURL string) error {
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return fmt.Errorf("can't parse repo URL: %v", err)
+	}
+	host := u.Hostname()
+	if strings.ContainsAny(username+password, " \t\n") {
+		return fmt.Errorf("credentials containing whitespace can't be stored in .netrc")
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return fmt.Errorf("can't write .netrc: $HOME is not set")
+	}
+	netrcPath := filepath.Join(home, ".netrc")
+
+	existing, err := ioutil.ReadFile(netrcPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading %s: %v", netrcPath, err)
+	}
+	lines := []string{}
+	for _, entry := range netrcEntries(string(existing)) {
+		if len(entry) >= 2 && entry[0] == "machine" && entry[1] == host {
+			continue
+		}
+		lines = append(lines, strings.Join(entry, " "))
+	}
+	lines = append(lines, fmt.Sprintf("machine %s login %s password %s", host, username, password))
+
+	if err := ioutil.WriteFile(netrcPath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", netrcPath, err)
+	}
+	return nil
+}
+
+// setupGitCredentialHelper configures git to get credentials from helper,
+// an executable (plus optional arguments) speaking git's credential helper
+// protocol.
+func (s *Syncer) setupGitCredentialHelper(ctx context.Context, helper string) error {
+	s.logger(ctx).V(1).Infof("setting up git credential helper %s", helper)
+
+	fields := strings.Fields(helper)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty credential helper")
+	}
+	if !filepath.IsAbs(fields[0]) {
+		return fmt.Errorf("credential helper %q must be an absolute path", fields[0])
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return fmt.Errorf("credential helper not usable: %v", err)
+	}
+
+	s.setGitConfig("credential.helper", helper)
+	return nil
+}
@@ -0,0 +1,95 @@
+package gitsync
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveTokenAuth(t *testing.T) {
+	cases := []struct {
+		opts     Options
+		username string
+		password string
+		err      bool
+	}{
+		{Options{Repo: "https://github.com/a/b"}, "", "", false},
+		{Options{Repo: "https://github.com/a/b", GitHubToken: "tok"}, "x-access-token", "tok", false},
+		{Options{Repo: "https://gitlab.com/a/b", GitLabJobToken: "tok"}, "gitlab-ci-token", "tok", false},
+		{Options{Repo: "https://gitlab.com/a/b", GitLabDeployTokenUsername: "deploy", GitLabDeployToken: "tok"}, "deploy", "tok", false},
+		{Options{Repo: "https://gitlab.com/a/b", GitLabDeployToken: "tok"}, "", "", true},
+		{Options{Repo: "https://bitbucket.org/a/b", BitbucketUsername: "user", BitbucketAppPassword: "pw"}, "user", "pw", false},
+		{Options{Repo: "https://bitbucket.org/a/b", BitbucketAppPassword: "pw"}, "", "", true},
+		{Options{Repo: "https://bitbucket.org/a/b", BitbucketAccessToken: "tok"}, "x-token-auth", "tok", false},
+		{Options{Repo: "https://dev.azure.com/a/b/_git/c", AzureDevOpsPAT: "tok"}, "pat", "tok", false},
+		{Options{Repo: "git@github.com:a/b", GitHubToken: "tok"}, "", "", true},
+		{Options{Repo: "https://github.com/a/b", GitHubToken: "tok", Username: "u"}, "", "", true},
+		{Options{Repo: "https://github.com/a/b", GitHubToken: "tok", GitLabJobToken: "tok"}, "", "", true},
+	}
+
+	for i, testCase := range cases {
+		opts := testCase.opts
+		err := resolveTokenAuth(&opts)
+		if (err != nil) != testCase.err {
+			t.Fatalf("case %d: expected error %v but got %v", i, testCase.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if opts.Username != testCase.username || opts.Password != testCase.password {
+			t.Fatalf("case %d: expected %q/%q but %q/%q returned", i, testCase.username, testCase.password, opts.Username, opts.Password)
+		}
+	}
+}
+
+func TestNetrcEntries(t *testing.T) {
+	cases := []struct {
+		data string
+		exp  [][]string
+	}{
+		{"", [][]string{}},
+		{"machine a login u password p", [][]string{{"machine", "a", "login", "u", "password", "p"}}},
+		{"machine a\n  login u\n  password p\nmachine b login v password q\ndefault login w",
+			[][]string{
+				{"machine", "a", "login", "u", "password", "p"},
+				{"machine", "b", "login", "v", "password", "q"},
+				{"default", "login", "w"},
+			}},
+	}
+
+	for _, testCase := range cases {
+		val := netrcEntries(testCase.data)
+		if !reflect.DeepEqual(val, testCase.exp) {
+			t.Fatalf("expected %v but %v returned", testCase.exp, val)
+		}
+	}
+}
+
+func TestCredentialsPerSyncer(t *testing.T) {
+	ctx := context.Background()
+	syncers := map[string]*Syncer{}
+	for _, user := range []string{"a", "b"} {
+		s := &Syncer{opts: Options{Repo: "https://example.com/" + user}, env: map[string]string{}}
+		if err := s.storeGitCredentials(ctx, user, "secret-"+user); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.setupGitBearerToken(ctx, "token-"+user, s.opts.Repo); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		syncers[user] = s
+	}
+
+	for user, s := range syncers {
+		if s.env[askpassUsernameEnv] != user || s.env[askpassPasswordEnv] != "secret-"+user {
+			t.Fatalf("%s: expected its own credentials but got %q/%q", user, s.env[askpassUsernameEnv], s.env[askpassPasswordEnv])
+		}
+		out, err := s.runCommand(ctx, "", "git", "config", "--get-all", "http.https://example.com/.extraHeader")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", user, err)
+		}
+		if exp := "Authorization: Bearer token-" + user; strings.TrimSpace(out) != exp {
+			t.Fatalf("%s: expected %q but %q returned", user, exp, strings.TrimSpace(out))
+		}
+	}
+}
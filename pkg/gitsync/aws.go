@@ -0,0 +1,243 @@
+package gitsync
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405"
+	sigV4DateFormat = "20060102"
+
+	stsAPIVersion = "2011-06-15"
+)
+
+// awsCredentials is a set of AWS credentials and the time at which they
+// expire.  A zero expiry means they do not expire.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// valid returns true if the credentials can still be used for a sync.
+func (c *awsCredentials) valid() bool {
+	if c.AccessKeyID == "" {
+		return false
+	}
+	return c.Expiration.IsZero() || time.Now().Add(tokenRefreshMargin).Before(c.Expiration)
+}
+
+// assumeRoleWithWebIdentityResponse is the subset of the STS response that we
+// use.
+type assumeRoleWithWebIdentityResponse struct {
+	Result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"Credentials"`
+	} `xml:"AssumeRoleWithWebIdentityResult"`
+}
+
+// loadAWSCredentials returns credentials for the pod's IAM role.  With IRSA
+// (AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE) the projected token is
+// exchanged at STS; otherwise static credentials are read from the
+// environment.
+func loadAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		c := awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return awsCredentials{}, fmt.Errorf("no AWS credentials found: set AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return c, nil
+	}
+
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("error reading web identity token: %v", err)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "git-sync"
+	}
+
+	endpoint := "https://sts.amazonaws.com/"
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+	}
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", stsAPIVersion)
+	form.Set("RoleArn", roleARN)
+	form.Set("RoleSessionName", sessionName)
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: tokenTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("error calling AWS STS: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("error reading AWS STS response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("AWS STS returned status %d: %q", resp.StatusCode, string(body))
+	}
+
+	var r assumeRoleWithWebIdentityResponse
+	if err := xml.Unmarshal(body, &r); err != nil {
+		return awsCredentials{}, fmt.Errorf("error decoding AWS STS response: %v", err)
+	}
+	rc := r.Result.Credentials
+	return awsCredentials{
+		AccessKeyID:     rc.AccessKeyID,
+		SecretAccessKey: rc.SecretAccessKey,
+		SessionToken:    rc.SessionToken,
+		Expiration:      rc.Expiration,
+	}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// sigV4Signature signs canonicalRequest with AWS Signature Version 4.
+func sigV4Signature(creds awsCredentials, region, service string, t time.Time, canonicalRequest string) string {
+	date := t.Format(sigV4DateFormat)
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(sigV4TimeFormat) + "Z",
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// signAWSRequest signs req, whose body is body, for service in region with
+// AWS Signature Version 4.  It signs the Content-Type, Host and X-Amz-*
+// headers only.
+func signAWSRequest(req *http.Request, body string, creds awsCredentials, region, service string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(sigV4TimeFormat)+"Z")
+	names := []string{"content-type", "host", "x-amz-date"}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		names = append(names, "x-amz-security-token")
+	}
+
+	headers := ""
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers += name + ":" + strings.TrimSpace(value) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, req.URL.RawQuery, headers, signedHeaders, sha256Hex(body)}, "\n")
+
+	scope := strings.Join([]string{t.Format(sigV4DateFormat), region, service, "aws4_request"}, "/")
+	signature := sigV4Signature(creds, region, service, t, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// codeCommitRegion extracts the region from a CodeCommit HTTPS URL host,
+// e.g. "git-codecommit.us-east-1.amazonaws.com".
+func codeCommitRegion(host string) (string, error) {
+	parts := strings.Split(host, ".")
+	if len(parts) < 4 || parts[0] != "git-codecommit" {
+		return "", fmt.Errorf("%q is not a CodeCommit host", host)
+	}
+	return parts[1], nil
+}
+
+// codeCommitCredentials computes the git username and password for an
+// HTTPS CodeCommit URL, the same way as the AWS CLI credential helper does.
+// The password is a SigV4 signature, so it is only valid for a short time.
+func codeCommitCredentials(creds awsCredentials, repo string, t time.Time) (string, string, error) {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", "", fmt.Errorf("can't parse repo URL: %v", err)
+	}
+	region, err := codeCommitRegion(u.Host)
+	if err != nil {
+		return "", "", err
+	}
+
+	t = t.UTC()
+	canonicalRequest := fmt.Sprintf("GIT\n%s\n\nhost:%s\n\nhost\n", u.Path, u.Host)
+	signature := sigV4Signature(creds, region, "codecommit", t, canonicalRequest)
+
+	username := creds.AccessKeyID
+	if creds.SessionToken != "" {
+		username += "%" + creds.SessionToken
+	}
+	password := t.Format(sigV4TimeFormat) + "Z" + signature
+	return username, password, nil
+}
+
+// refreshCodeCommitCredentials signs a fresh CodeCommit password, renewing
+// the AWS credentials first if needed.
+func (s *Syncer) refreshCodeCommitCredentials(ctx context.Context) error {
+	if !s.awsCreds.valid() {
+		s.logger(ctx).V(1).Infof("loading AWS credentials for CodeCommit")
+		c, err := loadAWSCredentials(ctx)
+		if err != nil {
+			return err
+		}
+		s.awsCreds = c
+	}
+
+	username, password, err := codeCommitCredentials(s.awsCreds, s.opts.Repo, time.Now())
+	if err != nil {
+		return err
+	}
+	return s.setAskpassCredentials(username, password)
+}
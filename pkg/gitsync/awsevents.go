@@ -0,0 +1,128 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sqsAPIVersion = "2012-11-05"
+	snsAPIVersion = "2010-03-31"
+)
+
+// awsPublisher publishes Events to an SQS queue or an SNS topic, through
+// their query APIs, with the pod's AWS credentials (see
+// loadAWSCredentials).
+type awsPublisher struct {
+	// service is "sqs" or "sns".
+	service  string
+	endpoint string
+	region   string
+	// form holds the parameters that name the queue or topic.
+	form url.Values
+	// fifo is set for FIFO queues and topics, which need a message group.
+	fifo bool
+
+	creds awsCredentials
+}
+
+// newSQSPublisher returns a publisher to the queue at queueURL, e.g.
+// https://sqs.us-east-1.amazonaws.com/123456789012/git-sync.
+func newSQSPublisher(queueURL string) (*awsPublisher, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(u.Host, ".")
+	if u.Scheme != "https" || len(parts) < 4 || parts[0] != "sqs" {
+		return nil, fmt.Errorf("%q is not an SQS queue URL", queueURL)
+	}
+	return &awsPublisher{
+		service:  "sqs",
+		endpoint: queueURL,
+		region:   parts[1],
+		form:     url.Values{"Action": {"SendMessage"}, "Version": {sqsAPIVersion}},
+		fifo:     strings.HasSuffix(u.Path, ".fifo"),
+	}, nil
+}
+
+// newSNSPublisher returns a publisher to the topic topicARN, e.g.
+// arn:aws:sns:us-east-1:123456789012:git-sync.
+func newSNSPublisher(topicARN string) (*awsPublisher, error) {
+	parts := strings.Split(topicARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[3] == "" {
+		return nil, fmt.Errorf("%q is not an SNS topic ARN", topicARN)
+	}
+	region := parts[3]
+	host := "sns." + region + ".amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		host += ".cn"
+	}
+	return &awsPublisher{
+		service:  "sns",
+		endpoint: "https://" + host + "/",
+		region:   region,
+		form:     url.Values{"Action": {"Publish"}, "Version": {snsAPIVersion}, "TopicArn": {topicARN}},
+		fifo:     strings.HasSuffix(topicARN, ".fifo"),
+	}, nil
+}
+
+func (p *awsPublisher) PublishEvent(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+	if !p.creds.valid() {
+		c, err := loadAWSCredentials(ctx)
+		if err != nil {
+			return err
+		}
+		p.creds = c
+	}
+
+	form := url.Values{}
+	for k, v := range p.form {
+		form[k] = v
+	}
+	if p.service == "sqs" {
+		form.Set("MessageBody", string(payload))
+	} else {
+		form.Set("Message", string(payload))
+		form.Set("Subject", "git-sync: "+e.Name)
+	}
+	if p.fifo {
+		// Keep each repo's events in order, and drop duplicates of a swap.
+		form.Set("MessageGroupId", e.Name)
+		form.Set("MessageDeduplicationId", e.Name+"-"+e.NewHash)
+	}
+	body := form.Encode()
+
+	req, err := http.NewRequest("POST", p.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(req, body, p.creds, p.region, p.service, time.Now())
+
+	client := &http.Client{Timeout: eventTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling AWS %s: %v", strings.ToUpper(p.service), err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading AWS %s response: %v", strings.ToUpper(p.service), err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("AWS %s returned status %d: %q", strings.ToUpper(p.service), resp.StatusCode, string(respBody))
+	}
+	return nil
+}
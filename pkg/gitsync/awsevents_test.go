@@ -0,0 +1,106 @@
+package gitsync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAWSCredentials = awsCredentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSignAWSRequest(t *testing.T) {
+	body := "Action=SendMessage&MessageBody=hello&Version=2012-11-05"
+	req, _ := http.NewRequest("POST", "https://sqs.us-east-1.amazonaws.com/123456789012/q", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(req, body, testAWSCredentials, "us-east-1", "sqs", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/sqs/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=39f7b5420f2e179b2049b6a53d8b8393ed10caea29d498a1b08234e5ca614b82"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Fatalf("expected %s but %s returned", expected, auth)
+	}
+}
+
+func TestAWSPublisher(t *testing.T) {
+	cases := []struct {
+		newPublisher func() (*awsPublisher, error)
+		form         url.Values
+	}{
+		{func() (*awsPublisher, error) {
+			return newSQSPublisher("https://sqs.us-east-1.amazonaws.com/123456789012/q")
+		},
+			url.Values{"Action": {"SendMessage"}, "MessageGroupId": nil}},
+		{func() (*awsPublisher, error) {
+			return newSQSPublisher("https://sqs.eu-west-1.amazonaws.com/123456789012/q.fifo")
+		},
+			url.Values{"Action": {"SendMessage"}, "MessageGroupId": {"a"}, "MessageDeduplicationId": {"a-abc"}}},
+		{func() (*awsPublisher, error) { return newSNSPublisher("arn:aws:sns:us-east-1:123456789012:t") },
+			url.Values{"Action": {"Publish"}, "TopicArn": {"arn:aws:sns:us-east-1:123456789012:t"}, "Subject": {"git-sync: a"}}},
+	}
+
+	for _, testCase := range cases {
+		var form url.Values
+		var auth string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			form, auth = r.PostForm, r.Header.Get("Authorization")
+		}))
+
+		p, err := testCase.newPublisher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.endpoint = server.URL
+		p.creds = testAWSCredentials
+		err = p.PublishEvent(context.Background(), Event{Name: "a", NewHash: "abc"})
+		server.Close()
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", testCase.form, err)
+		}
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Fatalf("expected a SigV4 signature but got %q", auth)
+		}
+		for k, v := range testCase.form {
+			if strings.Join(form[k], ",") != strings.Join(v, ",") {
+				t.Fatalf("expected %s=%v but %v was sent", k, v, form[k])
+			}
+		}
+		if !strings.Contains(form.Get("MessageBody")+form.Get("Message"), `"newHash":"abc"`) {
+			t.Fatalf("expected the event to be sent but got %v", form)
+		}
+	}
+}
+
+func TestNewAWSPublisher(t *testing.T) {
+	cases := []struct {
+		sqs, sns string
+		err      bool
+	}{
+		{"https://sqs.us-east-1.amazonaws.com/123456789012/q", "", false},
+		{"http://sqs.us-east-1.amazonaws.com/123456789012/q", "", true},
+		{"https://queue.example.com/q", "", true},
+		{"", "arn:aws:sns:us-east-1:123456789012:t", false},
+		{"", "arn:aws:sqs:us-east-1:123456789012:t", true},
+		{"", "t", true},
+	}
+
+	for _, testCase := range cases {
+		var err error
+		if testCase.sqs != "" {
+			_, err = newSQSPublisher(testCase.sqs)
+		} else {
+			_, err = newSNSPublisher(testCase.sns)
+		}
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s%s: expected error %v but got %v", testCase.sqs, testCase.sns, testCase.err, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package gitsync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// budget is a bandwidth budget shared by a Manager's repos.  It is a token
+// bucket of bytes, refilled at rate bytes per second, holding at most a
+// second's worth.  A transfer can't be limited while git runs it, so it
+// waits until the bucket isn't in debt, and is then charged for what it
+// fetched, which may put the bucket in debt, making the next ones wait.
+type budget struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// waiting are the transfers waiting for the bucket.  The most stale
+	// goes first.
+	waiting map[*budgetWaiter]bool
+	// seq numbers the transfers, to break ties.
+	seq int
+	// changed is closed, and replaced, whenever a transfer starts or is
+	// charged, to wake the others.
+	changed chan struct{}
+}
+
+// budgetWaiter is a transfer waiting for the bucket.
+type budgetWaiter struct {
+	// lastSuccess is when its repo last synced successfully, or zero.
+	lastSuccess time.Time
+	seq         int
+}
+
+// before returns true if w goes before other.
+func (w *budgetWaiter) before(other *budgetWaiter) bool {
+	if !w.lastSuccess.Equal(other.lastSuccess) {
+		return w.lastSuccess.Before(other.lastSuccess)
+	}
+	return w.seq < other.seq
+}
+
+// newBudget returns a budget of rate bytes per second.
+func newBudget(rate int64) *budget {
+	return &budget{
+		rate:    float64(rate),
+		tokens:  float64(rate),
+		last:    time.Now(),
+		waiting: map[*budgetWaiter]bool{},
+		changed: make(chan struct{}),
+	}
+}
+
+// refill adds the tokens earned since the last refill.  The caller must
+// hold b.mu.
+func (b *budget) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// wake wakes the waiting transfers.  The caller must hold b.mu.
+func (b *budget) wake() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// wait blocks until a transfer for a repo that last synced successfully at
+// lastSuccess (zero if never) may start: when the bucket isn't in debt and
+// no more stale repo is waiting.  It returns false if ctx is cancelled
+// first.
+func (b *budget) wait(ctx context.Context, lastSuccess time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	me := &budgetWaiter{lastSuccess: lastSuccess, seq: b.seq}
+	b.waiting[me] = true
+	defer delete(b.waiting, me)
+
+	for {
+		b.refill()
+		var delay time.Duration
+		if b.tokens < 0 {
+			delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+		} else if b.first() == me {
+			b.wake()
+			return true
+		}
+
+		changed := b.changed
+		b.mu.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if delay > 0 {
+			timer = time.NewTimer(delay)
+			timeout = timer.C
+		}
+		cancelled := false
+		select {
+		case <-ctx.Done():
+			cancelled = true
+		case <-changed:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		b.mu.Lock()
+		if cancelled {
+			// Another transfer may have been waiting behind this one.
+			b.wake()
+			return false
+		}
+	}
+}
+
+// first returns the most stale waiting transfer.  The caller must hold
+// b.mu.
+func (b *budget) first() *budgetWaiter {
+	var first *budgetWaiter
+	for w := range b.waiting {
+		if first == nil || w.before(first) {
+			first = w
+		}
+	}
+	return first
+}
+
+// charge takes n bytes, fetched by a transfer, from the bucket.
+func (b *budget) charge(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens -= float64(n)
+	b.wake()
+}
+
+// throttle runs transfer, which fetches into the clone, within the budget
+// shared with the Manager's other repos, if any.  What it costs is how much
+// the object store grows.
+func (s *Syncer) throttle(ctx context.Context, transfer func() error) error {
+	if s.budget == nil {
+		return transfer()
+	}
+	s.logger(ctx).V(2).Infof("waiting for the bandwidth budget")
+	if !s.budget.wait(ctx, s.Status().LastSuccess) {
+		return ctx.Err()
+	}
+	before := s.objectsSize()
+	err := transfer()
+	if n := s.objectsSize() - before; n > 0 {
+		s.budget.charge(n)
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package gitsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudgetWait(t *testing.T) {
+	b := newBudget(1000)
+	if !b.wait(context.Background(), time.Time{}) {
+		t.Fatalf("expected a fresh budget not to wait")
+	}
+	// Overdrawn for 0.2s.
+	b.charge(1200)
+
+	now := time.Now()
+	order := make(chan string, 2)
+	for name, lastSuccess := range map[string]time.Time{"recent": now, "stale": now.Add(-time.Hour)} {
+		go func(name string, lastSuccess time.Time) {
+			if b.wait(context.Background(), lastSuccess) {
+				order <- name
+			}
+		}(name, lastSuccess)
+	}
+	start := time.Now()
+	if first := <-order; first != "stale" {
+		t.Fatalf("expected the stale repo to go first but %s did", first)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("expected to wait for the budget but waited %v", d)
+	}
+	<-order
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.charge(10000)
+	cancel()
+	if b.wait(ctx, time.Time{}) {
+		t.Fatalf("expected a cancelled wait to fail")
+	}
+}
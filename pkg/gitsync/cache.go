@@ -0,0 +1,77 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	cacheHit   = "hit"
+	cacheClone = "clone"
+)
+
+// prepareCache makes Options.CacheDir a bare clone of the repo, up to date
+// with Options.Branch, for Clone to borrow objects from.  A cache left by a
+// previous run, e.g. on a volume that outlives the pod, is checked quickly
+// and reused, and otherwise cloned afresh.
+func (s *Syncer) prepareCache(ctx context.Context) error {
+	ctx = withLogFields(ctx, "phase", "cache")
+	dir := s.opts.CacheDir
+	ref := "refs/heads/" + s.opts.Branch
+
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+		// Only what the branch needs is checked, which is much quicker than
+		// a full fsck.
+		_, err := s.runCommand(ctx, dir, "git", "fsck", "--connectivity-only", "--no-dangling", "--no-progress", ref)
+		if err == nil {
+			args := append([]string{"fetch", "--tags"}, s.shallowArgs()...)
+			err = s.runTransfer(ctx, dir, append(args, "origin", "+"+ref+":"+ref)...)
+		}
+		if err == nil {
+			s.recordCache(cacheHit)
+			s.logger(ctx).V(0).Infof("reusing cache %s", dir)
+			return nil
+		}
+		s.logger(ctx).V(0).Infof("WARNING: cache %s can't be used, cloning it again: %v", dir, err)
+	}
+
+	// Remove the contents, rather than the directory, which may be a
+	// mount point.
+	entries, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(e); err != nil {
+			return fmt.Errorf("error clearing cache: %v", err)
+		}
+	}
+	args := []string{"clone", "--bare", "-b", s.opts.Branch}
+	if s.opts.Depth != 0 {
+		args = append(args, "--depth", strconv.Itoa(s.opts.Depth))
+	}
+	args = append(args, s.shallowArgs()...)
+	if s.opts.PartialClone {
+		args = append(args, "--filter=blob:none")
+	}
+	if err := s.runTransfer(ctx, "", append(args, s.opts.Repo, dir)...); err != nil {
+		return err
+	}
+	s.recordCache(cacheClone)
+	s.logger(ctx).V(0).Infof("cloned cache %s", dir)
+	return nil
+}
+
+// recordCache counts a use of the cache, with result cacheHit or
+// cacheClone.
+func (s *Syncer) recordCache(result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cacheUses == nil {
+		s.cacheUses = map[string]int{}
+	}
+	s.cacheUses[result]++
+}
@@ -0,0 +1,55 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrepareCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	for _, args := range [][]string{
+		{"init", "-q", repo},
+		{"-C", repo, "-c", "user.name=a", "-c", "user.email=a@a", "commit", "-q", "--allow-empty", "-m", "one"},
+		{"-C", repo, "branch", "-f", "sync"},
+	} {
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("can't set up repo: %v: %s", err, output)
+		}
+	}
+
+	cache := filepath.Join(dir, "cache")
+	s := &Syncer{opts: Options{Repo: repo, Branch: "sync", CacheDir: cache}, env: map[string]string{}}
+	ctx := context.Background()
+	steps := []struct {
+		prepare func()
+		result  string
+	}{
+		{func() {}, cacheClone},
+		{func() {}, cacheHit},
+		{func() { os.RemoveAll(filepath.Join(cache, "objects")) }, cacheClone},
+	}
+
+	for i, step := range steps {
+		step.prepare()
+		before := s.metrics()
+		if err := s.prepareCache(ctx); err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if got := s.cacheUses[step.result] - before.cacheUses[step.result]; got != 1 {
+			t.Fatalf("step %d: expected a cache %s but %v counted", i, step.result, s.cacheUses)
+		}
+		if _, err := s.runCommand(ctx, cache, "git", "rev-parse", "--verify", "refs/heads/sync"); err != nil {
+			t.Fatalf("step %d: expected the branch in the cache but got %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stagedHash returns the revision Options.CanaryLink points to, or "".
+func (s *Syncer) stagedHash() string {
+	if s.opts.CanaryLink == "" {
+		return ""
+	}
+	target, err := os.Readlink(filepath.Join(s.opts.Root, s.opts.CanaryLink))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(filepath.Base(target), revDirPrefix)
+}
+
+// stage points Options.CanaryLink to the new revision in st, rather than
+// publishing it, and removes the revision it pointed to before, unless
+// that is published.
+func (s *Syncer) stage(ctx context.Context, st *SyncState) error {
+	ctx = withLogFields(ctx, "phase", "stage")
+	oldDir, err := s.updateSymlink(ctx, s.opts.Root, s.opts.CanaryLink, st.Dir)
+	if err != nil {
+		return s.discard(ctx, st.Dir, err)
+	}
+	s.logger(ctx).V(0).Infof("staged %s in %s", st.NewHash, s.opts.CanaryLink)
+	if oldDir == "" || filepath.Base(oldDir) == revDirPrefix+st.OldHash || filepath.Base(oldDir) == filepath.Base(st.Dir) {
+		return nil
+	}
+	return s.removeRevision(ctx, oldDir)
+}
+
+// unstage points Options.CanaryLink back to the published revision, e.g.
+// once the rev is back at it, and removes the revision it pointed to.
+func (s *Syncer) unstage(ctx context.Context, published string) error {
+	staged := s.stagedHash()
+	if staged == "" || published == "" || staged == published {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "stage")
+	stagedDir := filepath.Join(s.opts.Root, revDirPrefix+staged)
+	if _, err := s.updateSymlink(ctx, s.opts.Root, s.opts.CanaryLink, s.publishedDir(published)); err != nil {
+		return err
+	}
+	s.logger(ctx).V(0).Infof("unstaged %s", staged)
+	return s.removeRevision(ctx, stagedDir)
+}
+
+// promote publishes the staged revision in st once it has soaked for
+// Options.CanarySoak seconds or been approved with Promote.
+func (s *Syncer) promote(ctx context.Context, st *SyncState) error {
+	s.mu.Lock()
+	approved := s.approved == st.NewHash
+	s.mu.Unlock()
+
+	if !approved {
+		soak := waitTime(s.opts.CanarySoak)
+		info, err := os.Lstat(filepath.Join(s.opts.Root, s.opts.CanaryLink))
+		if err != nil {
+			return fmt.Errorf("error accessing %s: %v", s.opts.CanaryLink, err)
+		}
+		if staged := time.Since(info.ModTime()); soak == 0 || staged < soak {
+			if soak == 0 {
+				s.logger(ctx).V(1).Infof("%s is staged, waiting for approval", st.NewHash)
+			} else {
+				s.logger(ctx).V(1).Infof("%s is staged, promoting in %v", st.NewHash, soak-staged-(soak-staged)%time.Second)
+			}
+			return nil
+		}
+	}
+
+	ctx = withLogFields(ctx, "hash", st.NewHash)
+	s.logger(ctx).V(0).Infof("promoting %s", st.NewHash)
+	st.Dir = filepath.Join(s.opts.Root, revDirPrefix+st.NewHash)
+	if err := s.runPhase(ctx, PhasePublish, st, s.publish); err != nil {
+		return s.cleanupAfter(ctx, st, err)
+	}
+	s.mu.Lock()
+	s.approved = ""
+	s.mu.Unlock()
+	return s.runPhase(ctx, PhaseCleanup, st, s.cleanup)
+}
+
+// Promote approves the revision staged in Options.CanaryLink, which the
+// next sync, started right away, publishes.
+func (s *Syncer) Promote() error {
+	if s.opts.CanaryLink == "" {
+		return fmt.Errorf("repo %q has no canary link", s.opts.Name)
+	}
+	staged := s.stagedHash()
+	if published, _ := s.publishedHash(); staged == "" || staged == published {
+		return fmt.Errorf("repo %q has no revision staged", s.opts.Name)
+	}
+	s.mu.Lock()
+	s.approved = staged
+	s.mu.Unlock()
+	s.poke()
+	return nil
+}
@@ -0,0 +1,67 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCanaryLink(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	source := &fakeSource{hash: "one"}
+	s := &Syncer{
+		opts:   Options{Root: root, Dest: "link", CanaryLink: "next"},
+		source: source,
+		env:    map[string]string{},
+	}
+	ctx := context.Background()
+	expect := func(published, staged string) {
+		if err := s.SyncOnce(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := s.publishedHash(); got != published {
+			t.Fatalf("expected %q to be published but %q is", published, got)
+		}
+		if got := s.stagedHash(); got != staged {
+			t.Fatalf("expected %q to be staged but %q is", staged, got)
+		}
+	}
+
+	// Without a soak period, only approval promotes.
+	expect("", "one")
+	expect("", "one")
+	if err := s.Promote(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect("one", "one")
+	if err := s.Promote(); err == nil {
+		t.Fatalf("expected an error with nothing staged")
+	}
+
+	s.opts.CanarySoak = 0.2
+	source.hash = "two"
+	expect("one", "two")
+	expect("one", "two")
+	time.Sleep(300 * time.Millisecond)
+	expect("two", "two")
+	if _, err := os.Stat(filepath.Join(root, revDirPrefix+"one")); !os.IsNotExist(err) {
+		t.Fatalf("expected the previous revision to be removed but got %v", err)
+	}
+
+	// Going back to the published revision unstages the new one.
+	source.hash = "three"
+	expect("two", "three")
+	source.hash = "two"
+	expect("two", "two")
+	if _, err := os.Stat(filepath.Join(root, revDirPrefix+"three")); !os.IsNotExist(err) {
+		t.Fatalf("expected the unstaged revision to be removed but got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CloneCheckFail refuses to sync a clone that doesn't match the options.
+	CloneCheckFail = "fail"
+	// CloneCheckWarn logs how a clone doesn't match the options, and syncs
+	// it anyway.
+	CloneCheckWarn = "warn"
+	// CloneCheckReconcile changes a clone to match the options.
+	CloneCheckReconcile = "reconcile"
+)
+
+// cloneMismatch is a way in which an existing clone doesn't match the
+// options, and how to fix it.
+type cloneMismatch struct {
+	problem string
+	fix     func(ctx context.Context) error
+}
+
+// checkClone checks, per Options.CloneCheck, that an existing clone in
+// Options.Root was cloned from Options.Repo, tracks Options.Branch, and is
+// shallow only if the options ask for it, as it may have been left by a
+// git-sync with other flags.  It checks once, or before every sync with
+// Options.CloneCheckEverySync.
+func (s *Syncer) checkClone(ctx context.Context) error {
+	if _, ok := s.source.(*gitSource); !ok || s.opts.CloneCheck == "" {
+		return nil
+	}
+	if s.cloneChecked && !s.opts.CloneCheckEverySync {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(s.opts.Root, ".git")); os.IsNotExist(err) {
+		// The sync clones it afresh.
+		return nil
+	}
+
+	mismatches, err := s.cloneMismatches(ctx)
+	if err != nil {
+		return fmt.Errorf("can't check the clone in %s: %v", s.opts.Root, err)
+	}
+	problems := []string{}
+	for _, m := range mismatches {
+		problems = append(problems, m.problem)
+	}
+	switch {
+	case len(mismatches) == 0:
+	case s.opts.CloneCheck == CloneCheckFail:
+		return fmt.Errorf("the clone in %s doesn't match the flags: %s (remove it, or use --clone-check=%s)", s.opts.Root, strings.Join(problems, "; "), CloneCheckReconcile)
+	case s.opts.CloneCheck == CloneCheckWarn:
+		s.logger(ctx).V(0).Infof("WARNING: the clone in %s doesn't match the flags: %s", s.opts.Root, strings.Join(problems, "; "))
+	default:
+		for _, m := range mismatches {
+			if err := m.fix(ctx); err != nil {
+				return fmt.Errorf("can't reconcile the clone in %s (%s): %v", s.opts.Root, m.problem, err)
+			}
+			s.logger(ctx).V(0).Infof("reconciled the clone in %s: %s", s.opts.Root, m.problem)
+		}
+	}
+	s.cloneChecked = true
+	return nil
+}
+
+// cloneMismatches returns the ways in which the clone in Options.Root
+// doesn't match the options.
+func (s *Syncer) cloneMismatches(ctx context.Context) ([]cloneMismatch, error) {
+	mismatches := []cloneMismatch{}
+
+	output, err := s.runCommand(ctx, s.opts.Root, "git", "config", "--get", "remote.origin.url")
+	if err != nil {
+		return nil, err
+	}
+	if url := strings.TrimSpace(output); !sameRepo(url, s.opts.Repo) {
+		mismatches = append(mismatches, cloneMismatch{
+			problem: fmt.Sprintf("it was cloned from %s, not --repo %s", url, s.opts.Repo),
+			fix: func(ctx context.Context) error {
+				_, err := s.runCommand(ctx, s.opts.Root, "git", "remote", "set-url", "origin", s.opts.Repo)
+				return err
+			},
+		})
+	}
+
+	branch := "a detached HEAD"
+	if output, err := s.runCommand(ctx, s.opts.Root, "git", "symbolic-ref", "-q", "--short", "HEAD"); err == nil {
+		branch = "branch " + strings.TrimSpace(output)
+	}
+	if branch != "branch "+s.opts.Branch {
+		mismatches = append(mismatches, cloneMismatch{
+			problem: fmt.Sprintf("it tracks %s, not --branch %s", branch, s.opts.Branch),
+			fix: func(ctx context.Context) error {
+				_, err := s.runCommand(ctx, s.opts.Root, "git", "symbolic-ref", "HEAD", "refs/heads/"+s.opts.Branch)
+				return err
+			},
+		})
+	}
+
+	shallow := s.opts.Depth != 0 || s.opts.AutoDepth || len(s.shallowArgs()) > 0
+	if _, err := os.Stat(filepath.Join(s.opts.Root, ".git", "shallow")); err == nil && !shallow {
+		mismatches = append(mismatches, cloneMismatch{
+			problem: "it is shallow, but no --depth, --shallow-since, --shallow-exclude or --auto-depth is set",
+			fix: func(ctx context.Context) error {
+				return s.throttle(ctx, func() error {
+					return s.runTransfer(ctx, s.opts.Root, "fetch", "--unshallow", "origin", s.opts.Branch)
+				})
+			},
+		})
+	}
+	return mismatches, nil
+}
+
+// sameRepo returns true if url, the remote of a clone, is repo, which git
+// records as an absolute path if it is a local one.
+func sameRepo(url, repo string) bool {
+	if url == repo {
+		return true
+	}
+	if _, err := os.Stat(repo); err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(repo)
+	return err == nil && abs == url
+}
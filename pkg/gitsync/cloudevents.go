@@ -0,0 +1,71 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// cloudEventType is the CloudEvents type of an Event.
+	cloudEventType = "io.k8s.git-sync.published"
+	// cloudEventSpecVersion is the version of CloudEvents we speak.
+	cloudEventSpecVersion = "1.0"
+)
+
+// cloudEventsPublisher sends Events to a CloudEvents sink, e.g. a Knative
+// broker or an Argo Events webhook source, in the HTTP binary content mode:
+// the Event is the JSON body and its CloudEvents attributes are headers.
+type cloudEventsPublisher struct {
+	sink string
+}
+
+// newCloudEventsPublisher returns a publisher to the sink at rawURL.
+func newCloudEventsPublisher(rawURL string) (*cloudEventsPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPURL(rawURL) || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", rawURL)
+	}
+	return &cloudEventsPublisher{sink: rawURL}, nil
+}
+
+func (p *cloudEventsPublisher) PublishEvent(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", p.sink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", cloudEventSpecVersion)
+	req.Header.Set("Ce-Type", cloudEventType)
+	req.Header.Set("Ce-Source", e.Repo)
+	req.Header.Set("Ce-Subject", e.Name)
+	// A revision can be published more than once, e.g. after a rollback.
+	req.Header.Set("Ce-Id", fmt.Sprintf("%s-%s-%d", e.Name, e.NewHash, e.Time.UnixNano()))
+	req.Header.Set("Ce-Time", e.Time.UTC().Format(time.RFC3339Nano))
+
+	client := &http.Client{Timeout: eventTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending CloudEvent: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("CloudEvents sink returned status %d: %q", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloudEventsPublisher(t *testing.T) {
+	cases := []struct {
+		status int
+		err    bool
+	}{
+		{http.StatusAccepted, false},
+		{http.StatusNotFound, true},
+	}
+
+	for _, testCase := range cases {
+		var headers http.Header
+		var e Event
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = r.Header
+			json.NewDecoder(r.Body).Decode(&e)
+			w.WriteHeader(testCase.status)
+		}))
+
+		p, err := newCloudEventsPublisher(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		err = p.PublishEvent(context.Background(), Event{Name: "a", Repo: "https://a/a", NewHash: "abc", Time: now})
+		server.Close()
+		if (err != nil) != testCase.err {
+			t.Fatalf("%d: expected error %v but got %v", testCase.status, testCase.err, err)
+		}
+
+		expected := map[string]string{
+			"Ce-Specversion": "1.0",
+			"Ce-Type":        cloudEventType,
+			"Ce-Source":      "https://a/a",
+			"Ce-Subject":     "a",
+			"Ce-Time":        "2020-01-02T03:04:05Z",
+			"Content-Type":   "application/json",
+		}
+		for k, v := range expected {
+			if got := headers.Get(k); got != v {
+				t.Fatalf("%d: expected %s %q but %q sent", testCase.status, k, v, got)
+			}
+		}
+		if headers.Get("Ce-Id") == "" || e.NewHash != "abc" {
+			t.Fatalf("%d: expected an id and the event but %q and %+v sent", testCase.status, headers.Get("Ce-Id"), e)
+		}
+	}
+
+	if _, err := newCloudEventsPublisher("broker.default.svc"); err == nil {
+		t.Fatalf("expected an error for a URL without a scheme")
+	}
+}
@@ -0,0 +1,258 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// publishCopy copies the files of newDir into the Options.Dest directory,
+// leaving the directory itself in place, and returns newDir, which is no
+// longer needed.
+func (s *Syncer) publishCopy(ctx context.Context, hash, newDir string) (string, error) {
+	dest := filepath.Join(s.opts.Root, s.opts.Dest)
+	exists, err := checkDestDir(dest)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := os.Mkdir(dest, 0755); err != nil {
+			return "", fmt.Errorf("error creating %s: %v", dest, err)
+		}
+	}
+
+	// Files are staged under the root, rather than in dest, so that
+	// consumers never see them, and renamed into place from there.
+	tmpDir := filepath.Join(s.opts.Root, "tmp-copy")
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return "", fmt.Errorf("error removing stale directory: %v", err)
+	}
+	if err := os.Mkdir(tmpDir, 0700); err != nil {
+		return "", fmt.Errorf("error creating %s: %v", tmpDir, err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	copied, err := copyTree(newDir, dest, tmpDir)
+	if err != nil {
+		return "", fmt.Errorf("error copying %s to %s: %v", newDir, dest, err)
+	}
+	removed, err := removeExtraFiles(newDir, dest)
+	if err != nil {
+		return "", fmt.Errorf("error removing old files from %s: %v", dest, err)
+	}
+	s.logger(ctx).V(1).Infof("copied %d and removed %d files in %s", copied, removed, dest)
+
+	if err := writeFileAtomic(s.hashFile(), []byte(hash+"\n")); err != nil {
+		return "", fmt.Errorf("error recording published hash: %v", err)
+	}
+	return newDir, nil
+}
+
+// copyTree makes every directory, file and symlink in src, except a
+// top-level .git, exist in dst, and returns how many it had to change.
+// Changed files and symlinks are written in tmpDir, which must be on the
+// same filesystem as dst, and renamed into place.
+func copyTree(src, dst, tmpDir string) (int, error) {
+	copied := 0
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		existing, err := os.Lstat(target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		exists := err == nil
+
+		switch {
+		case info.IsDir():
+			if exists && !existing.IsDir() {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+				exists = false
+			}
+			if !exists {
+				if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+					return err
+				}
+				copied++
+			} else if existing.Mode().Perm() != info.Mode().Perm() {
+				if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+					return err
+				}
+			}
+			return nil
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if exists && existing.Mode()&os.ModeSymlink != 0 {
+				if old, err := os.Readlink(target); err == nil && old == link {
+					return nil
+				}
+			}
+			tmp := filepath.Join(tmpDir, "link")
+			if err := os.Symlink(link, tmp); err != nil {
+				return err
+			}
+			copied++
+			return replaceFile(tmp, target, existing)
+
+		case info.Mode().IsRegular():
+			if exists {
+				same, err := sameContents(path, info, target, existing)
+				if err != nil || same {
+					return err
+				}
+			}
+			tmp, err := copyFile(path, info.Mode().Perm(), tmpDir)
+			if err != nil {
+				return err
+			}
+			copied++
+			return replaceFile(tmp, target, existing)
+		}
+		return nil
+	})
+	return copied, err
+}
+
+// replaceFile renames tmp to target, first removing target if it is a
+// directory, which rename can't replace.
+func replaceFile(tmp, target string, existing os.FileInfo) error {
+	if existing != nil && existing.IsDir() {
+		if err := os.RemoveAll(target); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// sameContents returns true if the regular files a and b have the same
+// permissions and contents.
+func sameContents(a string, aInfo os.FileInfo, b string, bInfo os.FileInfo) (bool, error) {
+	if !bInfo.Mode().IsRegular() || aInfo.Mode() != bInfo.Mode() || aInfo.Size() != bInfo.Size() {
+		return false, nil
+	}
+	aData, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	bData, err := ioutil.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aData, bData), nil
+}
+
+// copyFile copies the file at path to a new file in dir, with permissions
+// perm, and returns its name.  Where the filesystem supports it, the copy
+// is a reflink, which shares the original's blocks until either changes.
+func copyFile(path string, perm os.FileMode, dir string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(dir, "file")
+	if err != nil {
+		return "", err
+	}
+	if cloneFile(out, in) != nil {
+		_, err = io.Copy(out, in)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// removeExtraFiles removes whatever is in dst but not in src, and returns
+// how many files and directories it removed.
+func removeExtraFiles(src, dst string) (int, error) {
+	removed := 0
+	err := filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		_, err = os.Lstat(filepath.Join(src, rel))
+		if err == nil && rel != ".git" {
+			return nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		removed++
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// reflinkFile creates dst as a copy-on-write clone of the file src, with
+// its permissions.
+func reflinkFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = cloneFile(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package gitsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates files, mapping a path to its contents, under dir.  A
+// contents of "->target" creates a symlink instead.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for path, contents := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(contents) > 2 && contents[:2] == "->" {
+			if err := os.Symlink(contents[2:], path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	src, dst, tmp := filepath.Join(root, "src"), filepath.Join(root, "dst"), filepath.Join(root, "tmp")
+	for _, dir := range []string{src, dst, tmp} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	writeTree(t, src, map[string]string{
+		".git":          "gitdir: ../.git/worktrees/rev-x",
+		"same":          "same",
+		"changed":       "new",
+		"dir/file":      "new",
+		"was-a-dir":     "file",
+		"link":          "->same",
+		"sub/kept/file": "kept",
+	})
+	writeTree(t, dst, map[string]string{
+		"same":            "same",
+		"changed":         "old",
+		"was-a-dir/file":  "old",
+		"gone":            "old",
+		"gone-dir/file":   "old",
+		"sub/kept/file":   "kept",
+		"sub/kept/extra":  "old",
+		"link":            "->changed",
+		".git/worktrees/": "",
+	})
+
+	copied, err := copyTree(src, dst, tmp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if copied != 5 {
+		t.Fatalf("expected 5 changes but %d were made", copied)
+	}
+	removed, err := removeExtraFiles(src, dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 4 {
+		t.Fatalf("expected 4 removals but %d were made", removed)
+	}
+
+	expected := map[string]string{
+		"same":          "same",
+		"changed":       "new",
+		"dir/file":      "new",
+		"was-a-dir":     "file",
+		"link":          "same",
+		"sub/kept/file": "kept",
+	}
+	for path, contents := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(dst, path))
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", path, err)
+		}
+		if string(data) != contents {
+			t.Fatalf("expected %s to contain %q but %q returned", path, contents, string(data))
+		}
+	}
+	for _, path := range []string{".git", "gone", "gone-dir", "sub/kept/extra"} {
+		if _, err := os.Lstat(filepath.Join(dst, path)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed but got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// decrypt decrypts the SOPS and age encrypted files of a new revision in
+// place, with the age identities in Options.DecryptKeyFile, before the
+// post-checkout hooks see it.
+func (s *Syncer) decrypt(ctx context.Context, st *SyncState) error {
+	if s.opts.DecryptKeyFile == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "decrypt")
+
+	sopsFiles, ageFiles := []string{}, []string{}
+	err := filepath.Walk(st.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if fi.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if matchName(s.opts.DecryptSOPSPattern, fi.Name()) {
+			sopsFiles = append(sopsFiles, path)
+		} else if matchName(s.opts.DecryptAgePattern, fi.Name()) {
+			ageFiles = append(ageFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error finding encrypted files: %v", err)
+	}
+
+	st.decrypted = map[string]bool{}
+	decrypted := func(path string) {
+		if rel, err := filepath.Rel(st.Dir, path); err == nil {
+			st.decrypted[filepath.ToSlash(rel)] = true
+		}
+	}
+	for _, path := range sopsFiles {
+		if err := s.runDecrypt(ctx, "sops", "--decrypt", "--in-place", path); err != nil {
+			return err
+		}
+		decrypted(path)
+	}
+	for _, path := range ageFiles {
+		out := strings.TrimSuffix(path, filepath.Ext(path))
+		if err := s.runDecrypt(ctx, "age", "--decrypt", "--identity", s.opts.DecryptKeyFile, "--output", out, path); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		decrypted(out)
+	}
+	if n := len(sopsFiles) + len(ageFiles); n > 0 {
+		s.logger(ctx).V(1).Infof("decrypted %d files", n)
+	}
+	return nil
+}
+
+// matchName returns true if pattern is set and matches name.
+func matchName(pattern, name string) bool {
+	if pattern == "" {
+		return false
+	}
+	matched, _ := filepath.Match(pattern, name)
+	return matched
+}
+
+// runDecrypt runs a decryption command, pointing SOPS at the key file.
+func (s *Syncer) runDecrypt(ctx context.Context, command string, args ...string) error {
+	s.logger(ctx).V(5).Infof("run: %s", cmdForLog(command, args...))
+	cmd := s.command(ctx, command, args...)
+	cmd.Env = append(cmd.Env, "SOPS_AGE_KEY_FILE="+s.opts.DecryptKeyFile)
+	output, err := runGroup(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("error decrypting %s: %v: %q", args[len(args)-1], err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// treeSource is a fakeSource whose revisions hold files, as for writeTree.
+type treeSource struct {
+	fakeSource
+	t     *testing.T
+	files map[string]string
+}
+
+func (f *treeSource) Materialize(ctx context.Context, hash, dir string) error {
+	if err := f.fakeSource.Materialize(ctx, hash, dir); err != nil {
+		return err
+	}
+	writeTree(f.t, dir, f.files)
+	return nil
+}
+
+func TestSyncOnceDecrypt(t *testing.T) {
+	bin, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(bin)
+
+	// Fake sops and age, which "decrypt" by writing the key file's path.
+	ioutil.WriteFile(filepath.Join(bin, "sops"), []byte("#!/bin/sh\n[ \"$1 $2\" = \"--decrypt --in-place\" ] || exit 1\necho \"$SOPS_AGE_KEY_FILE\" > \"$3\"\n"), 0755)
+	ioutil.WriteFile(filepath.Join(bin, "age"), []byte("#!/bin/sh\n[ \"$1\" = --decrypt ] || exit 1\necho \"$3\" > \"$5\"\n"), 0755)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	s := &Syncer{
+		opts: Options{
+			Root:               root,
+			Dest:               "link",
+			Rev:                "HEAD",
+			DecryptKeyFile:     "/keys/age.txt",
+			DecryptSOPSPattern: "*.sops.*",
+			DecryptAgePattern:  "*.age",
+		},
+		source: &treeSource{fakeSource: fakeSource{hash: "one"}, t: t, files: map[string]string{
+			"config.sops.yaml":    "ENC",
+			"sub/token.age":       "ENC",
+			"plain.yaml":          "plain",
+			".git/objects/x.age":  "ENC",
+			"sub/other.sops.json": "ENC",
+		}},
+		env: map[string]string{},
+	}
+	if err := s.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := filepath.Join(root, "rev-one")
+	expected := map[string]string{
+		"config.sops.yaml":    "/keys/age.txt\n",
+		"sub/other.sops.json": "/keys/age.txt\n",
+		"sub/token":           "/keys/age.txt\n",
+		"plain.yaml":          "plain",
+		".git/objects/x.age":  "ENC",
+	}
+	for path, contents := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", path, err)
+		}
+		if string(data) != contents {
+			t.Fatalf("%s: expected %q but %q found", path, contents, string(data))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub/token.age")); !os.IsNotExist(err) {
+		t.Fatalf("expected the encrypted age file to be removed but got %v", err)
+	}
+}
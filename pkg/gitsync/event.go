@@ -0,0 +1,152 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// eventTimeout bounds publishing an Event when ctx has no deadline.
+const eventTimeout = 30 * time.Second
+
+// Event reports that a new revision of a repo has been published.
+type Event struct {
+	Name string `json:"name"`
+	Repo string `json:"repo"`
+	Rev  string `json:"rev"`
+	// OldHash is the revision that was replaced, if any.
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash"`
+	// Paths are the files that differ between OldHash and NewHash, if the
+	// SourceProvider can tell.
+	Paths []string  `json:"paths,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// EventPublisher sends Events to a downstream system, e.g. a message
+// queue, so that it can react to changes without polling.
+type EventPublisher interface {
+	PublishEvent(ctx context.Context, e Event) error
+}
+
+// ChangeLister is implemented by SourceProviders that can list the paths
+// that differ between two revisions, for Event.Paths.
+type ChangeLister interface {
+	ChangedPaths(ctx context.Context, oldHash, newHash string) ([]string, error)
+}
+
+// Commit describes a revision, for notifications.
+type Commit struct {
+	Hash      string
+	Committer string
+	// Subject is the first line of the commit message.
+	Subject string
+}
+
+// CommitDescriber is implemented by SourceProviders that can describe a
+// revision.
+type CommitDescriber interface {
+	DescribeCommit(ctx context.Context, hash string) (Commit, error)
+}
+
+// newEventPublishers returns the publishers configured by the options of
+// each kind, not including Options.Publishers.
+func newEventPublishers(o Options) ([]EventPublisher, error) {
+	publishers := []EventPublisher{}
+	if o.NATSURL != "" {
+		p, err := newNATSPublisher(o.NATSURL, o.NATSSubject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --nats-url or --nats-subject: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.KafkaRESTURL != "" {
+		p, err := newKafkaPublisher(o.KafkaRESTURL, o.KafkaTopic, o.KafkaKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --kafka-rest-url, --kafka-topic or --kafka-key: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.SQSQueueURL != "" {
+		p, err := newSQSPublisher(o.SQSQueueURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sqs-queue-url: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.SNSTopicARN != "" {
+		p, err := newSNSPublisher(o.SNSTopicARN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sns-topic-arn: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.PubSubTopic != "" {
+		p, err := newPubSubPublisher(o.PubSubTopic, o.GCPServiceAccount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --pubsub-topic: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.CloudEventsSink != "" {
+		p, err := newCloudEventsPublisher(o.CloudEventsSink)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --cloudevents-sink: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.GitHubStatusContext != "" {
+		p, err := newGitHubStatusPublisher(o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --github-status-context: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.WebhookURL != "" {
+		p, err := newWebhookPublisher(o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --webhook-* flags: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	if o.ReloadURL != "" {
+		p, err := newReloadPublisher(o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --reload-* flags: %v", err)
+		}
+		publishers = append(publishers, p)
+	}
+	return publishers, nil
+}
+
+// publishEvent sends an Event for the swap from oldHash to newHash to every
+// publisher.  The swap has happened by now, so failures are only logged.
+func (s *Syncer) publishEvent(ctx context.Context, oldHash, newHash string) {
+	if len(s.publishers) == 0 {
+		return
+	}
+
+	e := Event{
+		Name:    s.opts.Name,
+		Repo:    s.opts.Repo,
+		Rev:     s.opts.Rev,
+		OldHash: oldHash,
+		NewHash: newHash,
+		Time:    time.Now(),
+	}
+	if lister, ok := s.source.(ChangeLister); ok && oldHash != "" {
+		paths, err := lister.ChangedPaths(ctx, oldHash, newHash)
+		if err != nil {
+			s.logger(ctx).Errorf("can't list changed paths: %v", err)
+		}
+		e.Paths = paths
+	}
+
+	for _, p := range s.publishers {
+		if err := p.PublishEvent(ctx, e); err != nil {
+			s.logger(ctx).Errorf("error publishing event: %v", err)
+			continue
+		}
+		s.logger(ctx).V(1).Infof("published event to %T", p)
+	}
+}
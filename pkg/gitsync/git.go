@@ -0,0 +1,537 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gitSource is the SourceProvider for git repos.  It keeps a clone in
+// Options.Root and materializes each revision as a worktree of it.
+type gitSource struct {
+	s *Syncer
+	// fallback, if set, is the ref fetched in place of Options.Branch while
+	// the branch or tag synced is missing, per MissingRefFallback.
+	fallback string
+}
+
+// Resolve clones the repo if needed and returns the hash of rev.
+func (g *gitSource) Resolve(ctx context.Context, rev string) (string, error) {
+	s := g.s
+	gitRepoPath := filepath.Join(s.opts.Root, ".git")
+	_, err := os.Stat(gitRepoPath)
+	switch {
+	case os.IsNotExist(err):
+		if err := s.Clone(ctx); err != nil {
+			return "", err
+		}
+		if rev != "HEAD" {
+			if err := g.deepenTo(ctx, rev); err != nil {
+				return "", err
+			}
+		}
+		return s.hashForRev(ctx, rev)
+	case err != nil:
+		return "", fmt.Errorf("error checking if repo exists %q: %v", gitRepoPath, err)
+	}
+
+	if fullHashRE.MatchString(rev) {
+		// A full hash can't move, so the remote needn't be asked.
+		return rev, nil
+	}
+
+	if rev == "HEAD" {
+		ref := "refs/heads/" + s.opts.Branch
+		hash, err := s.remoteHashForRef(ctx, ref, s.opts.Root)
+		if err != nil {
+			return "", err
+		}
+		if hash == "" {
+			return g.missingRef(ctx, fmt.Sprintf("branch %q", s.opts.Branch))
+		}
+		g.fallback = ""
+		return hash, nil
+	}
+	return g.resolveTag(ctx, rev)
+}
+
+// resolveTag returns the commit that the remote's tag rev points to.  An
+// annotated tag is listed twice, as itself and, with "^{}", peeled to its
+// commit; a lightweight tag is listed only as its commit.  If the remote has
+// no such tag, an abbreviated hash is resolved in the clone.  With
+// Options.ImmutableTags, a tag already in the clone isn't looked up again.
+func (g *gitSource) resolveTag(ctx context.Context, rev string) (string, error) {
+	s := g.s
+	ref := "refs/tags/" + rev
+	local, _ := s.runCommand(ctx, s.opts.Root, "git", "rev-parse", "-q", "--verify", ref+"^{commit}")
+	local = strings.TrimSpace(local)
+	if s.opts.ImmutableTags && local != "" {
+		return local, nil
+	}
+	output, err := s.runCommand(ctx, s.opts.Root, "git", "ls-remote", "-q", "origin", ref, ref+"^{}")
+	if err != nil {
+		return "", err
+	}
+	refs := parseLsRemote(output)
+	for _, r := range []string{ref + "^{}", ref} {
+		if hash, found := refs[r]; found {
+			if local != "" && hash != local {
+				s.logger(ctx).V(0).Infof("tag %s moved from %s to %s", rev, local, hash)
+			}
+			g.fallback = ""
+			return hash, nil
+		}
+	}
+	if hashRE.MatchString(rev) {
+		// Not a tag, so probably a commit, which remotes don't list.
+		return s.hashForRev(ctx, rev)
+	}
+	return g.missingRef(ctx, fmt.Sprintf("tag %q", rev))
+}
+
+// branch returns the branch to fetch: Options.Branch, or the fallback ref
+// in its place.
+func (g *gitSource) branch() string {
+	if g.fallback != "" {
+		return g.fallback
+	}
+	return g.s.opts.Branch
+}
+
+// Fetch fetches Options.Branch, or the fallback ref in its place, and tags
+// from the remote, cloning the repo first if Resolve, which usually does, was
+// skipped for a RefResolver.
+func (g *gitSource) Fetch(ctx context.Context, hash string) error {
+	_, err := os.Stat(filepath.Join(g.s.opts.Root, ".git"))
+	if os.IsNotExist(err) {
+		if err := g.s.Clone(ctx); err != nil {
+			return err
+		}
+		return g.deepenTo(ctx, hash)
+	}
+	if hash == g.s.opts.Rev && fullHashRE.MatchString(hash) {
+		// A pinned hash that was fetched before needn't be fetched again.
+		if g.hasCommit(ctx, hash) {
+			g.s.logger(ctx).V(1).Infof("%s is already fetched", hash)
+			return nil
+		}
+	}
+	if err := g.s.fetchBranch(ctx, g.branch()); err != nil {
+		return err
+	}
+	return g.deepenTo(ctx, hash)
+}
+
+// hasCommit returns true if the clone has commit rev.
+func (g *gitSource) hasCommit(ctx context.Context, rev string) bool {
+	_, err := g.s.runCommand(ctx, g.s.opts.Root, "git", "cat-file", "-e", rev+"^{commit}")
+	return err == nil
+}
+
+// deepenTo deepens the clone, with Options.AutoDepth, until it has commit
+// rev: first by fetching rev alone, if it is a full hash and the server
+// allows it, then by ever more commits of the branch, and finally all of
+// them.  Without it, a shallow clone that lacks rev is an error, as rev is
+// older than the history fetched.
+func (g *gitSource) deepenTo(ctx context.Context, rev string) error {
+	s := g.s
+	if g.hasCommit(ctx, rev) {
+		return nil
+	}
+	if !s.opts.AutoDepth {
+		if _, err := os.Stat(filepath.Join(s.opts.Root, ".git", "shallow")); err == nil {
+			return fmt.Errorf("%s is not in the shallow clone of branch %s: use --auto-depth, a larger --depth or an earlier --shallow-since", rev, g.branch())
+		}
+		return nil
+	}
+	return s.throttle(ctx, func() error {
+		if fullHashRE.MatchString(rev) {
+			if err := s.runTransfer(ctx, s.opts.Root, "fetch", "--depth", "1", "origin", rev); err == nil && g.hasCommit(ctx, rev) {
+				s.logger(ctx).V(0).Infof("fetched %s by hash", rev)
+				return nil
+			}
+		}
+		for depth := 16; depth <= 4096; depth *= 4 {
+			if err := s.runTransfer(ctx, s.opts.Root, "fetch", "--deepen", strconv.Itoa(depth), "origin", g.branch()); err != nil {
+				return err
+			}
+			if g.hasCommit(ctx, rev) {
+				s.logger(ctx).V(0).Infof("deepened the clone by %d commits for %s", depth, rev)
+				return nil
+			}
+		}
+		if _, err := os.Stat(filepath.Join(s.opts.Root, ".git", "shallow")); err == nil {
+			if err := s.runTransfer(ctx, s.opts.Root, "fetch", "--unshallow", "origin", g.branch()); err != nil {
+				return err
+			}
+			if g.hasCommit(ctx, rev) {
+				s.logger(ctx).V(0).Infof("fetched the whole history for %s", rev)
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not in the history of branch %s", rev, g.branch())
+	})
+}
+
+// Materialize adds a worktree at dir, reset to hash, which has been
+// fetched.
+func (g *gitSource) Materialize(ctx context.Context, hash, dir string) error {
+	s := g.s
+	// The worktree is added at hash, rather than at the branch and then
+	// reset, so that its files are written once.
+	args := []string{"worktree", "add", "--detach"}
+	oldHash, oldDir := "", ""
+	if s.opts.HardlinkWorktrees || s.opts.ReflinkWorktrees {
+		var err error
+		if oldHash, oldDir, err = g.publishedWorktree(); err != nil {
+			return err
+		}
+	}
+	if len(s.opts.SparsePaths) > 0 || oldDir != "" {
+		// Check out only the sparse paths below, so that a partial clone
+		// fetches no other blobs, or only what isn't linked from oldDir.
+		args = append(args, "--no-checkout")
+	}
+	_, err := s.runCommand(ctx, s.opts.Root, "git", append(args, dir, hash)...)
+	if err != nil {
+		return err
+	}
+	g.s.logger(ctx).V(0).Infof("added worktree %s for %s", dir, hash)
+
+	// The .git file in the worktree directory holds a reference to
+	// /git/.git/worktrees/<worktree-dir-name>. Replace it with a reference
+	// using relative paths, so that other containers can use a different volume
+	// mount name.
+	worktreePathRelative, err := filepath.Rel(s.opts.Root, dir)
+	if err != nil {
+		return err
+	}
+	gitDirRef := []byte("gitdir: ../.git/worktrees/" + filepath.ToSlash(worktreePathRelative) + "\n")
+	if err = ioutil.WriteFile(filepath.Join(dir, ".git"), gitDirRef, 0644); err != nil {
+		return err
+	}
+
+	if len(s.opts.SparsePaths) > 0 {
+		if err := s.setupSparseCheckout(ctx, filepath.ToSlash(worktreePathRelative)); err != nil {
+			return err
+		}
+	}
+
+	if oldDir != "" {
+		if err := g.linkUnchanged(ctx, oldHash, hash, oldDir, dir); err != nil {
+			return err
+		}
+	}
+
+	return g.resetWorktree(ctx, hash, dir)
+}
+
+// publishedWorktree returns the published revision and its directory, or
+// "" if there is none.
+func (g *gitSource) publishedWorktree() (string, string, error) {
+	hash, err := g.s.publishedHash()
+	if err != nil || hash == "" {
+		return "", "", err
+	}
+	dir := g.s.publishedDir(hash)
+	if _, err := os.Stat(dir); err != nil {
+		return "", "", nil
+	}
+	return hash, dir, nil
+}
+
+// linkUnchanged hardlinks, or with Options.ReflinkWorktrees clones, the
+// files of revision hash that are the same in oldHash from oldDir, where
+// oldHash is published, into the new worktree at dir, and loads hash into
+// its index, so that the reset that follows only writes the files that
+// changed.  git replaces files rather than rewriting them, so resetting dir
+// never changes oldDir, and files that were changed in oldDir after
+// checkout, e.g. decrypted, are replaced.
+func (g *gitSource) linkUnchanged(ctx context.Context, oldHash, hash, oldDir, dir string) error {
+	s := g.s
+	link, linked := os.Link, "hardlinked"
+	if s.opts.ReflinkWorktrees {
+		link, linked = reflinkFile, "cloned"
+	}
+	changed, err := g.ChangedPaths(ctx, oldHash, hash)
+	if err != nil {
+		return err
+	}
+	skip := map[string]bool{}
+	for _, p := range changed {
+		skip[p] = true
+	}
+	output, err := s.runCommand(ctx, s.opts.Root, "git", "ls-tree", "-r", "-z", "--name-only", hash)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, p := range strings.Split(output, "\x00") {
+		if p == "" || skip[p] {
+			continue
+		}
+		src := filepath.Join(oldDir, filepath.FromSlash(p))
+		if info, err := os.Lstat(src); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := link(src, dst); err != nil {
+			// E.g. the volume doesn't support hard links or reflinks: the
+			// reset writes the rest.
+			s.logger(ctx).V(0).Infof("WARNING: can't link %s: %v", src, err)
+			break
+		}
+		n++
+	}
+
+	if _, err := s.runCommand(ctx, dir, "git", "read-tree", hash); err != nil {
+		return err
+	}
+	if _, err := s.runCommand(ctx, dir, "git", "update-index", "-q", "--refresh"); err != nil {
+		return err
+	}
+	s.logger(ctx).V(1).Infof("%s %d unchanged files from %s", linked, n, oldDir)
+	return nil
+}
+
+// resetWorktree resets the working copy of the worktree at dir to hash,
+// with its submodules.
+func (g *gitSource) resetWorktree(ctx context.Context, hash, dir string) error {
+	s := g.s
+	_, err := s.runCommand(ctx, dir, "git", "reset", "--hard", hash)
+	if err != nil {
+		return err
+	}
+	g.s.logger(ctx).V(0).Infof("reset worktree %s to %s", dir, hash)
+
+	if s.opts.Submodules {
+		// Submodules are fetched in parallel, per Options.SubmoduleJobs,
+		// since fetching dozens of them one at a time dominates the sync.
+		args := []string{"submodule", "update", "--init", "--recursive"}
+		if s.opts.SubmoduleJobs > 0 {
+			args = append(args, "--jobs", strconv.Itoa(s.opts.SubmoduleJobs))
+		}
+		if _, err := s.runCommand(ctx, dir, "git", args...); err != nil {
+			return err
+		}
+		g.s.logger(ctx).V(0).Infof("updated submodules of worktree %s", dir)
+	}
+	return nil
+}
+
+// reuse resets the existing worktree at dir to hash, e.g. one left by a
+// sync that failed to publish it, or by a rollback, rather than adding it
+// again, which git refuses.  Files that aren't in hash, such as decrypted
+// ones, are removed, so that the worktree is as if newly added.  It returns
+// false if dir is not a worktree of the clone, or can't be reset.
+func (g *gitSource) reuse(ctx context.Context, hash, dir string) bool {
+	s := g.s
+	// A directory without a .git file of its own is inside the clone, and
+	// rev-parse finds the clone's.
+	top, err := s.runCommand(ctx, dir, "git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return false
+	}
+	want, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	if got, err := filepath.EvalSymlinks(strings.TrimSpace(top)); err != nil || got != want {
+		return false
+	}
+	if _, err := s.runCommand(ctx, dir, "git", "clean", "-ffdxq"); err != nil {
+		s.logger(ctx).V(0).Infof("can't reuse worktree %s: %v", dir, err)
+		return false
+	}
+	if err := g.resetWorktree(ctx, hash, dir); err != nil {
+		s.logger(ctx).V(0).Infof("can't reuse worktree %s: %v", dir, err)
+		return false
+	}
+	return true
+}
+
+// setupSparseCheckout limits the checkout of the worktree name, and of the
+// repo itself (which the copy publish strategy checks out from), to
+// Options.SparsePaths.
+func (s *Syncer) setupSparseCheckout(ctx context.Context, name string) error {
+	if _, err := s.runCommand(ctx, s.opts.Root, "git", "config", "core.sparseCheckout", "true"); err != nil {
+		return err
+	}
+	patterns := []byte(strings.Join(s.opts.SparsePaths, "\n") + "\n")
+	for _, gitDir := range []string{
+		filepath.Join(s.opts.Root, ".git"),
+		filepath.Join(s.opts.Root, ".git", "worktrees", filepath.FromSlash(name)),
+	} {
+		info := filepath.Join(gitDir, "info")
+		if err := os.MkdirAll(info, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(info, "sparse-checkout"), patterns, 0644); err != nil {
+			return fmt.Errorf("error writing sparse-checkout: %v", err)
+		}
+	}
+	return nil
+}
+
+// Cleanup prunes the metadata of removed worktrees, and every
+// Options.PruneInterval, the objects that only they needed.
+func (g *gitSource) Cleanup(ctx context.Context) error {
+	_, err := g.s.runCommand(ctx, g.s.opts.Root, "git", "worktree", "prune")
+	if err != nil {
+		return err
+	}
+	g.s.logger(ctx).V(1).Infof("pruned old worktrees")
+	return g.s.pruneObjects(ctx)
+}
+
+// Moved points the metadata of the worktree now at dir to its new path, so
+// that git doesn't prune it.
+func (g *gitSource) Moved(ctx context.Context, dir string) error {
+	ref, err := ioutil.ReadFile(filepath.Join(dir, ".git"))
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(strings.TrimSpace(strings.TrimPrefix(string(ref), "gitdir:")))
+	abs, err := filepath.Abs(filepath.Join(dir, ".git"))
+	if err != nil {
+		return err
+	}
+	gitdir := filepath.Join(g.s.opts.Root, ".git", "worktrees", name, "gitdir")
+	if err := ioutil.WriteFile(gitdir, []byte(abs+"\n"), 0644); err != nil {
+		return fmt.Errorf("error updating worktree %s: %v", name, err)
+	}
+	return nil
+}
+
+// ChangedPaths lists the files that differ between two commits.
+func (g *gitSource) ChangedPaths(ctx context.Context, oldHash, newHash string) ([]string, error) {
+	output, err := g.s.runCommand(ctx, g.s.opts.Root, "git", "diff", "-z", "--name-only", oldHash, newHash)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, p := range strings.Split(string(output), "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// DescribeCommit returns the committer and subject of a commit.
+func (g *gitSource) DescribeCommit(ctx context.Context, hash string) (Commit, error) {
+	output, err := g.s.runCommand(ctx, g.s.opts.Root, "git", "log", "-1", "--format=%cn%x00%s", hash)
+	if err != nil {
+		return Commit{}, err
+	}
+	parts := strings.SplitN(strings.TrimSpace(output), "\x00", 2)
+	if len(parts) != 2 {
+		return Commit{}, fmt.Errorf("unexpected git log output %q", output)
+	}
+	return Commit{Hash: hash, Committer: parts[0], Subject: parts[1]}, nil
+}
+
+// Clone clones the repo into Options.Root, without checking out any files.
+func (s *Syncer) Clone(ctx context.Context) error {
+	args := []string{"clone", "--no-checkout", "-b", s.opts.Branch}
+	if s.opts.Depth != 0 {
+		args = append(args, "--depth", strconv.Itoa(s.opts.Depth))
+	} else if s.opts.AutoDepth {
+		args = append(args, "--depth", "1")
+	}
+	args = append(args, s.shallowArgs()...)
+	if s.opts.PartialClone {
+		// Blobs are fetched on demand, when they are checked out.
+		args = append(args, "--filter=blob:none")
+	}
+	if s.opts.CacheDir != "" {
+		if err := s.prepareCache(ctx); err != nil {
+			return fmt.Errorf("error preparing cache: %v", err)
+		}
+		// Copy the objects, rather than borrowing them for good, so that
+		// the clone doesn't break if the cache does.
+		args = append(args, "--reference", s.opts.CacheDir, "--dissociate")
+	}
+	args = append(args, s.opts.Repo, s.opts.Root)
+	err := s.throttle(ctx, func() error {
+		return s.runTransfer(ctx, "", args...)
+	})
+	if err != nil {
+		return err
+	}
+	s.logger(ctx).V(0).Infof("cloned %s", s.opts.Repo)
+
+	return nil
+}
+
+// Fetch updates the clone from the remote.
+func (s *Syncer) Fetch(ctx context.Context) error {
+	return s.fetchBranch(ctx, s.opts.Branch)
+}
+
+// fetchBranch fetches branch, and tags, from the remote.  Tags that moved
+// are updated, unless Options.ImmutableTags is set, in which case git
+// refuses to.
+func (s *Syncer) fetchBranch(ctx context.Context, branch string) error {
+	args := []string{"fetch", "--tags"}
+	if !s.opts.ImmutableTags {
+		args = append(args, "--force")
+	}
+	args = append(args, s.shallowArgs()...)
+	return s.throttle(ctx, func() error {
+		return s.runTransfer(ctx, s.opts.Root, append(args, "origin", branch)...)
+	})
+}
+
+// shallowArgs returns the arguments of clone and fetch that bound the
+// history they get, by date or by excluded refs, per Options.ShallowSince
+// and Options.ShallowExclude.  Fetches pass them too, so that the bound
+// moves on as new commits arrive.
+func (s *Syncer) shallowArgs() []string {
+	args := []string{}
+	if s.opts.ShallowSince != "" {
+		args = append(args, "--shallow-since="+s.opts.ShallowSince)
+	}
+	for _, ref := range s.opts.ShallowExclude {
+		args = append(args, "--shallow-exclude="+ref)
+	}
+	return args
+}
+
+func (s *Syncer) hashForRev(ctx context.Context, rev string) (string, error) {
+	output, err := s.runCommand(ctx, s.opts.Root, "git", "rev-list", "-n1", rev)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(output), "\n"), nil
+}
+
+func (s *Syncer) remoteHashForRef(ctx context.Context, ref, gitRoot string) (string, error) {
+	output, err := s.runCommand(ctx, gitRoot, "git", "ls-remote", "-q", "origin", ref)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(string(output), "\t")
+	return parts[0], nil
+}
+
+// parseLsRemote parses the output of git ls-remote into a map of ref to
+// hash.
+func parseLsRemote(output string) map[string]string {
+	refs := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) == 2 {
+			refs[parts[1]] = parts[0]
+		}
+	}
+	return refs
+}
@@ -0,0 +1,486 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGit runs a git command to set up a test.
+func runGit(t *testing.T, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@a", "GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@a")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("can't set up repo: %v: %s", err, output)
+	}
+	return string(output)
+}
+
+func TestReuseDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "one")
+
+	root := filepath.Join(dir, "root")
+	s := &Syncer{opts: Options{Repo: repo, Branch: "sync", Root: root}, env: map[string]string{}}
+	s.source = &gitSource{s: s}
+	ctx := context.Background()
+	if err := s.Clone(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hash, err := s.hashForRev(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	worktree := filepath.Join(root, revDirPrefix+hash)
+	if err := s.source.Materialize(ctx, hash, worktree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	extra := filepath.Join(worktree, "decrypted")
+	if err := ioutil.WriteFile(extra, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused, err := s.reuseDir(ctx, hash, worktree); err != nil || !reused {
+		t.Fatalf("expected the worktree to be reused but got %v, %v", reused, err)
+	}
+	if _, err := os.Stat(extra); !os.IsNotExist(err) {
+		t.Fatalf("expected files not in the revision to be removed but got %v", err)
+	}
+
+	// A directory that isn't a worktree, e.g. left half-created, is
+	// removed.
+	stale := filepath.Join(root, revDirPrefix+"stale")
+	if err := os.Mkdir(stale, 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused, err := s.reuseDir(ctx, hash, stale); err != nil || reused {
+		t.Fatalf("expected the directory not to be reused but got %v, %v", reused, err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected the directory to be removed but got %v", err)
+	}
+}
+
+func TestHardlinkWorktrees(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	writeTree(t, repo, map[string]string{"changed": "one", "same": "same"})
+	runGit(t, "-C", repo, "add", ".")
+	runGit(t, "-C", repo, "commit", "-q", "-m", "one")
+
+	root := filepath.Join(dir, "root")
+	s := &Syncer{
+		opts: Options{Repo: repo, Branch: "sync", Rev: "HEAD", Root: root, Dest: "link", HardlinkWorktrees: true},
+		env:  map[string]string{},
+	}
+	s.source = &gitSource{s: s}
+	ctx := context.Background()
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before, err := os.Stat(filepath.Join(root, "link", "same"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeTree(t, repo, map[string]string{"changed": "two"})
+	runGit(t, "-C", repo, "commit", "-q", "-am", "two")
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, err := os.Stat(filepath.Join(root, "link", "same"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !os.SameFile(before, after) {
+		t.Fatalf("expected the unchanged file to be hardlinked")
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(root, "link", "changed")); err != nil || string(data) != "two" {
+		t.Fatalf("expected the changed file to be updated but got %q, %v", data, err)
+	}
+}
+
+func TestReflinkWorktrees(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	writeTree(t, repo, map[string]string{"changed": "one", "same": "same"})
+	runGit(t, "-C", repo, "add", ".")
+	runGit(t, "-C", repo, "commit", "-q", "-m", "one")
+
+	root := filepath.Join(dir, "root")
+	s := &Syncer{
+		opts: Options{Repo: repo, Branch: "sync", Rev: "HEAD", Root: root, Dest: "link", ReflinkWorktrees: true},
+		env:  map[string]string{},
+	}
+	s.source = &gitSource{s: s}
+	ctx := context.Background()
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before, err := os.Stat(filepath.Join(root, "link", "same"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Whether or not the filesystem supports reflinks, the files are
+	// copies rather than links.
+	writeTree(t, repo, map[string]string{"changed": "two"})
+	runGit(t, "-C", repo, "commit", "-q", "-am", "two")
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, err := os.Stat(filepath.Join(root, "link", "same"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if os.SameFile(before, after) {
+		t.Fatalf("expected the unchanged file to be a copy")
+	}
+	for name, exp := range map[string]string{"same": "same", "changed": "two"} {
+		if data, err := ioutil.ReadFile(filepath.Join(root, "link", name)); err != nil || string(data) != exp {
+			t.Fatalf("%s: expected %q but got %q, %v", name, exp, data, err)
+		}
+	}
+}
+
+func TestAutoDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	hashes := []string{}
+	for _, content := range []string{"one", "two", "three"} {
+		writeTree(t, repo, map[string]string{"file": content})
+		runGit(t, "-C", repo, "add", ".")
+		runGit(t, "-C", repo, "commit", "-q", "-m", content)
+		hashes = append(hashes, strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD")))
+	}
+
+	// Local clones ignore --depth, unlike file:// ones.
+	root := filepath.Join(dir, "root")
+	s := &Syncer{
+		opts: Options{Repo: "file://" + repo, Branch: "sync", Rev: hashes[0], Root: root, Dest: "link", AutoDepth: true},
+		env:  map[string]string{},
+	}
+	s.source = &gitSource{s: s}
+	if err := s.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if published, _ := s.publishedHash(); published != hashes[0] {
+		t.Fatalf("expected %s to be published but %q is", hashes[0], published)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(root, "link", "file")); err != nil || string(data) != "one" {
+		t.Fatalf("expected the first commit to be checked out but got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".git", "shallow")); err != nil {
+		t.Fatalf("expected the clone to stay shallow but got %v", err)
+	}
+}
+
+func TestDepthUnreachableRev(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	hashes := []string{}
+	for _, content := range []string{"one", "two", "three"} {
+		writeTree(t, repo, map[string]string{"file": content})
+		runGit(t, "-C", repo, "add", ".")
+		runGit(t, "-C", repo, "commit", "-q", "-m", content)
+		hashes = append(hashes, strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD")))
+	}
+
+	cases := []struct {
+		rev string
+		err bool
+	}{
+		{hashes[2], false},
+		{hashes[1][:12], false},
+		{hashes[0], true},
+	}
+	for i, testCase := range cases {
+		s := &Syncer{
+			opts: Options{Repo: "file://" + repo, Branch: "sync", Rev: testCase.rev, Depth: 2, Root: filepath.Join(dir, fmt.Sprintf("root%d", i)), Dest: "link"},
+			env:  map[string]string{},
+		}
+		s.source = &gitSource{s: s}
+		err := s.SyncOnce(context.Background())
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s: expected error %v but got %v", testCase.rev, testCase.err, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "is not in the shallow clone") {
+			t.Fatalf("%s: expected an unreachable rev error but got %v", testCase.rev, err)
+		}
+	}
+}
+
+func TestResolveTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	writeTree(t, repo, map[string]string{"file": "one"})
+	runGit(t, "-C", repo, "add", ".")
+	runGit(t, "-C", repo, "commit", "-q", "-m", "one")
+	hash := strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD"))
+	runGit(t, "-C", repo, "tag", "light")
+	runGit(t, "-C", repo, "tag", "-a", "-m", "annotated", "annotated")
+
+	s := &Syncer{
+		opts: Options{Repo: repo, Branch: "sync", Rev: "HEAD", Root: filepath.Join(dir, "root"), Dest: "link"},
+		env:  map[string]string{},
+	}
+	g := &gitSource{s: s}
+	s.source = g
+	if err := s.Clone(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, rev := range []string{"light", "annotated", hash[:8]} {
+		got, err := g.Resolve(context.Background(), rev)
+		if err != nil {
+			t.Fatalf("unexpected error resolving %s: %v", rev, err)
+		}
+		if got != hash {
+			t.Fatalf("expected %s to resolve to %s but %q returned", rev, hash, got)
+		}
+	}
+	if _, err := g.Resolve(context.Background(), "missing"); err == nil {
+		t.Fatalf("expected an error resolving a missing tag")
+	}
+}
+
+func TestCheckClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "one")
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "two")
+	runGit(t, "-C", repo, "branch", "other")
+	other := filepath.Join(dir, "other")
+	runGit(t, "clone", "-q", "--bare", repo, other)
+
+	// A clone of another repo and branch, shallow though no flag asks for
+	// it.
+	root := filepath.Join(dir, "root")
+	runGit(t, "clone", "-q", "--no-checkout", "--depth", "1", "-b", "other", "file://"+other, root)
+
+	ctx := context.Background()
+	newSyncer := func(policy string) *Syncer {
+		s := &Syncer{
+			opts: Options{Repo: repo, Branch: "sync", Root: root, CloneCheck: policy},
+			env:  map[string]string{},
+		}
+		s.source = &gitSource{s: s}
+		return s
+	}
+	if mismatches, err := newSyncer("").cloneMismatches(ctx); err != nil || len(mismatches) != 3 {
+		t.Fatalf("expected 3 mismatches but got %d, %v", len(mismatches), err)
+	}
+	if s := newSyncer(CloneCheckFail); s.checkClone(ctx) == nil || s.cloneChecked {
+		t.Fatalf("expected the check to fail")
+	}
+	if s := newSyncer(CloneCheckWarn); s.checkClone(ctx) != nil || !s.cloneChecked {
+		t.Fatalf("expected the check to only warn")
+	}
+	if err := newSyncer(CloneCheckReconcile).checkClone(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mismatches, err := newSyncer("").cloneMismatches(ctx); err != nil || len(mismatches) != 0 {
+		t.Fatalf("expected the clone to be reconciled but got %d mismatches, %v", len(mismatches), err)
+	}
+}
+
+func TestMissingRef(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "one")
+	synced := strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD"))
+	runGit(t, "-C", repo, "checkout", "-q", "-b", "main")
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "two")
+	main := strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD"))
+
+	root := filepath.Join(dir, "root")
+	ctx := context.Background()
+	newSyncer := func(policy string) *Syncer {
+		s := &Syncer{
+			opts: Options{Repo: repo, Branch: "sync", Rev: "HEAD", Root: root, Dest: "link", MissingRef: policy},
+			env:  map[string]string{},
+		}
+		s.source = &gitSource{s: s}
+		return s
+	}
+	if err := newSyncer("").SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runGit(t, "-C", repo, "branch", "-q", "-D", "sync")
+
+	if err := newSyncer(MissingRefFail).SyncOnce(ctx); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected the sync to fail but got %v", err)
+	}
+	s := newSyncer(MissingRefKeep)
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if published, _ := s.publishedHash(); published != synced {
+		t.Fatalf("expected %s to stay published but %q is", synced, published)
+	}
+	s = newSyncer(MissingRefFallback)
+	s.opts.FallbackRef = "main"
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if published, _ := s.publishedHash(); published != main {
+		t.Fatalf("expected %s to be published but %q is", main, published)
+	}
+}
+
+func TestMovedTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "one")
+	runGit(t, "-C", repo, "tag", "-a", "-m", "stable", "stable")
+	first := strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD"))
+
+	ctx := context.Background()
+	newSyncer := func(root string, immutable bool) *Syncer {
+		s := &Syncer{
+			opts: Options{Repo: repo, Branch: "sync", Rev: "stable", Root: filepath.Join(dir, root), Dest: "link", ImmutableTags: immutable},
+			env:  map[string]string{},
+		}
+		s.source = &gitSource{s: s}
+		return s
+	}
+	mutable, immutable := newSyncer("mutable", false), newSyncer("immutable", true)
+	for _, s := range []*Syncer{mutable, immutable} {
+		if err := s.SyncOnce(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "two")
+	runGit(t, "-C", repo, "tag", "-f", "-a", "-m", "stable", "stable")
+	second := strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD"))
+	for _, s := range []*Syncer{mutable, immutable} {
+		if err := s.SyncOnce(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if published, _ := mutable.publishedHash(); published != second {
+		t.Fatalf("expected the moved tag, %s, to be published but %q is", second, published)
+	}
+	if published, _ := immutable.publishedHash(); published != first {
+		t.Fatalf("expected %s to stay published with --immutable-tags but %q is", first, published)
+	}
+}
+
+func TestReconcileWorktrees(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	hashes := []string{}
+	for _, content := range []string{"one", "two"} {
+		writeTree(t, repo, map[string]string{"file": content})
+		runGit(t, "-C", repo, "add", ".")
+		runGit(t, "-C", repo, "commit", "-q", "-m", content)
+		hashes = append(hashes, strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD")))
+	}
+
+	root := filepath.Join(dir, "root")
+	s := &Syncer{opts: Options{Repo: repo, Branch: "sync", Root: root}, env: map[string]string{}}
+	g := &gitSource{s: s}
+	s.source = g
+	ctx := context.Background()
+	if err := s.Clone(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dirs := []string{}
+	for _, hash := range hashes {
+		d := filepath.Join(root, revDirPrefix+hash)
+		if err := g.Materialize(ctx, hash, d); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		dirs = append(dirs, d)
+	}
+
+	// The first worktree lost its registration, and the second its
+	// directory.
+	if err := os.RemoveAll(filepath.Join(root, ".git", "worktrees", revDirPrefix+hashes[0])); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.RemoveAll(dirs[1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := s.reconcileWorktrees(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head := strings.TrimSpace(runGit(t, "-C", dirs[0], "rev-parse", "HEAD")); head != hashes[0] {
+		t.Fatalf("expected %s to be registered at %s but %q returned", dirs[0], hashes[0], head)
+	}
+	if status := runGit(t, "-C", dirs[0], "status", "--porcelain"); status != "" {
+		t.Fatalf("expected %s to be clean but %q returned", dirs[0], status)
+	}
+	if err := g.Materialize(ctx, hashes[1], dirs[1]); err != nil {
+		t.Fatalf("expected the dangling worktree to be pruned but got %v", err)
+	}
+}
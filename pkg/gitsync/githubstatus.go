@@ -0,0 +1,99 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// githubStatusPublisher sets a commit status on every published revision,
+// so that it shows as deployed on GitHub.
+type githubStatusPublisher struct {
+	// statusesURL is the statuses endpoint of the repo, less the hash.
+	statusesURL string
+	token       string
+	context     string
+	description string
+	targetURL   string
+}
+
+// newGitHubStatusPublisher returns a publisher setting statuses named
+// o.GitHubStatusContext on o.Repo, with o.GitHubToken.
+func newGitHubStatusPublisher(o Options) (*githubStatusPublisher, error) {
+	if o.GitHubToken == "" {
+		return nil, fmt.Errorf("--github-token is required")
+	}
+	u, err := url.Parse(o.Repo)
+	if err != nil || !isHTTPURL(o.Repo) {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", o.Repo)
+	}
+	parts := strings.Split(strings.Trim(strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("can't find the owner and name of repo %q", o.Repo)
+	}
+
+	api := o.GitHubAPIURL
+	if api == "" {
+		if u.Hostname() == "github.com" {
+			api = "https://api.github.com"
+		} else {
+			// GitHub Enterprise Server.
+			api = u.Scheme + "://" + u.Host + "/api/v3"
+		}
+	} else if !isHTTPURL(api) {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", api)
+	}
+	return &githubStatusPublisher{
+		statusesURL: fmt.Sprintf("%s/repos/%s/%s/statuses/", strings.TrimSuffix(api, "/"), parts[0], parts[1]),
+		token:       o.GitHubToken,
+		context:     o.GitHubStatusContext,
+		description: o.GitHubStatusDescription,
+		targetURL:   o.GitHubStatusTargetURL,
+	}, nil
+}
+
+// githubStatus is a GitHub commit status request.
+type githubStatus struct {
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+func (p *githubStatusPublisher) PublishEvent(ctx context.Context, e Event) error {
+	body, err := json.Marshal(githubStatus{
+		State:       "success",
+		Context:     p.context,
+		Description: p.description,
+		TargetURL:   p.targetURL,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding commit status: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", p.statusesURL+e.NewHash, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+
+	client := &http.Client{Timeout: eventTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error setting GitHub commit status: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GitHub returned status %d setting commit status: %q", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewGitHubStatusPublisher(t *testing.T) {
+	cases := []struct {
+		repo     string
+		api      string
+		token    string
+		expected string
+		err      bool
+	}{
+		{"https://github.com/a/b.git", "", "t", "https://api.github.com/repos/a/b/statuses/", false},
+		{"https://git.example.com/a/b", "", "t", "https://git.example.com/api/v3/repos/a/b/statuses/", false},
+		{"https://git.example.com/a/b", "https://api.example.com/", "t", "https://api.example.com/repos/a/b/statuses/", false},
+		{"https://github.com/a/b", "", "", "", true},
+		{"git@github.com:a/b.git", "", "t", "", true},
+		{"https://github.com/a", "", "t", "", true},
+	}
+
+	for _, testCase := range cases {
+		p, err := newGitHubStatusPublisher(Options{Repo: testCase.repo, GitHubAPIURL: testCase.api, GitHubToken: testCase.token, GitHubStatusContext: "deploy"})
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s: expected error %v but got %v", testCase.repo, testCase.err, err)
+		}
+		if err == nil && p.statusesURL != testCase.expected {
+			t.Fatalf("%s: expected %s but %s returned", testCase.repo, testCase.expected, p.statusesURL)
+		}
+	}
+}
+
+func TestGitHubStatusPublisher(t *testing.T) {
+	var path, auth string
+	var status githubStatus
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&status)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	p, err := newGitHubStatusPublisher(Options{
+		Repo:                    "https://github.com/a/b",
+		GitHubAPIURL:            server.URL,
+		GitHubToken:             "secret",
+		GitHubStatusContext:     "deploy/prod",
+		GitHubStatusDescription: "Deployed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishEvent(context.Background(), Event{NewHash: "abc"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := githubStatus{State: "success", Context: "deploy/prod", Description: "Deployed"}
+	if path != "/repos/a/b/statuses/abc" || auth != "Bearer secret" || status != expected {
+		t.Fatalf("unexpected request to %s with %q: %+v", path, auth, status)
+	}
+}
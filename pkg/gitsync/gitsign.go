@@ -0,0 +1,30 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+)
+
+// verifyGitsign checks, before the new revision is checked out, that its
+// commit was signed with gitsign (Sigstore keyless signing) by
+// Options.GitsignIdentity, as vouched for by Options.GitsignIssuer, and that
+// the signature is in the Rekor transparency log.
+func (s *Syncer) verifyGitsign(ctx context.Context, st *SyncState) error {
+	if s.opts.GitsignIdentity == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "gitsign")
+	if _, ok := s.source.(*gitSource); !ok {
+		return fmt.Errorf("gitsign verification needs a git repo")
+	}
+
+	output, err := s.runCommand(ctx, s.opts.Root, "gitsign", "verify",
+		"--certificate-identity="+s.opts.GitsignIdentity,
+		"--certificate-oidc-issuer="+s.opts.GitsignIssuer,
+		st.NewHash)
+	if err != nil {
+		return fmt.Errorf("commit %s failed gitsign verification: %v", st.NewHash, err)
+	}
+	s.logger(ctx).V(1).Infof("verified gitsign signature of %s: %s", st.NewHash, output)
+	return nil
+}
@@ -0,0 +1,52 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyGitsign(t *testing.T) {
+	bin, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(bin)
+
+	// A fake gitsign, which accepts only commit "good" by jane.
+	ioutil.WriteFile(filepath.Join(bin, "gitsign"), []byte(`#!/bin/sh
+[ "$1 $2 $3 $4" = "verify --certificate-identity=jane@example.com --certificate-oidc-issuer=https://issuer good" ]
+`), 0755)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cases := []struct {
+		identity string
+		hash     string
+		git      bool
+		err      bool
+	}{
+		{"", "bad", true, false},
+		{"jane@example.com", "good", true, false},
+		{"jane@example.com", "bad", true, true},
+		{"john@example.com", "good", true, true},
+		{"jane@example.com", "good", false, true},
+	}
+
+	for _, testCase := range cases {
+		s := &Syncer{
+			opts: Options{Root: bin, GitsignIdentity: testCase.identity, GitsignIssuer: "https://issuer"},
+			env:  map[string]string{},
+		}
+		s.source = &fakeSource{}
+		if testCase.git {
+			s.source = &gitSource{s: s}
+		}
+		err := s.verifyGitsign(context.Background(), &SyncState{NewHash: testCase.hash})
+		if (err != nil) != testCase.err {
+			t.Fatalf("%+v: expected error %v but got %v", testCase, testCase.err, err)
+		}
+	}
+}
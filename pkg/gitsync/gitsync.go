@@ -0,0 +1,606 @@
+// Package gitsync pulls a git repository to a local directory, publishing
+// each revision as a worktree behind an atomically swapped symlink.  It is
+// the engine of the git-sync command, and can be embedded in other programs.
+//
+// Credentials and other settings are passed to git through environment
+// variables set on git's child processes only, so that Syncers in one
+// process each use their own.  The exception is Options.Netrc, which
+// stores credentials in ~/.netrc, per host, for every Syncer sharing $HOME.
+package gitsync // import "k8s.io/git-sync/pkg/gitsync"
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// revDirPrefix is prepended to the hash to name the directory each
+// revision is materialized in.
+const revDirPrefix = "rev-"
+
+// Syncer syncs one repository to a directory.
+type Syncer struct {
+	opts       Options
+	source     SourceProvider
+	publishers []EventPublisher
+	hooks      []Hook
+	notifier   *notifier
+
+	// env is set for every command run, on top of our own environment.
+	env map[string]string
+
+	// oauth2Token is the token obtained by the OAuth2 client-credentials
+	// flow.
+	oauth2Token cachedToken
+
+	// stsToken is the token obtained by exchanging the workload's OIDC
+	// token.
+	stsToken cachedToken
+
+	// gcpToken is the token obtained from the GCE/GKE metadata server.
+	gcpToken cachedToken
+
+	// azureToken is the token obtained from the Azure instance metadata
+	// service for a managed identity.
+	azureToken cachedToken
+
+	// awsCreds caches the credentials used to sign requests to AWS.
+	awsCreds awsCredentials
+
+	// vaultToken is the Vault client token obtained by Kubernetes auth.
+	vaultToken cachedToken
+	// vaultTokenRenewable is whether vaultToken can be renewed rather than
+	// obtained by logging in again.
+	vaultTokenRenewable bool
+
+	// vaultSecretRead is when the git credentials were last read from Vault,
+	// and vaultSecretData is what was read.
+	vaultSecretRead time.Time
+	vaultSecretData map[string]string
+
+	// sshControlDir holds the sockets of the SSH connections kept open by
+	// --ssh-control-persist, once created.
+	sshControlDir string
+
+	// lastPrune is when unreachable objects were last pruned, per
+	// --prune-interval.
+	lastPrune time.Time
+
+	// fileWatches are checked before every sync.
+	fileWatches []*fileWatch
+
+	// wake interrupts the wait between syncs.
+	wake chan struct{}
+
+	// slots, if not nil, is shared with other Syncers to limit how many
+	// sync at once.  A sync sends to it first, and receives from it after.
+	slots chan struct{}
+	// budget, if not nil, is a bandwidth budget shared with other Syncers.
+	budget *budget
+	// onSync, if set, is called after every sync that isn't skipped, with
+	// its error and how long it took.
+	onSync func(err error, d time.Duration) error
+
+	// syncMu is held by SyncOnce, so that syncs of the clone never overlap,
+	// even if a program calls SyncOnce while Run is running.  It guards
+	// cloneChecked, which is set once the clone has passed checkClone, and
+	// worktreesReconciled, once reconcileWorktrees has run.
+	syncMu              sync.Mutex
+	cloneChecked        bool
+	worktreesReconciled bool
+
+	// mu guards status, stats, overruns, policyDenials, cacheUses, paused,
+	// approved and phaseFuncs.
+	mu     sync.Mutex
+	status Status
+	stats  map[string]syncStats
+	// overruns counts the syncs that took longer than Options.Wait.
+	overruns      int
+	policyDenials int
+	cacheUses     map[string]int
+	paused        bool
+	// approved is the staged revision approved by Promote, if any.
+	approved   string
+	phaseFuncs map[Phase]*phaseFuncs
+}
+
+// New validates opts and configures git to use the credentials, HTTP, TLS
+// and SSH settings they give.  ctx bounds the commands run to do so.
+func New(ctx context.Context, opts Options) (*Syncer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	goGit := opts.Backend == BackendGoGit
+	if !goGit {
+		if _, err := exec.LookPath("git"); err != nil {
+			return nil, fmt.Errorf("git executable not found: %v", err)
+		}
+	}
+	opts.setDefaults()
+	if err := resolveTokenAuth(&opts); err != nil {
+		return nil, err
+	}
+
+	s := &Syncer{
+		opts:   opts,
+		source: opts.Source,
+		env:    map[string]string{},
+		wake:   make(chan struct{}, 1),
+	}
+	switch {
+	case s.source != nil:
+	case goGit:
+		s.source = &goGitSource{s: s}
+	default:
+		s.source = &gitSource{s: s}
+	}
+	publishers, err := newEventPublishers(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.publishers = append(append([]EventPublisher{}, opts.Publishers...), publishers...)
+	if s.notifier, err = newNotifier(opts); err != nil {
+		return nil, err
+	}
+	s.hooks = append([]Hook{}, opts.Hooks...)
+	for _, path := range opts.HookPlugins {
+		h, err := loadHookPlugin(path)
+		if err != nil {
+			return nil, err
+		}
+		s.hooks = append(s.hooks, h)
+	}
+	for _, command := range opts.HookCommands {
+		s.hooks = append(s.hooks, execHook{command: command, logger: s.logger})
+	}
+	if goGit {
+		// go-git takes its credentials with every fetch, rather than from
+		// git's configuration.
+		return s, nil
+	}
+	if err := s.setup(ctx); err != nil {
+		return nil, err
+	}
+	s.watchCredentialFiles()
+	return s, nil
+}
+
+// setup runs the one-time git configuration.
+func (s *Syncer) setup(ctx context.Context) error {
+	output, err := s.runCommand(ctx, "", "git", "--version")
+	if err != nil {
+		return err
+	}
+	if err := s.checkGitVersion(output); err != nil {
+		return err
+	}
+
+	if s.opts.CredentialHelper != "" {
+		if err := s.setupGitCredentialHelper(ctx, s.opts.CredentialHelper); err != nil {
+			return fmt.Errorf("can't configure credential helper: %v", err)
+		}
+	}
+
+	if s.opts.Username != "" && s.opts.Password != "" {
+		if err := s.storeGitCredentials(ctx, s.opts.Username, s.opts.Password); err != nil {
+			return fmt.Errorf("can't set up git credentials: %v", err)
+		}
+	}
+
+	if len(s.opts.HTTPHeaders) > 0 {
+		if err := s.setupGitHTTPHeaders(ctx, s.opts.HTTPHeaders); err != nil {
+			return fmt.Errorf("can't configure HTTP headers: %v", err)
+		}
+	}
+
+	if s.opts.TLSClientCert != "" {
+		if err := s.setupGitTLSClientCert(ctx, s.opts.TLSClientCert, s.opts.TLSClientKey); err != nil {
+			return fmt.Errorf("can't configure TLS client certificate: %v", err)
+		}
+	}
+
+	if s.opts.CACertFile != "" {
+		if err := s.setupGitCACert(ctx, s.opts.CACertFile); err != nil {
+			return fmt.Errorf("can't configure CA bundle: %v", err)
+		}
+	}
+
+	if s.opts.InsecureSkipTLSVerify {
+		if err := s.setupGitInsecureSkipTLSVerify(ctx); err != nil {
+			return fmt.Errorf("can't disable TLS verification: %v", err)
+		}
+	}
+
+	if err := s.setupGitTuning(ctx); err != nil {
+		return fmt.Errorf("can't configure git threads and compression: %v", err)
+	}
+
+	if err := s.setupGitUserAgent(ctx, s.opts.HTTPUserAgent); err != nil {
+		return fmt.Errorf("can't configure HTTP user agent: %v", err)
+	}
+	s.setupLowSpeedLimit(ctx)
+
+	if s.opts.SSH {
+		if err := s.setupSSH(ctx); err != nil {
+			return fmt.Errorf("can't configure SSH: %v", err)
+		}
+	}
+	return nil
+}
+
+// Run syncs every Options.Wait seconds until ctx is cancelled.  It returns
+// an error if the first sync fails or more than Options.MaxSyncFailures
+// syncs in a row fail, not counting those that satisfy IsStale, and nil
+// once ctx is cancelled, after the first sync if Options.OneTime is set.
+func (s *Syncer) Run(ctx context.Context) error {
+	initialSync := true
+	failCount := 0
+	// stale is set while syncs can't reach the remote, per
+	// Options.ServeStale.
+	stale := false
+	for {
+		if s.Paused() {
+			if !s.sleep(ctx, -1) {
+				return nil
+			}
+			continue
+		}
+
+		if !s.acquireSlot(ctx) {
+			return nil
+		}
+		start := time.Now()
+		err := s.SyncOnce(ctx)
+		s.releaseSlot()
+		if IsSkipped(err) && ctx.Err() == nil {
+			// Not a failure: try again next time.
+			s.logger(ctx).V(0).Infof("%v", err)
+			if !s.sleep(ctx, waitTime(s.opts.Wait)) {
+				return nil
+			}
+			continue
+		}
+		took := time.Since(start)
+		s.recordSync(ctx, err, took)
+		if s.onSync != nil {
+			if err := s.onSync(err, took); err != nil {
+				s.logger(ctx).Errorf("%v", err)
+			}
+		}
+		if IsStale(err) && ctx.Err() == nil && !s.opts.OneTime {
+			// Not counted as a failure: the published revision is still
+			// served, and the remote is tried again next time.
+			if !stale {
+				s.notifyFailure(ctx, err)
+			}
+			stale = true
+			s.logger(ctx).Errorf("%v", err)
+			if !s.sleep(ctx, waitTime(s.opts.Wait)) {
+				return nil
+			}
+			continue
+		}
+		stale = false
+		if err != nil {
+			if ctx.Err() != nil {
+				// Shutting down; the error is just the cancellation.
+				return nil
+			}
+			if failCount == 0 {
+				// Only the first of a run of failures is announced.
+				s.notifyFailure(ctx, err)
+			}
+			if initialSync || failCount >= s.opts.MaxSyncFailures {
+				return fmt.Errorf("error syncing repo: %v", err)
+			}
+
+			failCount++
+			s.logger(ctx).Errorf("unexpected error syncing repo: %v", err)
+			s.logger(ctx).V(0).Infof("waiting %v before retrying", waitTime(s.opts.Wait))
+			if !s.sleep(ctx, waitTime(s.opts.Wait)) {
+				return nil
+			}
+			continue
+		}
+		if initialSync {
+			// If rev is already a hash, it resolves to itself (or to the full
+			// hash, if abbreviated).  Of course, a tag "abc" could match
+			// "abcdef12345678", so this is only a prefix match.
+			if hash, err := s.publishedHash(); err != nil {
+				return err
+			} else if s.opts.RefResolver == nil && strings.HasPrefix(hash, s.opts.Rev) {
+				s.logger(ctx).V(0).Infof("rev %s appears to be a git hash, no further sync needed", s.opts.Rev)
+				<-ctx.Done()
+				return nil
+			}
+			if s.opts.OneTime {
+				return nil
+			}
+			initialSync = false
+		}
+
+		failCount = 0
+		s.logger(ctx).V(1).Infof("next sync in %v", waitTime(s.opts.Wait))
+		if !s.sleep(ctx, waitTime(s.opts.Wait)) {
+			return nil
+		}
+	}
+}
+
+// SyncOnce brings the published worktree up to date with the remote rev,
+// cloning the repo first if needed.  It gives up after Options.Timeout.  If
+// a pre-fetch hook or Options.PresyncCommand skips the sync, the error
+// satisfies IsSkipped, and with Options.ServeStale, if the remote can't be
+// reached but a revision is published, IsStale.  Calls are serialized: one
+// made while another sync runs waits for it to finish.
+func (s *Syncer) SyncOnce(ctx context.Context) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTime(s.opts.Timeout))
+		defer cancel()
+	}
+
+	if s.pinnedHashPublished() {
+		// A hash never moves, so there is nothing to ask the remote, e.g.
+		// after a restart.
+		s.logger(ctx).V(1).Infof("rev %s is already published, no update required", s.opts.Rev)
+		return nil
+	}
+
+	if err := s.presync(withLogFields(ctx, "phase", "presync")); err != nil {
+		return err
+	}
+	if err := s.refreshCredentials(withLogFields(ctx, "phase", "credentials")); err != nil {
+		return err
+	}
+	if err := s.checkClone(withLogFields(ctx, "phase", "check")); err != nil {
+		return err
+	}
+	if err := s.reconcileWorktrees(withLogFields(ctx, "phase", "check")); err != nil {
+		return err
+	}
+
+	ctx = withLogFields(ctx, "phase", string(PhaseResolve))
+	published, err := s.publishedHash()
+	if err != nil {
+		return err
+	}
+	st := &SyncState{OldHash: published}
+	s.startProfile(st)
+	defer s.endProfile(ctx, st)
+	if err := s.runPhase(ctx, PhaseResolve, st, s.resolve); err != nil {
+		return s.staleIfTransient(published, err)
+	}
+	s.logger(ctx).V(2).Infof("published hash: %s", published)
+	s.logger(ctx).V(2).Infof("remote hash:    %s", st.NewHash)
+	if st.NewHash == published {
+		s.logger(ctx).V(1).Infof("no update required")
+		return s.unstage(ctx, published)
+	}
+	if staged := s.stagedHash(); staged != "" && st.NewHash == staged {
+		return s.promote(ctx, st)
+	}
+
+	ctx = withLogFields(ctx, "hash", st.NewHash)
+	s.logger(ctx).V(0).Infof("syncing to %s", s.opts.Rev)
+	if err := s.publishState(ctx, st); err != nil && !st.published {
+		// E.g. the fetch failed, and the new revision was discarded.
+		return s.staleIfTransient(published, err)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// pinnedHashPublished returns true if Options.Rev is a full hash, which is
+// already published.
+func (s *Syncer) pinnedHashPublished() bool {
+	if s.opts.RefResolver != nil || !fullHashRE.MatchString(s.opts.Rev) {
+		return false
+	}
+	published, err := s.publishedHash()
+	return err == nil && published == s.opts.Rev
+}
+
+// Publish fetches and checks out revision hash and publishes it in
+// Options.Dest, according to Options.PublishStrategy, removing the
+// previous revision.  It runs the phases of a sync after PhaseResolve.
+func (s *Syncer) Publish(ctx context.Context, hash string) error {
+	published, err := s.publishedHash()
+	if err != nil {
+		return err
+	}
+	ctx = withLogFields(ctx, "hash", hash)
+	return s.publishState(ctx, &SyncState{OldHash: published, NewHash: hash})
+}
+
+// chmod applies Options.Chmod, if set, to dir.
+func (s *Syncer) chmod(ctx context.Context, dir string) error {
+	if s.opts.Chmod == 0 {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		s.logger(ctx).V(0).Infof("WARNING: ignoring --change-permissions, which is not supported on Windows")
+		return nil
+	}
+	return chmodTree(dir, s.opts.Chmod)
+}
+
+// chmodTree sets the permissions of dir and everything in it, except
+// symlinks, to mode, which is written like chmod's octal modes (e.g. 775).
+func chmodTree(dir string, mode int) error {
+	perm, err := strconv.ParseUint(strconv.Itoa(mode), 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file permissions %d: must be octal", mode)
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			return fmt.Errorf("error changing permissions: %v", err)
+		}
+		return nil
+	})
+}
+
+// discard removes a materialized revision that won't be published, so that
+// the next sync can retry it, and returns err.
+func (s *Syncer) discard(ctx context.Context, dir string, err error) error {
+	if rmErr := os.RemoveAll(dir); rmErr != nil {
+		s.logger(ctx).Errorf("error removing %s: %v", dir, rmErr)
+		return err
+	}
+	if cleanupErr := s.source.Cleanup(ctx); cleanupErr != nil {
+		s.logger(ctx).Errorf("%v", cleanupErr)
+	}
+	return err
+}
+
+// updateSymlink atomically swaps the symlink to point at the specified
+// directory, and returns the directory it pointed to before.
+func (s *Syncer) updateSymlink(ctx context.Context, gitRoot, link, newDir string) (string, error) {
+	// Get currently-linked repo directory (to be removed), unless it doesn't exist
+	currentDir, err := filepath.EvalSymlinks(filepath.Join(gitRoot, link))
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error accessing symlink: %v", err)
+	}
+
+	// newDir is /git/rev-..., we need to change it to relative path.
+	// Volume in other container may not be mounted at /git, so the symlink can't point to /git.
+	newDirRelative, err := filepath.Rel(gitRoot, newDir)
+	if err != nil {
+		return "", fmt.Errorf("error converting to relative path: %v", err)
+	}
+
+	tmpLink := filepath.Join(gitRoot, "tmp-link")
+	if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error removing stale symlink: %v", err)
+	}
+	if err := createSymlink(newDirRelative, tmpLink); err != nil {
+		return "", fmt.Errorf("error creating symlink: %v", err)
+	}
+	s.logger(ctx).V(1).Infof("created symlink %s -> %s", "tmp-link", newDirRelative)
+
+	if err := replaceSymlink(tmpLink, filepath.Join(gitRoot, link)); err != nil {
+		return "", fmt.Errorf("error replacing symlink: %v", err)
+	}
+	s.logger(ctx).V(1).Infof("renamed symlink %s to %s", "tmp-link", link)
+
+	return currentDir, nil
+}
+
+// setEnv sets an environment variable for the commands we run, and
+// through them for git's own child processes.
+func (s *Syncer) setEnv(key, value string) {
+	s.env[key] = value
+}
+
+// command returns a Cmd which runs with our environment plus s.env.
+func (s *Syncer) command(ctx context.Context, command string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = []string{}
+	for _, kv := range os.Environ() {
+		if _, found := s.env[strings.SplitN(kv, "=", 2)[0]]; !found {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	for k, v := range s.env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	return cmd
+}
+
+func cmdForLog(command string, args ...string) string {
+	if strings.ContainsAny(command, " \t\n") {
+		command = fmt.Sprintf("%q", command)
+	}
+	// Don't quote args in place, since they are also what gets run.
+	quoted := make([]string, len(args))
+	for i := range args {
+		quoted[i] = args[i]
+		if strings.ContainsAny(args[i], " \t\n") {
+			quoted[i] = fmt.Sprintf("%q", args[i])
+		}
+	}
+	return command + " " + strings.Join(quoted, " ")
+}
+
+func (s *Syncer) runCommand(ctx context.Context, cwd, command string, args ...string) (string, error) {
+	s.logger(ctx).V(5).Infof("run(%q): %s", cwd, cmdForLog(command, args...))
+
+	cmd := s.command(ctx, command, args...)
+	if cwd != "" {
+		cmd.Dir = cwd
+	}
+	output, err := s.combinedOutput(ctx, cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("command timed out: %s", cmdForLog(command, args...))
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running command: %v: %q", err, string(output))
+	}
+
+	return string(output), nil
+}
+
+func waitTime(seconds float64) time.Duration {
+	return time.Duration(int(seconds*1000)) * time.Millisecond
+}
+
+// acquireSlot waits until fewer than the allowed number of Syncers are
+// syncing.  It returns false if ctx is cancelled first.
+func (s *Syncer) acquireSlot(ctx context.Context) bool {
+	if s.slots == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+	s.logger(ctx).V(1).Infof("waiting for other repos to finish syncing")
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// releaseSlot lets another Syncer sync.
+func (s *Syncer) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// sleep waits for d (forever if negative), or until woken by SyncNow or
+// Resume, returning false if ctx is cancelled first.
+func (s *Syncer) sleep(ctx context.Context, d time.Duration) bool {
+	var timeout <-chan time.Time
+	if d >= 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timeout:
+	case <-s.wake:
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCmdForLog(t *testing.T) {
+	args := []string{"config", "user.name", "A B"}
+	got := cmdForLog("git", args...)
+	if exp := `git config user.name "A B"`; got != exp {
+		t.Fatalf("expected %q but %q returned", exp, got)
+	}
+	if exp := []string{"config", "user.name", "A B"}; !reflect.DeepEqual(args, exp) {
+		t.Fatalf("expected args to be unchanged but got %q", args)
+	}
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	s := &Syncer{env: map[string]string{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.runCommand(ctx, "", "sleep", "10")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error but %v returned", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the command to be killed but it ran for %v", elapsed)
+	}
+}
+
+func TestRunCommandTimeoutKillsChildren(t *testing.T) {
+	s := &Syncer{env: map[string]string{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The backgrounded sleep holds the output open after sh is killed, as
+	// git's children do.
+	start := time.Now()
+	_, err := s.runCommand(ctx, "", "sh", "-c", "sleep 10 & wait")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error but %v returned", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the command's children to be killed but it ran for %v", elapsed)
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	s := &Syncer{env: map[string]string{"HOME": "/override"}}
+	out, err := s.runCommand(context.Background(), "", "sh", "-c", "echo $HOME")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "/override\n" {
+		t.Fatalf("expected %q but %q returned", "/override\n", out)
+	}
+}
+
+func TestChmodTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Symlink("/", filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := chmodTree(dir, 750); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, path := range []string{dir, file} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.Mode().Perm() != 0750 {
+			t.Fatalf("expected %s to have mode 0750 but %v returned", path, info.Mode().Perm())
+		}
+	}
+
+	if err := chmodTree(dir, 789); err == nil {
+		t.Fatalf("expected an error for a non-octal mode")
+	}
+}
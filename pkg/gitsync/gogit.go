@@ -0,0 +1,426 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+const (
+	// BackendExec syncs with the git CLI.
+	BackendExec = "exec"
+	// BackendGoGit syncs in process with go-git, so that no git binary is
+	// needed.
+	BackendGoGit = "gogit"
+)
+
+// goGitSource is the SourceProvider for Options.Backend gogit.  It keeps a
+// bare clone in Options.Root/.git, which the git CLI can take over, and
+// materializes each revision by writing out its tree.
+type goGitSource struct {
+	s *Syncer
+	// tag is the tag Options.Rev names, if any, fetched along with the
+	// branch.
+	tag string
+}
+
+// open opens the clone in Options.Root, creating it if needed.
+func (g *goGitSource) open() (*git.Repository, error) {
+	gitDir := filepath.Join(g.s.opts.Root, ".git")
+	repo, err := git.PlainOpen(gitDir)
+	if err == nil {
+		return repo, nil
+	}
+	if err != git.ErrRepositoryNotExists {
+		return nil, fmt.Errorf("error opening repo %q: %v", gitDir, err)
+	}
+	if repo, err = git.PlainInit(gitDir, true); err != nil {
+		return nil, fmt.Errorf("error creating repo %q: %v", gitDir, err)
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{g.s.opts.Repo},
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error adding remote %s: %v", redactURL(g.s.opts.Repo), err)
+	}
+	return repo, nil
+}
+
+// auth returns the credentials to fetch with, read afresh every time so
+// that rotated key files are picked up, or nil if there are none.
+func (g *goGitSource) auth() (transport.AuthMethod, error) {
+	s := g.s
+	if !s.opts.SSH {
+		if s.opts.Username == "" && s.opts.Password == "" {
+			return nil, nil
+		}
+		return &githttp.BasicAuth{Username: s.opts.Username, Password: s.opts.Password}, nil
+	}
+
+	endpoint, err := transport.NewEndpoint(s.opts.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --repo: %v", err)
+	}
+	user := endpoint.User
+	if user == "" {
+		user = "git"
+	}
+	passphrase, err := s.sshKeyPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	signers := []ssh.Signer{}
+	for _, path := range s.sshKeyFiles() {
+		if err := checkSSHKeyFile(path); err != nil {
+			return nil, err
+		}
+		keys, err := gitssh.NewPublicKeysFromFile(user, path, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH key %s: %v", path, err)
+		}
+		signers = append(signers, keys.Signer)
+	}
+	auth := &gitssh.PublicKeysCallback{
+		User:     user,
+		Callback: func() ([]ssh.Signer, error) { return signers, nil },
+	}
+	if s.opts.SSHKnownHosts {
+		if _, err := os.Stat(s.opts.SSHKnownHostsFile); err != nil {
+			return nil, fmt.Errorf("error: could not find SSH known_hosts Secret: %v", err)
+		}
+		if auth.HostKeyCallback, err = gitssh.NewKnownHostsCallback(s.opts.SSHKnownHostsFile); err != nil {
+			return nil, fmt.Errorf("error reading SSH known_hosts: %v", err)
+		}
+	} else {
+		auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	}
+	return auth, nil
+}
+
+// listRemote returns the hash of every ref of the remote.  go-git can't
+// cancel a listing, so it is abandoned, rather than stopped, if ctx is done
+// first.
+func (g *goGitSource) listRemote(ctx context.Context, repo *git.Repository) (map[string]string, error) {
+	auth, err := g.auth()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		refs []*plumbing.Reference
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		refs, err := remote.List(&git.ListOptions{Auth: auth})
+		done <- result{refs, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return nil, fmt.Errorf("error listing the refs of %s: %v", redactURL(g.s.opts.Repo), r.err)
+		}
+		refs := map[string]string{}
+		for _, ref := range r.refs {
+			if ref.Type() == plumbing.HashReference {
+				refs[ref.Name().String()] = ref.Hash().String()
+			}
+		}
+		return refs, nil
+	}
+}
+
+// Resolve returns the hash of rev: a full hash as it is, HEAD as the
+// remote's Options.Branch, and anything else as a tag, which is fetched if
+// it is annotated, to find its commit.
+func (g *goGitSource) Resolve(ctx context.Context, rev string) (string, error) {
+	s := g.s
+	if fullHashRE.MatchString(rev) {
+		return rev, nil
+	}
+	repo, err := g.open()
+	if err != nil {
+		return "", err
+	}
+	refs, err := g.listRemote(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+
+	if rev == "HEAD" {
+		hash, found := refs["refs/heads/"+s.opts.Branch]
+		if !found {
+			return "", fmt.Errorf("branch %q not found in %s", s.opts.Branch, redactURL(s.opts.Repo))
+		}
+		g.tag = ""
+		return hash, nil
+	}
+
+	hash, found := refs["refs/tags/"+rev]
+	if !found {
+		return "", fmt.Errorf("tag %q not found in %s (abbreviated hashes need --backend=%s)", rev, redactURL(s.opts.Repo), BackendExec)
+	}
+	g.tag = rev
+	if _, err := repo.Object(plumbing.AnyObject, plumbing.NewHash(hash)); err != nil {
+		if err := g.fetch(ctx, repo); err != nil {
+			return "", err
+		}
+	}
+	obj, err := repo.Object(plumbing.AnyObject, plumbing.NewHash(hash))
+	if err != nil {
+		return "", fmt.Errorf("error reading tag %q: %v", rev, err)
+	}
+	switch o := obj.(type) {
+	case *object.Commit:
+		return hash, nil
+	case *object.Tag:
+		commit, err := o.Commit()
+		if err != nil {
+			return "", fmt.Errorf("tag %q doesn't point to a commit: %v", rev, err)
+		}
+		return commit.Hash.String(), nil
+	}
+	return "", fmt.Errorf("tag %q points to a %s, not a commit", rev, obj.Type())
+}
+
+// Fetch fetches Options.Branch, and the tag synced if any, unless the clone
+// already has hash.
+func (g *goGitSource) Fetch(ctx context.Context, hash string) error {
+	repo, err := g.open()
+	if err != nil {
+		return err
+	}
+	if _, err := repo.CommitObject(plumbing.NewHash(hash)); err == nil {
+		g.s.logger(ctx).V(1).Infof("%s is already fetched", hash)
+		return nil
+	}
+	if err := g.fetch(ctx, repo); err != nil {
+		return err
+	}
+	if _, err := repo.CommitObject(plumbing.NewHash(hash)); err != nil {
+		return fmt.Errorf("%s is not in the fetched history of branch %s: %v", hash, g.s.opts.Branch, err)
+	}
+	return nil
+}
+
+// fetch fetches Options.Branch, and the tag synced if any, as deep as
+// Options.Depth.
+func (g *goGitSource) fetch(ctx context.Context, repo *git.Repository) error {
+	s := g.s
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	specs := []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", s.opts.Branch, s.opts.Branch))}
+	if g.tag != "" {
+		specs = append(specs, config.RefSpec(fmt.Sprintf("+refs/tags/%s:refs/tags/%s", g.tag, g.tag)))
+	}
+	return s.throttle(ctx, func() error {
+		err := repo.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: specs,
+			Depth:    s.opts.Depth,
+			Auth:     auth,
+			Tags:     git.NoTags,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return fmt.Errorf("error fetching %s: %v", redactURL(s.opts.Repo), err)
+		}
+		s.logger(ctx).V(1).Infof("fetched branch %s", s.opts.Branch)
+		return nil
+	})
+}
+
+// Materialize writes the tree of hash to dir.
+func (g *goGitSource) Materialize(ctx context.Context, hash, dir string) error {
+	repo, err := g.open()
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return fmt.Errorf("error reading commit %s: %v", hash, err)
+	}
+	files, err := commit.Files()
+	if err != nil {
+		return fmt.Errorf("error reading the tree of %s: %v", hash, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	err = files.ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return writeGoGitFile(f, filepath.Join(dir, filepath.FromSlash(f.Name)))
+	})
+	if err != nil {
+		return fmt.Errorf("error checking out %s: %v", hash, err)
+	}
+	g.s.logger(ctx).V(0).Infof("checked out %s in %s", hash, dir)
+	return nil
+}
+
+// writeGoGitFile writes f, a file, executable or symlink, to path.
+func writeGoGitFile(f *object.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if f.Mode == filemode.Symlink {
+		target, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, path)
+	}
+	mode := os.FileMode(0644)
+	if f.Mode == filemode.Executable {
+		mode = 0755
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Cleanup does nothing: materialized dirs hold no reference to the clone.
+func (g *goGitSource) Cleanup(ctx context.Context) error {
+	return nil
+}
+
+// ChangedPaths lists the files that differ between two commits.
+func (g *goGitSource) ChangedPaths(ctx context.Context, oldHash, newHash string) ([]string, error) {
+	repo, err := g.open()
+	if err != nil {
+		return nil, err
+	}
+	trees := []*object.Tree{}
+	for _, hash := range []string{oldHash, newHash} {
+		commit, err := repo.CommitObject(plumbing.NewHash(hash))
+		if err != nil {
+			return nil, fmt.Errorf("error reading commit %s: %v", hash, err)
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("error reading the tree of %s: %v", hash, err)
+		}
+		trees = append(trees, tree)
+	}
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, c := range changes {
+		if c.To.Name != "" {
+			paths = append(paths, c.To.Name)
+		} else {
+			paths = append(paths, c.From.Name)
+		}
+	}
+	return paths, nil
+}
+
+// DescribeCommit returns the committer and subject of a commit.
+func (g *goGitSource) DescribeCommit(ctx context.Context, hash string) (Commit, error) {
+	repo, err := g.open()
+	if err != nil {
+		return Commit{}, err
+	}
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return Commit{}, fmt.Errorf("error reading commit %s: %v", hash, err)
+	}
+	subject := strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0]
+	return Commit{Hash: hash, Committer: commit.Committer.Name, Subject: subject}, nil
+}
+
+// checkGoGit returns an error naming the first option set in o that
+// Options.Backend gogit doesn't support, as it needs the git CLI or
+// settings go-git lacks.
+func (o Options) checkGoGit() error {
+	if o.Source != nil {
+		return fmt.Errorf("--backend=%s can't be used with a custom Options.Source", BackendGoGit)
+	}
+	unsupported := []struct {
+		flag string
+		set  bool
+	}{
+		{"--credential-helper", o.CredentialHelper != ""},
+		{"--netrc", o.Netrc},
+		{"--askpass-url", o.AskpassURL != ""},
+		{"--oauth2-token-url", o.OAuth2TokenURL != ""},
+		{"--sts-url", o.STSURL != ""},
+		{"--gcp-metadata-token", o.GCPMetadataToken},
+		{"--azure-managed-identity", o.AzureManagedIdentity},
+		{"--codecommit", o.CodeCommit},
+		{"--vault-addr", o.VaultAddr != ""},
+		{"--http-header", len(o.HTTPHeaders) > 0},
+		{"--http-user-agent", o.HTTPUserAgent != ""},
+		{"--http-low-speed-limit", o.HTTPLowSpeedLimit != 0},
+		{"--tls-client-cert", o.TLSClientCert != ""},
+		{"--ca-cert-file", o.CACertFile != ""},
+		{"--insecure-skip-tls-verify", o.InsecureSkipTLSVerify},
+		{"--ssh-host-fingerprint", len(o.SSHHostFingerprints) > 0},
+		{"--ssh-config-file", o.SSHConfigFile != ""},
+		{"--ssh-proxy-command", o.SSHProxyCommand != ""},
+		{"--ssh-proxy-jump", o.SSHProxyJump != ""},
+		{"--ssh-control-persist", o.SSHControlPersist != 0},
+		{"--ssh-auth-sock", o.SSHAuthSock != ""},
+		{"--shallow-since", o.ShallowSince != ""},
+		{"--shallow-exclude", len(o.ShallowExclude) > 0},
+		{"--auto-depth", o.AutoDepth},
+		{"--clone-check-every-sync", o.CloneCheckEverySync},
+		{"--missing-ref", o.MissingRef != "" && o.MissingRef != MissingRefFail},
+		{"--immutable-tags", o.ImmutableTags},
+		{"--prune-interval", o.PruneInterval != 0},
+		{"--submodules", o.Submodules},
+		{"--cache-dir", o.CacheDir != ""},
+		{"--partial-clone", o.PartialClone},
+		{"--sparse-path", len(o.SparsePaths) > 0},
+		{"--pack-threads", o.PackThreads != 0},
+		{"--index-threads", o.IndexThreads != 0},
+		{"--compression-level", o.CompressionLevel != nil},
+		{"--checkout-workers", o.CheckoutWorkers != 0},
+		{"--hardlink-worktrees", o.HardlinkWorktrees},
+		{"--reflink-worktrees", o.ReflinkWorktrees},
+		{"--publish-strategy=" + PublishInPlace, o.PublishStrategy == PublishInPlace},
+		{"--verify-gitsign-identity", o.GitsignIdentity != ""},
+		{"--audit-log", o.AuditLog != ""},
+	}
+	for _, u := range unsupported {
+		if u.set {
+			return fmt.Errorf("%s needs --backend=%s", u.flag, BackendExec)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoGitSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	writeTree(t, repo, map[string]string{"file": "one", "sub/run.sh": "#!/bin/sh\n"})
+	if err := os.Chmod(filepath.Join(repo, "sub", "run.sh"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Symlink("file", filepath.Join(repo, "link")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runGit(t, "-C", repo, "add", ".")
+	runGit(t, "-C", repo, "commit", "-q", "-m", "one")
+	runGit(t, "-C", repo, "tag", "-a", "-m", "v1", "v1")
+	one := strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD"))
+
+	root := filepath.Join(dir, "root")
+	s := &Syncer{
+		opts: Options{Repo: repo, Branch: "sync", Rev: "HEAD", Root: root, Dest: "link", Backend: BackendGoGit},
+		env:  map[string]string{},
+	}
+	g := &goGitSource{s: s}
+	s.source = g
+	ctx := context.Background()
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(root, "link", "file")); err != nil || string(data) != "one" {
+		t.Fatalf("expected file to be checked out but got %q, %v", data, err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "link", "sub", "run.sh")); err != nil || fi.Mode()&0111 == 0 {
+		t.Fatalf("expected sub/run.sh to be executable but got %v, %v", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "link", "link")); err != nil || target != "file" {
+		t.Fatalf("expected link to point to file but got %q, %v", target, err)
+	}
+
+	writeTree(t, repo, map[string]string{"file": "two", "new": "new"})
+	runGit(t, "-C", repo, "add", ".")
+	runGit(t, "-C", repo, "commit", "-q", "-m", "two")
+	two := strings.TrimSpace(runGit(t, "-C", repo, "rev-parse", "HEAD"))
+	if err := s.SyncOnce(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash, err := s.publishedHash(); err != nil || hash != two {
+		t.Fatalf("expected %s to be published but got %q, %v", two, hash, err)
+	}
+	paths, err := g.ChangedPaths(ctx, one, two)
+	if err != nil || !reflect.DeepEqual(paths, []string{"file", "new"}) {
+		t.Fatalf("expected file and new to have changed but got %q, %v", paths, err)
+	}
+	if c, err := g.DescribeCommit(ctx, two); err != nil || c.Committer != "a" || c.Subject != "two" {
+		t.Fatalf("expected commit two by a but got %+v, %v", c, err)
+	}
+
+	// An annotated tag resolves to its commit.
+	if hash, err := g.Resolve(ctx, "v1"); err != nil || hash != one {
+		t.Fatalf("expected v1 to resolve to %s but got %q, %v", one, hash, err)
+	}
+	if _, err := g.Resolve(ctx, "v2"); err == nil {
+		t.Fatalf("expected a missing tag to fail")
+	}
+}
+
+func TestCheckGoGit(t *testing.T) {
+	cases := []struct {
+		opts Options
+		err  bool
+	}{
+		{Options{Repo: "https://github.com/a/b", Backend: BackendGoGit, Depth: 1, Username: "a", Password: "b"}, false},
+		{Options{Repo: "git@github.com:a/b", Backend: BackendGoGit, SSH: true, SSHKnownHosts: true}, false},
+		{Options{Repo: "https://github.com/a/b", Backend: BackendGoGit, Submodules: true}, true},
+		{Options{Repo: "https://github.com/a/b", Backend: BackendGoGit, HTTPHeaders: []string{"X-A: b"}}, true},
+		{Options{Repo: "https://github.com/a/b", Backend: BackendGoGit, Source: &fakeSource{}}, true},
+		{Options{Repo: "https://github.com/a/b", Backend: "libgit2"}, true},
+	}
+
+	for _, testCase := range cases {
+		if err := testCase.opts.Validate(); (err != nil) != testCase.err {
+			t.Fatalf("%+v: expected error %v but got %v", testCase.opts, testCase.err, err)
+		}
+	}
+}
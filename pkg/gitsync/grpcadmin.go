@@ -0,0 +1,153 @@
+package gitsync
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/git-sync/pkg/adminpb"
+)
+
+// grpcAdminServer serves the gRPC admin API of a Manager through the
+// adminHandler of the REST one, so that both check what is put the same
+// way.
+type grpcAdminServer struct {
+	h *adminHandler
+}
+
+// NewGRPCAdminServer returns a gRPC server with the admin API for m, as
+// defined in pkg/adminpb/admin.proto.  Repos are put as with AdminHandler,
+// and their unset options are taken from defaults.  If token is set, calls
+// must send it as a bearer token in their "authorization" metadata.  opts,
+// e.g. TLS credentials, are passed to grpc.NewServer.
+func NewGRPCAdminServer(m *Manager, defaults Options, token string, opts ...grpc.ServerOption) *grpc.Server {
+	if token != "" {
+		opts = append(opts, grpc.UnaryInterceptor(bearerTokenInterceptor(token)))
+	}
+	server := grpc.NewServer(opts...)
+	adminpb.RegisterAdminServer(server, &grpcAdminServer{h: &adminHandler{m: m, defaults: defaults}})
+	return server
+}
+
+// bearerTokenInterceptor rejects calls that don't send token as a bearer
+// token.
+func bearerTokenInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		auth := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md["authorization"]) > 0 {
+			auth = md["authorization"][0]
+		}
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (g *grpcAdminServer) ListRepos(ctx context.Context, req *adminpb.ListReposRequest) (*adminpb.ListReposResponse, error) {
+	resp := &adminpb.ListReposResponse{}
+	for _, st := range g.h.m.Statuses() {
+		resp.Repos = append(resp.Repos, repoStatus(st))
+	}
+	return resp, nil
+}
+
+func (g *grpcAdminServer) GetRepo(ctx context.Context, req *adminpb.RepoRequest) (*adminpb.RepoStatus, error) {
+	return g.status(req.Name)
+}
+
+func (g *grpcAdminServer) PutRepo(ctx context.Context, req *adminpb.PutRepoRequest) (*adminpb.PutRepoResponse, error) {
+	code, err := g.h.putRepo(req.Name, []byte(req.OptionsJson))
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	st, err := g.status(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &adminpb.PutRepoResponse{Created: code == http.StatusCreated, Status: st}, nil
+}
+
+func (g *grpcAdminServer) RemoveRepo(ctx context.Context, req *adminpb.RepoRequest) (*adminpb.RemoveRepoResponse, error) {
+	if _, err := g.h.m.Remove(req.Name); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &adminpb.RemoveRepoResponse{}, nil
+}
+
+func (g *grpcAdminServer) SyncRepo(ctx context.Context, req *adminpb.RepoRequest) (*adminpb.RepoStatus, error) {
+	return g.action(req.Name, "sync")
+}
+
+func (g *grpcAdminServer) PauseRepo(ctx context.Context, req *adminpb.RepoRequest) (*adminpb.RepoStatus, error) {
+	return g.action(req.Name, "pause")
+}
+
+func (g *grpcAdminServer) ResumeRepo(ctx context.Context, req *adminpb.RepoRequest) (*adminpb.RepoStatus, error) {
+	return g.action(req.Name, "resume")
+}
+
+func (g *grpcAdminServer) PromoteRepo(ctx context.Context, req *adminpb.RepoRequest) (*adminpb.RepoStatus, error) {
+	return g.action(req.Name, "promote")
+}
+
+func (g *grpcAdminServer) action(name, action string) (*adminpb.RepoStatus, error) {
+	if code, err := g.h.doAction(name, action); err != nil {
+		return nil, grpcError(code, err)
+	}
+	return g.status(name)
+}
+
+func (g *grpcAdminServer) status(name string) (*adminpb.RepoStatus, error) {
+	st, err := g.h.m.Status(name)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return repoStatus(st), nil
+}
+
+// grpcError returns err with the gRPC code matching code, an HTTP status
+// returned by the REST admin API.
+func grpcError(code int, err error) error {
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// repoStatus converts st to its message.
+func repoStatus(st Status) *adminpb.RepoStatus {
+	return &adminpb.RepoStatus{
+		Name:                st.Name,
+		Repo:                st.Repo,
+		Rev:                 st.Rev,
+		Hash:                st.Hash,
+		Staged:              st.Staged,
+		LastSync:            unixTime(st.LastSync),
+		LastSuccess:         unixTime(st.LastSuccess),
+		LastError:           st.LastError,
+		ConsecutiveFailures: int32(st.ConsecutiveFail),
+		Stale:               st.Stale,
+		Paused:              st.Paused,
+		Stopped:             st.Stopped,
+	}
+}
+
+// unixTime returns t in seconds since the epoch, or 0 if t is zero.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,101 @@
+package gitsync
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"k8s.io/git-sync/pkg/adminpb"
+)
+
+func TestGRPCAdminServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx)
+	defaults := Options{
+		Source: &fakeSource{hash: "one"},
+		Rev:    "HEAD",
+		Wait:   60,
+		Root:   dir,
+	}
+	server := NewGRPCAdminServer(m, defaults, "secret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %v", err)
+	}
+	go server.Serve(ln)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("can't dial: %v", err)
+	}
+	defer conn.Close()
+	client := adminpb.NewAdminClient(conn)
+	authed := metadata.NewOutgoingContext(ctx, metadata.Pairs("authorization", "Bearer secret"))
+	wrong := metadata.NewOutgoingContext(ctx, metadata.Pairs("authorization", "Bearer wrong"))
+
+	put := func(ctx context.Context, name, options string) error {
+		_, err := client.PutRepo(ctx, &adminpb.PutRepoRequest{Name: name, OptionsJson: options})
+		return err
+	}
+	action := func(call func(context.Context, *adminpb.RepoRequest, ...grpc.CallOption) (*adminpb.RepoStatus, error), name string) error {
+		_, err := call(authed, &adminpb.RepoRequest{Name: name})
+		return err
+	}
+	cases := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"list without token", func() error { _, err := client.ListRepos(ctx, &adminpb.ListReposRequest{}); return err }, codes.Unauthenticated},
+		{"list with wrong token", func() error { _, err := client.ListRepos(wrong, &adminpb.ListReposRequest{}); return err }, codes.Unauthenticated},
+		{"get missing", func() error { return action(client.GetRepo, "a") }, codes.NotFound},
+		{"put a", func() error { return put(authed, "a", `{"repo": "https://example.com/a"}`) }, codes.OK},
+		{"put a again", func() error { return put(authed, "a", `{"repo": "https://example.com/a", "wait": 30}`) }, codes.OK},
+		{"put without repo", func() error { return put(authed, "b", `{}`) }, codes.InvalidArgument},
+		{"put bad json", func() error { return put(authed, "b", `not json`) }, codes.InvalidArgument},
+		{"put exechookCommand", func() error {
+			return put(authed, "b", `{"repo": "https://example.com/b", "exechookCommand": "sh -c id"}`)
+		}, codes.InvalidArgument},
+		{"put root outside", func() error { return put(authed, "b", `{"repo": "https://example.com/b", "root": "/etc"}`) }, codes.InvalidArgument},
+		{"put name with slash", func() error { return put(authed, "../b", `{"repo": "https://example.com/b"}`) }, codes.InvalidArgument},
+		{"get a", func() error { return action(client.GetRepo, "a") }, codes.OK},
+		{"sync a", func() error { return action(client.SyncRepo, "a") }, codes.OK},
+		{"pause a", func() error { return action(client.PauseRepo, "a") }, codes.OK},
+		{"sync paused a", func() error { return action(client.SyncRepo, "a") }, codes.FailedPrecondition},
+		{"resume a", func() error { return action(client.ResumeRepo, "a") }, codes.OK},
+		{"remove a", func() error { _, err := client.RemoveRepo(authed, &adminpb.RepoRequest{Name: "a"}); return err }, codes.OK},
+		{"remove a again", func() error { _, err := client.RemoveRepo(authed, &adminpb.RepoRequest{Name: "a"}); return err }, codes.NotFound},
+	}
+
+	for _, testCase := range cases {
+		if code := grpc.Code(testCase.call()); code != testCase.code {
+			t.Fatalf("%s: expected %v but %v returned", testCase.name, testCase.code, code)
+		}
+	}
+
+	resp, err := client.PutRepo(authed, &adminpb.PutRepoRequest{Name: "c", OptionsJson: `{"repo": "https://example.com/c"}`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Created || resp.Status.Name != "c" || resp.Status.Repo != "https://example.com/c" {
+		t.Fatalf("expected c to be created but %+v returned", resp)
+	}
+	list, err := client.ListRepos(authed, &adminpb.ListReposRequest{})
+	if err != nil || len(list.Repos) != 1 || list.Repos[0].Name != "c" {
+		t.Fatalf("expected only c to be listed but got %v, %v", list, err)
+	}
+}
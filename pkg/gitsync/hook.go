@@ -0,0 +1,241 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/thockin/logr"
+)
+
+// HookPhase is the point in a sync at which hooks run.
+type HookPhase string
+
+const (
+	// HookPreFetch runs before every check of the remote.  A failure skips
+	// the sync.
+	HookPreFetch HookPhase = "pre-fetch"
+	// HookPostCheckout runs once a new revision has been checked out, before
+	// it is published.  A failure discards it, e.g. to reject invalid
+	// content.
+	HookPostCheckout HookPhase = "post-checkout"
+	// HookPostPublish runs once a new revision has been published.  A
+	// failure is only logged.
+	HookPostPublish HookPhase = "post-publish"
+)
+
+// hookProtocolVersion is HookEvent.Version, to be bumped if fields change
+// meaning.
+const hookProtocolVersion = 1
+
+// HookEvent describes the point in a sync at which a hook runs.  Exec hooks
+// read it as JSON on stdin.
+type HookEvent struct {
+	Version int       `json:"version"`
+	Phase   HookPhase `json:"phase"`
+	Name    string    `json:"name"`
+	Repo    string    `json:"repo"`
+	Rev     string    `json:"rev"`
+	// OldHash is the published revision, if any.
+	OldHash string `json:"oldHash,omitempty"`
+	// NewHash is the revision being synced, after pre-fetch.
+	NewHash string `json:"newHash,omitempty"`
+	// Worktree is the directory holding NewHash, after pre-fetch.
+	Worktree string `json:"worktree,omitempty"`
+}
+
+// Hook extends a sync at each HookPhase.
+type Hook interface {
+	RunHook(ctx context.Context, e HookEvent) error
+}
+
+// execHook runs a command for every phase, passing the HookEvent as JSON on
+// stdin.  A non-zero exit is a failure.
+type execHook struct {
+	command string
+	logger  func(context.Context) logr.Logger
+}
+
+func (h execHook) RunHook(ctx context.Context, e HookEvent) error {
+	input, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, h.command)
+	cmd.Dir = e.Worktree
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := runGroup(ctx, cmd)
+	if len(output) > 0 {
+		h.logger(ctx).V(1).Infof("hook %s: %s", h.command, output)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook %s failed: %v: %q", e.Phase, h.command, err, string(output))
+	}
+	return nil
+}
+
+// skipError is returned by a sync skipped by a pre-fetch hook or
+// Options.PresyncCommand, which is not a failure.
+type skipError struct {
+	reason error
+}
+
+func (e skipError) Error() string {
+	return fmt.Sprintf("sync skipped: %v", e.reason)
+}
+
+// repoHookPath is where, in a worktree, the repo's own post-checkout hook
+// lives.
+var repoHookPath = filepath.Join(".git-sync", "post-checkout")
+
+// repoHook runs the repo's own post-checkout hook, if Options.AllowRepoHooks
+// is set and the new revision has one.  It runs in the worktree with a
+// minimal environment, without git-sync's credentials, for up to
+// Options.RepoHookTimeout seconds.  A failure discards the revision.
+func (s *Syncer) repoHook(ctx context.Context, st *SyncState) error {
+	if !s.opts.AllowRepoHooks {
+		return nil
+	}
+	script := filepath.Join(st.Dir, repoHookPath)
+	fi, err := os.Lstat(script)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("repo hook %s is not a regular file", repoHookPath)
+	}
+
+	ctx = withLogFields(ctx, "phase", "repo-hook")
+	if s.opts.RepoHookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTime(s.opts.RepoHookTimeout))
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = st.Dir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + st.Dir,
+		"GIT_SYNC_NAME=" + s.opts.Name,
+		"GIT_SYNC_REV=" + s.opts.Rev,
+		"GIT_SYNC_OLD_HASH=" + st.OldHash,
+		"GIT_SYNC_NEW_HASH=" + st.NewHash,
+	}
+	output, err := runGroup(ctx, cmd)
+	if len(output) > 0 {
+		s.logger(ctx).V(1).Infof("repo hook: %s", output)
+	}
+	if err != nil {
+		return fmt.Errorf("repo hook %s failed: %v: %q", repoHookPath, err, string(output))
+	}
+	return nil
+}
+
+// IsSkipped returns true if err is from a sync that was skipped rather than
+// failed.
+func IsSkipped(err error) bool {
+	_, ok := err.(skipError)
+	return ok
+}
+
+// presync runs Options.PresyncCommand, if set, returning a skipError if it
+// fails.
+func (s *Syncer) presync(ctx context.Context) error {
+	if s.opts.PresyncCommand == "" {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, s.opts.PresyncCommand)
+	cmd.Env = s.hookEnv()
+	output, err := runGroup(ctx, cmd)
+	if len(output) > 0 {
+		s.logger(ctx).V(1).Infof("presync %s: %s", s.opts.PresyncCommand, output)
+	}
+	if err != nil {
+		return skipError{fmt.Errorf("presync %s failed: %v: %q", s.opts.PresyncCommand, err, string(output))}
+	}
+	return nil
+}
+
+// hookEnv returns the environment of Options.PresyncCommand and
+// Options.ExechookCommand: git-sync's own, the repo's options and extra.
+func (s *Syncer) hookEnv(extra ...string) []string {
+	env := append(os.Environ(),
+		"GIT_SYNC_NAME="+s.opts.Name,
+		"GIT_SYNC_REPO="+s.opts.Repo,
+		"GIT_SYNC_REV="+s.opts.Rev,
+	)
+	return append(env, extra...)
+}
+
+// runHooks runs every hook for e, stopping at the first failure.
+func (s *Syncer) runHooks(ctx context.Context, e HookEvent) error {
+	e.Version = hookProtocolVersion
+	e.Name = s.opts.Name
+	e.Repo = s.opts.Repo
+	e.Rev = s.opts.Rev
+	ctx = withLogFields(ctx, "phase", string(e.Phase))
+	for _, h := range s.hooks {
+		if err := h.RunHook(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exechook runs Options.ExechookCommand once a new revision has been
+// published, in its worktree.  Failed runs are retried after
+// Options.ExechookBackoff, doubling every time, up to
+// Options.ExechookRetries times, and are then only logged.
+func (s *Syncer) exechook(ctx context.Context, st *SyncState) error {
+	if s.opts.ExechookCommand == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "exechook")
+	backoff := waitTime(s.opts.ExechookBackoff)
+	for retries := 0; ; retries++ {
+		err := s.runExechook(ctx, st)
+		if err == nil {
+			return nil
+		}
+		if retries >= s.opts.ExechookRetries || ctx.Err() != nil {
+			s.logger(ctx).Errorf("%v", err)
+			return nil
+		}
+		s.logger(ctx).V(0).Infof("WARNING: %v; retrying in %v", err, backoff)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// runExechook runs Options.ExechookCommand once, giving up after
+// Options.ExechookTimeout.
+func (s *Syncer) runExechook(ctx context.Context, st *SyncState) error {
+	if s.opts.ExechookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTime(s.opts.ExechookTimeout))
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, s.opts.ExechookCommand)
+	cmd.Dir = st.Dir
+	cmd.Env = s.hookEnv("GIT_SYNC_OLD_HASH="+st.OldHash, "GIT_SYNC_NEW_HASH="+st.NewHash)
+	output, err := runGroup(ctx, cmd)
+	if len(output) > 0 {
+		s.logger(ctx).V(1).Infof("exechook %s: %s", s.opts.ExechookCommand, output)
+	}
+	if err != nil {
+		return fmt.Errorf("exechook %s failed: %v: %q", s.opts.ExechookCommand, err, string(output))
+	}
+	return nil
+}
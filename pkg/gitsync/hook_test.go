@@ -0,0 +1,248 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeHook records the phases it runs at, and fails at failPhase.
+type fakeHook struct {
+	phases    []string
+	failPhase HookPhase
+}
+
+func (f *fakeHook) RunHook(ctx context.Context, e HookEvent) error {
+	f.phases = append(f.phases, fmt.Sprintf("%s:%s", e.Phase, e.NewHash))
+	if e.Phase == f.failPhase {
+		return fmt.Errorf("%s failed", e.Phase)
+	}
+	return nil
+}
+
+func TestSyncOnceHooks(t *testing.T) {
+	cases := []struct {
+		failPhase HookPhase
+		phases    string
+		published bool
+		err       bool
+	}{
+		{"", "pre-fetch: post-checkout:one post-publish:one", true, false},
+		{HookPreFetch, "pre-fetch:", false, true},
+		{HookPostCheckout, "pre-fetch: post-checkout:one", false, true},
+		{HookPostPublish, "pre-fetch: post-checkout:one post-publish:one", true, false},
+	}
+
+	for _, testCase := range cases {
+		root, err := ioutil.TempDir("", "git-sync-test-")
+		if err != nil {
+			t.Fatalf("can't create temp dir: %v", err)
+		}
+		defer os.RemoveAll(root)
+
+		hook := &fakeHook{failPhase: testCase.failPhase}
+		s := &Syncer{
+			opts:   Options{Root: root, Dest: "link", Rev: "HEAD"},
+			source: &fakeSource{hash: "one"},
+			hooks:  []Hook{hook},
+			env:    map[string]string{},
+		}
+		err = s.SyncOnce(context.Background())
+		if (err != nil) != testCase.err {
+			t.Fatalf("%q: expected error %v but got %v", testCase.failPhase, testCase.err, err)
+		}
+		if skipped := IsSkipped(err); skipped != (testCase.failPhase == HookPreFetch) {
+			t.Fatalf("%q: unexpected skipped %v", testCase.failPhase, skipped)
+		}
+		if phases := strings.Join(hook.phases, " "); phases != testCase.phases {
+			t.Fatalf("%q: expected hooks %s but %s ran", testCase.failPhase, testCase.phases, phases)
+		}
+		_, err = os.Stat(filepath.Join(root, "rev-one"))
+		if published := err == nil; published != testCase.published {
+			t.Fatalf("%q: expected published %v but got %v", testCase.failPhase, testCase.published, published)
+		}
+	}
+}
+
+func TestExecHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "stdin")
+	script := filepath.Join(dir, "hook")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\ncat > "+out+"\ngrep -q post-checkout "+out+" && exit 3\nexit 0\n"), 0755)
+
+	h := execHook{command: script, logger: (&Syncer{}).logger}
+	if err := h.RunHook(context.Background(), HookEvent{Phase: HookPreFetch, Name: "a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stdin, _ := ioutil.ReadFile(out)
+	if expected := `{"version":0,"phase":"pre-fetch","name":"a","repo":"","rev":""}`; string(stdin) != expected {
+		t.Fatalf("expected %s but %s was sent", expected, stdin)
+	}
+	if err := h.RunHook(context.Background(), HookEvent{Phase: HookPostCheckout, Worktree: dir}); err == nil {
+		t.Fatalf("expected the exit status to fail the hook")
+	}
+}
+
+func TestSyncOnceExechook(t *testing.T) {
+	cases := []struct {
+		failures int
+		retries  int
+		runs     int
+	}{
+		{0, 0, 1},
+		{2, 3, 3},
+		{5, 2, 3},
+	}
+
+	for _, testCase := range cases {
+		root, err := ioutil.TempDir("", "git-sync-test-")
+		if err != nil {
+			t.Fatalf("can't create temp dir: %v", err)
+		}
+		defer os.RemoveAll(root)
+
+		// The script logs every run and fails the first failures times.
+		runs := filepath.Join(root, "runs")
+		script := filepath.Join(root, "exechook")
+		ioutil.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\necho \"$(pwd) $GIT_SYNC_OLD_HASH:$GIT_SYNC_NEW_HASH\" >> %s\n[ $(wc -l < %s) -gt %d ]\n", runs, runs, testCase.failures)), 0755)
+
+		s := &Syncer{
+			opts: Options{
+				Root:            root,
+				Dest:            "link",
+				Rev:             "HEAD",
+				ExechookCommand: script,
+				ExechookRetries: testCase.retries,
+				ExechookBackoff: 0.001,
+			},
+			source: &fakeSource{hash: "one"},
+			env:    map[string]string{},
+		}
+		if err := s.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("%d failures: unexpected error: %v", testCase.failures, err)
+		}
+
+		data, err := ioutil.ReadFile(runs)
+		if err != nil {
+			t.Fatalf("%d failures: exechook didn't run: %v", testCase.failures, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != testCase.runs {
+			t.Fatalf("%d failures: expected %d runs but %d ran", testCase.failures, testCase.runs, len(lines))
+		}
+		dir, _ := filepath.EvalSymlinks(filepath.Join(root, "rev-one"))
+		if expected := dir + " :one"; lines[0] != expected {
+			t.Fatalf("%d failures: expected %q but %q returned", testCase.failures, expected, lines[0])
+		}
+	}
+}
+
+func TestRunPresync(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// The script fails the first time it runs.
+	runs := filepath.Join(root, "runs")
+	script := filepath.Join(root, "presync")
+	ioutil.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\necho \"$GIT_SYNC_NAME\" >> %s\n[ $(wc -l < %s) -gt 1 ]\n", runs, runs)), 0755)
+
+	s := &Syncer{
+		opts: Options{
+			Name:           "a",
+			Root:           root,
+			Dest:           "link",
+			Rev:            "HEAD",
+			OneTime:        true,
+			PresyncCommand: script,
+		},
+		source: &fakeSource{hash: "one"},
+		env:    map[string]string{},
+	}
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatalf("presync didn't run: %v", err)
+	}
+	if string(data) != "a\na\n" {
+		t.Fatalf("expected two runs but %q returned", string(data))
+	}
+	if st := s.Status(); st.Hash != "one" || st.ConsecutiveFail != 0 {
+		t.Fatalf("expected hash one and no failures but %+v returned", st)
+	}
+}
+
+// repoHookSource is a fakeSource whose revisions hold script as their
+// repo hook.
+type repoHookSource struct {
+	fakeSource
+	script string
+}
+
+func (f *repoHookSource) Materialize(ctx context.Context, hash, dir string) error {
+	if err := f.fakeSource.Materialize(ctx, hash, dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".git-sync"), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, repoHookPath), []byte(f.script), 0755)
+}
+
+func TestSyncOnceRepoHook(t *testing.T) {
+	cases := []struct {
+		allow     bool
+		script    string
+		timeout   float64
+		ran       bool
+		published bool
+	}{
+		{false, "#!/bin/sh\ntouch ran\n", 0, false, true},
+		{true, "#!/bin/sh\ntouch ran\n", 0, true, true},
+		{true, "#!/bin/sh\n[ -z \"$GIT_SYNC_SECRET\" ] && [ \"$GIT_SYNC_NEW_HASH\" = one ] && touch ran\n", 0, true, true},
+		{true, "#!/bin/sh\ntouch ran\nexit 1\n", 0, true, false},
+		{true, "#!/bin/sh\ntouch ran\nexec sleep 5\n", 0.1, true, false},
+	}
+
+	os.Setenv("GIT_SYNC_SECRET", "x")
+	defer os.Unsetenv("GIT_SYNC_SECRET")
+	for i, testCase := range cases {
+		root, err := ioutil.TempDir("", "git-sync-test-")
+		if err != nil {
+			t.Fatalf("can't create temp dir: %v", err)
+		}
+		defer os.RemoveAll(root)
+
+		s := &Syncer{
+			opts:   Options{Root: root, Dest: "link", Rev: "HEAD", AllowRepoHooks: testCase.allow, RepoHookTimeout: testCase.timeout},
+			source: &repoHookSource{fakeSource: fakeSource{hash: "one"}, script: testCase.script},
+			env:    map[string]string{},
+		}
+		err = s.SyncOnce(context.Background())
+		if (err != nil) == testCase.published {
+			t.Fatalf("case %d: expected published %v but got error %v", i, testCase.published, err)
+		}
+		_, err = os.Stat(filepath.Join(root, "link", "ran"))
+		if ran := err == nil; testCase.published && ran != testCase.ran {
+			t.Fatalf("case %d: expected ran %v but got %v", i, testCase.ran, ran)
+		}
+		_, err = os.Stat(filepath.Join(root, "rev-one"))
+		if published := err == nil; published != testCase.published {
+			t.Fatalf("case %d: expected published %v but got %v", i, testCase.published, published)
+		}
+	}
+}
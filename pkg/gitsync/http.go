@@ -0,0 +1,82 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/git-sync/pkg/version"
+)
+
+// parseHTTPHeader splits a "Name: value" header.
+func parseHTTPHeader(header string) (string, string, error) {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.ContainsAny(strings.TrimSpace(parts[0]), " \t") {
+		return "", "", fmt.Errorf("invalid HTTP header %q: must be \"Name: value\"", header)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+// setupGitHTTPHeaders configures git to send headers on every HTTP request,
+// through setGitConfig rather than "git config --global", so that the
+// headers of one repo in a --config file aren't sent to the others.  Each
+// header replaces any earlier header of the same name.
+func (s *Syncer) setupGitHTTPHeaders(ctx context.Context, headers []string) error {
+	s.logger(ctx).V(1).Infof("setting up git HTTP headers")
+	names := []string{}
+	values := map[string]string{}
+	for _, h := range headers {
+		name, value, err := parseHTTPHeader(h)
+		if err != nil {
+			return err
+		}
+		if _, found := values[name]; !found {
+			names = append(names, name)
+		}
+		values[name] = value
+	}
+	for _, name := range names {
+		s.addGitConfig("http.extraHeader", name+": "+values[name])
+	}
+	return nil
+}
+
+// defaultUserAgent returns git's own HTTP user agent with the git-sync
+// version appended, e.g. "git/2.11.0 git-sync/v2.0.4".
+func (s *Syncer) defaultUserAgent(ctx context.Context) (string, error) {
+	output, err := s.runCommand(ctx, "", "git", "--version")
+	if err != nil {
+		return "", err
+	}
+	gitVersion := strings.TrimPrefix(strings.TrimSpace(output), "git version ")
+	return fmt.Sprintf("git/%s git-sync/%s", gitVersion, version.VERSION), nil
+}
+
+// setupGitUserAgent configures the user agent git sends on HTTP requests.
+func (s *Syncer) setupGitUserAgent(ctx context.Context, userAgent string) error {
+	if userAgent == "" {
+		ua, err := s.defaultUserAgent(ctx)
+		if err != nil {
+			return err
+		}
+		userAgent = ua
+	}
+	s.logger(ctx).V(1).Infof("setting git HTTP user agent to %q", userAgent)
+
+	s.setEnv("GIT_HTTP_USER_AGENT", userAgent)
+	return nil
+}
+
+// setupLowSpeedLimit has git abort HTTP transfers that stall, per
+// Options.HTTPLowSpeedLimit and Options.HTTPLowSpeedTime.  Behind flaky NAT
+// gateways, a connection can go quiet without being closed, and the fetch
+// would otherwise hang until the sync times out.
+func (s *Syncer) setupLowSpeedLimit(ctx context.Context) {
+	if s.opts.HTTPLowSpeedLimit == 0 {
+		return
+	}
+	s.logger(ctx).V(1).Infof("aborting HTTP transfers slower than %d bytes/s for %ds", s.opts.HTTPLowSpeedLimit, s.opts.HTTPLowSpeedTime)
+	s.setGitConfig("http.lowSpeedLimit", strconv.Itoa(s.opts.HTTPLowSpeedLimit))
+	s.setGitConfig("http.lowSpeedTime", strconv.Itoa(s.opts.HTTPLowSpeedTime))
+}
@@ -0,0 +1,72 @@
+package gitsync
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseHTTPHeader(t *testing.T) {
+	cases := []struct {
+		header string
+		name   string
+		value  string
+		err    bool
+	}{
+		{"X-Foo: bar", "X-Foo", "bar", false},
+		{"X-Foo:bar: baz", "X-Foo", "bar: baz", false},
+		{"X-Foo:", "X-Foo", "", false},
+		{"X-Foo", "", "", true},
+		{": bar", "", "", true},
+		{"X Foo: bar", "", "", true},
+	}
+
+	for _, testCase := range cases {
+		name, value, err := parseHTTPHeader(testCase.header)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%q: expected error %v but got %v", testCase.header, testCase.err, err)
+		}
+		if name != testCase.name || value != testCase.value {
+			t.Fatalf("%q: expected %q/%q but %q/%q returned", testCase.header, testCase.name, testCase.value, name, value)
+		}
+	}
+}
+
+func TestSetupLowSpeedLimit(t *testing.T) {
+	s := &Syncer{opts: Options{HTTPLowSpeedLimit: 1000, HTTPLowSpeedTime: 30}, env: map[string]string{}}
+	s.setupLowSpeedLimit(context.Background())
+	cases := map[string]string{
+		"GIT_CONFIG_COUNT":   "2",
+		"GIT_CONFIG_KEY_0":   "http.lowSpeedLimit",
+		"GIT_CONFIG_VALUE_0": "1000",
+		"GIT_CONFIG_KEY_1":   "http.lowSpeedTime",
+		"GIT_CONFIG_VALUE_1": "30",
+	}
+	for name, exp := range cases {
+		if got := s.env[name]; got != exp {
+			t.Fatalf("%s: expected %q but %q returned", name, exp, got)
+		}
+	}
+}
+
+func TestSetupGitHTTPHeaders(t *testing.T) {
+	s := &Syncer{env: map[string]string{}}
+	s.setGitConfig("pack.threads", "1")
+	if err := s.setupGitHTTPHeaders(context.Background(), []string{"X-A: one", "X-B: two", "X-A: three"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := map[string]string{
+		"GIT_CONFIG_COUNT":   "3",
+		"GIT_CONFIG_KEY_1":   "http.extraHeader",
+		"GIT_CONFIG_VALUE_1": "X-A: three",
+		"GIT_CONFIG_KEY_2":   "http.extraHeader",
+		"GIT_CONFIG_VALUE_2": "X-B: two",
+	}
+	for name, exp := range cases {
+		if got := s.env[name]; got != exp {
+			t.Fatalf("%s: expected %q but %q returned", name, exp, got)
+		}
+	}
+	if err := s.setupGitHTTPHeaders(context.Background(), []string{"bad"}); err == nil {
+		t.Fatalf("expected an invalid header to be rejected")
+	}
+}
@@ -0,0 +1,119 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// kafkaContentType is the REST Proxy v2 media type for JSON records.
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// kafkaPublisher publishes Events to a Kafka topic through a Kafka REST
+// Proxy (e.g. Confluent REST Proxy, Redpanda's HTTP proxy or the Strimzi
+// Kafka Bridge), which saves implementing the Kafka wire protocol.
+type kafkaPublisher struct {
+	topicURL string
+	user     *url.Userinfo
+	// key names the Event field used as the record key: "name", "repo" or
+	// "none".
+	key string
+}
+
+// newKafkaPublisher returns a publisher to topic through the REST proxy at
+// rawURL, which may hold basic auth credentials.
+func newKafkaPublisher(rawURL, topic, key string) (*kafkaPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPURL(rawURL) || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", rawURL)
+	}
+	if topic == "" || strings.ContainsAny(topic, "/ ") {
+		return nil, fmt.Errorf("invalid topic %q", topic)
+	}
+	switch key {
+	case "name", "repo", "none":
+	default:
+		return nil, fmt.Errorf("invalid key %q: must be name, repo or none", key)
+	}
+
+	user := u.User
+	u.User = nil
+	return &kafkaPublisher{
+		topicURL: strings.TrimSuffix(u.String(), "/") + "/topics/" + url.PathEscape(topic),
+		user:     user,
+		key:      key,
+	}, nil
+}
+
+// kafkaRecord is a record in a REST Proxy produce request.
+type kafkaRecord struct {
+	Key   *string `json:"key,omitempty"`
+	Value Event   `json:"value"`
+}
+
+// kafkaProduceResponse is the part of a REST Proxy produce response we use.
+// Records can fail individually, even with status 200.
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Error *string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (p *kafkaPublisher) PublishEvent(ctx context.Context, e Event) error {
+	record := kafkaRecord{Value: e}
+	switch p.key {
+	case "name":
+		record.Key = &e.Name
+	case "repo":
+		record.Key = &e.Repo
+	}
+	body, err := json.Marshal(map[string][]kafkaRecord{"records": {record}})
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", p.topicURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json, application/json")
+	if p.user != nil {
+		pass, _ := p.user.Password()
+		req.SetBasicAuth(p.user.Username(), pass)
+	}
+
+	client := &http.Client{Timeout: eventTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling Kafka REST proxy: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading Kafka REST proxy response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka REST proxy returned status %d: %q", resp.StatusCode, string(respBody))
+	}
+	var pr kafkaProduceResponse
+	if err := json.Unmarshal(respBody, &pr); err != nil {
+		return fmt.Errorf("error decoding Kafka REST proxy response: %v", err)
+	}
+	for _, o := range pr.Offsets {
+		if o.Error != nil {
+			return fmt.Errorf("Kafka REST proxy failed to produce: %s", *o.Error)
+		}
+	}
+	return nil
+}
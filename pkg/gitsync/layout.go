@@ -0,0 +1,81 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checkLayout checks, after checkout, that the new revision has every path
+// in Options.RequirePaths and nothing matching Options.ForbidPaths, so that
+// e.g. the wrong branch is not published by mistake.
+func (s *Syncer) checkLayout(ctx context.Context, st *SyncState) error {
+	if len(s.opts.RequirePaths) == 0 && len(s.opts.ForbidPaths) == 0 {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "layout")
+
+	violations := []string{}
+	for _, p := range s.opts.RequirePaths {
+		matches, err := filepath.Glob(filepath.Join(st.Dir, filepath.FromSlash(p)))
+		if err != nil {
+			return fmt.Errorf("invalid required path %q: %v", p, err)
+		}
+		if len(matches) == 0 {
+			violations = append(violations, fmt.Sprintf("%s is missing", p))
+		}
+	}
+
+	if len(s.opts.ForbidPaths) > 0 {
+		err := filepath.Walk(st.Dir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(st.Dir, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if rel == "." {
+				return nil
+			}
+			if rel == ".git" {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			for _, pattern := range s.opts.ForbidPaths {
+				if matchPath(pattern, rel) {
+					violations = append(violations, fmt.Sprintf("%s matches forbidden %s", rel, pattern))
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error checking layout: %v", err)
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("revision %s has the wrong layout: %s", st.NewHash, strings.Join(violations, "; "))
+	}
+	s.logger(ctx).V(1).Infof("layout of %s is as required", st.NewHash)
+	return nil
+}
+
+// matchPath returns true if pattern matches rel, a slash-separated path.
+// A pattern without a slash matches the last element of rel, at any depth,
+// and one with a slash matches all of rel.
+func matchPath(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(rel))
+		return matched
+	}
+	matched, _ := path.Match(strings.TrimPrefix(pattern, "/"), rel)
+	return matched
+}
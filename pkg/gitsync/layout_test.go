@@ -0,0 +1,69 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		rel      string
+		expected bool
+	}{
+		{"*.tfstate", "prod.tfstate", true},
+		{"*.tfstate", "envs/prod/prod.tfstate", true},
+		{"*.tfstate", "prod.tfstate.md", false},
+		{"vendor/*", "vendor/lib", true},
+		{"vendor/*", "sub/vendor/lib", false},
+		{"/vendor", "vendor", true},
+		{"sub/*/x", "sub/a/x", true},
+	}
+
+	for _, testCase := range cases {
+		if got := matchPath(testCase.pattern, testCase.rel); got != testCase.expected {
+			t.Fatalf("%q %q: expected %v but %v returned", testCase.pattern, testCase.rel, testCase.expected, got)
+		}
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"kustomization.yaml":  "",
+		"base/deploy.yaml":    "",
+		"secret.tfstate":      "",
+		".git/objects/x.yaml": "",
+	})
+
+	cases := []struct {
+		require []string
+		forbid  []string
+		err     string
+	}{
+		{nil, nil, ""},
+		{[]string{"kustomization.yaml", "base/*.yaml"}, []string{"*.zip", ".git"}, ""},
+		{[]string{"Chart.yaml"}, nil, "Chart.yaml is missing"},
+		{nil, []string{"*.tfstate"}, "secret.tfstate matches forbidden *.tfstate"},
+		{nil, []string{"base"}, "base matches forbidden base"},
+		{[]string{"overlays/*"}, []string{"*.tfstate"}, "overlays/* is missing; secret.tfstate"},
+	}
+
+	for _, testCase := range cases {
+		s := &Syncer{opts: Options{RequirePaths: testCase.require, ForbidPaths: testCase.forbid}}
+		err := s.checkLayout(context.Background(), &SyncState{NewHash: "one", Dir: dir})
+		if testCase.err == "" && err != nil {
+			t.Fatalf("%+v: unexpected error: %v", testCase, err)
+		}
+		if testCase.err != "" && (err == nil || !strings.Contains(err.Error(), testCase.err)) {
+			t.Fatalf("%+v: expected error %q but got %v", testCase, testCase.err, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/thockin/glogr"
+	"github.com/thockin/logr"
+)
+
+// defaultLogger logs through glog, for Syncers without Options.Logger.
+var defaultLogger = newLogger()
+
+func newLogger() logr.Logger {
+	// glogr.New never fails.
+	g, _ := glogr.New()
+	return g
+}
+
+// logFieldsKey is the context key of the log fields added by withLogFields.
+type logFieldsKey struct{}
+
+// withLogFields returns a copy of ctx whose Syncer log lines carry the
+// given key and value pairs as well, replacing any of the same keys.
+func withLogFields(ctx context.Context, kv ...string) context.Context {
+	fields, _ := ctx.Value(logFieldsKey{}).([]string)
+	return context.WithValue(ctx, logFieldsKey{}, setLogFields(fields, kv...))
+}
+
+// setLogFields returns fields, a list of key and value pairs, with the
+// pairs in kv set.
+func setLogFields(fields []string, kv ...string) []string {
+	out := append([]string{}, fields...)
+	for i := 0; i+1 < len(kv); i += 2 {
+		found := false
+		for j := 0; j+1 < len(out); j += 2 {
+			if out[j] == kv[i] {
+				out[j+1] = kv[i+1]
+				found = true
+			}
+		}
+		if !found {
+			out = append(out, kv[i], kv[i+1])
+		}
+	}
+	return out
+}
+
+// logPrefix formats fields as `key=value key="quoted value": `.
+func logPrefix(fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	parts := []string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		parts = append(parts, fields[i]+"="+value)
+	}
+	return strings.Join(parts, " ") + ": "
+}
+
+// redactURL hides any password in a URL, for logging.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// logger returns Options.Logger, or the default, prefixing every line with
+// the repo's name and URL and the given key and value pairs.
+func (o Options) logger(kv ...string) logr.Logger {
+	base := o.Logger
+	if base == nil {
+		base = defaultLogger
+	}
+	fields := setLogFields([]string{"name", o.Name, "repo", redactURL(o.Repo)}, kv...)
+	return base.NewWithPrefix(logPrefix(fields))
+}
+
+// logger returns the Syncer's logger, adding any fields added to ctx by
+// withLogFields.
+func (s *Syncer) logger(ctx context.Context) logr.Logger {
+	fields, _ := ctx.Value(logFieldsKey{}).([]string)
+	return s.opts.logger(fields...)
+}
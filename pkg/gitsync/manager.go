@@ -0,0 +1,297 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manager runs a set of Syncers, each in its own loop, and lets repos be
+// added, removed and reconfigured while it runs.
+type Manager struct {
+	ctx context.Context
+
+	mu    sync.Mutex
+	repos map[string]*managedRepo
+	// err is the first error a repo stopped with.
+	err error
+	// changed is signalled whenever a repo stops.
+	changed chan struct{}
+	// slots, if not nil, holds a token for every repo syncing.
+	slots chan struct{}
+	// budget, if not nil, is the bandwidth budget shared by the repos.
+	budget *budget
+	// textfile, if set, is written with the metrics after every sync.
+	textfile string
+
+	// textfileMu serializes writing textfile.
+	textfileMu sync.Mutex
+	// statsd, if set, is sent the metrics of a repo after each of its syncs.
+	statsd *statsdClient
+}
+
+// managedRepo is a Syncer run by a Manager.
+type managedRepo struct {
+	opts    Options
+	syncer  *Syncer
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	// removed is set when the repo is stopped by Remove, rather than
+	// stopping by itself.
+	removed bool
+	// done is set once the loop has exited, with err.
+	done bool
+	err  error
+}
+
+// NewManager returns a Manager whose repos run until ctx is cancelled.
+func NewManager(ctx context.Context) *Manager {
+	return &Manager{
+		ctx:     ctx,
+		repos:   map[string]*managedRepo{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// SetMaxConcurrentSyncs limits how many repos sync at once to n, or lifts
+// the limit if n is 0, so that many repos don't all fetch together.  It
+// applies to repos added afterwards.
+func (m *Manager) SetMaxConcurrentSyncs(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slots = nil
+	if n > 0 {
+		m.slots = make(chan struct{}, n)
+	}
+}
+
+// SetBandwidthBudget limits the repos to fetching n bytes per second
+// between them, or lifts the limit if n is 0.  Fetches wait their turn,
+// the repos that have gone longest without a successful sync first.  It
+// applies to repos added afterwards.
+func (m *Manager) SetBandwidthBudget(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.budget = nil
+	if n > 0 {
+		m.budget = newBudget(n)
+	}
+}
+
+// SetMetricsTextfile makes the Manager write the metrics of every repo, as
+// for WriteMetrics, to path after every sync, e.g. for node-exporter's
+// textfile collector, or stop if path is "".
+func (m *Manager) SetMetricsTextfile(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.textfile = path
+}
+
+// writeTextfile writes the metrics to the textfile, if set.  The file is
+// replaced atomically, so that it is never read half-written.
+func (m *Manager) writeTextfile() error {
+	m.mu.Lock()
+	path := m.textfile
+	m.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+
+	m.textfileMu.Lock()
+	defer m.textfileMu.Unlock()
+	buf := &bytes.Buffer{}
+	if err := m.WriteMetrics(buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing metrics textfile: %v", err)
+	}
+	return nil
+}
+
+// Add validates opts, sets up a Syncer for them and starts syncing.  Repos
+// must have distinct names and roots, including repos that have stopped.
+func (m *Manager) Add(opts Options) error {
+	opts.setDefaults()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.repos[opts.Name]; found {
+		return fmt.Errorf("repo %q already exists", opts.Name)
+	}
+	for name, r := range m.repos {
+		if filepath.Clean(r.opts.Root) == filepath.Clean(opts.Root) {
+			return fmt.Errorf("root %s is already used by repo %q", opts.Root, name)
+		}
+	}
+
+	syncer, err := New(m.ctx, opts)
+	if err != nil {
+		return err
+	}
+	syncer.slots = m.slots
+	syncer.budget = m.budget
+	syncer.onSync = func(err error, d time.Duration) error {
+		statsdErr := m.sendStatsd(syncer, err, d)
+		if err := m.writeTextfile(); err != nil {
+			return err
+		}
+		return statsdErr
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	r := &managedRepo{
+		opts:    opts,
+		syncer:  syncer,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+	m.repos[opts.Name] = r
+	go m.run(ctx, r)
+	opts.logger().V(0).Infof("added repo")
+	return nil
+}
+
+// run syncs r until it stops.  Stopped repos are kept, so that their
+// status can be seen, until they are removed.
+func (m *Manager) run(ctx context.Context, r *managedRepo) {
+	err := r.syncer.Run(ctx)
+
+	m.mu.Lock()
+	r.done = true
+	r.err = err
+	if err != nil && !r.removed && m.err == nil {
+		m.err = fmt.Errorf("%s: %v", r.opts.Name, err)
+	}
+	m.mu.Unlock()
+
+	close(r.stopped)
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Remove stops syncing the named repo, leaving its files in place, and
+// returns its options.
+func (m *Manager) Remove(name string) (Options, error) {
+	m.mu.Lock()
+	r, found := m.repos[name]
+	if found {
+		r.removed = true
+		delete(m.repos, name)
+	}
+	m.mu.Unlock()
+	if !found {
+		return Options{}, fmt.Errorf("repo %q not found", name)
+	}
+
+	r.cancel()
+	<-r.stopped
+	r.opts.logger().V(0).Infof("removed repo")
+	return r.opts, nil
+}
+
+// Update replaces the options of the repo named by opts.Name, restarting
+// its loop.  The existing clone and published files are reused.  If the
+// new options can't be applied, the old ones are restored.
+func (m *Manager) Update(opts Options) error {
+	opts.setDefaults()
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	old, err := m.Remove(opts.Name)
+	if err != nil {
+		return err
+	}
+	if err := m.Add(opts); err != nil {
+		if restoreErr := m.Add(old); restoreErr != nil {
+			old.logger().Errorf("can't restore repo: %v", restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Names returns the names of the repos, sorted.
+func (m *Manager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := []string{}
+	for name := range m.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the Syncer of the named repo.
+func (m *Manager) Get(name string) (*Syncer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, found := m.repos[name]
+	if !found {
+		return nil, fmt.Errorf("repo %q not found", name)
+	}
+	return r.syncer, nil
+}
+
+// Status returns the status of the named repo.
+func (m *Manager) Status(name string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, found := m.repos[name]
+	if !found {
+		return Status{}, fmt.Errorf("repo %q not found", name)
+	}
+	return r.status(), nil
+}
+
+// Statuses returns the status of every repo, sorted by name.
+func (m *Manager) Statuses() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	all := []Status{}
+	for _, r := range m.repos {
+		all = append(all, r.status())
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// status returns the status of r.  The caller must hold the Manager's lock.
+func (r *managedRepo) status() Status {
+	st := r.syncer.Status()
+	st.Stopped = r.done
+	if r.err != nil {
+		st.LastError = r.err.Error()
+	}
+	return st
+}
+
+// Wait blocks until a repo fails, returning its error, or until no repos
+// are running, e.g. because they were all one-time syncs or ctx was
+// cancelled.
+func (m *Manager) Wait() error {
+	for {
+		m.mu.Lock()
+		err, running := m.err, 0
+		for _, r := range m.repos {
+			if !r.done {
+				running++
+			}
+		}
+		m.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if running == 0 {
+			return nil
+		}
+		<-m.changed
+	}
+}
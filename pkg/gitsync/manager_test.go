@@ -0,0 +1,210 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx)
+
+	opts := func(name string, wait float64) Options {
+		return Options{
+			Source: &fakeSource{hash: "one"},
+			Name:   name,
+			Repo:   "https://example.com/" + name,
+			Rev:    "HEAD",
+			Root:   filepath.Join(dir, name),
+			Wait:   wait,
+		}
+	}
+
+	if err := m.Add(opts("a", 60)); err != nil {
+		t.Fatalf("unexpected error adding a: %v", err)
+	}
+	if err := m.Add(opts("b", 60)); err != nil {
+		t.Fatalf("unexpected error adding b: %v", err)
+	}
+	if err := m.Add(opts("a", 60)); err == nil {
+		t.Fatalf("expected an error adding a twice")
+	}
+	dup := opts("c", 60)
+	dup.Root = filepath.Join(dir, "a")
+	if err := m.Add(dup); err == nil {
+		t.Fatalf("expected an error reusing a's root")
+	}
+	if names := m.Names(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("expected [a b] but %v returned", names)
+	}
+
+	if err := m.Update(opts("a", 30)); err != nil {
+		t.Fatalf("unexpected error updating a: %v", err)
+	}
+	if _, err := m.Remove("b"); err != nil {
+		t.Fatalf("unexpected error removing b: %v", err)
+	}
+	if _, err := m.Remove("b"); err == nil {
+		t.Fatalf("expected an error removing b twice")
+	}
+	if names := m.Names(); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Fatalf("expected [a] but %v returned", names)
+	}
+
+	cancel()
+	if err := m.Wait(); err != nil {
+		t.Fatalf("unexpected error waiting: %v", err)
+	}
+}
+
+// countingSource is a fakeSource that records how many Resolves overlap.
+type countingSource struct {
+	fakeSource
+	mu      *sync.Mutex
+	running *int
+	max     *int
+}
+
+func (c *countingSource) Resolve(ctx context.Context, rev string) (string, error) {
+	c.mu.Lock()
+	*c.running++
+	if *c.running > *c.max {
+		*c.max = *c.running
+	}
+	c.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	c.mu.Lock()
+	*c.running--
+	c.mu.Unlock()
+	return c.hash, nil
+}
+
+func TestManagerMaxConcurrentSyncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager(context.Background())
+	m.SetMaxConcurrentSyncs(2)
+	mu, running, max := &sync.Mutex{}, 0, 0
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		err := m.Add(Options{
+			Source:  &countingSource{fakeSource: fakeSource{hash: "one"}, mu: mu, running: &running, max: &max},
+			Name:    name,
+			Repo:    "https://example.com/" + name,
+			Rev:     "HEAD",
+			Root:    filepath.Join(dir, name),
+			OneTime: true,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error adding %s: %v", name, err)
+		}
+	}
+	if err := m.Wait(); err != nil {
+		t.Fatalf("unexpected error waiting: %v", err)
+	}
+	if max != 2 {
+		t.Fatalf("expected 2 concurrent syncs but %d ran", max)
+	}
+}
+
+func TestManagerMetricsTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx)
+	textfile := filepath.Join(dir, "git-sync.prom")
+	m.SetMetricsTextfile(textfile)
+	opts := Options{
+		Source:  &fakeSource{hash: "one"},
+		Name:    "a",
+		Repo:    "https://example.com/a",
+		Rev:     "HEAD",
+		Root:    filepath.Join(dir, "a"),
+		OneTime: true,
+	}
+	if err := m.Add(opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(textfile)
+	if err != nil {
+		t.Fatalf("expected the textfile to be written but got %v", err)
+	}
+	if expected := `git_sync_count_total{name="a",status="success"} 1`; !strings.Contains(string(data), expected) {
+		t.Fatalf("expected %q in the textfile but %q written", expected, string(data))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected only the root and textfile but found %v", files)
+	}
+}
+
+func TestManagerStatsd(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %v", err)
+	}
+	defer conn.Close()
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx)
+	if err := m.SetStatsd(conn.LocalAddr().String(), "git_sync", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := Options{
+		Source:  &fakeSource{hash: "one"},
+		Name:    "a.b",
+		Repo:    "https://example.com/a",
+		Rev:     "HEAD",
+		Root:    filepath.Join(dir, "a"),
+		OneTime: true,
+	}
+	if err := m.Add(opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("expected metrics but got %v", err)
+	}
+	if expected := "git_sync.a_b.count.success:1|c\n"; !strings.HasPrefix(string(buf[:n]), expected) {
+		t.Fatalf("expected %q first but %q sent", expected, string(buf[:n]))
+	}
+}
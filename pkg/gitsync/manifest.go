@@ -0,0 +1,139 @@
+package gitsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifest describes a published revision, down to the contents of its
+// files, so that consumers can check what they were given.
+type manifest struct {
+	Name string    `json:"name"`
+	Repo string    `json:"repo"`
+	Rev  string    `json:"rev"`
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+	// Files maps the path of every file to "sha256:" and the hash of its
+	// contents, or of every symlink to "symlink:" and its target.
+	Files map[string]string `json:"files"`
+}
+
+// writeManifest writes the manifest of the new revision to
+// Options.ManifestFile, signed with cosign if configured, just before the
+// revision is published.
+func (s *Syncer) writeManifest(ctx context.Context, st *SyncState) error {
+	if s.opts.ManifestFile == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "manifest")
+
+	m := manifest{
+		Name:  s.opts.Name,
+		Repo:  redactURL(s.opts.Repo),
+		Rev:   s.opts.Rev,
+		Hash:  st.NewHash,
+		Time:  time.Now().UTC(),
+		Files: map[string]string{},
+	}
+	err := filepath.Walk(st.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(st.Dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == ".git":
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			m.Files[rel] = "symlink:" + target
+		case fi.Mode().IsRegular():
+			sum, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			m.Files[rel] = "sha256:" + sum
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error building manifest: %v", err)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Sign a temporary copy, so that the manifest is replaced only once its
+	// signature is ready.
+	path := s.opts.ManifestFile
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+
+	renames := [][2]string{}
+	if s.opts.ManifestCosignKey != "" || s.opts.ManifestCosignKeyless {
+		args := []string{"sign-blob", "--yes", "--output-signature", tmp.Name() + ".sig"}
+		renames = append(renames, [2]string{tmp.Name() + ".sig", path + ".sig"})
+		if s.opts.ManifestCosignKeyless {
+			args = append(args, "--output-certificate", tmp.Name()+".pem")
+			renames = append(renames, [2]string{tmp.Name() + ".pem", path + ".pem"})
+		} else {
+			args = append(args, "--key", s.opts.ManifestCosignKey)
+		}
+		for _, r := range renames {
+			defer os.Remove(r[0])
+		}
+		if _, err := s.runCommand(ctx, "", "cosign", append(args, tmp.Name())...); err != nil {
+			return fmt.Errorf("error signing manifest: %v", err)
+		}
+	}
+	renames = append(renames, [2]string{tmp.Name(), path})
+	for _, r := range renames {
+		if err := os.Rename(r[0], r[1]); err != nil {
+			return fmt.Errorf("error writing manifest: %v", err)
+		}
+	}
+	s.logger(ctx).V(1).Infof("wrote manifest of %d files to %s", len(m.Files), path)
+	return nil
+}
+
+// fileSHA256 returns the hex SHA-256 of the contents of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,208 @@
+package gitsync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	syncSuccess = "success"
+	syncError   = "error"
+)
+
+// syncStats counts a Syncer's syncs with one outcome.
+type syncStats struct {
+	count    int
+	duration time.Duration
+}
+
+// repoMetrics is a snapshot of the metrics of one repo.
+type repoMetrics struct {
+	name   string
+	stats  map[string]syncStats
+	status Status
+	// overruns counts the syncs that took longer than --wait.
+	overruns int
+	// deliveryFailures counts the failures of the repo's webhook and reload
+	// URL, by kind, if it has them.
+	deliveryFailures map[string]int
+	// policyDenials counts the revisions denied by the repo's policy, if
+	// policy is set.
+	policy        bool
+	policyDenials int
+	// cacheUses counts how often the repo's cache was reused or cloned, if
+	// it has one.
+	cacheUses map[string]int
+}
+
+// metrics returns a snapshot of the metrics of s.
+func (s *Syncer) metrics() repoMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := map[string]syncStats{}
+	for outcome, st := range s.stats {
+		stats[outcome] = st
+	}
+	rm := repoMetrics{
+		name:             s.opts.Name,
+		stats:            stats,
+		overruns:         s.overruns,
+		deliveryFailures: map[string]int{},
+		policy:           s.opts.PolicyFile != "",
+		policyDenials:    s.policyDenials,
+	}
+	if s.opts.CacheDir != "" {
+		rm.cacheUses = map[string]int{cacheHit: s.cacheUses[cacheHit], cacheClone: s.cacheUses[cacheClone]}
+	}
+	for _, p := range s.publishers {
+		if wp, ok := p.(*webhookPublisher); ok {
+			rm.deliveryFailures[wp.kind] = wp.deliveryFailures()
+		}
+	}
+	return rm
+}
+
+// WriteMetrics writes the metrics of every repo to w in the Prometheus text
+// format, each labelled with the name of its repo.
+func (m *Manager) WriteMetrics(w io.Writer) error {
+	m.mu.Lock()
+	all := []repoMetrics{}
+	for _, r := range m.repos {
+		rm := r.syncer.metrics()
+		rm.status = r.status()
+		all = append(all, rm)
+	}
+	m.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	bw := bufio.NewWriter(w)
+	header := func(name, typ, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header("git_sync_count_total", "counter", "How many syncs completed, by repo and outcome.")
+	for _, rm := range all {
+		for _, outcome := range []string{syncSuccess, syncError} {
+			fmt.Fprintf(bw, "git_sync_count_total{name=%s,status=%q} %d\n", labelValue(rm.name), outcome, rm.stats[outcome].count)
+		}
+	}
+	header("git_sync_duration_seconds", "summary", "How long syncs took, by repo and outcome.")
+	for _, rm := range all {
+		for _, outcome := range []string{syncSuccess, syncError} {
+			st := rm.stats[outcome]
+			labels := fmt.Sprintf("{name=%s,status=%q}", labelValue(rm.name), outcome)
+			fmt.Fprintf(bw, "git_sync_duration_seconds_sum%s %g\n", labels, st.duration.Seconds())
+			fmt.Fprintf(bw, "git_sync_duration_seconds_count%s %d\n", labels, st.count)
+		}
+	}
+	header("git_sync_overruns_total", "counter", "How many syncs of the repo took longer than --wait, delaying the next.")
+	for _, rm := range all {
+		fmt.Fprintf(bw, "git_sync_overruns_total{name=%s} %d\n", labelValue(rm.name), rm.overruns)
+	}
+	header("git_sync_last_success_timestamp_seconds", "gauge", "When the repo last synced successfully, or 0.")
+	for _, rm := range all {
+		ts := 0.0
+		if !rm.status.LastSuccess.IsZero() {
+			ts = float64(rm.status.LastSuccess.UnixNano()) / float64(time.Second)
+		}
+		fmt.Fprintf(bw, "git_sync_last_success_timestamp_seconds{name=%s} %.3f\n", labelValue(rm.name), ts)
+	}
+	header("git_sync_consecutive_failures", "gauge", "How many syncs of the repo have failed in a row.")
+	for _, rm := range all {
+		fmt.Fprintf(bw, "git_sync_consecutive_failures{name=%s} %d\n", labelValue(rm.name), rm.status.ConsecutiveFail)
+	}
+	header("git_sync_paused", "gauge", "Whether syncing the repo is paused.")
+	for _, rm := range all {
+		fmt.Fprintf(bw, "git_sync_paused{name=%s} %d\n", labelValue(rm.name), boolMetric(rm.status.Paused))
+	}
+	header("git_sync_stale", "gauge", "Whether the repo's remote can't be reached, so its published revision may be out of date.")
+	for _, rm := range all {
+		fmt.Fprintf(bw, "git_sync_stale{name=%s} %d\n", labelValue(rm.name), boolMetric(rm.status.Stale))
+	}
+	header("git_sync_stopped", "gauge", "Whether the repo has stopped syncing for good.")
+	for _, rm := range all {
+		fmt.Fprintf(bw, "git_sync_stopped{name=%s} %d\n", labelValue(rm.name), boolMetric(rm.status.Stopped))
+	}
+	header("git_sync_step_seconds", "gauge", "How long each step of the repo's last sync took, with --profile-syncs.")
+	for _, rm := range all {
+		if p := rm.status.LastProfile; p != nil {
+			for _, step := range p.Steps {
+				fmt.Fprintf(bw, "git_sync_step_seconds{name=%s,step=%q} %g\n", labelValue(rm.name), step.Name, step.Seconds)
+			}
+		}
+	}
+	header("git_sync_fetched_bytes", "gauge", "Roughly how much the repo's last sync fetched, with --profile-syncs.")
+	for _, rm := range all {
+		if p := rm.status.LastProfile; p != nil {
+			fmt.Fprintf(bw, "git_sync_fetched_bytes{name=%s} %d\n", labelValue(rm.name), p.FetchedBytes)
+		}
+	}
+	header("git_sync_transfer_objects", "gauge", "How many objects the repo's clone or fetch in progress has received or resolved so far, by stage.")
+	for _, rm := range all {
+		if p := rm.status.Transfer; p != nil {
+			fmt.Fprintf(bw, "git_sync_transfer_objects{name=%s,stage=%q} %d\n", labelValue(rm.name), p.Stage, p.Objects)
+		}
+	}
+	header("git_sync_transfer_bytes", "gauge", "How many bytes the repo's clone or fetch in progress has received so far.")
+	for _, rm := range all {
+		if p := rm.status.Transfer; p != nil {
+			fmt.Fprintf(bw, "git_sync_transfer_bytes{name=%s} %d\n", labelValue(rm.name), p.Bytes)
+		}
+	}
+	header("git_sync_transfer_bytes_per_second", "gauge", "How fast the repo's clone or fetch in progress is receiving, per git.")
+	for _, rm := range all {
+		if p := rm.status.Transfer; p != nil {
+			fmt.Fprintf(bw, "git_sync_transfer_bytes_per_second{name=%s} %d\n", labelValue(rm.name), p.BytesPerSecond)
+		}
+	}
+	header("git_sync_transfer_last_progress_timestamp_seconds", "gauge", "When the repo's clone or fetch in progress last made progress.")
+	for _, rm := range all {
+		if p := rm.status.Transfer; p != nil {
+			fmt.Fprintf(bw, "git_sync_transfer_last_progress_timestamp_seconds{name=%s} %.3f\n", labelValue(rm.name), float64(p.Updated.UnixNano())/float64(time.Second))
+		}
+	}
+	header("git_sync_webhook_failures_total", "counter", "How many events couldn't be delivered to the repo's webhook, after retries.")
+	for _, rm := range all {
+		if n, found := rm.deliveryFailures["webhook"]; found {
+			fmt.Fprintf(bw, "git_sync_webhook_failures_total{name=%s} %d\n", labelValue(rm.name), n)
+		}
+	}
+	header("git_sync_reload_failures_total", "counter", "How many calls to the repo's reload URL failed.")
+	for _, rm := range all {
+		if n, found := rm.deliveryFailures["reload"]; found {
+			fmt.Fprintf(bw, "git_sync_reload_failures_total{name=%s} %d\n", labelValue(rm.name), n)
+		}
+	}
+	header("git_sync_policy_denials_total", "counter", "How many revisions the repo's policy denied.")
+	for _, rm := range all {
+		if rm.policy {
+			fmt.Fprintf(bw, "git_sync_policy_denials_total{name=%s} %d\n", labelValue(rm.name), rm.policyDenials)
+		}
+	}
+	header("git_sync_cache_total", "counter", "How often the repo's cache was reused (hit) or cloned again (clone).")
+	for _, rm := range all {
+		for _, result := range []string{cacheHit, cacheClone} {
+			if n, found := rm.cacheUses[result]; found {
+				fmt.Fprintf(bw, "git_sync_cache_total{name=%s,result=%q} %d\n", labelValue(rm.name), result, n)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// labelValue quotes v as a Prometheus label value.
+func labelValue(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLabelValue(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+	}{
+		{"repo", `"repo"`},
+		{`a"b`, `"a\"b"`},
+		{`a\b`, `"a\\b"`},
+		{"a\nb", `"a\nb"`},
+	}
+
+	for _, testCase := range cases {
+		if got := labelValue(testCase.value); got != testCase.expected {
+			t.Fatalf("expected %s but %s returned", testCase.expected, got)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Repo b's reload URL fails.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx)
+	for _, name := range []string{"b", "a"} {
+		opts := Options{
+			Source:  &fakeSource{hash: "one"},
+			Name:    name,
+			Repo:    "https://example.com/" + name,
+			Rev:     "HEAD",
+			Root:    filepath.Join(dir, name),
+			OneTime: true,
+		}
+		if name == "b" {
+			opts.ReloadURL = server.URL
+		}
+		if err := m.Add(opts); err != nil {
+			t.Fatalf("unexpected error adding %s: %v", name, err)
+		}
+	}
+	if err := m.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := m.WriteMetrics(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE git_sync_count_total counter\n",
+		`git_sync_count_total{name="a",status="success"} 1` + "\n",
+		`git_sync_count_total{name="a",status="error"} 0` + "\n",
+		`git_sync_count_total{name="b",status="success"} 1` + "\n",
+		`git_sync_duration_seconds_count{name="a",status="success"} 1` + "\n",
+		`git_sync_overruns_total{name="a"} 0` + "\n",
+		`git_sync_consecutive_failures{name="b"} 0` + "\n",
+		`git_sync_stopped{name="a"} 1` + "\n",
+		`git_sync_reload_failures_total{name="b"} 1` + "\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expected %q in metrics but %q returned", line, out)
+		}
+	}
+	if strings.Contains(out, `git_sync_reload_failures_total{name="a"}`) {
+		t.Fatalf("expected no reload metric for a but %q returned", out)
+	}
+	if strings.Index(out, `{name="a"`) > strings.Index(out, `{name="b"`) {
+		t.Fatalf("expected repos sorted by name but %q returned", out)
+	}
+}
@@ -0,0 +1,65 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// MissingRefFail fails the sync if the remote no longer has the branch
+	// or tag being synced.
+	MissingRefFail = "fail"
+	// MissingRefKeep keeps serving the published revision until the branch
+	// or tag is back.
+	MissingRefKeep = "keep"
+	// MissingRefFallback syncs Options.FallbackRef until the branch or tag
+	// is back.
+	MissingRefFallback = "fallback"
+)
+
+// missingRef returns the hash to sync, per Options.MissingRef, when the
+// remote no longer has the branch or tag being synced, which what names.
+func (g *gitSource) missingRef(ctx context.Context, what string) (string, error) {
+	s := g.s
+	switch s.opts.MissingRef {
+	case MissingRefKeep:
+		published, err := s.publishedHash()
+		if err != nil {
+			return "", err
+		}
+		if published == "" {
+			return "", fmt.Errorf("%s not found on the remote, and nothing is published yet", what)
+		}
+		s.logger(ctx).V(0).Infof("WARNING: %s not found on the remote, still serving %s", what, published)
+		return published, nil
+	case MissingRefFallback:
+		hash, err := g.resolveRef(ctx, s.opts.FallbackRef)
+		if err != nil {
+			return "", err
+		}
+		if hash == "" {
+			return "", fmt.Errorf("%s not found on the remote, nor --fallback-ref %s", what, s.opts.FallbackRef)
+		}
+		s.logger(ctx).V(0).Infof("WARNING: %s not found on the remote, syncing --fallback-ref %s instead", what, s.opts.FallbackRef)
+		g.fallback = s.opts.FallbackRef
+		return hash, nil
+	}
+	return "", fmt.Errorf("%s not found on the remote", what)
+}
+
+// resolveRef returns the commit that the remote's branch or tag ref
+// points to, or "" if it has neither.
+func (g *gitSource) resolveRef(ctx context.Context, ref string) (string, error) {
+	branch, tag := "refs/heads/"+ref, "refs/tags/"+ref
+	output, err := g.s.runCommand(ctx, g.s.opts.Root, "git", "ls-remote", "-q", "origin", branch, tag, tag+"^{}")
+	if err != nil {
+		return "", err
+	}
+	refs := parseLsRemote(output)
+	for _, r := range []string{branch, tag + "^{}", tag} {
+		if hash, found := refs[r]; found {
+			return hash, nil
+		}
+	}
+	return "", nil
+}
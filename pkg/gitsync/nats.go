@@ -0,0 +1,135 @@
+package gitsync
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// natsPublisher publishes Events to a NATS subject.  It speaks just enough
+// of the NATS client protocol to do so, on a new connection per Event,
+// since Events are rare.
+type natsPublisher struct {
+	url     *url.URL
+	subject string
+}
+
+// newNATSPublisher returns a publisher to subject on the server at rawURL,
+// which is nats://[user:password@]host[:port], nats://token@host[:port], or
+// tls://... to require TLS.
+func newNATSPublisher(rawURL, subject string) (*natsPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host given")
+	}
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid subject %q", subject)
+	}
+	return &natsPublisher{url: u, subject: subject}, nil
+}
+
+// natsInfo is the part of the server's INFO message we use.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// natsConnect is the client's CONNECT message.
+type natsConnect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+func (p *natsPublisher) PublishEvent(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	host := p.url.Host
+	if p.url.Port() == "" {
+		host = net.JoinHostPort(p.url.Hostname(), "4222")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("error connecting to NATS: %v", err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(eventTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	// The server speaks first, with INFO.
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading from NATS: %v", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting from NATS: %q", strings.TrimSpace(line))
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		return fmt.Errorf("error parsing NATS INFO: %v", err)
+	}
+	if info.TLSRequired || p.url.Scheme == "tls" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: p.url.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("error starting TLS with NATS: %v", err)
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+	}
+
+	connect := natsConnect{Name: "git-sync"}
+	if user := p.url.User; user != nil {
+		if pass, ok := user.Password(); ok {
+			connect.User, connect.Pass = user.Username(), pass
+		} else {
+			connect.AuthToken = user.Username()
+		}
+	}
+	connectJSON, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	// PING makes the server answer PONG once it has processed the PUB, or
+	// -ERR if it rejected anything.
+	_, err = fmt.Fprintf(conn, "CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", connectJSON, p.subject, len(payload), payload)
+	if err != nil {
+		return fmt.Errorf("error writing to NATS: %v", err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("error reading from NATS: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// NotifySlack posts Slack incoming webhook messages.
+	NotifySlack = "slack"
+	// NotifyTeams posts Microsoft Teams incoming webhook message cards.
+	NotifyTeams = "teams"
+)
+
+// notifier posts human-readable messages about syncs to a chat webhook.
+type notifier struct {
+	url    string
+	format string
+	// commitURL is prefixed to a hash to link to the commit, if set.
+	commitURL string
+}
+
+// newNotifier returns a notifier posting to o.NotifyURL, or nil if it isn't
+// set.
+func newNotifier(o Options) (*notifier, error) {
+	if o.NotifyURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(o.NotifyURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPURL(o.NotifyURL) || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", o.NotifyURL)
+	}
+	switch o.NotifyFormat {
+	case NotifySlack, NotifyTeams:
+	default:
+		return nil, fmt.Errorf("invalid format %q: must be %s or %s", o.NotifyFormat, NotifySlack, NotifyTeams)
+	}
+	n := &notifier{url: o.NotifyURL, format: o.NotifyFormat, commitURL: o.NotifyCommitURL}
+	if n.commitURL == "" {
+		n.commitURL = defaultCommitURL(o.Repo)
+	}
+	return n, nil
+}
+
+// defaultCommitURL guesses the web page of a commit of repo, less the hash,
+// for the hosts whose layout is known.
+func defaultCommitURL(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || !isHTTPURL(repo) {
+		return ""
+	}
+	u.User = nil
+	base := strings.TrimSuffix(strings.TrimSuffix(u.String(), "/"), ".git")
+	switch u.Hostname() {
+	case "github.com", "gitlab.com":
+		return base + "/commit/"
+	case "bitbucket.org":
+		return base + "/commits/"
+	}
+	return ""
+}
+
+// link formats a link to text for n's format, or just text without url.
+func (n *notifier) link(text, url string) string {
+	if url == "" {
+		return text
+	}
+	if n.format == NotifyTeams {
+		return fmt.Sprintf("[%s](%s)", text, url)
+	}
+	return fmt.Sprintf("<%s|%s>", url, text)
+}
+
+// post sends text, colored as a success or failure.
+func (n *notifier) post(ctx context.Context, text string, success bool) error {
+	var msg interface{}
+	switch n.format {
+	case NotifyTeams:
+		color := "D00000"
+		if success {
+			color = "2EB886"
+		}
+		msg = map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    strings.SplitN(text, "\n", 2)[0],
+			"themeColor": color,
+			"text":       text,
+		}
+	default:
+		msg = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error encoding notification: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: eventTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting notification: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("notification webhook returned status %d: %q", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// notifySuccess announces a newly published revision.  Failures are only
+// logged.
+func (s *Syncer) notifySuccess(ctx context.Context, st *SyncState) error {
+	if s.notifier == nil {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "notify")
+	commit := Commit{Hash: st.NewHash}
+	if d, ok := s.source.(CommitDescriber); ok {
+		c, err := d.DescribeCommit(ctx, st.NewHash)
+		if err != nil {
+			s.logger(ctx).Errorf("can't describe commit: %v", err)
+		} else {
+			commit = c
+		}
+	}
+
+	short := commit.Hash
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	link := ""
+	if s.notifier.commitURL != "" {
+		link = s.notifier.commitURL + commit.Hash
+	}
+	text := fmt.Sprintf("%s synced %s to %s", s.opts.Name, s.opts.Rev, s.notifier.link(short, link))
+	if commit.Subject != "" {
+		text += fmt.Sprintf(": %s (%s)", commit.Subject, commit.Committer)
+	}
+	if err := s.notifier.post(ctx, text, true); err != nil {
+		s.logger(ctx).Errorf("%v", err)
+	}
+	return nil
+}
+
+// notifyFailure announces that syncing failed with err.  Failures are only
+// logged.
+func (s *Syncer) notifyFailure(ctx context.Context, err error) {
+	if s.notifier == nil {
+		return
+	}
+	ctx = withLogFields(ctx, "phase", "notify")
+	text := fmt.Sprintf("%s failed to sync %s: %v", s.opts.Name, s.opts.Rev, err)
+	if err := s.notifier.post(ctx, text, false); err != nil {
+		s.logger(ctx).Errorf("%v", err)
+	}
+}
@@ -0,0 +1,724 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thockin/logr"
+)
+
+// Options contains the options available for a Syncer to sync
+type Options struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	GitHubToken string `json:"githubToken"`
+
+	GitLabDeployTokenUsername string `json:"gitlabDeployTokenUsername"`
+	GitLabDeployToken         string `json:"gitlabDeployToken"`
+	GitLabJobToken            string `json:"gitlabJobToken"`
+
+	BitbucketUsername    string `json:"bitbucketUsername"`
+	BitbucketAppPassword string `json:"bitbucketAppPassword"`
+	BitbucketAccessToken string `json:"bitbucketAccessToken"`
+
+	AzureDevOpsPAT string `json:"azureDevOpsPAT"`
+
+	OAuth2TokenURL     string `json:"oauth2TokenURL"`
+	OAuth2ClientID     string `json:"oauth2ClientID"`
+	OAuth2ClientSecret string `json:"oauth2ClientSecret"`
+	OAuth2Scope        string `json:"oauth2Scope"`
+
+	STSURL              string `json:"stsURL"`
+	STSAudience         string `json:"stsAudience"`
+	STSScope            string `json:"stsScope"`
+	STSSubjectTokenFile string `json:"stsSubjectTokenFile"`
+	STSTokenUsername    string `json:"stsTokenUsername"`
+
+	CodeCommit bool `json:"codeCommit"`
+
+	GCPMetadataToken  bool   `json:"gcpMetadataToken"`
+	GCPServiceAccount string `json:"gcpServiceAccount"`
+
+	AzureManagedIdentity         bool   `json:"azureManagedIdentity"`
+	AzureManagedIdentityClientID string `json:"azureManagedIdentityClientID"`
+
+	VaultAddr        string  `json:"vaultAddr"`
+	VaultRole        string  `json:"vaultRole"`
+	VaultAuthMount   string  `json:"vaultAuthMount"`
+	VaultSecretPath  string  `json:"vaultSecretPath"`
+	VaultSATokenFile string  `json:"vaultSATokenFile"`
+	VaultRefresh     float64 `json:"vaultRefresh"`
+
+	HTTPHeaders   []string `json:"httpHeaders"`
+	HTTPUserAgent string   `json:"httpUserAgent"`
+	// HTTPLowSpeedLimit and HTTPLowSpeedTime, if not 0, abort an HTTP
+	// transfer slower than HTTPLowSpeedLimit bytes per second for
+	// HTTPLowSpeedTime seconds, so that a stalled fetch fails, and is
+	// retried by the next sync, rather than hanging until Timeout.
+	HTTPLowSpeedLimit int `json:"httpLowSpeedLimit"`
+	HTTPLowSpeedTime  int `json:"httpLowSpeedTime"`
+
+	TLSClientCert string `json:"tlsClientCert"`
+	TLSClientKey  string `json:"tlsClientKey"`
+	CACertFile    string `json:"caCertFile"`
+
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify"`
+
+	Netrc bool `json:"netrc"`
+
+	CredentialHelper string `json:"credentialHelper"`
+
+	AskpassURL        string `json:"askpassURL"`
+	SSH               bool   `json:"useSSH"`
+	SSHKnownHosts     bool   `json:"sshKnownHosts"`
+	SSHKnownHostsFile string `json:"sshKnownHostsFile"`
+
+	SSHHostFingerprints []string `json:"sshHostFingerprints"`
+	SSHConfigFile       string   `json:"sshConfigFile"`
+	SSHProxyCommand     string   `json:"sshProxyCommand"`
+	SSHProxyJump        string   `json:"sshProxyJump"`
+	// SSHControlPersist, if not 0, keeps an SSH connection open for that
+	// many seconds after its last use, for the next git commands to reuse.
+	SSHControlPersist float64 `json:"sshControlPersist"`
+
+	SSHKeyFiles          []string `json:"sshKeyFiles"`
+	SSHAuthSock          string   `json:"sshAuthSock"`
+	SSHKeyPassphrase     string   `json:"sshKeyPassphrase"`
+	SSHKeyPassphraseFile string   `json:"sshKeyPassphraseFile"`
+
+	// Logger receives the Syncer's output, prefixed with fields identifying
+	// the repo and what is being done to it.  If nil, output goes to glog.
+	Logger logr.Logger `json:"-"`
+
+	// Source provides the revisions to publish.  If nil, Repo is synced
+	// per Backend.
+	Source SourceProvider `json:"-"`
+	// Backend is what syncs Repo: exec, the git CLI (the default), or
+	// gogit, go-git in process, which needs no git binary but supports only
+	// the basic options: a branch, a tag or full hash, Depth, and
+	// username/password or SSH key credentials.
+	Backend string `json:"backend"`
+
+	// RefResolver, if set, picks the revision to sync instead of Rev, e.g.
+	// the latest commit approved in a release system.  It returns a full
+	// hash, which the source must be able to fetch: for git, a commit
+	// reachable from Branch.
+	RefResolver func(ctx context.Context) (string, error) `json:"-"`
+
+	// Publishers are sent an Event every time a new revision is published.
+	Publishers []EventPublisher `json:"-"`
+
+	// Hooks run at each HookPhase of every sync, followed by the Hook of
+	// each of the HookPlugins, and then the HookCommands.
+	Hooks        []Hook   `json:"-"`
+	HookPlugins  []string `json:"hookPlugins"`
+	HookCommands []string `json:"hookCommands"`
+
+	// GitsignIdentity, if set, is the identity (e.g. an email address or a
+	// CI workflow URL) whose gitsign signature every commit must bear, in a
+	// certificate from GitsignIssuer, before it is checked out.
+	GitsignIdentity string `json:"gitsignIdentity"`
+	GitsignIssuer   string `json:"gitsignIssuer"`
+
+	// PolicyFile, if set, is a Rego policy (or a directory of them) that
+	// every new revision must pass before it is checked out: PolicyQuery is
+	// evaluated by opa, and a true result or a non-empty set of messages
+	// denies the revision.
+	PolicyFile  string `json:"policyFile"`
+	PolicyQuery string `json:"policyQuery"`
+
+	// RequirePaths must each match something in every new revision, and
+	// ForbidPaths nothing, or it is not published.  Both are globs, relative
+	// to the root of the repo; a ForbidPaths glob without a slash matches
+	// file names at any depth.
+	RequirePaths []string `json:"requirePaths"`
+	ForbidPaths  []string `json:"forbidPaths"`
+
+	// AllowEscapingSymlinks lets revisions with absolute symlinks, or
+	// symlinks that resolve outside of them, be published.
+	AllowEscapingSymlinks bool `json:"allowEscapingSymlinks"`
+
+	// MaxFileSize and MaxTotalSize, if not 0, limit the size in bytes of
+	// each file of a revision, and of all of them, or it is not published.
+	MaxFileSize  int64 `json:"maxFileSize"`
+	MaxTotalSize int64 `json:"maxTotalSize"`
+
+	// SecretScan, if set, scans the files changed in every new revision for
+	// secrets, with the rules in SecretScanRules or well-known ones, and
+	// per its value, warn or block, logs them or refuses the revision.
+	SecretScan      string `json:"secretScan"`
+	SecretScanRules string `json:"secretScanRules"`
+
+	// DecryptKeyFile, if set, holds the age identities with which to decrypt
+	// the files matching DecryptSOPSPattern, with sops, and
+	// DecryptAgePattern, with age, after every checkout.
+	DecryptKeyFile     string `json:"decryptKeyFile"`
+	DecryptSOPSPattern string `json:"decryptSOPSPattern"`
+	DecryptAgePattern  string `json:"decryptAgePattern"`
+
+	// RenderDest, if set, is where to publish every revision rendered per
+	// RenderMode, envsubst or template, leaving Dest as it is.  Only the
+	// files matching RenderPattern are rendered.
+	RenderDest    string `json:"renderDest"`
+	RenderMode    string `json:"renderMode"`
+	RenderPattern string `json:"renderPattern"`
+
+	// ManifestFile, if set, is where to write a manifest of every newly
+	// published revision, listing the hash of every file.  It is signed with
+	// cosign, into ManifestFile.sig, with the key file ManifestCosignKey, or
+	// with ManifestCosignKeyless through OIDC, which also writes the signing
+	// certificate to ManifestFile.pem.
+	ManifestFile          string `json:"manifestFile"`
+	ManifestCosignKey     string `json:"manifestCosignKey"`
+	ManifestCosignKeyless bool   `json:"manifestCosignKeyless"`
+
+	// AllowRepoHooks runs the repo's own .git-sync/post-checkout, if any,
+	// after every checkout, for up to RepoHookTimeout seconds.
+	AllowRepoHooks  bool    `json:"allowRepoHooks"`
+	RepoHookTimeout float64 `json:"repoHookTimeout"`
+
+	// PresyncCommand runs before every sync, which it skips if it fails.
+	PresyncCommand string `json:"presyncCommand"`
+	// ExechookCommand runs in the worktree of every newly published
+	// revision.  ExechookTimeout and ExechookBackoff are in seconds.
+	ExechookCommand string  `json:"exechookCommand"`
+	ExechookTimeout float64 `json:"exechookTimeout"`
+	ExechookRetries int     `json:"exechookRetries"`
+	ExechookBackoff float64 `json:"exechookBackoff"`
+
+	NATSURL     string `json:"natsURL"`
+	NATSSubject string `json:"natsSubject"`
+
+	KafkaRESTURL string `json:"kafkaRESTURL"`
+	KafkaTopic   string `json:"kafkaTopic"`
+	KafkaKey     string `json:"kafkaKey"`
+
+	SQSQueueURL string `json:"sqsQueueURL"`
+	SNSTopicARN string `json:"snsTopicARN"`
+	PubSubTopic string `json:"pubsubTopic"`
+
+	CloudEventsSink string `json:"cloudEventsSink"`
+
+	// GitHubStatusContext, if set, names a commit status set on every
+	// published revision with GitHubToken, through GitHubAPIURL (guessed
+	// from Repo if not set).
+	GitHubStatusContext     string `json:"githubStatusContext"`
+	GitHubStatusDescription string `json:"githubStatusDescription"`
+	GitHubStatusTargetURL   string `json:"githubStatusTargetURL"`
+	GitHubAPIURL            string `json:"githubAPIURL"`
+
+	// WebhookTimeout and WebhookBackoff are in seconds.  If
+	// WebhookSuccessCodes is empty, any 2xx status is a success.
+	WebhookURL          string  `json:"webhookURL"`
+	WebhookMethod       string  `json:"webhookMethod"`
+	WebhookTimeout      float64 `json:"webhookTimeout"`
+	WebhookTemplate     string  `json:"webhookTemplate"`
+	WebhookSuccessCodes []int   `json:"webhookSuccessCodes"`
+	WebhookRetries      int     `json:"webhookRetries"`
+	WebhookBackoff      float64 `json:"webhookBackoff"`
+
+	// ReloadURL, if set, is called with ReloadMethod, GET or POST, and no
+	// body, after every new revision.  ReloadTimeout is in seconds.  If
+	// ReloadSuccessCodes is empty, any 2xx status is a success.
+	ReloadURL          string  `json:"reloadURL"`
+	ReloadMethod       string  `json:"reloadMethod"`
+	ReloadTimeout      float64 `json:"reloadTimeout"`
+	ReloadSuccessCodes []int   `json:"reloadSuccessCodes"`
+
+	// NotifyURL is a Slack or Teams incoming webhook, per NotifyFormat, to
+	// which to post a message about every new revision and failure.
+	// NotifyCommitURL, followed by a hash, links to a commit.
+	NotifyURL       string `json:"notifyURL"`
+	NotifyFormat    string `json:"notifyFormat"`
+	NotifyCommitURL string `json:"notifyCommitURL"`
+
+	// Name identifies the repo to a Manager.  It defaults to Dest.
+	Name string `json:"name"`
+
+	Repo            string  `json:"repo"`
+	Branch          string  `json:"branch"`
+	Rev             string  `json:"rev"`
+	Depth           int     `json:"depth"`
+	Root            string  `json:"root"`
+	Dest            string  `json:"dest"`
+	Wait            float64 `json:"wait"`
+	Timeout         float64 `json:"timeout"`
+	OneTime         bool    `json:"oneTime"`
+	MaxSyncFailures int     `json:"maxSyncFailures"`
+	Chmod           int     `json:"chmod"`
+	PublishStrategy string  `json:"publishStrategy"`
+	StatusFile      string  `json:"statusFile"`
+	AuditLog        string  `json:"auditLog"`
+	AddUser         bool    `json:"addUser"`
+
+	// ShallowSince and ShallowExclude, like Depth, make the clone shallow,
+	// with the history since a date (e.g. "2023-01-01" or "6 months ago"),
+	// or not reachable from any of the given refs (e.g. the previous
+	// release tag).
+	ShallowSince   string   `json:"shallowSince"`
+	ShallowExclude []string `json:"shallowExclude"`
+	// AutoDepth clones only the latest commit, and deepens the clone only
+	// when a rev isn't in it, e.g. a hash further back.
+	AutoDepth bool `json:"autoDepth"`
+
+	// CloneCheck is what to do, on the first sync, if an existing clone in
+	// Root wasn't cloned from Repo, doesn't track Branch, or is shallow
+	// though no option asks for that: fail, warn (the default) or
+	// reconcile, which changes the clone to match.  CloneCheckEverySync
+	// checks before every sync instead.
+	CloneCheck          string `json:"cloneCheck"`
+	CloneCheckEverySync bool   `json:"cloneCheckEverySync"`
+
+	// MissingRef is what to do if the remote no longer has Branch, or the
+	// tag Rev, e.g. because it was deleted: fail the sync (the default),
+	// keep serving the published revision, or fall back to syncing
+	// FallbackRef, a branch or tag, until it is back.
+	MissingRef  string `json:"missingRef"`
+	FallbackRef string `json:"fallbackRef"`
+	// ImmutableTags syncs a tag Rev only once: if it is moved to another
+	// commit, e.g. a tag like "stable", the commit it was first synced at
+	// stays published.
+	ImmutableTags bool `json:"immutableTags"`
+
+	// PruneInterval, if not 0, is how often, in seconds, to delete the
+	// objects that no revision still synced needs, which otherwise pile up
+	// as a branch moves on.
+	PruneInterval float64 `json:"pruneInterval"`
+
+	// ProfileSyncs logs how long each step of every sync takes, and how
+	// much it fetched, and records it in Status.LastProfile and the
+	// metrics.
+	ProfileSyncs bool `json:"profileSyncs"`
+
+	// CanaryLink, if set, is the name of a symlink under Root to which
+	// every new revision is published first, for canaries.  It is
+	// published in Dest once it has been there for CanarySoak seconds, or
+	// approved with Syncer.Promote, which is the only way if CanarySoak is
+	// 0.
+	CanaryLink string  `json:"canaryLink"`
+	CanarySoak float64 `json:"canarySoak"`
+
+	// ServeStale keeps serving the published revision, if any, when the
+	// remote can't be reached, e.g. because of a network outage, without
+	// counting it towards MaxSyncFailures, until it can again.
+	ServeStale bool `json:"serveStale"`
+
+	// Submodules checks out the submodules of every revision, recursively,
+	// fetching SubmoduleJobs at once, or as many as git's
+	// submodule.fetchJobs (one by default) if 0.
+	Submodules    bool `json:"submodules"`
+	SubmoduleJobs int  `json:"submoduleJobs"`
+
+	// PartialClone clones without blobs, which git fetches as they are
+	// checked out.  With SparsePaths, patterns as in .gitignore, only the
+	// matching files are checked out, and so fetched.
+	PartialClone bool     `json:"partialClone"`
+	SparsePaths  []string `json:"sparsePaths"`
+
+	// CacheDir, if set, holds a bare clone of the repo, e.g. on a volume
+	// that outlives the pod, from which Root is cloned locally, so that a
+	// restart with an empty Root doesn't clone the repo over the network
+	// again.
+	CacheDir string `json:"cacheDir"`
+
+	// PackThreads and IndexThreads, if not 0, limit the threads git uses to
+	// pack and index objects, and to read the index of a checkout, which
+	// otherwise match the node's CPUs.  CompressionLevel, if set, is git's
+	// core.compression: the zlib level of the objects git writes, from 0
+	// (none) and 1 (fastest) to 9 (smallest), or -1 for zlib's default.
+	PackThreads      int  `json:"packThreads"`
+	IndexThreads     int  `json:"indexThreads"`
+	CompressionLevel *int `json:"compressionLevel"`
+
+	// CheckoutWorkers, if not 0, is how many files git writes at once
+	// when checking out a revision that changes at least
+	// CheckoutParallelThreshold of them (git's default if 0).  It needs git
+	// 2.32 or later.
+	CheckoutWorkers           int `json:"checkoutWorkers"`
+	CheckoutParallelThreshold int `json:"checkoutParallelThreshold"`
+
+	// HardlinkWorktrees creates the worktree of every revision with hard
+	// links to the files of the published revision that it doesn't change,
+	// rather than writing all of them again.  Hooks must not rewrite files
+	// in place, which would change the published revision too.
+	HardlinkWorktrees bool `json:"hardlinkWorktrees"`
+	// ReflinkWorktrees does the same with copy-on-write clones of the
+	// files, on filesystems that support them (e.g. XFS and btrfs), which
+	// hooks may rewrite.  Elsewhere, the files are written as usual.
+	ReflinkWorktrees bool `json:"reflinkWorktrees"`
+}
+
+// setDefaults fills in options that default to the value of others.
+func (o *Options) setDefaults() {
+	if o.Dest == "" {
+		parts := strings.Split(strings.Trim(o.Repo, "/"), "/")
+		o.Dest = parts[len(parts)-1]
+	}
+	if o.Name == "" {
+		o.Name = o.Dest
+	}
+	if o.PublishStrategy == "" {
+		o.PublishStrategy = PublishSymlink
+	}
+	if o.NATSSubject == "" {
+		o.NATSSubject = "git-sync"
+	}
+	if o.KafkaTopic == "" {
+		o.KafkaTopic = "git-sync"
+	}
+	if o.KafkaKey == "" {
+		o.KafkaKey = "repo"
+	}
+	if o.WebhookMethod == "" {
+		o.WebhookMethod = "POST"
+	}
+	if o.ReloadMethod == "" {
+		o.ReloadMethod = "POST"
+	}
+	if o.RenderMode == "" {
+		o.RenderMode = RenderEnvsubst
+	}
+	if o.RenderPattern == "" {
+		o.RenderPattern = "*"
+	}
+	if o.NotifyFormat == "" {
+		o.NotifyFormat = NotifySlack
+	}
+	if o.PolicyQuery == "" {
+		o.PolicyQuery = "data.gitsync.deny"
+	}
+	if o.CloneCheck == "" {
+		o.CloneCheck = CloneCheckWarn
+	}
+}
+
+// Override returns a copy of o with the fields set in the JSON object
+// data, which uses the same names as the json tags, replaced.
+func (o Options) Override(data []byte) (Options, error) {
+	// Round-trip through JSON, so that the copy doesn't share o's slices.
+	base, err := json.Marshal(o)
+	if err != nil {
+		return Options{}, err
+	}
+	var out Options
+	if err := json.Unmarshal(base, &out); err != nil {
+		return Options{}, err
+	}
+	out.Logger = o.Logger
+	out.Source = o.Source
+	out.RefResolver = o.RefResolver
+	out.Publishers = o.Publishers
+	out.Hooks = o.Hooks
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Options{}, err
+	}
+	return out, nil
+}
+
+// isBareName returns true if name is a file name of its own, rather than a
+// path or "." or "..", which would lead out of Options.Root.
+func isBareName(name string) bool {
+	return filepath.Base(name) == name && name != "." && name != ".."
+}
+
+// Validate returns an error if the options are incomplete or contradictory.
+// Errors name the equivalent git-sync flags.
+func (o Options) Validate() error {
+	if o.Repo == "" {
+		return fmt.Errorf("--repo must be provided")
+	}
+	o.setDefaults()
+	if !isBareName(o.Dest) {
+		return fmt.Errorf("--dest must be a bare name")
+	}
+	switch o.PublishStrategy {
+	case PublishSymlink, PublishRename, PublishCopy, PublishPointer, PublishInPlace:
+	default:
+		return fmt.Errorf("--publish-strategy must be symlink, rename, copy, pointer or in-place")
+	}
+	switch o.Backend {
+	case "", BackendExec:
+	case BackendGoGit:
+		if err := o.checkGoGit(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("--backend must be %s or %s", BackendExec, BackendGoGit)
+	}
+	if o.PublishStrategy == PublishInPlace && o.Source != nil {
+		return fmt.Errorf("--publish-strategy=%s only works with the git source", PublishInPlace)
+	}
+	if o.PublishStrategy == PublishInPlace {
+		// A revision is checked out in --dest itself, so it is already
+		// published by the time the checks could refuse it.
+		checks := []struct {
+			set  bool
+			flag string
+		}{
+			{len(o.RequirePaths) > 0, "--require-path"},
+			{len(o.ForbidPaths) > 0, "--forbid-path"},
+			{!o.AllowEscapingSymlinks, "checking symlinks (without --allow-escaping-symlinks)"},
+			{o.MaxFileSize > 0, "--max-file-size"},
+			{o.MaxTotalSize > 0, "--max-total-size"},
+			{o.SecretScan == SecretScanBlock, "--secret-scan=" + SecretScanBlock},
+		}
+		for _, c := range checks {
+			if c.set {
+				return fmt.Errorf("--publish-strategy=%s can't be used with %s, since it can't refuse a revision before publishing it", PublishInPlace, c.flag)
+			}
+		}
+	}
+
+	if err := resolveTokenAuth(&o); err != nil {
+		return err
+	}
+
+	if o.OAuth2TokenURL != "" {
+		if o.OAuth2ClientID == "" || o.OAuth2ClientSecret == "" {
+			return fmt.Errorf("--oauth2-token-url requires --oauth2-client-id and --oauth2-client-secret")
+		}
+	}
+
+	bearerFlags := []string{}
+	if o.OAuth2TokenURL != "" {
+		bearerFlags = append(bearerFlags, "--oauth2-token-url")
+	}
+	if o.STSURL != "" {
+		bearerFlags = append(bearerFlags, "--sts-url")
+	}
+	if o.GCPMetadataToken {
+		bearerFlags = append(bearerFlags, "--gcp-metadata-token")
+	}
+	if o.AzureManagedIdentity {
+		bearerFlags = append(bearerFlags, "--azure-managed-identity")
+	}
+	if len(bearerFlags) > 1 {
+		return fmt.Errorf("%s are mutually exclusive", strings.Join(bearerFlags, " and "))
+	}
+	if len(bearerFlags) > 0 && !isHTTPURL(o.Repo) {
+		return fmt.Errorf("%s requires an HTTP(S) --repo", bearerFlags[0])
+	}
+
+	if o.CodeCommit {
+		if !isHTTPURL(o.Repo) {
+			return fmt.Errorf("--codecommit requires an HTTP(S) --repo")
+		}
+		if _, _, err := codeCommitCredentials(awsCredentials{}, o.Repo, time.Now()); err != nil {
+			return fmt.Errorf("invalid --repo for --codecommit: %v", err)
+		}
+	}
+
+	if o.VaultAddr != "" && (o.VaultRole == "" || o.VaultSecretPath == "") {
+		return fmt.Errorf("--vault-addr requires --vault-role and --vault-secret-path")
+	}
+
+	if o.CredentialHelper != "" && (o.Username != "" || o.Password != "") {
+		return fmt.Errorf("--credential-helper can't be combined with --username, --password or token flags")
+	}
+
+	if (o.TLSClientCert == "") != (o.TLSClientKey == "") {
+		return fmt.Errorf("--tls-client-cert and --tls-client-key must be given together")
+	}
+	if o.InsecureSkipTLSVerify && o.CACertFile != "" {
+		return fmt.Errorf("--insecure-skip-tls-verify and --ca-cert-file are mutually exclusive")
+	}
+
+	if o.CanaryLink != "" {
+		if !isBareName(o.CanaryLink) || o.CanaryLink == o.Dest {
+			return fmt.Errorf("--canary-link must be a bare name other than --dest")
+		}
+		if o.PublishStrategy != "" && o.PublishStrategy != PublishSymlink {
+			return fmt.Errorf("--canary-link only works with --publish-strategy=%s", PublishSymlink)
+		}
+		if o.OneTime || o.RenderDest != "" {
+			return fmt.Errorf("--canary-link can't be used with --one-time or --render-dest")
+		}
+	}
+	if o.CanarySoak < 0 {
+		return fmt.Errorf("--canary-soak can't be negative")
+	}
+	if o.RenderDest != "" {
+		if !isBareName(o.RenderDest) || o.RenderDest == o.Dest {
+			return fmt.Errorf("--render-dest must be a bare name other than --dest")
+		}
+		switch o.RenderMode {
+		case RenderEnvsubst, RenderTemplate:
+		default:
+			return fmt.Errorf("--render-mode must be %s or %s", RenderEnvsubst, RenderTemplate)
+		}
+		if _, err := filepath.Match(o.RenderPattern, ""); err != nil {
+			return fmt.Errorf("invalid --render-pattern %q: %v", o.RenderPattern, err)
+		}
+	}
+
+	for _, pattern := range []string{o.DecryptSOPSPattern, o.DecryptAgePattern} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid --decrypt-sops-pattern or --decrypt-age-pattern %q: %v", pattern, err)
+		}
+	}
+
+	if o.GitsignIdentity != "" {
+		if o.GitsignIssuer == "" {
+			return fmt.Errorf("--verify-gitsign-identity requires --verify-gitsign-issuer")
+		}
+		if o.Source != nil {
+			return fmt.Errorf("--verify-gitsign-identity only works with git repos")
+		}
+	}
+
+	if o.ManifestCosignKey != "" && o.ManifestCosignKeyless {
+		return fmt.Errorf("--manifest-cosign-key and --manifest-cosign-keyless are mutually exclusive")
+	}
+	if (o.ManifestCosignKey != "" || o.ManifestCosignKeyless) && o.ManifestFile == "" {
+		return fmt.Errorf("--manifest-cosign-key and --manifest-cosign-keyless require --manifest-file")
+	}
+
+	for _, pattern := range append(append([]string{}, o.RequirePaths...), o.ForbidPaths...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid --require-path or --forbid-path %q: %v", pattern, err)
+		}
+	}
+
+	if o.CacheDir != "" && filepath.Clean(o.CacheDir) == filepath.Clean(o.Root) {
+		return fmt.Errorf("--cache-dir must be other than --root")
+	}
+	if o.SubmoduleJobs < 0 {
+		return fmt.Errorf("--submodule-jobs can't be negative")
+	}
+	if o.PackThreads < 0 || o.IndexThreads < 0 {
+		return fmt.Errorf("--pack-threads and --index-threads can't be negative")
+	}
+	if o.HardlinkWorktrees {
+		if o.Source != nil || o.PublishStrategy == PublishInPlace {
+			return fmt.Errorf("--hardlink-worktrees only works with the git source, and with worktrees")
+		}
+		if len(o.SparsePaths) > 0 {
+			return fmt.Errorf("--hardlink-worktrees and --sparse-path are mutually exclusive")
+		}
+	}
+	if o.ReflinkWorktrees {
+		if o.HardlinkWorktrees {
+			return fmt.Errorf("--reflink-worktrees and --hardlink-worktrees are mutually exclusive")
+		}
+		if o.Source != nil || o.PublishStrategy == PublishInPlace {
+			return fmt.Errorf("--reflink-worktrees only works with the git source, and with worktrees")
+		}
+		if len(o.SparsePaths) > 0 {
+			return fmt.Errorf("--reflink-worktrees and --sparse-path are mutually exclusive")
+		}
+	}
+	if o.HTTPLowSpeedLimit < 0 || o.HTTPLowSpeedTime < 0 {
+		return fmt.Errorf("--http-low-speed-limit and --http-low-speed-time can't be negative")
+	}
+	if (o.HTTPLowSpeedLimit == 0) != (o.HTTPLowSpeedTime == 0) {
+		return fmt.Errorf("--http-low-speed-limit and --http-low-speed-time must be set together")
+	}
+	if o.PruneInterval < 0 {
+		return fmt.Errorf("--prune-interval can't be negative")
+	}
+	if o.CheckoutWorkers < 0 || o.CheckoutParallelThreshold < 0 {
+		return fmt.Errorf("--checkout-workers and --checkout-parallel-threshold can't be negative")
+	}
+	if o.CompressionLevel != nil && (*o.CompressionLevel < -1 || *o.CompressionLevel > 9) {
+		return fmt.Errorf("--compression-level must be between -1 and 9")
+	}
+	if o.MaxFileSize < 0 || o.MaxTotalSize < 0 {
+		return fmt.Errorf("--max-file-size and --max-total-size can't be negative")
+	}
+	switch o.SecretScan {
+	case "", SecretScanWarn, SecretScanBlock:
+	default:
+		return fmt.Errorf("--secret-scan must be %s or %s", SecretScanWarn, SecretScanBlock)
+	}
+	if o.SecretScanRules != "" {
+		if o.SecretScan == "" {
+			return fmt.Errorf("--secret-scan-rules requires --secret-scan")
+		}
+		if _, err := loadSecretRules(o.SecretScanRules); err != nil {
+			return fmt.Errorf("invalid --secret-scan-rules: %v", err)
+		}
+	}
+
+	if o.RepoHookTimeout < 0 {
+		return fmt.Errorf("--repo-hook-timeout can't be negative")
+	}
+	if o.ExechookTimeout < 0 || o.ExechookRetries < 0 || o.ExechookBackoff < 0 {
+		return fmt.Errorf("--exechook-timeout, --exechook-retries and --exechook-backoff can't be negative")
+	}
+
+	if _, err := newEventPublishers(o); err != nil {
+		return err
+	}
+	if _, err := newNotifier(o); err != nil {
+		return fmt.Errorf("invalid --notify-url or --notify-format: %v", err)
+	}
+
+	if o.SSH && (o.Username != "" || o.Password != "" || o.CredentialHelper != "" || o.Netrc) {
+		return fmt.Errorf("--ssh can't be combined with --username, --password, token flags, --credential-helper or --netrc, which are for HTTPS")
+	}
+	if o.ShallowSince != "" || len(o.ShallowExclude) > 0 {
+		if o.Depth > 0 {
+			return fmt.Errorf("--depth can't be combined with --shallow-since or --shallow-exclude")
+		}
+		if o.Rev != "HEAD" && hashRE.MatchString(o.Rev) && len(o.Rev) >= 7 {
+			return fmt.Errorf("--rev %s looks like a commit hash, which --shallow-since or --shallow-exclude may cut off: use a tag", o.Rev)
+		}
+	}
+	if o.AutoDepth {
+		if o.Depth > 0 || o.ShallowSince != "" || len(o.ShallowExclude) > 0 {
+			return fmt.Errorf("--auto-depth can't be combined with --depth, --shallow-since or --shallow-exclude")
+		}
+		if o.Source != nil {
+			return fmt.Errorf("--auto-depth only works with the git source")
+		}
+	}
+	for _, ref := range o.ShallowExclude {
+		if ref == "" || strings.HasPrefix(ref, "-") {
+			return fmt.Errorf("invalid --shallow-exclude %q", ref)
+		}
+	}
+	switch o.CloneCheck {
+	case "", CloneCheckFail, CloneCheckWarn, CloneCheckReconcile:
+	default:
+		return fmt.Errorf("--clone-check must be %s, %s or %s", CloneCheckFail, CloneCheckWarn, CloneCheckReconcile)
+	}
+	if o.CloneCheckEverySync && o.Source != nil {
+		return fmt.Errorf("--clone-check-every-sync only works with the git source")
+	}
+	switch o.MissingRef {
+	case "", MissingRefFail, MissingRefKeep:
+	case MissingRefFallback:
+		if o.FallbackRef == "" {
+			return fmt.Errorf("--missing-ref=%s requires --fallback-ref", MissingRefFallback)
+		}
+	default:
+		return fmt.Errorf("--missing-ref must be %s, %s or %s", MissingRefFail, MissingRefKeep, MissingRefFallback)
+	}
+	if o.FallbackRef != "" {
+		if o.MissingRef != MissingRefFallback {
+			return fmt.Errorf("--fallback-ref requires --missing-ref=%s", MissingRefFallback)
+		}
+		if strings.HasPrefix(o.FallbackRef, "-") {
+			return fmt.Errorf("invalid --fallback-ref %q", o.FallbackRef)
+		}
+	}
+	if o.MissingRef != "" && o.MissingRef != MissingRefFail && o.Source != nil {
+		return fmt.Errorf("--missing-ref only works with the git source")
+	}
+	if o.ImmutableTags && o.Source != nil {
+		return fmt.Errorf("--immutable-tags only works with the git source")
+	}
+
+	if o.SSHControlPersist < 0 {
+		return fmt.Errorf("--ssh-control-persist can't be negative")
+	}
+	if o.SSHProxyCommand != "" && o.SSHProxyJump != "" {
+		return fmt.Errorf("--ssh-proxy-command and --ssh-proxy-jump are mutually exclusive")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package gitsync
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	zlibDefault, tooHigh := -1, 10
+	cases := []struct {
+		opts Options
+		err  bool
+	}{
+		{Options{Repo: "https://github.com/a/b"}, false},
+		{Options{}, true},
+		{Options{Repo: "https://github.com/a/b", Dest: "x/y"}, true},
+		{Options{Repo: "https://github.com/a/b", Dest: ".."}, true},
+		{Options{Repo: "https://github.com/a/b", Dest: "."}, true},
+		{Options{Repo: "https://github.com/a/b", Dest: "x/"}, true},
+		{Options{Repo: "https://github.com/a/b", Dest: "..x"}, false},
+		{Options{Repo: "https://github.com/a/b", CanaryLink: ".."}, true},
+		{Options{Repo: "https://github.com/a/b", RenderDest: "..", RenderMode: RenderTemplate}, true},
+		{Options{Repo: "https://github.com/a/b", GitHubToken: "tok", Username: "u"}, true},
+		{Options{Repo: "https://github.com/a/b", GitHubToken: "tok", CredentialHelper: "/bin/helper"}, true},
+		{Options{Repo: "https://github.com/a/b", OAuth2TokenURL: "https://idp/token"}, true},
+		{Options{Repo: "https://github.com/a/b", STSURL: "https://sts", GCPMetadataToken: true}, true},
+		{Options{Repo: "git@github.com:a/b", GCPMetadataToken: true}, true},
+		{Options{Repo: "https://github.com/a/b", TLSClientCert: "cert.pem"}, true},
+		{Options{Repo: "https://github.com/a/b", InsecureSkipTLSVerify: true, CACertFile: "ca.pem"}, true},
+		{Options{Repo: "git@github.com:a/b", SSH: true, SSHProxyCommand: "nc %h %p", SSHProxyJump: "bastion"}, true},
+		{Options{Repo: "git@github.com:a/b", SSH: true, SSHControlPersist: -1}, true},
+		{Options{Repo: "git@github.com:a/b", SSH: true, Password: "p"}, true},
+		{Options{Repo: "git@github.com:a/b", SSH: true, GitHubToken: "tok"}, true},
+		{Options{Repo: "https://github.com/a/b", Depth: 1, Rev: "1077e1d717a2"}, false},
+		{Options{Repo: "https://github.com/a/b", Depth: 1, Rev: "v1.0"}, false},
+		{Options{Repo: "https://github.com/a/b", Rev: "1077e1d717a2"}, false},
+		{Options{Repo: "https://github.com/a/b", ShallowSince: "6 months ago", ShallowExclude: []string{"v1.0"}}, false},
+		{Options{Repo: "https://github.com/a/b", Depth: 1, ShallowSince: "2023-01-01"}, true},
+		{Options{Repo: "https://github.com/a/b", ShallowExclude: []string{"v1.0"}, Rev: "1077e1d717a2"}, true},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishPointer}, false},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: "copy-on-write"}, true},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishInPlace, AllowEscapingSymlinks: true}, false},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishInPlace}, true},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishInPlace, AllowEscapingSymlinks: true, ForbidPaths: []string{"*.tfstate"}}, true},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishInPlace, AllowEscapingSymlinks: true, MaxTotalSize: 1}, true},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishInPlace, AllowEscapingSymlinks: true, SecretScan: SecretScanBlock}, true},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishInPlace, AllowEscapingSymlinks: true, SecretScan: SecretScanWarn}, false},
+		{Options{Repo: "https://github.com/a/b", PublishStrategy: PublishInPlace, Source: &fakeSource{}}, true},
+		{Options{Repo: "https://github.com/a/b", ExechookCommand: "/bin/true", ExechookRetries: 3}, false},
+		{Options{Repo: "https://github.com/a/b", ExechookCommand: "/bin/true", ExechookBackoff: -1}, true},
+		{Options{Repo: "https://github.com/a/b", NotifyURL: "https://hooks.slack.com/services/x", NotifyFormat: NotifyTeams}, false},
+		{Options{Repo: "https://github.com/a/b", NotifyURL: "https://hooks.slack.com/services/x", NotifyFormat: "irc"}, true},
+		{Options{Repo: "https://github.com/a/b", DecryptKeyFile: "/k", DecryptSOPSPattern: "*.sops.*"}, false},
+		{Options{Repo: "https://github.com/a/b", DecryptKeyFile: "/k", DecryptAgePattern: "[.age"}, true},
+		{Options{Repo: "https://github.com/a/b", RenderDest: "rendered", RenderMode: RenderTemplate}, false},
+		{Options{Repo: "https://github.com/a/b", RenderDest: "b"}, true},
+		{Options{Repo: "https://github.com/a/b", RenderDest: "rendered", RenderMode: "jinja"}, true},
+		{Options{Repo: "https://github.com/a/b", ManifestFile: "/git/manifest.json", ManifestCosignKeyless: true}, false},
+		{Options{Repo: "https://github.com/a/b", ManifestCosignKey: "/keys/cosign.key"}, true},
+		{Options{Repo: "https://github.com/a/b", ManifestFile: "/git/manifest.json", ManifestCosignKey: "/keys/cosign.key", ManifestCosignKeyless: true}, true},
+		{Options{Repo: "https://github.com/a/b", GitsignIdentity: "jane@example.com", GitsignIssuer: "https://github.com/login/oauth"}, false},
+		{Options{Repo: "https://github.com/a/b", GitsignIdentity: "jane@example.com"}, true},
+		{Options{Repo: "https://github.com/a/b", RequirePaths: []string{"kustomization.yaml"}, ForbidPaths: []string{"*.tfstate"}}, false},
+		{Options{Repo: "https://github.com/a/b", ForbidPaths: []string{"[x"}}, true},
+		{Options{Repo: "https://github.com/a/b", PackThreads: 2, IndexThreads: 1, CompressionLevel: &zlibDefault}, false},
+		{Options{Repo: "https://github.com/a/b", PackThreads: -1}, true},
+		{Options{Repo: "https://github.com/a/b", CompressionLevel: &tooHigh}, true},
+		{Options{Repo: "https://github.com/a/b", CheckoutWorkers: 8, CheckoutParallelThreshold: 1000}, false},
+		{Options{Repo: "https://github.com/a/b", CheckoutWorkers: -1}, true},
+		{Options{Repo: "https://github.com/a/b", HTTPLowSpeedLimit: 1000, HTTPLowSpeedTime: 30}, false},
+		{Options{Repo: "https://github.com/a/b", HTTPLowSpeedLimit: 1000}, true},
+		{Options{Repo: "https://github.com/a/b", HTTPLowSpeedLimit: -1, HTTPLowSpeedTime: 30}, true},
+		{Options{Repo: "https://github.com/a/b", PruneInterval: -1}, true},
+		{Options{Repo: "https://github.com/a/b", HardlinkWorktrees: true}, false},
+		{Options{Repo: "https://github.com/a/b", HardlinkWorktrees: true, PublishStrategy: PublishInPlace}, true},
+		{Options{Repo: "https://github.com/a/b", HardlinkWorktrees: true, SparsePaths: []string{"/a/"}}, true},
+		{Options{Repo: "https://github.com/a/b", ReflinkWorktrees: true}, false},
+		{Options{Repo: "https://github.com/a/b", CanaryLink: "next", CanarySoak: 600}, false},
+		{Options{Repo: "https://github.com/a/b", AutoDepth: true, Rev: "1077e1d717a21ec8bc4bdc1e4ec6e0cd4c0d1a8e"}, false},
+		{Options{Repo: "https://github.com/a/b", AutoDepth: true, Depth: 1}, true},
+		{Options{Repo: "https://github.com/a/b", CloneCheck: CloneCheckReconcile, CloneCheckEverySync: true}, false},
+		{Options{Repo: "https://github.com/a/b", CloneCheck: "ignore"}, true},
+		{Options{Repo: "https://github.com/a/b", MissingRef: MissingRefKeep}, false},
+		{Options{Repo: "https://github.com/a/b", MissingRef: MissingRefFallback, FallbackRef: "main"}, false},
+		{Options{Repo: "https://github.com/a/b", MissingRef: MissingRefFallback}, true},
+		{Options{Repo: "https://github.com/a/b", FallbackRef: "main"}, true},
+		{Options{Repo: "https://github.com/a/b", MissingRef: "ignore"}, true},
+		{Options{Repo: "https://github.com/a/b", Rev: "stable", ImmutableTags: true}, false},
+		{Options{Repo: "https://github.com/a/b", ImmutableTags: true, Source: &fakeSource{}}, true},
+		{Options{Repo: "https://github.com/a/b", CloneCheckEverySync: true, Source: &fakeSource{}}, true},
+		{Options{Repo: "https://github.com/a/b", CanaryLink: "next", PublishStrategy: PublishCopy}, true},
+		{Options{Repo: "https://github.com/a/b", CanaryLink: "a/next"}, true},
+		{Options{Repo: "https://github.com/a/b", ReflinkWorktrees: true, HardlinkWorktrees: true}, true},
+		{Options{Repo: "https://github.com/a/b", SecretScan: SecretScanBlock}, false},
+		{Options{Repo: "https://github.com/a/b", SecretScan: "fail"}, true},
+		{Options{Repo: "https://github.com/a/b", SecretScanRules: "/rules.txt"}, true},
+	}
+
+	for _, testCase := range cases {
+		err := testCase.opts.Validate()
+		if (err != nil) != testCase.err {
+			t.Fatalf("%+v: expected error %v but got %v", testCase.opts, testCase.err, err)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Phase is a step of a sync.  A sync runs them in order, stopping after
+// PhaseResolve if the published revision is up to date.
+type Phase string
+
+const (
+	// PhaseResolve finds the revision Options.Rev refers to upstream.
+	PhaseResolve Phase = "resolve"
+	// PhaseFetch downloads the revision, if the source needs to.
+	PhaseFetch Phase = "fetch"
+	// PhaseCheckout materializes the revision in SyncState.Dir.
+	PhaseCheckout Phase = "checkout"
+	// PhaseValidate does nothing by itself; functions registered for it
+	// can reject the revision before it is published.
+	PhaseValidate Phase = "validate"
+	// PhasePublish publishes the revision in Options.Dest.
+	PhasePublish Phase = "publish"
+	// PhaseCleanup removes the previously published revision.
+	PhaseCleanup Phase = "cleanup"
+)
+
+// SyncState is what a sync knows so far.
+type SyncState struct {
+	// OldHash is the published revision before the sync, or "".
+	OldHash string
+	// NewHash is the revision being synced, once resolved.
+	NewHash string
+	// Dir is the directory holding NewHash, once checked out.  Publishing
+	// may move it.
+	Dir string
+
+	// published is set once NewHash is published, after which it is no
+	// longer discarded on failure.
+	published bool
+	// oldDir is the directory of OldHash, to be removed by PhaseCleanup.
+	oldDir string
+	// renderedDir holds NewHash rendered for Options.RenderDest, and
+	// oldRenderedDir OldHash, to be removed by PhaseCleanup.
+	renderedDir    string
+	oldRenderedDir string
+	// decrypted holds the paths of the files decryption wrote, relative to
+	// Dir, and recheck is set while the checks run again on the new
+	// revision once it is decrypted, hooked and rendered.
+	decrypted map[string]bool
+	recheck   bool
+
+	// profile, with Options.ProfileSyncs, is where the time went so far,
+	// and objectsSize the size of the object store when the sync started.
+	profile     *SyncProfile
+	objectsSize int64
+}
+
+// PhaseFunc runs before or after a phase.  An error fails the sync, and
+// discards the new revision unless it has already been published.
+type PhaseFunc func(ctx context.Context, st *SyncState) error
+
+// phaseFuncs holds the functions registered for one phase.
+type phaseFuncs struct {
+	before, after []PhaseFunc
+}
+
+// Before registers f to run before every run of phase p, after the hooks
+// and any functions registered before it.
+func (s *Syncer) Before(p Phase, f PhaseFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phaseFuncs == nil {
+		s.phaseFuncs = map[Phase]*phaseFuncs{}
+	}
+	if s.phaseFuncs[p] == nil {
+		s.phaseFuncs[p] = &phaseFuncs{}
+	}
+	s.phaseFuncs[p].before = append(s.phaseFuncs[p].before, f)
+}
+
+// After registers f to run after every successful run of phase p, after
+// the hooks and any functions registered before it.
+func (s *Syncer) After(p Phase, f PhaseFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phaseFuncs == nil {
+		s.phaseFuncs = map[Phase]*phaseFuncs{}
+	}
+	if s.phaseFuncs[p] == nil {
+		s.phaseFuncs[p] = &phaseFuncs{}
+	}
+	s.phaseFuncs[p].after = append(s.phaseFuncs[p].after, f)
+}
+
+// funcsFor returns the functions to run before and after phase p: the
+// built-in hooks and events, then the registered functions.
+func (s *Syncer) funcsFor(p Phase) ([]PhaseFunc, []PhaseFunc) {
+	var before, after []PhaseFunc
+	switch p {
+	case PhaseResolve:
+		before = append(before, s.preFetchHooks)
+	case PhaseCheckout:
+		before = append(before, s.verifyGitsign, s.checkPolicy)
+		after = append(after, s.checkLayout, s.checkSymlinks, s.checkSizes, s.scanSecrets, s.decrypt, s.postCheckoutHooks, s.repoHook, s.render, s.recheckTree)
+	case PhasePublish:
+		// The manifest is written before the revision is published, so
+		// that if that fails, the revision is discarded and retried.
+		before = append(before, s.writeManifest)
+		after = append(after, s.publishRendered, s.postPublishHooks, s.exechook, s.publishEvents, s.notifySuccess)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if funcs := s.phaseFuncs[p]; funcs != nil {
+		before = append(before, funcs.before...)
+		after = append(after, funcs.after...)
+	}
+	return before, after
+}
+
+// runPhase runs phase p, whose own work is body (if not nil), with the
+// functions registered for it.
+func (s *Syncer) runPhase(ctx context.Context, p Phase, st *SyncState, body PhaseFunc) error {
+	ctx = withLogFields(ctx, "phase", string(p))
+	before, after := s.funcsFor(p)
+	for _, f := range before {
+		if err := f(ctx, st); err != nil {
+			return err
+		}
+	}
+	if body != nil {
+		if err := body(ctx, st); err != nil {
+			return err
+		}
+	}
+	for _, f := range after {
+		if err := f(ctx, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recheckTree runs the checks run after checkout again, on the new revision
+// and its rendered copy, if decryption, hooks or rendering could have
+// changed their files since.  The files decryption wrote are not scanned
+// for secrets, which they are meant to hold.
+func (s *Syncer) recheckTree(ctx context.Context, st *SyncState) error {
+	if s.opts.DecryptKeyFile == "" && len(s.hooks) == 0 && !s.opts.AllowRepoHooks && s.opts.RenderDest == "" {
+		return nil
+	}
+	dirs := []string{st.Dir}
+	if st.renderedDir != "" {
+		dirs = append(dirs, st.renderedDir)
+	}
+	for _, dir := range dirs {
+		rst := *st
+		rst.Dir = dir
+		rst.recheck = true
+		for _, f := range []PhaseFunc{s.checkLayout, s.checkSymlinks, s.checkSizes, s.scanSecrets} {
+			if err := f(ctx, &rst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve is the body of PhaseResolve.
+func (s *Syncer) resolve(ctx context.Context, st *SyncState) error {
+	defer st.timeStep("resolve", time.Now())
+	hash, err := s.resolveRev(ctx)
+	if err != nil {
+		return err
+	}
+	st.NewHash = hash
+	return nil
+}
+
+// resolveRev returns the hash to sync, from Options.RefResolver if set
+// and otherwise by resolving Options.Rev.
+func (s *Syncer) resolveRev(ctx context.Context) (string, error) {
+	if s.opts.RefResolver == nil {
+		return s.source.Resolve(ctx, s.opts.Rev)
+	}
+	hash, err := s.opts.RefResolver(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error resolving rev: %v", err)
+	}
+	if hash == "" {
+		return "", fmt.Errorf("error resolving rev: no hash returned")
+	}
+	return hash, nil
+}
+
+// fetch is the body of PhaseFetch.
+func (s *Syncer) fetch(ctx context.Context, st *SyncState) error {
+	defer s.fetched(st)
+	defer st.timeStep("fetch", time.Now())
+	if f, ok := s.source.(Fetcher); ok {
+		return f.Fetch(ctx, st.NewHash)
+	}
+	return nil
+}
+
+// checkout is the body of PhaseCheckout.
+func (s *Syncer) checkout(ctx context.Context, st *SyncState) error {
+	start := time.Now()
+	if s.opts.PublishStrategy == PublishInPlace {
+		st.Dir = filepath.Join(s.opts.Root, s.opts.Dest)
+		if err := s.checkoutInPlace(ctx, st.NewHash, st.Dir); err != nil {
+			return err
+		}
+	} else {
+		st.Dir = filepath.Join(s.opts.Root, revDirPrefix+st.NewHash)
+		reused, err := s.reuseDir(ctx, st.NewHash, st.Dir)
+		if err != nil {
+			return err
+		}
+		if !reused {
+			if err := s.source.Materialize(ctx, st.NewHash, st.Dir); err != nil {
+				return err
+			}
+		}
+	}
+	st.timeStep("checkout", start)
+	defer st.timeStep("chmod", time.Now())
+	return s.chmod(ctx, st.Dir)
+}
+
+// publish is the body of PhasePublish.
+func (s *Syncer) publish(ctx context.Context, st *SyncState) error {
+	start := time.Now()
+	oldDir, err := s.swap(ctx, st.NewHash, st.Dir)
+	st.timeStep("swap", start)
+	if err != nil {
+		return err
+	}
+	st.published = true
+	st.oldDir = oldDir
+	st.Dir = s.publishedDir(st.NewHash)
+	return nil
+}
+
+// cleanup is the body of PhaseCleanup.
+func (s *Syncer) cleanup(ctx context.Context, st *SyncState) error {
+	defer st.timeStep("cleanup", time.Now())
+	if st.oldRenderedDir != "" {
+		if err := os.RemoveAll(st.oldRenderedDir); err != nil {
+			return fmt.Errorf("error removing %s: %v", st.oldRenderedDir, err)
+		}
+	}
+	if st.oldDir == "" {
+		return nil
+	}
+	return s.removeRevision(ctx, st.oldDir)
+}
+
+// cleanupAfter runs PhaseCleanup for st if it is published, so that the
+// previous revision is removed even though a function run after
+// PhasePublish failed with err, which it returns.
+func (s *Syncer) cleanupAfter(ctx context.Context, st *SyncState, err error) error {
+	if st.published {
+		if cleanupErr := s.runPhase(ctx, PhaseCleanup, st, s.cleanup); cleanupErr != nil {
+			s.logger(ctx).Errorf("%v", cleanupErr)
+		}
+	}
+	return err
+}
+
+// publishState runs the phases after PhaseResolve for st, discarding the
+// new revision if it fails before it is published.  With
+// Options.CanaryLink, it stops short of publishing, and stages the
+// revision instead.
+func (s *Syncer) publishState(ctx context.Context, st *SyncState) error {
+	phases := []struct {
+		phase Phase
+		body  PhaseFunc
+	}{
+		{PhaseFetch, s.fetch},
+		{PhaseCheckout, s.checkout},
+		{PhaseValidate, nil},
+		{PhasePublish, s.publish},
+		{PhaseCleanup, s.cleanup},
+	}
+	if s.opts.CanaryLink != "" {
+		// The revision is published once promoted.
+		phases = phases[:3]
+	}
+	for _, p := range phases {
+		if err := s.runPhase(ctx, p.phase, st, p.body); err != nil {
+			if !st.published && st.renderedDir != "" {
+				os.RemoveAll(st.renderedDir)
+			}
+			if !st.published && st.Dir != "" && s.opts.PublishStrategy != PublishInPlace {
+				return s.discard(withLogFields(ctx, "phase", string(p.phase)), st.Dir, err)
+			}
+			if p.phase == PhasePublish {
+				return s.cleanupAfter(ctx, st, err)
+			}
+			return err
+		}
+	}
+	if s.opts.CanaryLink != "" {
+		return s.stage(ctx, st)
+	}
+	return nil
+}
+
+// preFetchHooks runs the HookPreFetch hooks, whose failures skip the sync.
+func (s *Syncer) preFetchHooks(ctx context.Context, st *SyncState) error {
+	if err := s.runHooks(ctx, HookEvent{Phase: HookPreFetch, OldHash: st.OldHash}); err != nil {
+		return skipError{err}
+	}
+	return nil
+}
+
+// postCheckoutHooks runs the HookPostCheckout hooks.
+func (s *Syncer) postCheckoutHooks(ctx context.Context, st *SyncState) error {
+	return s.runHooks(ctx, HookEvent{Phase: HookPostCheckout, OldHash: st.OldHash, NewHash: st.NewHash, Worktree: st.Dir})
+}
+
+// postPublishHooks runs the HookPostPublish hooks, whose failures are only
+// logged.
+func (s *Syncer) postPublishHooks(ctx context.Context, st *SyncState) error {
+	if err := s.runHooks(ctx, HookEvent{Phase: HookPostPublish, OldHash: st.OldHash, NewHash: st.NewHash, Worktree: st.Dir}); err != nil {
+		s.logger(ctx).Errorf("%v", err)
+	}
+	return nil
+}
+
+// publishEvents sends an Event to every EventPublisher, logging failures.
+func (s *Syncer) publishEvents(ctx context.Context, st *SyncState) error {
+	s.publishEvent(withLogFields(ctx, "phase", "event"), st.OldHash, st.NewHash)
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package gitsync
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	// DefaultSSHKeyFile is where the SSH key Secret is expected to be
+	// mounted.
+	DefaultSSHKeyFile = "/etc/git-secret/ssh"
+
+	// DefaultSSHKnownHostsFile is where the known_hosts Secret is expected
+	// to be mounted.
+	DefaultSSHKnownHostsFile = "/etc/git-secret/known_hosts"
+
+	// sshKeyModeChecked is true if ssh refuses keys readable by others.
+	sshKeyModeChecked = true
+)
+
+// createSymlink creates link pointing to target, which is relative to the
+// directory of link.
+func createSymlink(target, link string) error {
+	return os.Symlink(target, link)
+}
+
+// replaceSymlink atomically renames tmp to link, replacing it.
+func replaceSymlink(tmp, link string) error {
+	return os.Rename(tmp, link)
+}
+
+// setProcessGroup makes cmd start a process group of its own.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills the process group that cmd started.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,59 @@
+package gitsync
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// DefaultSSHKeyFile is where the SSH key Secret is expected to be
+	// mounted.
+	DefaultSSHKeyFile = `C:\ProgramData\git-secret\ssh`
+
+	// DefaultSSHKnownHostsFile is where the known_hosts Secret is expected
+	// to be mounted.
+	DefaultSSHKnownHostsFile = `C:\ProgramData\git-secret\known_hosts`
+
+	// sshKeyModeChecked is true if ssh refuses keys readable by others.
+	sshKeyModeChecked = false
+)
+
+// createSymlink creates link pointing to target, which is relative to the
+// directory of link.  Creating a symlink needs the SeCreateSymbolicLink
+// privilege (or developer mode), so without it this falls back to a
+// junction, which can only point to an absolute path.
+func createSymlink(target, link string) error {
+	// mklink /D, unlike os.Symlink, always creates a directory symlink,
+	// whatever the current directory.
+	output, err := exec.Command("cmd", "/c", "mklink", "/D", link, target).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	abs := filepath.Join(filepath.Dir(link), target)
+	if jOutput, jErr := exec.Command("cmd", "/c", "mklink", "/J", link, abs).CombinedOutput(); jErr != nil {
+		return fmt.Errorf("can't create a symlink (%v: %s) or a junction (%v: %s)", err, output, jErr, jOutput)
+	}
+	return nil
+}
+
+// replaceSymlink renames tmp to link, replacing it.  Windows can't rename
+// over a directory link, so link is removed first, and briefly doesn't
+// exist.
+func replaceSymlink(tmp, link string) error {
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
+
+// setProcessGroup does nothing: killProcessGroup finds cmd's children
+// itself.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd and the processes it started.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
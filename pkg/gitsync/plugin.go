@@ -0,0 +1,45 @@
+package gitsync
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// hookPluginSymbol is the symbol a hook plugin exports: a variable of type
+// gitsync.Hook, or of a type implementing it.
+const hookPluginSymbol = "Hook"
+
+// loadHookPlugin opens the Go plugin at path (built with "go build
+// -buildmode=plugin" against this version of gitsync) and returns its Hook.
+// A plugin is loaded once per process, so Syncers using the same plugin
+// share its state.  Plugins need a git-sync built with cgo.
+func loadHookPlugin(path string) (Hook, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading hook plugin: %v", err)
+	}
+	sym, err := p.Lookup(hookPluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("error loading hook plugin %s: %v", path, err)
+	}
+	h, err := hookFromSymbol(sym)
+	if err != nil {
+		return nil, fmt.Errorf("error loading hook plugin %s: %v", path, err)
+	}
+	return h, nil
+}
+
+// hookFromSymbol returns the Hook that a plugin's Hook symbol, which is a
+// pointer to the variable, refers to.
+func hookFromSymbol(sym plugin.Symbol) (Hook, error) {
+	switch h := sym.(type) {
+	case *Hook:
+		if *h == nil {
+			return nil, fmt.Errorf("%s is nil", hookPluginSymbol)
+		}
+		return *h, nil
+	case Hook:
+		return h, nil
+	}
+	return nil, fmt.Errorf("%s is a %T, which does not implement gitsync.Hook", hookPluginSymbol, sym)
+}
@@ -0,0 +1,31 @@
+package gitsync
+
+import (
+	"testing"
+)
+
+func TestHookFromSymbol(t *testing.T) {
+	var hook Hook = &fakeHook{}
+	var nilHook Hook
+	notHook := "Hook"
+
+	cases := []struct {
+		sym interface{}
+		err bool
+	}{
+		{&hook, false},
+		{&fakeHook{}, false},
+		{&nilHook, true},
+		{&notHook, true},
+	}
+
+	for _, testCase := range cases {
+		h, err := hookFromSymbol(testCase.sym)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%T: expected error %v but got %v", testCase.sym, testCase.err, err)
+		}
+		if err == nil && h == nil {
+			t.Fatalf("%T: expected a hook but nil returned", testCase.sym)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// policyInput is the input document of the policy: what is about to be
+// published.
+type policyInput struct {
+	Name string `json:"name"`
+	Repo string `json:"repo"`
+	Rev  string `json:"rev"`
+	// OldHash is the published revision, if any.
+	OldHash string `json:"oldHash"`
+	NewHash string `json:"newHash"`
+	// Committer and Subject describe NewHash, if the SourceProvider can.
+	Committer string `json:"committer"`
+	Subject   string `json:"subject"`
+	// Paths are the files that differ between OldHash and NewHash, if the
+	// SourceProvider can tell, or nil.
+	Paths []string `json:"paths"`
+}
+
+// policyResult is the part of `opa eval --format json` output we read.
+type policyResult struct {
+	Result []struct {
+		Expressions []struct {
+			Value interface{} `json:"value"`
+		} `json:"expressions"`
+	} `json:"result"`
+}
+
+// checkPolicy evaluates Options.PolicyQuery with opa, against the Rego
+// policy in Options.PolicyFile, before the new revision is checked out.  A
+// true result, or a non-empty set of messages, denies the revision, which
+// fails the sync, as does an undefined or unexpected result.
+func (s *Syncer) checkPolicy(ctx context.Context, st *SyncState) error {
+	if s.opts.PolicyFile == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "policy")
+
+	input := policyInput{
+		Name:    s.opts.Name,
+		Repo:    redactURL(s.opts.Repo),
+		Rev:     s.opts.Rev,
+		OldHash: st.OldHash,
+		NewHash: st.NewHash,
+	}
+	if d, ok := s.source.(CommitDescriber); ok {
+		c, err := d.DescribeCommit(ctx, st.NewHash)
+		if err != nil {
+			return fmt.Errorf("can't describe commit for policy: %v", err)
+		}
+		input.Committer, input.Subject = c.Committer, c.Subject
+	}
+	if lister, ok := s.source.(ChangeLister); ok && st.OldHash != "" {
+		paths, err := lister.ChangedPaths(ctx, st.OldHash, st.NewHash)
+		if err != nil {
+			return fmt.Errorf("can't list changed paths for policy: %v", err)
+		}
+		input.Paths = paths
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"eval", "--format", "json", "--stdin-input", "--data", s.opts.PolicyFile, s.opts.PolicyQuery}
+	s.logger(ctx).V(5).Infof("run: %s", cmdForLog("opa", args...))
+	cmd := s.command(ctx, "opa", args...)
+	cmd.Stdin = bytes.NewReader(data)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := runGroup(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("error evaluating policy: %v: %q", err, stderr.String())
+	}
+	var result policyResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return fmt.Errorf("error reading policy result %q: %v", string(output), err)
+	}
+
+	// The policy fails closed: a query that is undefined, e.g. misspelled,
+	// or that returns something else than a boolean or a set, is an error
+	// rather than an allowed revision.
+	denials := []string{}
+	defined := false
+	for _, r := range result.Result {
+		for _, e := range r.Expressions {
+			defined = true
+			switch v := e.Value.(type) {
+			case bool:
+				if v {
+					denials = append(denials, "denied")
+				}
+			case []interface{}:
+				for _, msg := range v {
+					denials = append(denials, fmt.Sprintf("%v", msg))
+				}
+			default:
+				return fmt.Errorf("error evaluating policy: %s returned %s, not a boolean or a set", s.opts.PolicyQuery, policyValue(e.Value))
+			}
+		}
+	}
+	if !defined {
+		return fmt.Errorf("error evaluating policy: %s is undefined", s.opts.PolicyQuery)
+	}
+	if len(denials) == 0 {
+		s.logger(ctx).V(1).Infof("policy allows %s", st.NewHash)
+		return nil
+	}
+
+	s.mu.Lock()
+	s.policyDenials++
+	s.mu.Unlock()
+	return fmt.Errorf("policy denied %s: %s", st.NewHash, strings.Join(denials, "; "))
+}
+
+// policyValue describes a value of a policy result for errors, e.g. "a
+// string".
+func policyValue(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
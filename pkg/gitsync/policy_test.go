@@ -0,0 +1,69 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	bin, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(bin)
+
+	// A fake opa, whose "policy" is the result to return for new hashes
+	// mentioned in it, and an empty result otherwise.
+	ioutil.WriteFile(filepath.Join(bin, "opa"), []byte(`#!/bin/sh
+[ "$1 $2 $3 $4 $5 $7" = "eval --format json --stdin-input --data data.gitsync.deny" ] || exit 1
+hash=$(sed 's/.*"newHash":"\([^"]*\)".*/\1/')
+grep "^$hash " "$6" | cut -d' ' -f2- | grep . || echo '{}'
+`), 0755)
+	ioutil.WriteFile(filepath.Join(bin, "policy.rego"), []byte(
+		`allowed {"result":[{"expressions":[{"value":[]}]}]}
+denied {"result":[{"expressions":[{"value":["too big","no tests"]}]}]}
+yes {"result":[{"expressions":[{"value":true}]}]}
+no {"result":[{"expressions":[{"value":false}]}]}
+string {"result":[{"expressions":[{"value":"nope"}]}]}
+object {"result":[{"expressions":[{"value":{"a":1}}]}]}
+broken {"result":
+`), 0644)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cases := []struct {
+		hash string
+		err  string
+	}{
+		{"allowed", ""},
+		{"undefined", "data.gitsync.deny is undefined"},
+		{"string", "data.gitsync.deny returned a string, not a boolean or a set"},
+		{"object", "data.gitsync.deny returned an object"},
+		{"no", ""},
+		{"denied", "policy denied denied: too big; no tests"},
+		{"yes", "policy denied yes: denied"},
+		{"broken", "error reading policy result"},
+	}
+
+	s := &Syncer{
+		opts:   Options{Name: "a", PolicyFile: filepath.Join(bin, "policy.rego"), PolicyQuery: "data.gitsync.deny"},
+		source: &fakeSource{},
+		env:    map[string]string{},
+	}
+	for _, testCase := range cases {
+		err := s.checkPolicy(context.Background(), &SyncState{NewHash: testCase.hash})
+		if testCase.err == "" && err != nil {
+			t.Fatalf("%s: unexpected error: %v", testCase.hash, err)
+		}
+		if testCase.err != "" && (err == nil || !strings.Contains(err.Error(), testCase.err)) {
+			t.Fatalf("%s: expected error %q but got %v", testCase.hash, testCase.err, err)
+		}
+	}
+	if rm := s.metrics(); !rm.policy || rm.policyDenials != 2 {
+		t.Fatalf("expected 2 denials but %+v counted", rm)
+	}
+}
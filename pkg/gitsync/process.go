@@ -0,0 +1,36 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+)
+
+// runGroup runs cmd like cmd.CombinedOutput, or if cmd.Stderr is already
+// set, returning only the standard output, in a process group of its own.
+// If ctx is done first, the whole group is killed: killing git alone
+// leaves its children, e.g. git-remote-https, ssh or index-pack, running,
+// holding the output open, and a hung fetch would block shutdown forever.
+func runGroup(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	if cmd.Stderr == nil {
+		cmd.Stderr = output
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	return output.Bytes(), err
+}
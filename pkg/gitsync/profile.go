@@ -0,0 +1,84 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProfileStep is how long one step of a sync took.
+type ProfileStep struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// SyncProfile breaks down where the time of a sync went, with
+// Options.ProfileSyncs.
+type SyncProfile struct {
+	// Steps are the steps that ran, in order: resolve, fetch, checkout,
+	// chmod, swap and cleanup.  Hooks and checks are not included.
+	Steps []ProfileStep `json:"steps"`
+	// FetchedBytes is how much the object store grew while resolving and
+	// fetching, which is roughly what was transferred.  It is only known
+	// for the git source.
+	FetchedBytes int64 `json:"fetchedBytes"`
+}
+
+// timeStep records, when profiling, that step name of the sync took since
+// start.
+func (st *SyncState) timeStep(name string, start time.Time) {
+	if st.profile != nil {
+		st.profile.Steps = append(st.profile.Steps, ProfileStep{Name: name, Seconds: time.Since(start).Seconds()})
+	}
+}
+
+// startProfile starts profiling st, if Options.ProfileSyncs is set.
+func (s *Syncer) startProfile(st *SyncState) {
+	if !s.opts.ProfileSyncs {
+		return
+	}
+	st.profile = &SyncProfile{}
+	st.objectsSize = s.objectsSize()
+}
+
+// fetched records, when profiling, how much the object store has grown
+// since the sync started.
+func (s *Syncer) fetched(st *SyncState) {
+	if st.profile != nil {
+		st.profile.FetchedBytes = s.objectsSize() - st.objectsSize
+	}
+}
+
+// endProfile logs st's profile, if any, and records it in the status.
+func (s *Syncer) endProfile(ctx context.Context, st *SyncState) {
+	if st.profile == nil {
+		return
+	}
+	steps := []string{}
+	for _, step := range st.profile.Steps {
+		steps = append(steps, fmt.Sprintf("%s=%.3fs", step.Name, step.Seconds))
+	}
+	s.logger(withLogFields(ctx, "phase", "profile")).V(0).Infof("sync profile: %s fetched=%dB", strings.Join(steps, " "), st.profile.FetchedBytes)
+
+	s.mu.Lock()
+	s.status.LastProfile = st.profile
+	s.mu.Unlock()
+}
+
+// objectsSize returns the size of the git source's object store, or 0.
+func (s *Syncer) objectsSize() int64 {
+	if _, ok := s.source.(*gitSource); !ok {
+		return 0
+	}
+	var total int64
+	filepath.Walk(filepath.Join(s.opts.Root, ".git", "objects"), func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,148 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransferProgress is how far a clone or fetch has got, from git's progress
+// output.
+type TransferProgress struct {
+	// Stage is what git is doing, e.g. "Receiving objects" or "Resolving
+	// deltas".
+	Stage   string `json:"stage"`
+	Percent int    `json:"percent"`
+	Objects int64  `json:"objects"`
+	Total   int64  `json:"total"`
+	// Bytes and BytesPerSecond are only reported while receiving objects.
+	Bytes          int64     `json:"bytes"`
+	BytesPerSecond int64     `json:"bytesPerSecond"`
+	Started        time.Time `json:"started"`
+	// Updated is when git last reported progress.  A transfer that hasn't
+	// for long is probably hung, rather than slow.
+	Updated time.Time `json:"updated"`
+}
+
+// progressRE matches a line of git's progress output, e.g. "Receiving
+// objects:  45% (450/1000), 1.20 MiB | 2.40 MiB/s".
+var progressRE = regexp.MustCompile(`^(?:remote: )?([A-Z][a-z]+(?: [a-z]+)*):\s+(\d+)% \((\d+)/(\d+)\)(?:, ([\d.]+) ([KMG]iB|bytes)(?: \| ([\d.]+) ([KMG]iB|bytes)/s)?)?`)
+
+// parseSize parses a size as git prints it, e.g. "1.20" and "MiB".
+func parseSize(num, unit string) int64 {
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "KiB":
+		f *= 1 << 10
+	case "MiB":
+		f *= 1 << 20
+	case "GiB":
+		f *= 1 << 30
+	}
+	return int64(f)
+}
+
+// progressWriter is the stderr of a clone or fetch.  It records git's
+// progress in the Syncer's status as it goes, and keeps the other lines,
+// e.g. errors.
+type progressWriter struct {
+	s        *Syncer
+	started  time.Time
+	mu       sync.Mutex
+	partial  []byte
+	messages bytes.Buffer
+}
+
+// Write splits p into lines, which git ends with "\r" while it updates one
+// in place.
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// line handles one line of git's stderr.
+func (w *progressWriter) line(line string) {
+	m := progressRE.FindStringSubmatch(line)
+	if m == nil {
+		if strings.TrimSpace(line) != "" {
+			w.messages.WriteString(line + "\n")
+		}
+		return
+	}
+	if strings.HasPrefix(line, "remote: ") {
+		// The server preparing the pack isn't the transfer.
+		return
+	}
+	p := &TransferProgress{Stage: m[1], Started: w.started, Updated: time.Now()}
+	p.Percent, _ = strconv.Atoi(m[2])
+	p.Objects, _ = strconv.ParseInt(m[3], 10, 64)
+	p.Total, _ = strconv.ParseInt(m[4], 10, 64)
+	if m[5] != "" {
+		p.Bytes = parseSize(m[5], m[6])
+	}
+	if m[7] != "" {
+		p.BytesPerSecond = parseSize(m[7], m[8])
+	}
+
+	w.s.mu.Lock()
+	if prev := w.s.status.Transfer; prev != nil && m[5] == "" && prev.Stage == "Receiving objects" {
+		// Keep what was received while resolving deltas.
+		p.Bytes, p.BytesPerSecond = prev.Bytes, prev.BytesPerSecond
+	}
+	w.s.status.Transfer = p
+	w.s.mu.Unlock()
+}
+
+// output returns what git printed other than progress.
+func (w *progressWriter) output() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.messages.String() + string(w.partial)
+}
+
+// runTransfer runs a git clone or fetch, given by args, in cwd, like
+// runCommand, recording its progress in Status.Transfer while it runs.
+func (s *Syncer) runTransfer(ctx context.Context, cwd string, args ...string) error {
+	args = append([]string{args[0], "--progress"}, args[1:]...)
+	s.logger(ctx).V(5).Infof("run(%q): %s", cwd, cmdForLog("git", args...))
+
+	w := &progressWriter{s: s, started: time.Now()}
+	s.mu.Lock()
+	s.status.Transfer = &TransferProgress{Stage: "Connecting", Started: w.started, Updated: w.started}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.status.Transfer = nil
+		s.mu.Unlock()
+	}()
+
+	cmd := s.command(ctx, "git", args...)
+	cmd.Dir = cwd
+	cmd.Stderr = w
+	_, err := s.combinedOutput(ctx, cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out: %s", cmdForLog("git", args...))
+	}
+	if err != nil {
+		return fmt.Errorf("error running command: %v: %q", err, w.output())
+	}
+	return nil
+}
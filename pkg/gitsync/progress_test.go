@@ -0,0 +1,34 @@
+package gitsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProgressWriter(t *testing.T) {
+	s := &Syncer{}
+	w := &progressWriter{s: s}
+	out := "Cloning into '/git'...\n" +
+		"remote: Counting objects: 100% (1000/1000), done.\n" +
+		"Receiving objects:  45% (450/1000), 1.50 MiB | 512.00 KiB/s\r" +
+		"Receiving objects: 100% (1000/1000), 3.00 MiB | 1.00 MiB/s, done.\n" +
+		"Resolving deltas:  30% (30/1"
+	if _, err := w.Write([]byte(out)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := s.status.Transfer
+	if p == nil || p.Stage != "Receiving objects" || p.Objects != 1000 || p.Bytes != 3<<20 || p.BytesPerSecond != 1<<20 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+
+	w.Write([]byte("00)\r"))
+	p = s.status.Transfer
+	if p.Stage != "Resolving deltas" || p.Percent != 30 || p.Total != 100 || p.Bytes != 3<<20 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+
+	w.Write([]byte("fatal: the remote end hung up unexpectedly\n"))
+	if got := w.output(); !strings.HasPrefix(got, "Cloning into") || !strings.Contains(got, "fatal: the remote end hung up unexpectedly") || strings.Contains(got, "objects") {
+		t.Fatalf("unexpected output: %q", got)
+	}
+}
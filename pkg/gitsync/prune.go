@@ -0,0 +1,34 @@
+package gitsync
+
+import (
+	"context"
+	"time"
+)
+
+// pruneObjects deletes the objects of the clone that nothing reachable
+// needs, if Options.PruneInterval has passed since it last did.  It runs
+// after old worktrees are pruned, within a sync, so no other git command of
+// the Syncer is running.  The HEAD and index of every remaining worktree
+// keep their objects.
+func (s *Syncer) pruneObjects(ctx context.Context) error {
+	if s.opts.PruneInterval == 0 || time.Since(s.lastPrune) < waitTime(s.opts.PruneInterval) {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "prune")
+	start := time.Now()
+	for _, args := range [][]string{
+		// Reflog entries would keep every commit the branch was at alive.
+		{"reflog", "expire", "--expire=now", "--expire-unreachable=now", "--all"},
+		// Fetched objects are mostly in packs, which prune doesn't touch.
+		{"repack", "-a", "-d", "-q"},
+		// This also drops the shallow boundaries that no longer matter.
+		{"prune", "--expire=now"},
+	} {
+		if _, err := s.runCommand(ctx, s.opts.Root, "git", args...); err != nil {
+			return err
+		}
+	}
+	s.lastPrune = time.Now()
+	s.logger(ctx).V(0).Infof("pruned unreachable objects in %v", time.Since(start))
+	return nil
+}
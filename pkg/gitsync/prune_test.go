@@ -0,0 +1,69 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	runGit(t, "init", "-q", "-b", "sync", repo)
+	runGit(t, "-C", repo, "commit", "-q", "--allow-empty", "-m", "one")
+
+	root := filepath.Join(dir, "root")
+	s := &Syncer{opts: Options{Repo: repo, Branch: "sync", Root: root, PruneInterval: 3600}, env: map[string]string{}}
+	ctx := context.Background()
+	if err := s.Clone(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unreachable := func() string {
+		out, err := s.runCommand(ctx, root, "sh", "-c", "echo unreachable | git hash-object -w --stdin")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return strings.TrimSpace(out)
+	}
+	exists := func(hash string) bool {
+		_, err := s.runCommand(ctx, root, "git", "cat-file", "-e", hash)
+		return err == nil
+	}
+
+	obj := unreachable()
+	if err := s.pruneObjects(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists(obj) {
+		t.Fatalf("expected %s to be pruned", obj)
+	}
+	head, err := s.hashForRev(ctx, "HEAD")
+	if err != nil || !exists(head) {
+		t.Fatalf("expected HEAD to be kept but got %v", err)
+	}
+
+	// Not again before the interval has passed.
+	obj = unreachable()
+	if err := s.pruneObjects(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists(obj) {
+		t.Fatalf("expected %s not to be pruned yet", obj)
+	}
+	s.lastPrune = time.Now().Add(-2 * time.Hour)
+	if err := s.pruneObjects(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists(obj) {
+		t.Fatalf("expected %s to be pruned", obj)
+	}
+}
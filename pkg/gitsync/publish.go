@@ -0,0 +1,276 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// PublishSymlink publishes each revision by atomically swapping the
+	// Options.Dest symlink to its directory.
+	PublishSymlink = "symlink"
+
+	// PublishRename publishes each revision by renaming its directory to
+	// Options.Dest, for volumes that don't support symlinks.  Dest is
+	// briefly missing while the previous revision is moved out of the way.
+	PublishRename = "rename"
+
+	// PublishCopy publishes each revision by copying its files into the
+	// directory Options.Dest, for consumers that can't follow a changing
+	// symlink.  Each file is replaced atomically, but not the tree as a
+	// whole.
+	PublishCopy = "copy"
+
+	// PublishInPlace publishes each revision by checking it out over the
+	// previous one in Options.Dest, without worktrees, for tiny repos on
+	// tiny volumes.  Consumers can see a half-updated tree, and a
+	// post-checkout hook can't keep a revision from being published, nor
+	// can the checks of its files, which Options.Validate rejects.  It
+	// only works with the git source.
+	PublishInPlace = "in-place"
+
+	// PublishPointer publishes each revision by atomically replacing the
+	// file Options.Dest with one holding the name of its directory, for
+	// volumes that don't support symlinks.
+	PublishPointer = "pointer"
+)
+
+// swap makes newDir, holding revision hash, the published revision, and
+// returns the directory of the previous one, if it is to be removed.
+func (s *Syncer) swap(ctx context.Context, hash, newDir string) (string, error) {
+	switch s.opts.PublishStrategy {
+	case PublishRename:
+		return s.publishRename(ctx, hash, newDir)
+	case PublishCopy:
+		return s.publishCopy(ctx, hash, newDir)
+	case PublishPointer:
+		return s.publishPointer(ctx, newDir)
+	case PublishInPlace:
+		if err := writeFileAtomic(s.hashFile(), []byte(hash+"\n")); err != nil {
+			return "", fmt.Errorf("error recording published hash: %v", err)
+		}
+		return "", nil
+	}
+	return s.updateSymlink(ctx, s.opts.Root, s.opts.Dest, newDir)
+}
+
+// publishedDir returns the directory revision hash is in once published.
+func (s *Syncer) publishedDir(hash string) string {
+	switch s.opts.PublishStrategy {
+	case PublishRename, PublishCopy, PublishInPlace:
+		return filepath.Join(s.opts.Root, s.opts.Dest)
+	}
+	return filepath.Join(s.opts.Root, revDirPrefix+hash)
+}
+
+// publishedHash returns the revision published in Options.Dest, or "" if
+// nothing has been published yet.
+func (s *Syncer) publishedHash() (string, error) {
+	switch s.opts.PublishStrategy {
+	case PublishRename, PublishCopy, PublishInPlace:
+		data, err := ioutil.ReadFile(s.hashFile())
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading published hash: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case PublishPointer:
+		data, err := ioutil.ReadFile(filepath.Join(s.opts.Root, s.opts.Dest))
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading pointer file: %v", err)
+		}
+		return strings.TrimPrefix(strings.TrimSpace(string(data)), revDirPrefix), nil
+	}
+
+	link := filepath.Join(s.opts.Root, s.opts.Dest)
+	target, err := os.Readlink(link)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		if info, lstatErr := os.Lstat(link); lstatErr == nil && info.Mode()&os.ModeSymlink == 0 {
+			return "", fmt.Errorf("%s is not a symlink; remove it to change --publish-strategy", link)
+		}
+		return "", fmt.Errorf("error reading symlink: %v", err)
+	}
+	return strings.TrimPrefix(filepath.Base(target), revDirPrefix), nil
+}
+
+// hashFile is where the strategies that publish in the Options.Dest
+// directory record the hash of the revision in it.
+func (s *Syncer) hashFile() string {
+	return filepath.Join(s.opts.Root, "."+s.opts.Dest+".hash")
+}
+
+// publishRename renames newDir to Options.Dest, first moving the previous
+// revision out of the way, and returns where it moved it.
+func (s *Syncer) publishRename(ctx context.Context, hash, newDir string) (string, error) {
+	dest := filepath.Join(s.opts.Root, s.opts.Dest)
+	oldDir := ""
+	exists, err := checkDestDir(dest)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		oldDir = filepath.Join(s.opts.Root, "tmp-old")
+		if err := os.RemoveAll(oldDir); err != nil {
+			return "", fmt.Errorf("error removing stale directory: %v", err)
+		}
+		if err := os.Rename(dest, oldDir); err != nil {
+			return "", fmt.Errorf("error moving previous revision: %v", err)
+		}
+		if err := s.moved(ctx, oldDir); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.Rename(newDir, dest); err != nil {
+		if oldDir != "" {
+			if restoreErr := os.Rename(oldDir, dest); restoreErr != nil {
+				s.logger(ctx).Errorf("error restoring previous revision: %v", restoreErr)
+			}
+		}
+		return "", fmt.Errorf("error renaming %s to %s: %v", newDir, dest, err)
+	}
+	s.logger(ctx).V(1).Infof("renamed %s to %s", newDir, dest)
+	if err := s.moved(ctx, dest); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(s.hashFile(), []byte(hash+"\n")); err != nil {
+		return "", fmt.Errorf("error recording published hash: %v", err)
+	}
+	return oldDir, nil
+}
+
+// checkoutInPlace hard-resets the Options.Dest checkout at dest to
+// revision hash.  The clone in Options.Root holds its index.  The hash is
+// recorded only once published, so that if validation fails, the next
+// sync retries.
+func (s *Syncer) checkoutInPlace(ctx context.Context, hash, dest string) error {
+	if _, ok := s.source.(*gitSource); !ok {
+		return fmt.Errorf("--publish-strategy=%s only works with the git source", PublishInPlace)
+	}
+	exists, err := checkDestDir(dest)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := os.Mkdir(dest, 0755); err != nil {
+			return fmt.Errorf("error creating %s: %v", dest, err)
+		}
+	}
+	if _, err := s.runCommand(ctx, s.opts.Root, "git", "--work-tree", dest, "reset", "--hard", hash); err != nil {
+		return err
+	}
+	s.logger(ctx).V(0).Infof("reset %s to %s", dest, hash)
+	return nil
+}
+
+// checkDestDir returns whether the Options.Dest directory at dest exists,
+// and an error if something else is there.
+func checkDestDir(dest string) (bool, error) {
+	info, err := os.Lstat(dest)
+	switch {
+	case os.IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("error accessing %s: %v", dest, err)
+	case info.Mode()&os.ModeType != os.ModeDir:
+		return false, fmt.Errorf("%s is not a directory; remove it to change --publish-strategy", dest)
+	}
+	return true, nil
+}
+
+// reuseDir returns true if dir, in which revision hash is to be
+// materialized, already exists and the source could reset it to hash.
+// Otherwise, whatever is in dir is removed, so that it can be materialized
+// afresh.
+func (s *Syncer) reuseDir(ctx context.Context, hash, dir string) (bool, error) {
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error accessing %s: %v", dir, err)
+	}
+	if g, ok := s.source.(*gitSource); ok && g.reuse(ctx, hash, dir) {
+		s.logger(ctx).V(0).Infof("reused existing %s", dir)
+		return true, nil
+	}
+	s.logger(ctx).V(0).Infof("removing stale %s", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return false, fmt.Errorf("error removing stale directory: %v", err)
+	}
+	return false, s.source.Cleanup(ctx)
+}
+
+// moved tells the source, if it cares, that a materialized dir has been
+// renamed to dir.
+func (s *Syncer) moved(ctx context.Context, dir string) error {
+	if m, ok := s.source.(DirMover); ok {
+		return m.Moved(ctx, dir)
+	}
+	return nil
+}
+
+// publishPointer writes the name of newDir to the Options.Dest pointer
+// file, and returns the directory it pointed to before.
+func (s *Syncer) publishPointer(ctx context.Context, newDir string) (string, error) {
+	pointer := filepath.Join(s.opts.Root, s.opts.Dest)
+	if info, err := os.Lstat(pointer); err == nil && !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a file; remove it to change --publish-strategy", pointer)
+	}
+	published, err := s.publishedHash()
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeFileAtomic(pointer, []byte(filepath.Base(newDir)+"\n")); err != nil {
+		return "", fmt.Errorf("error writing pointer file: %v", err)
+	}
+	s.logger(ctx).V(1).Infof("pointed %s to %s", pointer, filepath.Base(newDir))
+
+	if published == "" {
+		return "", nil
+	}
+	return filepath.Join(s.opts.Root, revDirPrefix+published), nil
+}
+
+// removeRevision removes a previously published revision.
+func (s *Syncer) removeRevision(ctx context.Context, dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error removing directory: %v", err)
+	}
+	s.logger(ctx).V(1).Infof("removed %s", dir)
+	return s.source.Cleanup(ctx)
+}
+
+// writeFileAtomic replaces the file at path with data.  The file is renamed
+// into place, so readers never see it half-written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+)
+
+// pubsubTopicRE matches a full Pub/Sub topic name.
+var pubsubTopicRE = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// pubsubPublisher publishes Events to a Google Cloud Pub/Sub topic, with
+// an access token for the pod's service account from the metadata server.
+type pubsubPublisher struct {
+	// publishURL is the topic's :publish endpoint.
+	publishURL string
+	// tokenURL is the metadata server URL of the service account's token.
+	tokenURL string
+
+	token cachedToken
+}
+
+// newPubSubPublisher returns a publisher to topic, of the form
+// projects/PROJECT/topics/TOPIC, as serviceAccount.
+func newPubSubPublisher(topic, serviceAccount string) (*pubsubPublisher, error) {
+	if !pubsubTopicRE.MatchString(topic) {
+		return nil, fmt.Errorf("%q is not of the form projects/PROJECT/topics/TOPIC", topic)
+	}
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	return &pubsubPublisher{
+		publishURL: "https://pubsub.googleapis.com/v1/" + topic + ":publish",
+		tokenURL:   fmt.Sprintf(gcpMetadataTokenURL, serviceAccount),
+	}, nil
+}
+
+// pubsubMessage is a message in a Pub/Sub publish request.  Data is
+// base64-encoded by encoding/json.
+type pubsubMessage struct {
+	Data       []byte            `json:"data"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func (p *pubsubPublisher) PublishEvent(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+	if !p.token.valid() {
+		header := http.Header{}
+		header.Set("Metadata-Flavor", "Google")
+		t, err := getMetadataToken(ctx, p.tokenURL, header)
+		if err != nil {
+			return err
+		}
+		p.token = t
+	}
+
+	// Attributes let subscriptions filter without decoding the data.
+	msg := pubsubMessage{
+		Data:       payload,
+		Attributes: map[string]string{"name": e.Name, "repo": e.Repo, "newHash": e.NewHash},
+	}
+	body, err := json.Marshal(map[string][]pubsubMessage{"messages": {msg}})
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", p.publishURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.token.value)
+
+	client := &http.Client{Timeout: eventTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling Pub/Sub: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading Pub/Sub response: %v", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Fetch a new token next time.
+		p.token = cachedToken{}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Pub/Sub returned status %d: %q", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPubSubPublisher(t *testing.T) {
+	tokens := 0
+	var path, auth string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") == "Google" {
+			tokens++
+			w.Write([]byte(`{"access_token": "tok", "expires_in": 3600}`))
+			return
+		}
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"messageIds": ["1"]}`))
+	}))
+	defer server.Close()
+
+	p, err := newPubSubPublisher("projects/p/topics/t", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.publishURL = server.URL + "/v1/projects/p/topics/t:publish"
+	p.tokenURL = server.URL + "/token"
+
+	for _, hash := range []string{"abc", "def"} {
+		if err := p.PublishEvent(context.Background(), Event{Name: "a", NewHash: hash}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if path != "/v1/projects/p/topics/t:publish" || auth != "Bearer tok" {
+			t.Fatalf("unexpected request to %s with %q", path, auth)
+		}
+		var req struct {
+			Messages []pubsubMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || len(req.Messages) != 1 {
+			t.Fatalf("expected one message but sent %s", body)
+		}
+		var e Event
+		if err := json.Unmarshal(req.Messages[0].Data, &e); err != nil || e.NewHash != hash {
+			t.Fatalf("expected an event for %s but sent %s", hash, req.Messages[0].Data)
+		}
+		if req.Messages[0].Attributes["newHash"] != hash {
+			t.Fatalf("expected attribute newHash=%s but sent %v", hash, req.Messages[0].Attributes)
+		}
+	}
+	if tokens != 1 {
+		t.Fatalf("expected the token to be cached but %d were fetched", tokens)
+	}
+}
+
+func TestNewPubSubPublisher(t *testing.T) {
+	cases := []struct {
+		topic string
+		err   bool
+	}{
+		{"projects/p/topics/t", false},
+		{"projects/p/topics/", true},
+		{"t", true},
+		{"projects/p/subscriptions/s", true},
+	}
+
+	for _, testCase := range cases {
+		_, err := newPubSubPublisher(testCase.topic, "default")
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s: expected error %v but got %v", testCase.topic, testCase.err, err)
+		}
+	}
+}
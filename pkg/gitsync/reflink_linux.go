@@ -0,0 +1,19 @@
+package gitsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl.
+const ficlone = 0x40049409
+
+// cloneFile makes dst, which must be empty, a copy-on-write clone of src,
+// sharing its blocks, on filesystems that support reflinks, e.g. XFS and
+// btrfs.  Elsewhere, or across filesystems, it fails.
+func cloneFile(dst, src *os.File) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd()); errno != 0 {
+		return &os.SyscallError{Syscall: "ioctl FICLONE", Err: errno}
+	}
+	return nil
+}
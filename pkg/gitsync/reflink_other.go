@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package gitsync
+
+import (
+	"fmt"
+	"os"
+)
+
+// cloneFile would make dst a copy-on-write clone of src, but reflinks are
+// only supported on Linux.
+func cloneFile(dst, src *os.File) error {
+	return fmt.Errorf("reflinks are only supported on Linux")
+}
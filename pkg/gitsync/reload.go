@@ -0,0 +1,98 @@
+package gitsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+)
+
+// fileWatch is a set of mounted credential files, and the setup to re-run
+// when any of them changes.
+type fileWatch struct {
+	name   string
+	paths  []string
+	sum    [sha256.Size]byte
+	reload func(context.Context) error
+}
+
+// filesSum hashes the contents of paths.  Contents are compared rather than
+// modification times, because Kubernetes updates Secret volumes by swapping
+// symlinks.
+func filesSum(paths []string) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		h.Write([]byte(p))
+		h.Write(data)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// watchFiles arranges for reload to be called when any of paths changes.
+func (s *Syncer) watchFiles(name string, reload func(context.Context) error, paths ...string) {
+	nonEmpty := []string{}
+	for _, p := range paths {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return
+	}
+
+	w := &fileWatch{name: name, paths: nonEmpty, reload: reload}
+	if sum, err := filesSum(nonEmpty); err == nil {
+		w.sum = sum
+	}
+	s.fileWatches = append(s.fileWatches, w)
+}
+
+// watchCredentialFiles registers the credential files given in the flags,
+// so that rotated Secrets are applied without restarting.
+func (s *Syncer) watchCredentialFiles() {
+	if s.opts.SSH {
+		paths := append([]string{}, s.sshKeyFiles()...)
+		paths = append(paths, s.opts.SSHConfigFile, s.opts.SSHKeyPassphraseFile)
+		if s.opts.SSHKnownHosts && len(s.opts.SSHHostFingerprints) == 0 {
+			paths = append(paths, s.opts.SSHKnownHostsFile)
+		}
+		s.watchFiles("SSH credentials", s.setupSSH, paths...)
+	}
+	if s.opts.TLSClientCert != "" {
+		s.watchFiles("TLS client certificate", func(ctx context.Context) error {
+			return s.setupGitTLSClientCert(ctx, s.opts.TLSClientCert, s.opts.TLSClientKey)
+		}, s.opts.TLSClientCert, s.opts.TLSClientKey)
+	}
+	if s.opts.CACertFile != "" {
+		s.watchFiles("CA bundle", func(ctx context.Context) error {
+			return s.setupGitCACert(ctx, s.opts.CACertFile)
+		}, s.opts.CACertFile)
+	}
+}
+
+// reloadChangedFiles re-runs the setup for any watched files that have
+// changed since they were last applied.
+func (s *Syncer) reloadChangedFiles(ctx context.Context) error {
+	for _, w := range s.fileWatches {
+		sum, err := filesSum(w.paths)
+		if err != nil {
+			// Probably mid-rotation; try again next time.
+			s.logger(ctx).V(1).Infof("can't read %s, not reloading: %v", w.name, err)
+			continue
+		}
+		if sum == w.sum {
+			continue
+		}
+		s.logger(ctx).V(0).Infof("%s changed, reloading", w.name)
+		if err := w.reload(ctx); err != nil {
+			return err
+		}
+		w.sum = sum
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	// RenderEnvsubst replaces $VAR and ${VAR} with environment variables,
+	// like envsubst.
+	RenderEnvsubst = "envsubst"
+	// RenderTemplate executes files as Go templates.
+	RenderTemplate = "template"
+
+	// renderedDirPrefix starts the name of the directories holding
+	// rendered revisions.
+	renderedDirPrefix = "rendered-"
+)
+
+// renderData is what Go templates are executed with.
+type renderData struct {
+	Name string
+	Repo string
+	Rev  string
+	Hash string
+	// Env holds the environment variables.  Using a missing one is an
+	// error.
+	Env map[string]string
+}
+
+// render renders the new revision into a directory of its own, to be
+// published in Options.RenderDest alongside the raw checkout.
+func (s *Syncer) render(ctx context.Context, st *SyncState) error {
+	if s.opts.RenderDest == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "render")
+
+	dir := filepath.Join(s.opts.Root, renderedDirPrefix+st.NewHash)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error removing stale %s: %v", dir, err)
+	}
+	st.renderedDir = dir
+
+	data := renderData{
+		Name: s.opts.Name,
+		Repo: s.opts.Repo,
+		Rev:  s.opts.Rev,
+		Hash: st.NewHash,
+		Env:  map[string]string{},
+	}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		data.Env[parts[0]] = parts[1]
+	}
+
+	rendered := 0
+	err := filepath.Walk(st.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(st.Dir, path)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dir, rel)
+		switch {
+		case fi.IsDir():
+			if rel == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(out, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, out)
+		case !fi.Mode().IsRegular():
+			return nil
+		}
+
+		in, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if matchName(s.opts.RenderPattern, fi.Name()) {
+			if in, err = s.renderFile(rel, in, data); err != nil {
+				return err
+			}
+			rendered++
+		}
+		return ioutil.WriteFile(out, in, fi.Mode().Perm())
+	})
+	if err != nil {
+		return fmt.Errorf("error rendering: %v", err)
+	}
+	s.logger(ctx).V(1).Infof("rendered %d files into %s", rendered, dir)
+	return nil
+}
+
+// renderFile renders the contents of the file at path, per
+// Options.RenderMode.
+func (s *Syncer) renderFile(path string, contents []byte, data renderData) ([]byte, error) {
+	if s.opts.RenderMode == RenderTemplate {
+		tmpl, err := template.New(path).Option("missingkey=error").Parse(string(contents))
+		if err != nil {
+			return nil, err
+		}
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return []byte(os.ExpandEnv(string(contents))), nil
+}
+
+// publishRendered publishes the rendered revision in Options.RenderDest,
+// once the revision itself has been published.
+func (s *Syncer) publishRendered(ctx context.Context, st *SyncState) error {
+	if st.renderedDir == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "render")
+	oldDir, err := s.updateSymlink(ctx, s.opts.Root, s.opts.RenderDest, st.renderedDir)
+	if err != nil {
+		os.RemoveAll(st.renderedDir)
+		return err
+	}
+	if oldDir != "" && oldDir != st.renderedDir {
+		st.oldRenderedDir = oldDir
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncOnceRender(t *testing.T) {
+	os.Setenv("GIT_SYNC_TEST_REPLICAS", "3")
+	defer os.Unsetenv("GIT_SYNC_TEST_REPLICAS")
+
+	cases := []struct {
+		mode     string
+		pattern  string
+		files    map[string]string
+		expected map[string]string
+		err      bool
+	}{
+		{
+			RenderEnvsubst, "*.yaml",
+			map[string]string{"a.yaml": "replicas: ${GIT_SYNC_TEST_REPLICAS}", "run.sh": "echo $GIT_SYNC_TEST_REPLICAS", "sub/b.yaml": "n: $GIT_SYNC_TEST_REPLICAS"},
+			map[string]string{"a.yaml": "replicas: 3", "run.sh": "echo $GIT_SYNC_TEST_REPLICAS", "sub/b.yaml": "n: 3"},
+			false,
+		},
+		{
+			RenderTemplate, "*.tmpl",
+			map[string]string{"a.tmpl": "{{.Name}} {{.Hash}} {{.Env.GIT_SYNC_TEST_REPLICAS}}"},
+			map[string]string{"a.tmpl": "a one 3"},
+			false,
+		},
+		{
+			RenderTemplate, "*",
+			map[string]string{"a": "{{.Env.GIT_SYNC_TEST_MISSING}}"},
+			nil,
+			true,
+		},
+	}
+
+	for _, testCase := range cases {
+		root, err := ioutil.TempDir("", "git-sync-test-")
+		if err != nil {
+			t.Fatalf("can't create temp dir: %v", err)
+		}
+		defer os.RemoveAll(root)
+
+		s := &Syncer{
+			opts:   Options{Name: "a", Root: root, Dest: "link", Rev: "HEAD", RenderDest: "rendered", RenderMode: testCase.mode, RenderPattern: testCase.pattern},
+			source: &treeSource{fakeSource: fakeSource{hash: "one"}, t: t, files: testCase.files},
+			env:    map[string]string{},
+		}
+		err = s.SyncOnce(context.Background())
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s %s: expected error %v but got %v", testCase.mode, testCase.pattern, testCase.err, err)
+		}
+		if testCase.err {
+			for _, dir := range []string{"rev-one", "rendered-one"} {
+				if _, err := os.Stat(filepath.Join(root, dir)); !os.IsNotExist(err) {
+					t.Fatalf("%s %s: expected %s to be removed but got %v", testCase.mode, testCase.pattern, dir, err)
+				}
+			}
+			continue
+		}
+		for path, contents := range testCase.expected {
+			data, err := ioutil.ReadFile(filepath.Join(root, "rendered", path))
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", path, err)
+			}
+			if string(data) != contents {
+				t.Fatalf("%s: expected %q but %q rendered", path, contents, string(data))
+			}
+			data, err = ioutil.ReadFile(filepath.Join(root, "link", path))
+			if err != nil || string(data) != testCase.files[path] {
+				t.Fatalf("%s: expected the checkout untouched but %q found (%v)", path, string(data), err)
+			}
+		}
+
+		// The next revision replaces the rendered one.
+		s.source.(*treeSource).hash = "two"
+		if err := s.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("%s: unexpected error: %v", testCase.mode, err)
+		}
+		if target, _ := os.Readlink(filepath.Join(root, "rendered")); target != "rendered-two" {
+			t.Fatalf("%s: expected rendered-two but %s linked", testCase.mode, target)
+		}
+		if _, err := os.Stat(filepath.Join(root, "rendered-one")); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected rendered-one to be removed but got %v", testCase.mode, err)
+		}
+	}
+}
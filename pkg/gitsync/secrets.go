@@ -0,0 +1,356 @@
+package gitsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SecretScanWarn logs the secrets found in a new revision.
+	SecretScanWarn = "warn"
+	// SecretScanBlock refuses to publish a revision with secrets in it.
+	SecretScanBlock = "block"
+
+	// scanChunkSize is how much of a file is scanned for secrets at once.
+	// Longer lines are scanned a chunk at a time, each with the last
+	// scanOverlap bytes of the previous one, so that secrets spanning two
+	// chunks are found too.
+	scanChunkSize = 1 << 20
+	scanOverlap   = 4 << 10
+	// binarySniffSize is how much of a file is looked at, like git does,
+	// for a NUL byte that makes it binary.
+	binarySniffSize = 8000
+)
+
+// secretRule finds one kind of secret.
+type secretRule struct {
+	id string
+	// regex matches the secret in a line, if set.
+	regex *regexp.Regexp
+	// path, if set, limits the rule to the paths it matches, or is the
+	// whole rule if regex isn't set.
+	path *regexp.Regexp
+}
+
+// defaultSecretRules find well-known kinds of credentials.
+var defaultSecretRules = []secretRule{
+	{id: "aws-access-key-id", regex: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{id: "private-key", regex: regexp.MustCompile(`-----BEGIN[A-Z ]* PRIVATE KEY-----`)},
+	{id: "github-token", regex: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{id: "gitlab-token", regex: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{id: "slack-token", regex: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{id: "google-api-key", regex: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+}
+
+// loadSecretRules reads the rules in the file at path: a gitleaks config if
+// its name ends in .toml, and otherwise lines of a rule ID and a regex,
+// separated by whitespace.  Blank lines and lines starting with # are
+// ignored.
+func loadSecretRules(path string) ([]secretRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".toml") {
+		return parseGitleaksRules(data)
+	}
+
+	rules := []secretRule{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("line %d: expected a rule ID and a regex", i+1)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(strings.TrimPrefix(line, parts[0])))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		rules = append(rules, secretRule{id: parts[0], regex: re})
+	}
+	return rules, nil
+}
+
+// gitleaksAllowlistKeys are the keys of a gitleaks allowlist.
+var gitleaksAllowlistKeys = map[string]bool{
+	"description": true, "condition": true, "regexTarget": true, "regexes": true,
+	"paths": true, "commits": true, "stopwords": true,
+}
+
+// gitleaksKeys are the keys of each table of a gitleaks config that
+// parseGitleaksRules knows: the id, regex and path of [[rules]], which it
+// reads, and the rest, which only describe rules or narrow down what they
+// find, and so can be ignored, finding more rather than less.
+var gitleaksKeys = map[string]map[string]bool{
+	"": {"title": true},
+	"[[rules]]": {
+		"id": true, "regex": true, "path": true,
+		"description": true, "keywords": true, "entropy": true, "secretGroup": true, "tags": true,
+	},
+	"[allowlist]":          gitleaksAllowlistKeys,
+	"[[allowlists]]":       gitleaksAllowlistKeys,
+	"[rules.allowlist]":    gitleaksAllowlistKeys,
+	"[[rules.allowlists]]": gitleaksAllowlistKeys,
+}
+
+// parseGitleaksRules reads the id, regex and path of each [[rules]] table
+// of a gitleaks config.  Only the parts of TOML that gitleaks configs use
+// for them are understood; allowlists and the like are ignored.  Other
+// tables and keys, e.g. [extend], which would add rules, are rejected,
+// rather than scanning for less than the config says.
+func parseGitleaksRules(data []byte) ([]secretRule, error) {
+	rules := []secretRule{}
+	var rule *secretRule
+	add := func() error {
+		if rule != nil {
+			if rule.regex == nil && rule.path == nil {
+				return fmt.Errorf("rule %q has neither regex nor path", rule.id)
+			}
+			rules = append(rules, *rule)
+		}
+		rule = nil
+		return nil
+	}
+
+	table := ""
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if _, found := gitleaksKeys[line]; !found {
+				return nil, fmt.Errorf("line %d: unsupported table %s", i+1, line)
+			}
+			// A table other than [[rules]], e.g. [rules.allowlist], ends
+			// the rule's own keys.
+			if err := add(); err != nil {
+				return nil, err
+			}
+			if table = line; table == "[[rules]]" {
+				rule = &secretRule{}
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !gitleaksKeys[table][key] {
+			if table == "" {
+				return nil, fmt.Errorf("line %d: unsupported key %s", i+1, key)
+			}
+			return nil, fmt.Errorf("line %d: unsupported key %s in %s", i+1, key, table)
+		}
+		if rule == nil || (key != "id" && key != "regex" && key != "path") {
+			// Skip the rest of an array spanning lines.
+			if strings.HasPrefix(value, "[") {
+				for !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+					i++
+					value = strings.TrimSpace(lines[i])
+				}
+			}
+			continue
+		}
+		str, err := tomlString(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if key == "id" {
+			rule.id = str
+			continue
+		}
+		re, err := regexp.Compile(str)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if key == "regex" {
+			rule.regex = re
+		} else {
+			rule.path = re
+		}
+	}
+	if err := add(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// tomlString parses a one-line TOML string: a literal string, in single or
+// triple single quotes, or a basic string, in double quotes.
+func tomlString(s string) (string, error) {
+	switch {
+	case len(s) >= 6 && strings.HasPrefix(s, "'''") && strings.HasSuffix(s, "'''"):
+		return s[3 : len(s)-3], nil
+	case len(s) >= 2 && strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'"):
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	}
+	return "", fmt.Errorf("%s is not a string", s)
+}
+
+// scanSecrets scans the files of a new revision that changed since the
+// published one (or all of them, if none is) for secrets, after checkout
+// and before decryption.  Once the revision is decrypted, hooked and
+// rendered, it scans all of its files again, but those decryption wrote.
+// Per Options.SecretScan, what is found is logged or blocks the revision.
+// Secrets themselves are never logged, only where they are.
+func (s *Syncer) scanSecrets(ctx context.Context, st *SyncState) error {
+	if s.opts.SecretScan == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "secrets")
+
+	rules := defaultSecretRules
+	if s.opts.SecretScanRules != "" {
+		var err error
+		if rules, err = loadSecretRules(s.opts.SecretScanRules); err != nil {
+			return fmt.Errorf("error loading secret scanning rules: %v", err)
+		}
+	}
+
+	var paths []string
+	if lister, ok := s.source.(ChangeLister); ok && st.OldHash != "" && !st.recheck {
+		var err error
+		if paths, err = lister.ChangedPaths(ctx, st.OldHash, st.NewHash); err != nil {
+			return fmt.Errorf("can't list changed paths to scan: %v", err)
+		}
+	} else {
+		err := filepath.Walk(st.Dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(st.Dir, path)
+			if err != nil {
+				return err
+			}
+			if rel == ".git" && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			if fi.Mode().IsRegular() && !st.decrypted[filepath.ToSlash(rel)] {
+				paths = append(paths, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error listing files to scan: %v", err)
+		}
+	}
+
+	findings := []string{}
+	for _, p := range paths {
+		found, err := scanFile(st.Dir, p, rules)
+		if err != nil {
+			return fmt.Errorf("error scanning %s for secrets: %v", p, err)
+		}
+		findings = append(findings, found...)
+	}
+	if len(findings) == 0 {
+		s.logger(ctx).V(1).Infof("found no secrets in %d files", len(paths))
+		return nil
+	}
+	if s.opts.SecretScan == SecretScanBlock {
+		return fmt.Errorf("revision %s has secrets in it: %s", st.NewHash, strings.Join(findings, ", "))
+	}
+	s.logger(ctx).V(0).Infof("WARNING: revision %s has secrets in it: %s", st.NewHash, strings.Join(findings, ", "))
+	return nil
+}
+
+// scanFile returns where rules match in the file rel, under dir, as
+// "path:line (rule)", or "path (rule)" for rules without a regex.  Deleted
+// and binary files are skipped.  Files of any size are scanned, a chunk at
+// a time.
+func scanFile(dir, rel string, rules []secretRule) ([]string, error) {
+	fi, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	findings := []string{}
+	lineRules := []secretRule{}
+	for _, r := range rules {
+		if r.path != nil && !r.path.MatchString(rel) {
+			continue
+		}
+		if r.regex == nil {
+			findings = append(findings, fmt.Sprintf("%s (%s)", rel, r.id))
+			continue
+		}
+		lineRules = append(lineRules, r)
+	}
+	if len(lineRules) == 0 {
+		return findings, nil
+	}
+
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, scanChunkSize)
+	if head, _ := r.Peek(binarySniffSize); bytes.IndexByte(head, 0) >= 0 {
+		return findings, nil
+	}
+
+	// overlap is the end of the previous chunk of a long line, and found
+	// what was found in the line so far, so that the overlap doesn't
+	// report it again.
+	var overlap []byte
+	found := map[string]bool{}
+	for n := 1; ; {
+		chunk, err := r.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return nil, err
+		}
+		text := bytes.TrimRight(chunk, "\r\n")
+		if overlap != nil {
+			text = append(overlap, text...)
+		}
+		for _, rule := range lineRules {
+			if !rule.regex.Match(text) {
+				continue
+			}
+			if finding := fmt.Sprintf("%s:%d (%s)", rel, n, rule.id); !found[finding] {
+				found[finding] = true
+				findings = append(findings, finding)
+			}
+		}
+		switch err {
+		case bufio.ErrBufferFull:
+			if len(text) > scanOverlap {
+				text = text[len(text)-scanOverlap:]
+			}
+			overlap = append([]byte{}, text...)
+		case io.EOF:
+			return findings, nil
+		default:
+			overlap = nil
+			if len(found) > 0 {
+				found = map[string]bool{}
+			}
+			n++
+		}
+	}
+}
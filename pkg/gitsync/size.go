@@ -0,0 +1,45 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkSizes refuses, after checkout, a revision with a file bigger than
+// Options.MaxFileSize or files bigger than Options.MaxTotalSize in all, so
+// that a commit of huge files doesn't fill the volume it is published in.
+func (s *Syncer) checkSizes(ctx context.Context, st *SyncState) error {
+	if s.opts.MaxFileSize == 0 && s.opts.MaxTotalSize == 0 {
+		return nil
+	}
+	ctx = withLogFields(ctx, "phase", "size")
+
+	var total int64
+	err := filepath.Walk(st.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && fi.Name() == ".git" && filepath.Dir(path) == st.Dir {
+			return filepath.SkipDir
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if s.opts.MaxFileSize > 0 && fi.Size() > s.opts.MaxFileSize {
+			rel, _ := filepath.Rel(st.Dir, path)
+			return fmt.Errorf("%s is %d bytes, over the limit of %d (--max-file-size)", filepath.ToSlash(rel), fi.Size(), s.opts.MaxFileSize)
+		}
+		total += fi.Size()
+		if s.opts.MaxTotalSize > 0 && total > s.opts.MaxTotalSize {
+			return fmt.Errorf("its files are over the limit of %d bytes in all (--max-total-size)", s.opts.MaxTotalSize)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("revision %s is too big: %v", st.NewHash, err)
+	}
+	s.logger(ctx).V(1).Infof("files of %s are %d bytes in all", st.NewHash, total)
+	return nil
+}
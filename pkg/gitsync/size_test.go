@@ -0,0 +1,44 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckSizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"small":             "12345",
+		"sub/big":           "1234567890",
+		".git/objects/huge": strings.Repeat("x", 100),
+	})
+
+	cases := []struct {
+		maxFile  int64
+		maxTotal int64
+		err      string
+	}{
+		{0, 0, ""},
+		{10, 15, ""},
+		{9, 0, "sub/big is 10 bytes, over the limit of 9"},
+		{0, 14, "over the limit of 14 bytes in all"},
+	}
+
+	for _, testCase := range cases {
+		s := &Syncer{opts: Options{MaxFileSize: testCase.maxFile, MaxTotalSize: testCase.maxTotal}}
+		err := s.checkSizes(context.Background(), &SyncState{NewHash: "one", Dir: dir})
+		if testCase.err == "" && err != nil {
+			t.Fatalf("%+v: unexpected error: %v", testCase, err)
+		}
+		if testCase.err != "" && (err == nil || !strings.Contains(err.Error(), testCase.err)) {
+			t.Fatalf("%+v: expected error %q but got %v", testCase, testCase.err, err)
+		}
+	}
+}
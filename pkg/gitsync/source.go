@@ -0,0 +1,40 @@
+package gitsync
+
+import (
+	"context"
+)
+
+// SourceProvider fetches revisions of a source tree for a Syncer to
+// publish.  The Syncer owns publishing: it picks the directory each
+// revision is materialized in, publishes it in Options.Dest and removes
+// the previous one.  git, through the git CLI, is the default provider.
+type SourceProvider interface {
+	// Resolve returns the unique, stable ID (e.g. a commit hash) of the
+	// revision that rev currently refers to upstream.
+	Resolve(ctx context.Context, rev string) (string, error)
+
+	// Materialize writes the tree of revision hash, as returned by Resolve,
+	// to dir, which is directly under Options.Root and does not exist yet.
+	Materialize(ctx context.Context, hash, dir string) error
+
+	// Cleanup is called after a previously materialized dir has been
+	// removed, to release anything the provider keeps for it.
+	Cleanup(ctx context.Context) error
+}
+
+// Fetcher is implemented by SourceProviders that download a revision
+// separately from materializing it.
+type Fetcher interface {
+	// Fetch downloads revision hash, as returned by Resolve, before it is
+	// materialized.
+	Fetch(ctx context.Context, hash string) error
+}
+
+// DirMover is implemented by SourceProviders that need to know when the
+// Syncer renames a materialized dir, e.g. to update metadata that refers
+// to it by path.
+type DirMover interface {
+	// Moved is called after a materialized dir has been renamed to dir,
+	// which is directly under Options.Root.
+	Moved(ctx context.Context, dir string) error
+}
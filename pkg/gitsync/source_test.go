@@ -0,0 +1,341 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource materializes each revision as a directory holding a file
+// named after it.
+type fakeSource struct {
+	hash     string
+	cleanups int
+	// err, if set, is returned by Resolve.
+	err error
+}
+
+func (f *fakeSource) Resolve(ctx context.Context, rev string) (string, error) {
+	return f.hash, f.err
+}
+
+func (f *fakeSource) Materialize(ctx context.Context, hash, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, hash), nil, 0644)
+}
+
+func (f *fakeSource) Cleanup(ctx context.Context) error {
+	f.cleanups++
+	return nil
+}
+
+// fakePublisher records the Events it is sent.
+type fakePublisher struct {
+	events []Event
+}
+
+func (f *fakePublisher) PublishEvent(ctx context.Context, e Event) error {
+	f.events = append(f.events, e)
+	return nil
+}
+
+func TestSyncOnceSourceProvider(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	source := &fakeSource{}
+	publisher := &fakePublisher{}
+	s := &Syncer{
+		opts:       Options{Root: root, Dest: "link", Rev: "HEAD"},
+		source:     source,
+		publishers: []EventPublisher{publisher},
+		env:        map[string]string{},
+	}
+
+	for _, hash := range []string{"one", "one", "two"} {
+		source.hash = hash
+		if err := s.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("unexpected error syncing %s: %v", hash, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "link", hash)); err != nil {
+			t.Fatalf("expected %s to be published: %v", hash, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "rev-one")); !os.IsNotExist(err) {
+		t.Fatalf("expected the previous revision to be removed but got %v", err)
+	}
+	if source.cleanups != 1 {
+		t.Fatalf("expected 1 cleanup but %d happened", source.cleanups)
+	}
+	if len(publisher.events) != 2 {
+		t.Fatalf("expected 2 events but %d were published", len(publisher.events))
+	}
+	if e := publisher.events[1]; e.OldHash != "one" || e.NewHash != "two" {
+		t.Fatalf("expected an event for one -> two but %+v was published", e)
+	}
+}
+
+func TestSyncOncePublishStrategies(t *testing.T) {
+	cases := []struct {
+		strategy string
+		// published returns the directory holding the published revision.
+		published func(root string) (string, error)
+		cleanups  int
+	}{
+		{PublishRename, func(root string) (string, error) {
+			return filepath.Join(root, "dest"), nil
+		}, 1},
+		{PublishCopy, func(root string) (string, error) {
+			return filepath.Join(root, "dest"), nil
+		}, 2},
+		{PublishPointer, func(root string) (string, error) {
+			data, err := ioutil.ReadFile(filepath.Join(root, "dest"))
+			return filepath.Join(root, strings.TrimSpace(string(data))), err
+		}, 1},
+	}
+
+	for _, testCase := range cases {
+		root, err := ioutil.TempDir("", "git-sync-test-")
+		if err != nil {
+			t.Fatalf("can't create temp dir: %v", err)
+		}
+		defer os.RemoveAll(root)
+
+		source := &fakeSource{}
+		s := &Syncer{
+			opts:   Options{Root: root, Dest: "dest", Rev: "HEAD", PublishStrategy: testCase.strategy},
+			source: source,
+			env:    map[string]string{},
+		}
+		for _, hash := range []string{"one", "one", "two"} {
+			source.hash = hash
+			if err := s.SyncOnce(context.Background()); err != nil {
+				t.Fatalf("%s: unexpected error syncing %s: %v", testCase.strategy, hash, err)
+			}
+			dir, err := testCase.published(root)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", testCase.strategy, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, hash)); err != nil {
+				t.Fatalf("%s: expected %s to be published: %v", testCase.strategy, hash, err)
+			}
+			if published, _ := s.publishedHash(); published != hash {
+				t.Fatalf("%s: expected hash %s but %s returned", testCase.strategy, hash, published)
+			}
+		}
+		files, err := ioutil.ReadDir(root)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(files) != 2 {
+			t.Fatalf("%s: expected the previous revision to be removed but found %d files", testCase.strategy, len(files))
+		}
+		dir, _ := testCase.published(root)
+		if _, err := os.Stat(filepath.Join(dir, "one")); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected the previous revision's files to be removed but got %v", testCase.strategy, err)
+		}
+		if source.cleanups != testCase.cleanups {
+			t.Fatalf("%s: expected %d cleanups but %d happened", testCase.strategy, testCase.cleanups, source.cleanups)
+		}
+	}
+}
+
+func TestSyncOnceRefResolver(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	resolved, resolveErr := "approved", error(nil)
+	s := &Syncer{
+		opts: Options{Root: root, Dest: "link", Rev: "HEAD", RefResolver: func(ctx context.Context) (string, error) {
+			return resolved, resolveErr
+		}},
+		source: &fakeSource{hash: "latest"},
+		env:    map[string]string{},
+	}
+	if err := s.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash, _ := s.publishedHash(); hash != "approved" {
+		t.Fatalf("expected the resolved hash to be published but %q was", hash)
+	}
+
+	for _, testCase := range []struct {
+		hash string
+		err  error
+	}{{"", nil}, {"other", fmt.Errorf("release system unavailable")}} {
+		resolved, resolveErr = testCase.hash, testCase.err
+		if err := s.SyncOnce(context.Background()); err == nil {
+			t.Fatalf("%q, %v: expected an error", testCase.hash, testCase.err)
+		}
+		if hash, _ := s.publishedHash(); hash != "approved" {
+			t.Fatalf("%q, %v: expected the published hash to be kept but %q was published", testCase.hash, testCase.err, hash)
+		}
+	}
+}
+
+func TestSyncOncePinnedHash(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	hash := "1077e1d717a21ec8bc4bdc1e4ec6e0cd4c0d1a8e"
+	source := &fakeSource{hash: hash}
+	s := &Syncer{
+		opts:   Options{Root: root, Dest: "link", Rev: hash},
+		source: source,
+		env:    map[string]string{},
+	}
+	if err := s.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Once published, the source isn't asked again, e.g. after a restart
+	// while the remote is unreachable.
+	source.hash = ""
+	if err := s.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if published, _ := s.publishedHash(); published != hash {
+		t.Fatalf("expected %s to stay published but %q is", hash, published)
+	}
+}
+
+func TestSyncOnceServeStale(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	source := &fakeSource{err: fmt.Errorf("fatal: unable to access 'https://example.com/repo/': Could not resolve host: example.com")}
+	s := &Syncer{
+		opts:   Options{Root: root, Dest: "link", ServeStale: true},
+		source: source,
+		env:    map[string]string{},
+	}
+	// With nothing published, there's nothing to serve.
+	if err := s.SyncOnce(context.Background()); err == nil || IsStale(err) {
+		t.Fatalf("expected a failure but %v returned", err)
+	}
+
+	source.hash, source.err = "one", nil
+	if err := s.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source.hash, source.err = "two", fmt.Errorf("ssh: connect to host example.com port 22: Connection refused")
+	if err := s.SyncOnce(context.Background()); !IsStale(err) {
+		t.Fatalf("expected a stale sync but %v returned", err)
+	}
+	if published, _ := s.publishedHash(); published != "one" {
+		t.Fatalf("expected one to stay published but %q is", published)
+	}
+
+	// Other errors are failures as usual.
+	source.err = fmt.Errorf("remote: Repository not found.")
+	if err := s.SyncOnce(context.Background()); err == nil || IsStale(err) {
+		t.Fatalf("expected a failure but %v returned", err)
+	}
+}
+
+func TestSyncOnceProfile(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	s := &Syncer{
+		opts:   Options{Root: root, Dest: "link", ProfileSyncs: true},
+		source: &fakeSource{hash: "one"},
+		env:    map[string]string{},
+	}
+	if err := s.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	profile := s.Status().LastProfile
+	if profile == nil {
+		t.Fatalf("expected a profile")
+	}
+	steps := []string{}
+	for _, step := range profile.Steps {
+		steps = append(steps, step.Name)
+	}
+	if got, exp := strings.Join(steps, ","), "resolve,fetch,checkout,chmod,swap,cleanup"; got != exp {
+		t.Fatalf("expected steps %s but %s returned", exp, got)
+	}
+}
+
+// slowSource is a fakeSource whose Resolve takes a while, and which counts
+// the most Resolves that ran at once.
+type slowSource struct {
+	fakeSource
+	mu      sync.Mutex
+	running int
+	most    int
+}
+
+func (f *slowSource) Resolve(ctx context.Context, rev string) (string, error) {
+	f.mu.Lock()
+	f.running++
+	if f.running > f.most {
+		f.most = f.running
+	}
+	f.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	f.mu.Lock()
+	f.running--
+	f.mu.Unlock()
+	return f.hash, nil
+}
+
+func TestSyncOnceSerialized(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-sync-test-")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	source := &slowSource{fakeSource: fakeSource{hash: "one"}}
+	s := &Syncer{
+		opts:   Options{Root: root, Dest: "link", Rev: "HEAD", Wait: 0.01},
+		source: source,
+		env:    map[string]string{},
+	}
+
+	errs := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- s.SyncOnce(context.Background())
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if source.most != 1 {
+		t.Fatalf("expected syncs not to overlap but %d ran at once", source.most)
+	}
+
+	s.recordSync(context.Background(), nil, 5*time.Millisecond)
+	s.recordSync(context.Background(), nil, 50*time.Millisecond)
+	if rm := s.metrics(); rm.overruns != 1 {
+		t.Fatalf("expected 1 overrun but %d counted", rm.overruns)
+	}
+}
@@ -0,0 +1,295 @@
+package gitsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shellQuote quotes s for use in GIT_SSH_COMMAND, which git runs through
+// the shell.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// checkSSHKeyFile makes sure that ssh will accept the key at path.
+func checkSSHKeyFile(path string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error: could not find SSH key Secret: %v", err)
+	}
+
+	// Windows has no permission bits; OpenSSH checks the file's ACL instead.
+	if sshKeyModeChecked && fileInfo.Mode() != 0400 {
+		return fmt.Errorf("Permissions %s for SSH key are too open. It is recommended to mount secret volume with `defaultMode: 256` (decimal number for octal 0400).", fileInfo.Mode())
+	}
+	return nil
+}
+
+// sshCommand builds the ssh command line for git to use, offering the
+// private keys in keyFiles.
+func (s *Syncer) sshCommand(ctx context.Context, keyFiles []string) ([]string, error) {
+	args := []string{"ssh", "-q"}
+
+	if s.opts.SSHConfigFile != "" {
+		if _, err := os.Stat(s.opts.SSHConfigFile); err != nil {
+			return nil, fmt.Errorf("error: could not find SSH config: %v", err)
+		}
+		args = append(args, "-F", s.opts.SSHConfigFile)
+	}
+
+	if s.opts.SSHProxyCommand != "" {
+		args = append(args, "-o", "ProxyCommand="+s.opts.SSHProxyCommand)
+	}
+	if s.opts.SSHProxyJump != "" {
+		args = append(args, "-J", s.opts.SSHProxyJump)
+	}
+
+	if len(s.opts.SSHHostFingerprints) > 0 {
+		knownHostsFile, err := s.writePinnedKnownHosts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile="+knownHostsFile)
+	} else if s.opts.SSHKnownHosts {
+		if _, err := os.Stat(s.opts.SSHKnownHostsFile); err != nil {
+			return nil, fmt.Errorf("error: could not find SSH known_hosts Secret: %v", err)
+		}
+		args = append(args, "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile="+s.opts.SSHKnownHostsFile)
+	} else {
+		s.logger(ctx).V(0).Infof("WARNING: SSH host keys are not being verified (--ssh-known-hosts=false)")
+		args = append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	}
+
+	if s.opts.SSHControlPersist > 0 {
+		// One connection serves every ls-remote and fetch while it lasts,
+		// across syncs if it outlasts the wait, rather than each doing its
+		// own handshake.  A short directory and %C keep the socket path
+		// under the limit for Unix sockets.
+		if s.sshControlDir == "" {
+			dir, err := ioutil.TempDir("", "git-sync-ssh-")
+			if err != nil {
+				return nil, fmt.Errorf("error creating SSH control socket directory: %v", err)
+			}
+			s.sshControlDir = dir
+		}
+		args = append(args,
+			"-o", "ControlMaster=auto",
+			"-o", "ControlPath="+filepath.Join(s.sshControlDir, "%C"),
+			"-o", fmt.Sprintf("ControlPersist=%d", int(math.Ceil(s.opts.SSHControlPersist))))
+	}
+
+	for _, keyFile := range keyFiles {
+		if err := checkSSHKeyFile(keyFile); err != nil {
+			return nil, err
+		}
+		args = append(args, "-i", keyFile)
+	}
+
+	return args, nil
+}
+
+// setupGitSSH points GIT_SSH_COMMAND at an ssh command configured from the
+// flags, offering the private keys in keyFiles.
+func (s *Syncer) setupGitSSH(ctx context.Context, keyFiles []string) error {
+	s.logger(ctx).V(1).Infof("setting up git SSH credentials")
+
+	args, err := s.sshCommand(ctx, keyFiles)
+	if err != nil {
+		return err
+	}
+	quoted := make([]string, len(args))
+	for i := range args {
+		quoted[i] = shellQuote(args[i])
+	}
+
+	if s.opts.SSHAuthSock != "" {
+		fi, err := os.Stat(s.opts.SSHAuthSock)
+		if err != nil {
+			return fmt.Errorf("error: could not find ssh-agent socket: %v", err)
+		}
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("error: %s is not a socket", s.opts.SSHAuthSock)
+		}
+		s.setEnv("SSH_AUTH_SOCK", s.opts.SSHAuthSock)
+	}
+
+	//set env variable GIT_SSH_COMMAND to force git use customized ssh command
+	s.setEnv("GIT_SSH_COMMAND", strings.Join(quoted, " "))
+	return nil
+}
+
+// sshHostPort returns the host and port (empty if not given) of an SSH repo
+// URL, in either "ssh://[user@]host[:port]/path" or scp-like
+// "[user@]host:path" form.
+func sshHostPort(repo string) (string, string, error) {
+	if strings.Contains(repo, "://") {
+		u, err := url.Parse(repo)
+		if err != nil {
+			return "", "", fmt.Errorf("can't parse repo URL: %v", err)
+		}
+		if u.Scheme != "ssh" && u.Scheme != "git+ssh" {
+			return "", "", fmt.Errorf("%q is not an SSH URL", repo)
+		}
+		return u.Hostname(), u.Port(), nil
+	}
+
+	colon := strings.Index(repo, ":")
+	if colon < 0 {
+		return "", "", fmt.Errorf("%q is not an SSH URL", repo)
+	}
+	host := repo[:colon]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("%q is not an SSH URL", repo)
+	}
+	return host, "", nil
+}
+
+// sshFingerprint returns the OpenSSH SHA256 fingerprint of a base64 encoded
+// public key, e.g. "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8".
+func sshFingerprint(key string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid SSH public key: %v", err)
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "="), nil
+}
+
+// pinnedHostKeys filters the known_hosts lines from ssh-keyscan down to
+// those whose key matches one of fingerprints.
+func pinnedHostKeys(keyscan string, fingerprints []string) []string {
+	want := map[string]bool{}
+	for _, fp := range fingerprints {
+		want[strings.TrimRight(fp, "=")] = true
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(keyscan, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		fp, err := sshFingerprint(fields[2])
+		if err != nil {
+			continue
+		}
+		if want[fp] {
+			lines = append(lines, strings.Join(fields[:3], " "))
+		}
+	}
+	return lines
+}
+
+// writePinnedKnownHosts scans the git server's host keys and writes those
+// matching --ssh-host-fingerprint to a known_hosts file, whose path it
+// returns.  The scan itself is unauthenticated; the pinned fingerprints are
+// what make the result trustworthy.
+func (s *Syncer) writePinnedKnownHosts(ctx context.Context) (string, error) {
+	host, port, err := sshHostPort(s.opts.Repo)
+	if err != nil {
+		return "", err
+	}
+	args := []string{}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, host)
+	output, err := s.runCommand(ctx, "", "ssh-keyscan", args...)
+	if err != nil {
+		return "", fmt.Errorf("error scanning SSH host keys: %v", err)
+	}
+
+	lines := pinnedHostKeys(output, s.opts.SSHHostFingerprints)
+	if len(lines) == 0 {
+		return "", fmt.Errorf("no SSH host key for %s matches --ssh-host-fingerprint", host)
+	}
+
+	path := filepath.Join(os.TempDir(), "git-sync-known-hosts")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("error writing known_hosts: %v", err)
+	}
+	s.logger(ctx).V(1).Infof("pinned %d SSH host key(s) for %s", len(lines), host)
+	return path, nil
+}
+
+// setupSSHKeyPassphrase points SSH_ASKPASS at this binary, answering ssh's
+// key passphrase prompts with passphrase.
+func (s *Syncer) setupSSHKeyPassphrase(ctx context.Context, passphrase string) error {
+	s.logger(ctx).V(1).Infof("setting up SSH key passphrase")
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("can't find git-sync executable: %v", err)
+	}
+
+	env := map[string]string{
+		"SSH_ASKPASS": self,
+		// Use SSH_ASKPASS even without a display (OpenSSH 8.4+); older
+		// versions need DISPLAY set instead.
+		"SSH_ASKPASS_REQUIRE": "force",
+		"DISPLAY":             os.Getenv("DISPLAY"),
+		askpassHelperEnv:      "true",
+		askpassPassphraseEnv:  passphrase,
+	}
+	if env["DISPLAY"] == "" {
+		env["DISPLAY"] = ":0"
+	}
+	for k, v := range env {
+		s.setEnv(k, v)
+	}
+	return nil
+}
+
+// sshKeyPassphrase returns the passphrase for the SSH keys, read from
+// --ssh-key-passphrase-file if set.
+func (s *Syncer) sshKeyPassphrase() (string, error) {
+	if s.opts.SSHKeyPassphraseFile == "" {
+		return s.opts.SSHKeyPassphrase, nil
+	}
+	data, err := ioutil.ReadFile(s.opts.SSHKeyPassphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading SSH key passphrase: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// sshKeyFiles returns the private keys to offer.  The default key is
+// optional when an ssh_config or ssh-agent may supply keys instead.
+func (s *Syncer) sshKeyFiles() []string {
+	keyFiles := s.opts.SSHKeyFiles
+	if (s.opts.SSHConfigFile != "" || s.opts.SSHAuthSock != "") && len(keyFiles) == 1 && keyFiles[0] == DefaultSSHKeyFile {
+		if _, err := os.Stat(DefaultSSHKeyFile); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	return keyFiles
+}
+
+// setupSSH configures git's SSH connections from the flags.
+func (s *Syncer) setupSSH(ctx context.Context) error {
+	if err := s.setupGitSSH(ctx, s.sshKeyFiles()); err != nil {
+		return err
+	}
+	passphrase, err := s.sshKeyPassphrase()
+	if err != nil {
+		return err
+	}
+	if passphrase != "" {
+		return s.setupSSHKeyPassphrase(ctx, passphrase)
+	}
+	return nil
+}
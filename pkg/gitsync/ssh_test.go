@@ -0,0 +1,89 @@
+package gitsync
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSSHHostPort(t *testing.T) {
+	cases := []struct {
+		repo string
+		host string
+		port string
+		err  bool
+	}{
+		{"git@github.com:kubernetes/git-sync.git", "github.com", "", false},
+		{"github.com:kubernetes/git-sync.git", "github.com", "", false},
+		{"ssh://git@example.com:2222/repo.git", "example.com", "2222", false},
+		{"ssh://example.com/repo.git", "example.com", "", false},
+		{"https://github.com/kubernetes/git-sync", "", "", true},
+		{"/local/repo", "", "", true},
+	}
+
+	for _, testCase := range cases {
+		host, port, err := sshHostPort(testCase.repo)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%q: expected error %v but got %v", testCase.repo, testCase.err, err)
+		}
+		if host != testCase.host || port != testCase.port {
+			t.Fatalf("%q: expected %q/%q but %q/%q returned", testCase.repo, testCase.host, testCase.port, host, port)
+		}
+	}
+}
+
+func TestPinnedHostKeys(t *testing.T) {
+	// github.com's published ed25519 host key.
+	key := "AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	fp := "SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU"
+
+	keyscan := "# github.com:22 SSH-2.0-babeld\ngithub.com ssh-ed25519 " + key + "\ngithub.com ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ==\n"
+
+	got := pinnedHostKeys(keyscan, []string{fp})
+	exp := []string{"github.com ssh-ed25519 " + key}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v but %v returned", exp, got)
+	}
+
+	got = pinnedHostKeys(keyscan, []string{"SHA256:nope"})
+	if len(got) != 0 {
+		t.Fatalf("expected no keys but %v returned", got)
+	}
+}
+
+func TestSSHControlPersist(t *testing.T) {
+	ctx := context.Background()
+	s := &Syncer{opts: Options{SSHControlPersist: 90.5}}
+	controlOpts := func() []string {
+		args, err := s.sshCommand(ctx, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		opts := []string{}
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "Control") {
+				opts = append(opts, arg)
+			}
+		}
+		return opts
+	}
+
+	first := controlOpts()
+	defer os.RemoveAll(s.sshControlDir)
+	exp := []string{"ControlMaster=auto", "ControlPath=" + s.sshControlDir + "/%C", "ControlPersist=91"}
+	if !reflect.DeepEqual(first, exp) {
+		t.Fatalf("expected %v but %v returned", exp, first)
+	}
+	// The same connection must be found again, e.g. after the SSH key is
+	// replaced.
+	if second := controlOpts(); !reflect.DeepEqual(second, first) {
+		t.Fatalf("expected %v but %v returned", first, second)
+	}
+
+	s = &Syncer{}
+	if got := controlOpts(); len(got) != 0 {
+		t.Fatalf("expected no control options but %v returned", got)
+	}
+}
@@ -0,0 +1,69 @@
+package gitsync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// transientErrors are what git and ssh print when the remote can't be
+// reached for now, as opposed to e.g. refusing the credentials.
+var transientErrors = []string{
+	"could not resolve host",
+	"could not resolve hostname",
+	"temporary failure in name resolution",
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"operation timed out",
+	"failed to connect to",
+	"network is unreachable",
+	"no route to host",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"operation too slow",
+	"the requested url returned error: 502",
+	"the requested url returned error: 503",
+	"the requested url returned error: 504",
+	"command timed out",
+}
+
+// staleError is returned by a sync that couldn't reach the remote, with
+// Options.ServeStale, after which the previously published revision is
+// still served.
+type staleError struct {
+	reason error
+}
+
+func (e staleError) Error() string {
+	return fmt.Sprintf("serving the published revision while the remote is unreachable: %v", e.reason)
+}
+
+// IsStale returns true if err is from a sync that failed only because the
+// remote couldn't be reached, and left the previously published revision
+// in place.
+func IsStale(err error) bool {
+	_, ok := err.(staleError)
+	return ok
+}
+
+// isTransient returns true if err looks like the remote couldn't be
+// reached for now.
+func isTransient(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, e := range transientErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// staleIfTransient returns err as a staleError if Options.ServeStale is
+// set, a revision is published, and err looks transient.
+func (s *Syncer) staleIfTransient(published string, err error) error {
+	if err == nil || !s.opts.ServeStale || published == "" || IsSkipped(err) || !isTransient(err) {
+		return err
+	}
+	return staleError{err}
+}
@@ -0,0 +1,118 @@
+package gitsync
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+)
+
+// statsdClient sends metrics to a StatsD or DogStatsD agent over UDP.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	// tags sends the repo name and outcome as DogStatsD tags, rather than
+	// as parts of the metric names.
+	tags bool
+}
+
+// statsdUnsafe matches what can't be part of a StatsD metric name.
+var statsdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// SetStatsd makes the Manager send the metrics of a repo, as for
+// WriteMetrics, to the StatsD agent at addr (host:port) after each of its
+// syncs, with metric names starting with prefix.  With dogstatsd, the repo
+// name and outcome are sent as DogStatsD tags; otherwise they are part of
+// the names, e.g. prefix.NAME.count.success.  addr "" stops sending.
+func (m *Manager) SetStatsd(addr, prefix string, dogstatsd bool) error {
+	var c *statsdClient
+	if addr != "" {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			return fmt.Errorf("error connecting to statsd: %v", err)
+		}
+		c = &statsdClient{conn: conn, prefix: prefix, tags: dogstatsd}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.statsd != nil {
+		m.statsd.conn.Close()
+	}
+	m.statsd = c
+	return nil
+}
+
+// sendStatsd sends the metrics of s, after a sync that took d and failed
+// with err, if statsd is set.
+func (m *Manager) sendStatsd(s *Syncer, err error, d time.Duration) error {
+	m.mu.Lock()
+	c := m.statsd
+	m.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+
+	rm := s.metrics()
+	rm.status = s.Status()
+	outcome := syncSuccess
+	if err != nil {
+		outcome = syncError
+	}
+	if _, err := c.conn.Write(c.format(rm, outcome, d)); err != nil {
+		return fmt.Errorf("error sending metrics to statsd: %v", err)
+	}
+	return nil
+}
+
+// format formats the metrics of a sync of rm with outcome, which took d, as
+// one StatsD packet.  Counters are sent as increments; the totals kept for
+// Prometheus are sent as gauges.
+func (c *statsdClient) format(rm repoMetrics, outcome string, d time.Duration) []byte {
+	buf := &bytes.Buffer{}
+	line := func(metric, value, typ string, withOutcome bool) {
+		name := c.prefix + "."
+		if !c.tags {
+			name += statsdUnsafe.ReplaceAllString(rm.name, "_") + "."
+		}
+		name += metric
+		if withOutcome && !c.tags {
+			name += "." + outcome
+		}
+		fmt.Fprintf(buf, "%s:%s|%s", name, value, typ)
+		if c.tags {
+			fmt.Fprintf(buf, "|#name:%s", statsdUnsafe.ReplaceAllString(rm.name, "_"))
+			if withOutcome {
+				fmt.Fprintf(buf, ",status:%s", outcome)
+			}
+		}
+		buf.WriteString("\n")
+	}
+
+	line("count", "1", "c", true)
+	line("duration", fmt.Sprintf("%d", d/time.Millisecond), "ms", true)
+	ts := 0.0
+	if !rm.status.LastSuccess.IsZero() {
+		ts = float64(rm.status.LastSuccess.UnixNano()) / float64(time.Second)
+	}
+	line("last_success_timestamp_seconds", fmt.Sprintf("%.3f", ts), "g", false)
+	line("consecutive_failures", fmt.Sprintf("%d", rm.status.ConsecutiveFail), "g", false)
+	line("paused", fmt.Sprintf("%d", boolMetric(rm.status.Paused)), "g", false)
+	line("stale", fmt.Sprintf("%d", boolMetric(rm.status.Stale)), "g", false)
+	line("overruns_total", fmt.Sprintf("%d", rm.overruns), "g", false)
+	for _, kind := range []string{"webhook", "reload"} {
+		if n, found := rm.deliveryFailures[kind]; found {
+			line(kind+"_failures_total", fmt.Sprintf("%d", n), "g", false)
+		}
+	}
+	if rm.policy {
+		line("policy_denials_total", fmt.Sprintf("%d", rm.policyDenials), "g", false)
+	}
+	for _, result := range []string{cacheHit, cacheClone} {
+		if n, found := rm.cacheUses[result]; found {
+			line("cache_"+result+"_total", fmt.Sprintf("%d", n), "g", false)
+		}
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
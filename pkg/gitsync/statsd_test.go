@@ -0,0 +1,43 @@
+package gitsync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsdFormat(t *testing.T) {
+	rm := repoMetrics{
+		name:             "a",
+		status:           Status{ConsecutiveFail: 2},
+		overruns:         3,
+		deliveryFailures: map[string]int{"webhook": 1},
+	}
+	cases := []struct {
+		tags     bool
+		expected string
+	}{
+		{false, "gs.a.count.error:1|c\n" +
+			"gs.a.duration.error:1500|ms\n" +
+			"gs.a.last_success_timestamp_seconds:0.000|g\n" +
+			"gs.a.consecutive_failures:2|g\n" +
+			"gs.a.paused:0|g\n" +
+			"gs.a.stale:0|g\n" +
+			"gs.a.overruns_total:3|g\n" +
+			"gs.a.webhook_failures_total:1|g"},
+		{true, "gs.count:1|c|#name:a,status:error\n" +
+			"gs.duration:1500|ms|#name:a,status:error\n" +
+			"gs.last_success_timestamp_seconds:0.000|g|#name:a\n" +
+			"gs.consecutive_failures:2|g|#name:a\n" +
+			"gs.paused:0|g|#name:a\n" +
+			"gs.stale:0|g|#name:a\n" +
+			"gs.overruns_total:3|g|#name:a\n" +
+			"gs.webhook_failures_total:1|g|#name:a"},
+	}
+
+	for _, testCase := range cases {
+		c := &statsdClient{prefix: "gs", tags: testCase.tags}
+		if got := string(c.format(rm, syncError, 1500*time.Millisecond)); got != testCase.expected {
+			t.Fatalf("expected %q but %q returned", testCase.expected, got)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Status is a snapshot of a Syncer's progress.
+type Status struct {
+	Name string `json:"name"`
+	Repo string `json:"repo"`
+	Rev  string `json:"rev"`
+	// Hash is the published revision, if any.
+	Hash string `json:"hash,omitempty"`
+	// Staged is the revision in Options.CanaryLink waiting to be
+	// published, if any.
+	Staged string `json:"staged,omitempty"`
+
+	LastSync        time.Time `json:"lastSync"`
+	LastSuccess     time.Time `json:"lastSuccess"`
+	LastError       string    `json:"lastError,omitempty"`
+	ConsecutiveFail int       `json:"consecutiveFailures"`
+	// Stale is set while the remote can't be reached, and the published
+	// revision may be out of date, with Options.ServeStale.
+	Stale bool `json:"stale"`
+	// LastProfile is where the time of the last sync that got as far as
+	// resolving went, with Options.ProfileSyncs.
+	LastProfile *SyncProfile `json:"lastProfile,omitempty"`
+	// Transfer is how far the clone or fetch in progress, if any, has got.
+	Transfer *TransferProgress `json:"transfer,omitempty"`
+
+	Paused bool `json:"paused"`
+	// Stopped is set once the Syncer's loop has exited, e.g. after too
+	// many failures.
+	Stopped bool `json:"stopped"`
+}
+
+// recordSync updates the status and metrics after a sync attempt that took
+// d.
+func (s *Syncer) recordSync(ctx context.Context, err error, d time.Duration) {
+	hash, _ := s.publishedHash()
+
+	outcome := syncSuccess
+	if err != nil {
+		outcome = syncError
+	}
+
+	overrun := s.opts.Wait > 0 && d > waitTime(s.opts.Wait)
+	if overrun {
+		s.logger(ctx).V(0).Infof("sync took %v, longer than --wait (%v); the next sync waits for it", d, waitTime(s.opts.Wait))
+	}
+
+	s.mu.Lock()
+	if overrun {
+		s.overruns++
+	}
+	if s.stats == nil {
+		s.stats = map[string]syncStats{}
+	}
+	st := s.stats[outcome]
+	st.count++
+	st.duration += d
+	s.stats[outcome] = st
+	s.status.Hash = hash
+	s.status.Staged = ""
+	if staged := s.stagedHash(); staged != hash {
+		s.status.Staged = staged
+	}
+	s.status.LastSync = time.Now()
+	s.status.Stale = IsStale(err)
+	if err != nil {
+		s.status.LastError = err.Error()
+		s.status.ConsecutiveFail++
+	} else {
+		s.status.LastSuccess = s.status.LastSync
+		s.status.LastError = ""
+		s.status.ConsecutiveFail = 0
+	}
+	s.mu.Unlock()
+
+	if s.opts.StatusFile != "" {
+		if err := writeStatusFile(s.opts.StatusFile, s.Status()); err != nil {
+			s.logger(ctx).Errorf("%v", err)
+		}
+	}
+}
+
+// writeStatusFile replaces the file at path with st, as JSON.
+func writeStatusFile(path string, st Status) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("error encoding status: %v", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error writing status file: %v", err)
+	}
+	return nil
+}
+
+// ReadStatusFile returns the status written to path by a Syncer with
+// Options.StatusFile set.
+func ReadStatusFile(path string) (Status, error) {
+	var st Status
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("error parsing status file %s: %v", path, err)
+	}
+	return st, nil
+}
+
+// Synced returns true if the status shows a successful sync of rev, which
+// may be the configured rev or (a prefix of) the published hash.  An empty
+// rev matches any.
+func (st Status) Synced(rev string) bool {
+	if st.LastSuccess.IsZero() || st.Hash == "" {
+		return false
+	}
+	return rev == "" || rev == st.Rev || strings.HasPrefix(st.Hash, rev)
+}
+
+// Status returns the Syncer's current status.
+func (s *Syncer) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	st.Name = s.opts.Name
+	st.Repo = s.opts.Repo
+	st.Rev = s.opts.Rev
+	st.Paused = s.paused
+	return st
+}
+
+// SyncNow cuts short the wait before the next sync.  It fails if the
+// Syncer is paused.
+func (s *Syncer) SyncNow() error {
+	if s.Paused() {
+		return fmt.Errorf("repo %q is paused", s.opts.Name)
+	}
+	s.poke()
+	return nil
+}
+
+// Pause stops syncing, after any sync in progress, until Resume.
+func (s *Syncer) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// Resume undoes Pause, syncing right away.
+func (s *Syncer) Resume() {
+	s.mu.Lock()
+	s.paused = false
+	s.mu.Unlock()
+	s.poke()
+}
+
+// Paused returns true if the Syncer is paused.
+func (s *Syncer) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// poke wakes the loop if it is waiting between syncs.
+func (s *Syncer) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,63 @@
+package gitsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatusSynced(t *testing.T) {
+	synced := Status{Rev: "v1.0", Hash: "abcdef1234", LastSuccess: time.Now()}
+
+	cases := []struct {
+		status Status
+		rev    string
+		out    bool
+	}{
+		{synced, "", true},
+		{synced, "v1.0", true},
+		{synced, "abcdef", true},
+		{synced, "abcdef1234", true},
+		{synced, "v2.0", false},
+		{synced, "1234", false},
+		{Status{Rev: "v1.0", LastError: "no such rev"}, "", false},
+		{Status{Rev: "v1.0", Hash: "abcdef1234"}, "v1.0", false},
+	}
+
+	for _, testCase := range cases {
+		if out := testCase.status.Synced(testCase.rev); out != testCase.out {
+			t.Fatalf("%+v %q: expected %v but %v returned", testCase.status, testCase.rev, testCase.out, out)
+		}
+	}
+}
+
+func TestStatusFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "status.json")
+
+	if _, err := ReadStatusFile(path); !os.IsNotExist(err) {
+		t.Fatalf("expected a not-exist error but %v returned", err)
+	}
+	for _, hash := range []string{"abc", "def"} {
+		in := Status{Name: "repo", Hash: hash, LastSuccess: time.Now().UTC()}
+		if err := writeStatusFile(path, in); err != nil {
+			t.Fatal(err)
+		}
+		out, err := ReadStatusFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Hash != in.Hash || !out.LastSuccess.Equal(in.LastSuccess) {
+			t.Fatalf("expected %+v but %+v returned", in, out)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expected only the status file but found %d files", len(files))
+	}
+}
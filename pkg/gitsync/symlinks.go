@@ -0,0 +1,71 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkSymlinks refuses, after checkout, a revision holding absolute
+// symlinks or symlinks that resolve outside of it, which would let a commit
+// expose the rest of the filesystem to consumers, unless
+// Options.AllowEscapingSymlinks is set.
+func (s *Syncer) checkSymlinks(ctx context.Context, st *SyncState) error {
+	if s.opts.AllowEscapingSymlinks {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(st.Dir)
+	if err != nil {
+		return err
+	}
+
+	escaping := []string{}
+	err = filepath.Walk(st.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(st.Dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == ".git" && fi.IsDir() {
+			return filepath.SkipDir
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(target) || !symlinkInside(root, filepath.Join(root, rel)) {
+			escaping = append(escaping, fmt.Sprintf("%s -> %s", filepath.ToSlash(rel), target))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error checking symlinks: %v", err)
+	}
+	if len(escaping) > 0 {
+		return fmt.Errorf("revision %s has symlinks escaping the repo: %s", st.NewHash, strings.Join(escaping, ", "))
+	}
+	return nil
+}
+
+// symlinkInside returns true if the symlink at path, under root, resolves
+// to root or below it.  A dangling symlink is checked as far as the path
+// of its target goes.
+func symlinkInside(root, path string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return false
+		}
+		resolved = filepath.Join(filepath.Dir(path), target)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
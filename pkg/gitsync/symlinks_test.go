@@ -0,0 +1,53 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSymlinks(t *testing.T) {
+	cases := []struct {
+		links map[string]string
+		allow bool
+		err   string
+	}{
+		{map[string]string{"link": "a/file"}, false, ""},
+		{map[string]string{"a/link": "../a/file", "a/up": ".."}, false, ""},
+		{map[string]string{"dangling": "a/missing"}, false, ""},
+		{map[string]string{"abs": "/etc/passwd"}, false, "abs -> /etc/passwd"},
+		{map[string]string{"a/out": "../../outside"}, false, "a/out -> ../../outside"},
+		{map[string]string{"dangling": "../missing"}, false, "dangling -> ../missing"},
+		// Each link stays inside, but together they lead out.
+		{map[string]string{"a/b": "..", "c": "a/b/.."}, false, "c -> a/b/.."},
+		{map[string]string{"abs": "/etc/passwd"}, true, ""},
+	}
+
+	for _, testCase := range cases {
+		parent, err := ioutil.TempDir("", "git-sync-test-")
+		if err != nil {
+			t.Fatalf("can't create temp dir: %v", err)
+		}
+		defer os.RemoveAll(parent)
+		dir := filepath.Join(parent, "rev-one")
+		writeTree(t, dir, map[string]string{"a/file": "x", "outside": ""})
+		os.Rename(filepath.Join(dir, "outside"), filepath.Join(parent, "outside"))
+		for link, target := range testCase.links {
+			if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+				t.Fatalf("can't create symlink: %v", err)
+			}
+		}
+
+		s := &Syncer{opts: Options{AllowEscapingSymlinks: testCase.allow}}
+		err = s.checkSymlinks(context.Background(), &SyncState{NewHash: "one", Dir: dir})
+		if testCase.err == "" && err != nil {
+			t.Fatalf("%v: unexpected error: %v", testCase.links, err)
+		}
+		if testCase.err != "" && (err == nil || !strings.Contains(err.Error(), testCase.err)) {
+			t.Fatalf("%v: expected error %q but got %v", testCase.links, testCase.err, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package gitsync
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// setupGitTLSClientCert configures git to present the PEM client
+// certificate and key at certFile and keyFile.  git reads them on every
+// command, so a rotated Secret is picked up without a restart.
+func (s *Syncer) setupGitTLSClientCert(ctx context.Context, certFile, keyFile string) error {
+	s.logger(ctx).V(1).Infof("setting up git TLS client certificate")
+
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return fmt.Errorf("invalid TLS client certificate: %v", err)
+	}
+
+	env := map[string]string{
+		"GIT_SSL_CERT": certFile,
+		"GIT_SSL_KEY":  keyFile,
+	}
+	for k, v := range env {
+		s.setEnv(k, v)
+	}
+	return nil
+}
+
+// setupGitCACert configures git to verify HTTPS git servers against the PEM
+// CA bundle at caFile, in place of the system CAs.
+func (s *Syncer) setupGitCACert(ctx context.Context, caFile string) error {
+	s.logger(ctx).V(1).Infof("setting up git CA bundle %s", caFile)
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("error reading CA bundle: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no PEM certificates found in %s", caFile)
+	}
+
+	s.setEnv("GIT_SSL_CAINFO", caFile)
+	return nil
+}
+
+// setupGitInsecureSkipTLSVerify turns off verification of HTTPS git
+// servers' certificates.
+func (s *Syncer) setupGitInsecureSkipTLSVerify(ctx context.Context) error {
+	s.logger(ctx).V(0).Infof("WARNING: TLS certificate verification is disabled (--insecure-skip-tls-verify); git traffic can be intercepted or tampered with")
+
+	s.setEnv("GIT_SSL_NO_VERIFY", "true")
+	return nil
+}
@@ -0,0 +1,306 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// tokenRefreshMargin is how long before expiry a cached token is
+	// considered stale, so that it can't expire mid-fetch.
+	tokenRefreshMargin = 2 * time.Minute
+
+	tokenTimeout = 10 * time.Second
+)
+
+// cachedToken is an access token and the time at which it expires.  A zero
+// expiry means the token does not expire.
+type cachedToken struct {
+	value  string
+	expiry time.Time
+}
+
+// valid returns true if the token can still be used for a sync.
+func (t *cachedToken) valid() bool {
+	if t.value == "" {
+		return false
+	}
+	return t.expiry.IsZero() || time.Now().Add(tokenRefreshMargin).Before(t.expiry)
+}
+
+const (
+	gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/%s/token"
+
+	azureIMDSTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// azureDevOpsResource is the well-known Azure AD application ID of Azure
+	// DevOps.
+	azureDevOpsResource = "499b84ac-1321-427f-aa17-267ca6975798"
+)
+
+// getMetadataToken fetches a token from a cloud instance metadata server,
+// sending header on the request.
+func getMetadataToken(ctx context.Context, tokenURL string, header http.Header) (cachedToken, error) {
+	req, err := http.NewRequest("GET", tokenURL, nil)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header = header
+
+	client := &http.Client{Timeout: tokenTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("error calling metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("error reading metadata server response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cachedToken{}, fmt.Errorf("metadata server returned status %d: %q", resp.StatusCode, string(body))
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return cachedToken{}, fmt.Errorf("error decoding metadata server response: %v", err)
+	}
+	return tr.token()
+}
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenResponse is the subset of an OAuth2 token endpoint response that we
+// use.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn is a number of seconds, which some servers (e.g. Azure IMDS)
+	// send as a string.
+	ExpiresIn json.Number `json:"expires_in"`
+}
+
+// token converts the response into a cachedToken.
+func (r tokenResponse) token() (cachedToken, error) {
+	if r.AccessToken == "" {
+		return cachedToken{}, fmt.Errorf("token response has no access_token")
+	}
+	t := cachedToken{value: r.AccessToken}
+	if r.ExpiresIn != "" {
+		secs, err := r.ExpiresIn.Int64()
+		if err != nil {
+			return cachedToken{}, fmt.Errorf("invalid expires_in in token response: %v", err)
+		}
+		if secs > 0 {
+			t.expiry = time.Now().Add(time.Duration(secs) * time.Second)
+		}
+	}
+	return t, nil
+}
+
+// postTokenRequest sends form to tokenURL and decodes the token response.
+// If clientID is set, it is sent with clientSecret as HTTP basic auth.
+func postTokenRequest(ctx context.Context, tokenURL string, form url.Values, clientID, clientSecret string) (cachedToken, error) {
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return cachedToken{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	client := &http.Client{Timeout: tokenTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("error calling token endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("error reading token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cachedToken{}, fmt.Errorf("token endpoint returned status %d: %q", resp.StatusCode, string(body))
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return cachedToken{}, fmt.Errorf("error decoding token response: %v", err)
+	}
+	return tr.token()
+}
+
+// fetchOAuth2Token runs the OAuth2 client-credentials flow.
+func (s *Syncer) fetchOAuth2Token(ctx context.Context) (cachedToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if s.opts.OAuth2Scope != "" {
+		form.Set("scope", s.opts.OAuth2Scope)
+	}
+	return postTokenRequest(ctx, s.opts.OAuth2TokenURL, form, s.opts.OAuth2ClientID, s.opts.OAuth2ClientSecret)
+}
+
+// refreshOAuth2Token fetches a new OAuth2 token if the cached one is missing
+// or about to expire, and hands it to git as a bearer token.
+func (s *Syncer) refreshOAuth2Token(ctx context.Context) error {
+	if s.oauth2Token.valid() {
+		return nil
+	}
+
+	s.logger(ctx).V(1).Infof("fetching OAuth2 token from %s", s.opts.OAuth2TokenURL)
+	t, err := s.fetchOAuth2Token(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.setupGitBearerToken(ctx, t.value, s.opts.Repo); err != nil {
+		return err
+	}
+	s.oauth2Token = t
+	return nil
+}
+
+// fetchSTSToken exchanges the workload's OIDC token (e.g. a projected
+// Kubernetes service account token) for a git access token, per RFC 8693.
+// The OIDC token file is re-read each time, since it is rotated.
+func (s *Syncer) fetchSTSToken(ctx context.Context) (cachedToken, error) {
+	subject, err := ioutil.ReadFile(s.opts.STSSubjectTokenFile)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("error reading OIDC token: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", tokenExchangeGrantType)
+	form.Set("subject_token", strings.TrimSpace(string(subject)))
+	form.Set("subject_token_type", jwtTokenType)
+	form.Set("requested_token_type", accessTokenType)
+	if s.opts.STSAudience != "" {
+		form.Set("audience", s.opts.STSAudience)
+	}
+	if s.opts.STSScope != "" {
+		form.Set("scope", s.opts.STSScope)
+	}
+	return postTokenRequest(ctx, s.opts.STSURL, form, "", "")
+}
+
+// refreshSTSToken exchanges a new token if the cached one is missing or
+// about to expire.  It is handed to git as a bearer token, or as a basic
+// auth password if a username was configured.
+func (s *Syncer) refreshSTSToken(ctx context.Context) error {
+	if s.stsToken.valid() {
+		return nil
+	}
+
+	s.logger(ctx).V(1).Infof("exchanging OIDC token at %s", s.opts.STSURL)
+	t, err := s.fetchSTSToken(ctx)
+	if err != nil {
+		return err
+	}
+	if s.opts.STSTokenUsername != "" {
+		err = s.setAskpassCredentials(s.opts.STSTokenUsername, t.value)
+	} else {
+		err = s.setupGitBearerToken(ctx, t.value, s.opts.Repo)
+	}
+	if err != nil {
+		return err
+	}
+	s.stsToken = t
+	return nil
+}
+
+// fetchGCPToken gets an access token for the GCP service account from the
+// GCE/GKE metadata server, which also serves workload identity.
+func (s *Syncer) fetchGCPToken(ctx context.Context) (cachedToken, error) {
+	header := http.Header{}
+	header.Set("Metadata-Flavor", "Google")
+	return getMetadataToken(ctx, fmt.Sprintf(gcpMetadataTokenURL, s.opts.GCPServiceAccount), header)
+}
+
+// refreshGCPToken fetches a new GCP access token if the cached one is
+// missing or about to expire, and hands it to git as a bearer token.
+func (s *Syncer) refreshGCPToken(ctx context.Context) error {
+	if s.gcpToken.valid() {
+		return nil
+	}
+
+	s.logger(ctx).V(1).Infof("fetching GCP access token for service account %s", s.opts.GCPServiceAccount)
+	t, err := s.fetchGCPToken(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.setupGitBearerToken(ctx, t.value, s.opts.Repo); err != nil {
+		return err
+	}
+	s.gcpToken = t
+	return nil
+}
+
+// fetchAzureToken gets an Azure DevOps access token for the VM or pod's
+// managed identity from the Azure instance metadata service.
+func (s *Syncer) fetchAzureToken(ctx context.Context) (cachedToken, error) {
+	q := url.Values{}
+	q.Set("api-version", "2018-02-01")
+	q.Set("resource", azureDevOpsResource)
+	if s.opts.AzureManagedIdentityClientID != "" {
+		q.Set("client_id", s.opts.AzureManagedIdentityClientID)
+	}
+	header := http.Header{}
+	header.Set("Metadata", "true")
+	return getMetadataToken(ctx, azureIMDSTokenURL+"?"+q.Encode(), header)
+}
+
+// refreshAzureToken fetches a new managed identity token if the cached one
+// is missing or about to expire, and hands it to git as a bearer token.
+func (s *Syncer) refreshAzureToken(ctx context.Context) error {
+	if s.azureToken.valid() {
+		return nil
+	}
+
+	s.logger(ctx).V(1).Infof("fetching Azure managed identity token")
+	t, err := s.fetchAzureToken(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.setupGitBearerToken(ctx, t.value, s.opts.Repo); err != nil {
+		return err
+	}
+	s.azureToken = t
+	return nil
+}
+
+// extraHeaderKey returns the git config key for HTTP headers sent to the
+// host of gitURL only.
+func extraHeaderKey(gitURL string) (string, error) {
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return "", fmt.Errorf("can't parse repo URL: %v", err)
+	}
+	return fmt.Sprintf("http.%s://%s/.extraHeader", u.Scheme, u.Host), nil
+}
+
+// setupGitBearerToken configures git to send token as a bearer token on
+// this Syncer's requests to the host of gitURL, replacing any token set
+// previously.  It is passed in the environment by setGitConfig, which
+// doesn't log it.
+func (s *Syncer) setupGitBearerToken(ctx context.Context, token, gitURL string) error {
+	key, err := extraHeaderKey(gitURL)
+	if err != nil {
+		return err
+	}
+	s.setGitConfig(key, "Authorization: Bearer "+token)
+	return nil
+}
@@ -0,0 +1,120 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// setGitConfig sets a git config variable for the commands we run, through
+// $GIT_CONFIG_COUNT and friends (git 2.31 or later), rather than "git
+// config --global", so that it applies to this Syncer's commands only.
+// Setting a variable again replaces its value.
+func (s *Syncer) setGitConfig(key, value string) {
+	n, _ := strconv.Atoi(s.env["GIT_CONFIG_COUNT"])
+	for i := 0; i < n; i++ {
+		if s.env[fmt.Sprintf("GIT_CONFIG_KEY_%d", i)] == key {
+			s.setEnv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i), value)
+			return
+		}
+	}
+	s.addGitConfig(key, value)
+}
+
+// addGitConfig adds a value to a multi-valued git config variable for the
+// commands we run, like setGitConfig.
+func (s *Syncer) addGitConfig(key, value string) {
+	n, _ := strconv.Atoi(s.env["GIT_CONFIG_COUNT"])
+	s.setEnv(fmt.Sprintf("GIT_CONFIG_KEY_%d", n), key)
+	s.setEnv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", n), value)
+	s.setEnv("GIT_CONFIG_COUNT", strconv.Itoa(n+1))
+}
+
+// parseGitVersion returns the major and minor version of git in the output
+// of "git --version", e.g. "git version 2.34.1".
+func parseGitVersion(output string) (int, int, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(output), "git version ")
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("can't parse git version %q", output)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse git version %q", output)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse git version %q", output)
+	}
+	return major, minor, nil
+}
+
+// checkGitVersion returns an error if an option is set that needs a newer
+// git than the one whose "git --version" is output.  Older versions ignore
+// the config that setGitConfig passes in the environment, so the option
+// would silently do nothing.
+func (s *Syncer) checkGitVersion(output string) error {
+	major, minor, err := parseGitVersion(output)
+	if err != nil {
+		return err
+	}
+	needs := []struct {
+		set          bool
+		flag         string
+		major, minor int
+	}{
+		{len(s.opts.HTTPHeaders) > 0, "--http-header", 2, 31},
+		{s.opts.HTTPLowSpeedLimit > 0, "--http-low-speed-limit", 2, 31},
+		{s.opts.PackThreads > 0, "--pack-threads", 2, 31},
+		{s.opts.IndexThreads > 0, "--index-threads", 2, 31},
+		{s.opts.CompressionLevel != nil, "--compression-level", 2, 31},
+		{s.opts.CheckoutWorkers > 0, "--checkout-workers", 2, 32},
+		{s.opts.CredentialHelper != "", "--credential-helper", 2, 31},
+		{s.opts.OAuth2TokenURL != "", "--oauth2-token-url", 2, 31},
+		{s.opts.STSURL != "", "--sts-url", 2, 31},
+		{s.opts.GCPMetadataToken, "--gcp-metadata-token", 2, 31},
+		{s.opts.AzureManagedIdentity, "--azure-managed-identity", 2, 31},
+	}
+	for _, n := range needs {
+		if n.set && (major < n.major || major == n.major && minor < n.minor) {
+			return fmt.Errorf("%s needs git %d.%d or later, but %s is installed", n.flag, n.major, n.minor, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// setupGitTuning sets how many workers git checks out files with, per
+// Options.CheckoutWorkers, and limits the threads and CPU that git spends
+// packing, compressing and indexing, per Options.PackThreads,
+// Options.IndexThreads and Options.CompressionLevel.  By default git starts
+// a thread per CPU of the node, not of the container, and gets throttled
+// or OOM-killed.
+func (s *Syncer) setupGitTuning(ctx context.Context) error {
+	if s.opts.CheckoutWorkers > 0 {
+		// Checking out hundreds of thousands of files is mostly waiting for
+		// the filesystem, which parallel workers overlap.
+		s.setGitConfig("checkout.workers", strconv.Itoa(s.opts.CheckoutWorkers))
+		if s.opts.CheckoutParallelThreshold > 0 {
+			s.setGitConfig("checkout.thresholdForParallelism", strconv.Itoa(s.opts.CheckoutParallelThreshold))
+		}
+		s.logger(ctx).V(1).Infof("checking out with %d workers", s.opts.CheckoutWorkers)
+	}
+	if s.opts.CompressionLevel != nil {
+		// Passed as is: git takes 0 for none and -1 for zlib's default.
+		s.setGitConfig("core.compression", strconv.Itoa(*s.opts.CompressionLevel))
+		s.logger(ctx).V(1).Infof("set git's compression level to %d", *s.opts.CompressionLevel)
+	}
+	if s.opts.PackThreads == 0 && s.opts.IndexThreads == 0 {
+		return nil
+	}
+	if s.opts.PackThreads > 0 {
+		s.setGitConfig("pack.threads", strconv.Itoa(s.opts.PackThreads))
+	}
+	if s.opts.IndexThreads > 0 {
+		s.setGitConfig("index.threads", strconv.Itoa(s.opts.IndexThreads))
+	}
+	s.logger(ctx).V(1).Infof("limited git to %d pack threads and %d index threads (0 for git's defaults)",
+		s.opts.PackThreads, s.opts.IndexThreads)
+	return nil
+}
@@ -0,0 +1,67 @@
+package gitsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSetupGitTuning(t *testing.T) {
+	ctx := context.Background()
+	none := 0
+	s := &Syncer{opts: Options{PackThreads: 2, CompressionLevel: &none, CheckoutWorkers: 4}, env: map[string]string{}}
+	if err := s.setupGitTuning(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Setting a variable again must replace it rather than add another.
+	s.setGitConfig("pack.threads", "1")
+
+	cases := map[string]string{
+		"pack.threads":     "1",
+		"core.compression": "0",
+		"checkout.workers": "4",
+	}
+	for key, exp := range cases {
+		out, err := s.runCommand(ctx, "", "git", "config", "--get-all", key)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", key, err)
+		}
+		if got := strings.TrimSpace(out); got != exp {
+			t.Fatalf("%s: expected %q but %q returned", key, exp, got)
+		}
+	}
+	for _, key := range []string{"index.threads", "checkout.thresholdForParallelism"} {
+		if _, err := s.runCommand(ctx, "", "git", "config", "--get", key); err == nil {
+			t.Fatalf("expected %s to be unset", key)
+		}
+	}
+}
+
+func TestCheckGitVersion(t *testing.T) {
+	none := 0
+	cases := []struct {
+		opts    Options
+		version string
+		err     string
+	}{
+		{Options{}, "git version 2.8.6\n", ""},
+		{Options{HTTPHeaders: []string{"X-A: b"}}, "git version 2.8.6\n", "--http-header needs git 2.31 or later, but git version 2.8.6 is installed"},
+		{Options{HTTPHeaders: []string{"X-A: b"}}, "git version 2.31.0\n", ""},
+		{Options{CompressionLevel: &none}, "git version 2.30.9", "--compression-level needs git 2.31"},
+		{Options{CheckoutWorkers: 4}, "git version 2.31.1", "--checkout-workers needs git 2.32"},
+		{Options{CheckoutWorkers: 4}, "git version 2.39.2 (Apple Git-143)", ""},
+		{Options{PackThreads: 1}, "git version 3.0.0.windows.1", ""},
+		{Options{}, "not git", "can't parse git version"},
+	}
+
+	for _, testCase := range cases {
+		s := &Syncer{opts: testCase.opts}
+		err := s.checkGitVersion(testCase.version)
+		if testCase.err == "" && err != nil {
+			t.Fatalf("%q: unexpected error: %v", testCase.version, err)
+		}
+		if testCase.err != "" && (err == nil || !strings.Contains(err.Error(), testCase.err)) {
+			t.Fatalf("%q: expected error %q but got %v", testCase.version, testCase.err, err)
+		}
+	}
+}
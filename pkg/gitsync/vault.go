@@ -0,0 +1,202 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const vaultTimeout = 10 * time.Second
+
+// vaultAuth is the "auth" block of a Vault login or renew response.
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// vaultResponse is the subset of Vault API responses that we use.
+type vaultResponse struct {
+	Auth *vaultAuth `json:"auth"`
+	// Data holds the secret for KV v1, or the secret and its metadata (as
+	// "data" and "metadata") for KV v2.
+	Data          map[string]interface{} `json:"data"`
+	LeaseDuration int64                  `json:"lease_duration"`
+	Errors        []string               `json:"errors"`
+}
+
+// vaultRequest calls the Vault HTTP API at path, with body (if not nil)
+// encoded as JSON.
+func (s *Syncer) vaultRequest(ctx context.Context, method, path, token string, body interface{}) (*vaultResponse, error) {
+	var reqBody []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+	}
+
+	u := strings.TrimRight(s.opts.VaultAddr, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequest(method, u, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	client := &http.Client{Timeout: vaultTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling Vault: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Vault response: %v", err)
+	}
+	var vr vaultResponse
+	if len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, &vr); err != nil {
+			return nil, fmt.Errorf("error decoding Vault response: %v", err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault returned status %d for %s: %v", resp.StatusCode, path, vr.Errors)
+	}
+	return &vr, nil
+}
+
+// setVaultToken caches the client token from a login or renew response.
+func (s *Syncer) setVaultToken(auth *vaultAuth) error {
+	if auth == nil || auth.ClientToken == "" {
+		return fmt.Errorf("Vault response has no client token")
+	}
+	t := cachedToken{value: auth.ClientToken}
+	if auth.LeaseDuration > 0 {
+		t.expiry = time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second)
+	}
+	s.vaultToken = t
+	s.vaultTokenRenewable = auth.Renewable
+	return nil
+}
+
+// refreshVaultToken makes sure the Vault token is usable, renewing it if
+// possible and otherwise logging in with the pod's service account token.
+func (s *Syncer) refreshVaultToken(ctx context.Context) error {
+	if s.vaultToken.valid() {
+		return nil
+	}
+
+	if s.vaultToken.value != "" && s.vaultTokenRenewable {
+		s.logger(ctx).V(1).Infof("renewing Vault token")
+		resp, err := s.vaultRequest(ctx, "POST", "auth/token/renew-self", s.vaultToken.value, nil)
+		if err == nil {
+			return s.setVaultToken(resp.Auth)
+		}
+		s.logger(ctx).Errorf("can't renew Vault token, logging in again: %v", err)
+	}
+
+	s.logger(ctx).V(1).Infof("logging in to Vault as role %s", s.opts.VaultRole)
+	jwt, err := ioutil.ReadFile(s.opts.VaultSATokenFile)
+	if err != nil {
+		return fmt.Errorf("error reading service account token: %v", err)
+	}
+	login := map[string]string{
+		"role": s.opts.VaultRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}
+	resp, err := s.vaultRequest(ctx, "POST", "auth/"+s.opts.VaultAuthMount+"/login", "", login)
+	if err != nil {
+		return err
+	}
+	return s.setVaultToken(resp.Auth)
+}
+
+// readVaultSecret reads the git credentials secret, unwrapping KV v2
+// responses, and returns its string values.
+func (s *Syncer) readVaultSecret(ctx context.Context) (map[string]string, error) {
+	resp, err := s.vaultRequest(ctx, "GET", s.opts.VaultSecretPath, s.vaultToken.value, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data := resp.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	secret := map[string]string{}
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			secret[k] = s
+		}
+	}
+	return secret, nil
+}
+
+// vaultSecretChanged returns true if the git credentials in secret differ
+// from those last applied.
+func (s *Syncer) vaultSecretChanged(secret map[string]string) bool {
+	for _, k := range []string{"username", "password", "ssh"} {
+		if secret[k] != s.vaultSecretData[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshVaultCredentials re-reads the git credentials from Vault once
+// --vault-refresh has passed, and reconfigures git if they have rotated.
+// The secret may hold "username" and "password" for HTTPS, and/or "ssh" for
+// an SSH private key.
+func (s *Syncer) refreshVaultCredentials(ctx context.Context) error {
+	if s.vaultSecretData != nil && time.Since(s.vaultSecretRead) < waitTime(s.opts.VaultRefresh) {
+		return nil
+	}
+
+	if err := s.refreshVaultToken(ctx); err != nil {
+		return err
+	}
+	secret, err := s.readVaultSecret(ctx)
+	if err != nil {
+		return err
+	}
+	s.vaultSecretRead = time.Now()
+	if !s.vaultSecretChanged(secret) {
+		s.logger(ctx).V(2).Infof("git credentials in Vault are unchanged")
+		return nil
+	}
+
+	s.logger(ctx).V(0).Infof("applying git credentials from Vault %s", s.opts.VaultSecretPath)
+	if secret["username"] != "" && secret["password"] != "" {
+		if err := s.storeGitCredentials(ctx, secret["username"], secret["password"]); err != nil {
+			return err
+		}
+	}
+	if secret["ssh"] != "" {
+		keyPath := filepath.Join(os.TempDir(), "git-sync-vault-ssh")
+		// Replace rather than overwrite, since the key file is read-only.
+		os.Remove(keyPath)
+		if err := ioutil.WriteFile(keyPath, []byte(strings.TrimSpace(secret["ssh"])+"\n"), 0400); err != nil {
+			return fmt.Errorf("error writing SSH key from Vault: %v", err)
+		}
+		if err := s.setupGitSSH(ctx, []string{keyPath}); err != nil {
+			return err
+		}
+	}
+	s.vaultSecretData = secret
+	return nil
+}
@@ -0,0 +1,129 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Check is the result of one of Verify's checks.
+type Check struct {
+	Name string
+	// Detail describes what was found, if the check passed.
+	Detail string
+	Err    error
+}
+
+// hashRE matches what could be an abbreviated commit hash.
+var hashRE = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// fullHashRE matches a full SHA-1 or SHA-256 commit hash.
+var fullHashRE = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// Verify checks that a sync could work, without doing one: that the
+// credentials can be obtained, that the remote can be reached with them,
+// that Options.Rev exists and that Options.Root can be written to.  A
+// SourceProvider other than git, or an Options.RefResolver, is checked by
+// resolving the rev.
+func (s *Syncer) Verify(ctx context.Context) []Check {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTime(s.opts.Timeout))
+		defer cancel()
+	}
+	ctx = withLogFields(ctx, "phase", "verify")
+
+	checks := []Check{}
+	detail, err := s.checkRootWritable()
+	checks = append(checks, Check{Name: "root", Detail: detail, Err: err})
+
+	err = s.refreshCredentials(ctx)
+	checks = append(checks, Check{Name: "credentials", Err: err})
+	if err != nil {
+		return checks
+	}
+
+	if _, ok := s.source.(*gitSource); !ok {
+		hash, err := s.resolveRev(ctx)
+		return append(checks, Check{Name: "rev", Detail: hash, Err: err})
+	}
+
+	refs, err := s.lsRemote(ctx)
+	checks = append(checks, Check{Name: "remote", Detail: fmt.Sprintf("%d matching refs", len(refs)), Err: err})
+	if err != nil {
+		return checks
+	}
+	if s.opts.RefResolver != nil {
+		detail, err = s.resolveRev(ctx)
+	} else {
+		detail, err = checkRev(refs, s.opts.Branch, s.opts.Rev)
+	}
+	return append(checks, Check{Name: "rev", Detail: detail, Err: err})
+}
+
+// checkRootWritable checks that Options.Root, or the nearest existing
+// directory above it, can be written to.
+func (s *Syncer) checkRootWritable() (string, error) {
+	dir := filepath.Clean(s.opts.Root)
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return "", fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return "", err
+		}
+		dir = filepath.Dir(dir)
+	}
+	f, err := ioutil.TempFile(dir, ".git-sync-verify-")
+	if err != nil {
+		return "", fmt.Errorf("can't write to %s: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return dir + " is writable", nil
+}
+
+// lsRemote lists the refs of the remote that Options.Branch and
+// Options.Rev could refer to, as a map of ref to hash.  It works without a
+// clone.
+func (s *Syncer) lsRemote(ctx context.Context) (map[string]string, error) {
+	args := []string{"ls-remote", s.opts.Repo, "refs/heads/" + s.opts.Branch}
+	if s.opts.Rev != "HEAD" {
+		args = append(args, "refs/tags/"+s.opts.Rev, "refs/tags/"+s.opts.Rev+"^{}")
+	}
+	output, err := s.runCommand(ctx, "", "git", args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseLsRemote(output), nil
+}
+
+// checkRev checks that refs, as returned by lsRemote, have branch and rev.
+func checkRev(refs map[string]string, branch, rev string) (string, error) {
+	branchHash, found := refs["refs/heads/"+branch]
+	if !found {
+		return "", fmt.Errorf("branch %q not found", branch)
+	}
+	if rev == "HEAD" {
+		return fmt.Sprintf("branch %s is at %s", branch, branchHash), nil
+	}
+	if hash, found := refs["refs/tags/"+rev+"^{}"]; found {
+		return fmt.Sprintf("tag %s is at %s", rev, hash), nil
+	}
+	if hash, found := refs["refs/tags/"+rev]; found {
+		return fmt.Sprintf("tag %s is at %s", rev, hash), nil
+	}
+	if hashRE.MatchString(rev) {
+		// Remotes don't list commits, so this can't be checked without
+		// fetching.
+		return fmt.Sprintf("%s is not a tag, so assumed to be a commit on %s", rev, branch), nil
+	}
+	return "", fmt.Errorf("tag %q not found", rev)
+}
@@ -0,0 +1,38 @@
+package gitsync
+
+import (
+	"testing"
+)
+
+func TestCheckRev(t *testing.T) {
+	refs := map[string]string{
+		"refs/heads/master": "aaa",
+		"refs/tags/v1.0":    "bbb",
+		"refs/tags/v1.0^{}": "ccc",
+		"refs/tags/light":   "ddd",
+	}
+
+	cases := []struct {
+		branch string
+		rev    string
+		detail string
+		err    bool
+	}{
+		{"master", "HEAD", "branch master is at aaa", false},
+		{"main", "HEAD", "", true},
+		{"master", "v1.0", "tag v1.0 is at ccc", false},
+		{"master", "light", "tag light is at ddd", false},
+		{"master", "v2.0", "", true},
+		{"master", "abc123", "abc123 is not a tag, so assumed to be a commit on master", false},
+	}
+
+	for _, testCase := range cases {
+		detail, err := checkRev(refs, testCase.branch, testCase.rev)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s %s: expected error %v but got %v", testCase.branch, testCase.rev, testCase.err, err)
+		}
+		if detail != testCase.detail {
+			t.Fatalf("%s %s: expected %q but %q returned", testCase.branch, testCase.rev, testCase.detail, detail)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// webhookPublisher calls a URL with every Event, so that e.g. a CD system
+// is told about changes.
+type webhookPublisher struct {
+	// kind is "webhook" or "reload", for metrics.
+	kind    string
+	url     string
+	method  string
+	timeout time.Duration
+	// body renders the request body from the Event; if nil, the body is the
+	// Event as JSON, unless noBody is set.
+	body   *template.Template
+	noBody bool
+	// successCodes are the statuses of a successful call; if empty, any 2xx
+	// status is.
+	successCodes []int
+	retries      int
+	backoff      time.Duration
+
+	mu sync.Mutex
+	// failures counts the Events that couldn't be delivered.
+	failures int
+}
+
+// webhookFuncs are available to webhook body templates.
+var webhookFuncs = template.FuncMap{
+	// json encodes a value, e.g. to quote a string.
+	"json": func(v interface{}) (string, error) {
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	},
+}
+
+// newWebhookPublisher returns a publisher calling o.WebhookURL.
+func newWebhookPublisher(o Options) (*webhookPublisher, error) {
+	u, err := url.Parse(o.WebhookURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPURL(o.WebhookURL) || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", o.WebhookURL)
+	}
+	method := strings.ToUpper(o.WebhookMethod)
+	switch method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return nil, fmt.Errorf("invalid method %q: must be POST, PUT or PATCH", o.WebhookMethod)
+	}
+	if o.WebhookTimeout < 0 || o.WebhookRetries < 0 || o.WebhookBackoff < 0 {
+		return nil, fmt.Errorf("timeout, retries and backoff can't be negative")
+	}
+	for _, code := range o.WebhookSuccessCodes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid success code %d", code)
+		}
+	}
+	p := &webhookPublisher{
+		kind:         "webhook",
+		url:          o.WebhookURL,
+		method:       method,
+		timeout:      waitTime(o.WebhookTimeout),
+		successCodes: o.WebhookSuccessCodes,
+		retries:      o.WebhookRetries,
+		backoff:      waitTime(o.WebhookBackoff),
+	}
+	if o.WebhookTimeout == 0 {
+		p.timeout = eventTimeout
+	}
+	if o.WebhookTemplate != "" {
+		p.body, err = template.New("webhook").Funcs(webhookFuncs).Option("missingkey=error").Parse(o.WebhookTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// newReloadPublisher returns a publisher calling o.ReloadURL, with no body,
+// e.g. to make a server reload its config.
+func newReloadPublisher(o Options) (*webhookPublisher, error) {
+	u, err := url.Parse(o.ReloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPURL(o.ReloadURL) || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", o.ReloadURL)
+	}
+	method := strings.ToUpper(o.ReloadMethod)
+	if method != "GET" && method != "POST" {
+		return nil, fmt.Errorf("invalid method %q: must be GET or POST", o.ReloadMethod)
+	}
+	if o.ReloadTimeout < 0 {
+		return nil, fmt.Errorf("timeout can't be negative")
+	}
+	for _, code := range o.ReloadSuccessCodes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid success code %d", code)
+		}
+	}
+	p := &webhookPublisher{
+		kind:         "reload",
+		url:          o.ReloadURL,
+		method:       method,
+		timeout:      waitTime(o.ReloadTimeout),
+		noBody:       true,
+		successCodes: o.ReloadSuccessCodes,
+	}
+	if o.ReloadTimeout == 0 {
+		p.timeout = eventTimeout
+	}
+	return p, nil
+}
+
+// PublishEvent calls the webhook with e, retrying failed calls after
+// p.backoff, doubled every time, up to p.retries times.
+func (p *webhookPublisher) PublishEvent(ctx context.Context, e Event) error {
+	body, err := p.render(e)
+	if err != nil {
+		p.countFailure()
+		return err
+	}
+
+	backoff := p.backoff
+	for retries := 0; ; retries++ {
+		err := p.call(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if retries >= p.retries || ctx.Err() != nil {
+			p.countFailure()
+			if retries > 0 {
+				return fmt.Errorf("%v, after %d retries", err, retries)
+			}
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// render returns the request body for e.
+func (p *webhookPublisher) render(e Event) ([]byte, error) {
+	if p.noBody {
+		return nil, nil
+	}
+	if p.body == nil {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding event: %v", err)
+		}
+		return data, nil
+	}
+	buf := &bytes.Buffer{}
+	if err := p.body.Execute(buf, e); err != nil {
+		return nil, fmt.Errorf("error rendering webhook body: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// call calls the webhook once, with body.
+func (p *webhookPublisher) call(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(p.method, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if !p.noBody {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: p.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %s URL: %v", p.kind, err)
+	}
+	defer resp.Body.Close()
+
+	if !p.isSuccess(resp.StatusCode) {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s URL returned status %d: %q", p.kind, resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// isSuccess returns true if code is the status of a successful call.
+func (p *webhookPublisher) isSuccess(code int) bool {
+	if len(p.successCodes) == 0 {
+		return code >= 200 && code <= 299
+	}
+	for _, c := range p.successCodes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *webhookPublisher) countFailure() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures++
+}
+
+// deliveryFailures returns how many Events couldn't be delivered.
+func (p *webhookPublisher) deliveryFailures() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failures
+}
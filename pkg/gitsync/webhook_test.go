@@ -0,0 +1,146 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookPublisher(t *testing.T) {
+	cases := []struct {
+		method       string
+		template     string
+		successCodes []int
+		retries      int
+		statuses     []int
+		body         string
+		calls        int
+		err          bool
+	}{
+		{"post", "", nil, 0, []int{http.StatusOK}, `{"name":"a","repo":"https://a/a","rev":"HEAD","oldHash":"abc","newHash":"def","paths":["x"],"time":"0001-01-01T00:00:00Z"}`, 1, false},
+		{"PUT", `{"text": {{json (printf "%s: %s -> %s" .Name .OldHash .NewHash)}}, "files": {{json .Paths}}}`, nil, 0, []int{http.StatusNoContent}, `{"text": "a: abc -> def", "files": ["x"]}`, 1, false},
+		{"POST", "", nil, 0, []int{http.StatusInternalServerError}, "", 1, true},
+		{"POST", "", nil, 2, []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, "", 3, false},
+		{"POST", "", nil, 1, []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, "", 2, true},
+		{"POST", "", []int{http.StatusAccepted}, 0, []int{http.StatusOK}, "", 1, true},
+		{"POST", "", []int{http.StatusOK, http.StatusFound}, 0, []int{http.StatusFound}, "", 1, false},
+		{"POST", "{{.Nope}}", nil, 0, []int{http.StatusOK}, "", 0, true},
+	}
+
+	for i, testCase := range cases {
+		var method, contentType, body string
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, contentType = r.Method, r.Header.Get("Content-Type")
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+			w.WriteHeader(testCase.statuses[calls])
+			calls++
+		}))
+
+		p, err := newWebhookPublisher(Options{
+			WebhookURL:          server.URL + "/hook",
+			WebhookMethod:       testCase.method,
+			WebhookTemplate:     testCase.template,
+			WebhookSuccessCodes: testCase.successCodes,
+			WebhookRetries:      testCase.retries,
+			WebhookBackoff:      0.001,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.PublishEvent(context.Background(), Event{Name: "a", Repo: "https://a/a", Rev: "HEAD", OldHash: "abc", NewHash: "def", Paths: []string{"x"}})
+		server.Close()
+		if (err != nil) != testCase.err {
+			t.Fatalf("case %d: expected error %v but got %v", i, testCase.err, err)
+		}
+		if calls != testCase.calls {
+			t.Fatalf("case %d: expected %d calls but %d made", i, testCase.calls, calls)
+		}
+		expectedFailures := 0
+		if testCase.err {
+			expectedFailures = 1
+		}
+		if failures := p.deliveryFailures(); failures != expectedFailures {
+			t.Fatalf("case %d: expected %d delivery failures but %d counted", i, expectedFailures, failures)
+		}
+		if testCase.err {
+			continue
+		}
+		if method != p.method || contentType != "application/json" {
+			t.Fatalf("case %d: unexpected %s request with %s", i, method, contentType)
+		}
+		if testCase.body != "" && body != testCase.body {
+			t.Fatalf("case %d: expected body %s but %s sent", i, testCase.body, body)
+		}
+	}
+}
+
+func TestNewWebhookPublisher(t *testing.T) {
+	cases := []struct {
+		opts Options
+		err  bool
+	}{
+		{Options{WebhookURL: "https://ci.example.com/hook", WebhookMethod: "POST"}, false},
+		{Options{WebhookURL: "ci.example.com/hook", WebhookMethod: "POST"}, true},
+		{Options{WebhookURL: "https://ci.example.com/hook", WebhookMethod: "GET"}, true},
+		{Options{WebhookURL: "https://ci.example.com/hook", WebhookMethod: "POST", WebhookTimeout: -1}, true},
+		{Options{WebhookURL: "https://ci.example.com/hook", WebhookMethod: "POST", WebhookRetries: -1}, true},
+		{Options{WebhookURL: "https://ci.example.com/hook", WebhookMethod: "POST", WebhookSuccessCodes: []int{200, 202}}, false},
+		{Options{WebhookURL: "https://ci.example.com/hook", WebhookMethod: "POST", WebhookSuccessCodes: []int{2}}, true},
+		{Options{WebhookURL: "https://ci.example.com/hook", WebhookMethod: "POST", WebhookTemplate: "{{.Name"}, true},
+	}
+
+	for _, testCase := range cases {
+		_, err := newWebhookPublisher(testCase.opts)
+		if (err != nil) != testCase.err {
+			t.Fatalf("%+v: expected error %v but got %v", testCase.opts, testCase.err, err)
+		}
+	}
+}
+
+func TestReloadPublisher(t *testing.T) {
+	cases := []struct {
+		method       string
+		successCodes []int
+		status       int
+		err          bool
+	}{
+		{"GET", nil, http.StatusOK, false},
+		{"post", nil, http.StatusNoContent, false},
+		{"POST", nil, http.StatusServiceUnavailable, true},
+		{"POST", []int{http.StatusAccepted}, http.StatusOK, true},
+	}
+
+	for _, testCase := range cases {
+		var method, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+			w.WriteHeader(testCase.status)
+		}))
+
+		p, err := newReloadPublisher(Options{ReloadURL: server.URL + "/-/reload", ReloadMethod: testCase.method, ReloadSuccessCodes: testCase.successCodes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.PublishEvent(context.Background(), Event{Name: "a", NewHash: "def"})
+		server.Close()
+		if (err != nil) != testCase.err {
+			t.Fatalf("%s %d: expected error %v but got %v", testCase.method, testCase.status, testCase.err, err)
+		}
+		if method != p.method || body != "" {
+			t.Fatalf("%s %d: unexpected %s request with body %q", testCase.method, testCase.status, method, body)
+		}
+		if failures := p.deliveryFailures(); (failures == 1) != testCase.err {
+			t.Fatalf("%s %d: unexpected %d failures", testCase.method, testCase.status, failures)
+		}
+	}
+
+	if _, err := newReloadPublisher(Options{ReloadURL: "http://localhost/-/reload", ReloadMethod: "PUT"}); err == nil {
+		t.Fatalf("expected an error for method PUT")
+	}
+}
@@ -0,0 +1,119 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// reconcileWorktrees makes git's registry of worktrees, in .git/worktrees,
+// match the worktrees in Options.Root, once, before the first sync.  A crash
+// between adding a worktree and publishing it leaves a registration without
+// a worktree, for which git refuses to add the worktree again, and a lost
+// registration leaves a worktree in which git commands fail.  Worktrees are
+// pointed to their registrations, which are re-created if missing, and then
+// registrations without worktrees are pruned.
+func (s *Syncer) reconcileWorktrees(ctx context.Context) error {
+	g, ok := s.source.(*gitSource)
+	if !ok || s.worktreesReconciled {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(s.opts.Root, ".git")); os.IsNotExist(err) {
+		// The sync clones it afresh.
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(s.opts.Root)
+	if err != nil {
+		return fmt.Errorf("error listing %s: %v", s.opts.Root, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == ".git" {
+			continue
+		}
+		dir := filepath.Join(s.opts.Root, e.Name())
+		name := worktreeName(dir)
+		if name == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.opts.Root, ".git", "worktrees", name)); err == nil {
+			// Its path is recorded again, in case Root moved.
+			if err := g.Moved(ctx, dir); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := g.register(ctx, name, dir); err != nil {
+			s.logger(ctx).V(0).Infof("WARNING: can't register worktree %s: %v", dir, err)
+			continue
+		}
+		s.logger(ctx).V(0).Infof("registered worktree %s again", dir)
+	}
+
+	output, err := s.runCommand(ctx, s.opts.Root, "git", "worktree", "prune", "--verbose")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line != "" {
+			s.logger(ctx).V(0).Infof("pruned dangling worktree: %s", line)
+		}
+	}
+	s.worktreesReconciled = true
+	return nil
+}
+
+// worktreeName returns the name under .git/worktrees of the worktree at
+// dir, as written by Materialize, or "" if dir is not a worktree.
+func worktreeName(dir string) string {
+	ref, err := ioutil.ReadFile(filepath.Join(dir, ".git"))
+	if err != nil {
+		return ""
+	}
+	gitdir := strings.TrimSpace(strings.TrimPrefix(string(ref), "gitdir:"))
+	if !strings.HasPrefix(gitdir, "../.git/worktrees/") {
+		return ""
+	}
+	return strings.TrimPrefix(gitdir, "../.git/worktrees/")
+}
+
+// register re-creates the lost registration name of the worktree at dir,
+// which is named after the revision checked out in it, and rebuilds its
+// index from that revision, leaving its files as they are.
+func (g *gitSource) register(ctx context.Context, name, dir string) error {
+	s := g.s
+	hash := strings.TrimPrefix(name, revDirPrefix)
+	if !fullHashRE.MatchString(hash) || !g.hasCommit(ctx, hash) {
+		return fmt.Errorf("%s doesn't name a revision in the clone", name)
+	}
+	abs, err := filepath.Abs(filepath.Join(dir, ".git"))
+	if err != nil {
+		return err
+	}
+	admin := filepath.Join(s.opts.Root, ".git", "worktrees", name)
+	if err := os.MkdirAll(admin, 0755); err != nil {
+		return err
+	}
+	for file, content := range map[string]string{
+		"gitdir":    abs,
+		"commondir": "../..",
+		"HEAD":      hash,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(admin, file), []byte(content+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	if len(s.opts.SparsePaths) > 0 {
+		if err := s.setupSparseCheckout(ctx, name); err != nil {
+			return err
+		}
+	}
+	if _, err := s.runCommand(ctx, dir, "git", "read-tree", hash); err != nil {
+		return err
+	}
+	_, err = s.runCommand(ctx, dir, "git", "update-index", "-q", "--refresh")
+	return err
+}
@@ -369,5 +369,103 @@ pkill git-sync
 wait
 pass
 
+# Test submodule syncing
+testcase "submodule-sync"
+SUBMODULE="$DIR/submodule"
+rm -rf "$SUBMODULE"
+git init -q "$SUBMODULE"
+echo "$TESTCASE 1" > "$SUBMODULE"/file
+git -C "$SUBMODULE" add file
+git -C "$SUBMODULE" commit -qam "$TESTCASE 1"
+git -C "$REPO" submodule -q add "$SUBMODULE" sub
+git -C "$REPO" commit -qm "$TESTCASE 1"
+GIT_SYNC \
+    --logtostderr \
+    --v=5 \
+    --wait=0.1 \
+    --repo="$REPO" \
+    --root="$ROOT" \
+    --dest="link" \
+    --submodules \
+    --submodule-jobs=2 > "$DIR"/log."$TESTCASE" 2>&1 &
+sleep 2
+assert_link_exists "$ROOT"/link
+assert_file_exists "$ROOT"/link/sub/file
+assert_file_eq "$ROOT"/link/sub/file "$TESTCASE 1"
+# Move the submodule forward
+echo "$TESTCASE 2" > "$SUBMODULE"/file
+git -C "$SUBMODULE" commit -qam "$TESTCASE 2"
+git -C "$REPO"/sub pull -q origin master
+git -C "$REPO" commit -qam "$TESTCASE 2"
+sleep 2
+assert_link_exists "$ROOT"/link
+assert_file_exists "$ROOT"/link/sub/file
+assert_file_eq "$ROOT"/link/sub/file "$TESTCASE 2"
+# Wrap up
+pkill git-sync
+wait
+pass
+
+# Test sparse syncing
+testcase "sparse-sync"
+mkdir -p "$REPO"/sparse-in "$REPO"/sparse-out
+echo "$TESTCASE" > "$REPO"/sparse-in/file
+echo "$TESTCASE" > "$REPO"/sparse-out/file
+git -C "$REPO" add sparse-in sparse-out
+git -C "$REPO" commit -qm "$TESTCASE"
+GIT_SYNC \
+    --logtostderr \
+    --v=5 \
+    --repo="$REPO" \
+    --root="$ROOT" \
+    --dest="link" \
+    --sparse-path=/sparse-in/ \
+    --one-time > "$DIR"/log."$TESTCASE" 2>&1
+assert_link_exists "$ROOT"/link
+assert_file_exists "$ROOT"/link/sparse-in/file
+assert_file_eq "$ROOT"/link/sparse-in/file "$TESTCASE"
+if [[ -e "$ROOT"/link/sparse-out || -e "$ROOT"/link/file ]]; then
+    fail "files outside of --sparse-path were checked out"
+fi
+# Wrap up
+pass
+
+# Test syncing through a cache
+testcase "cache-sync"
+CACHE="$DIR/cache"
+rm -rf "$CACHE"
+mkdir "$CACHE"
+echo "$TESTCASE 1" > "$REPO"/file
+git -C "$REPO" commit -qam "$TESTCASE 1"
+GIT_SYNC \
+    --logtostderr \
+    --v=5 \
+    --repo="$REPO" \
+    --root="$ROOT" \
+    --dest="link" \
+    --cache-dir="$CACHE" \
+    --one-time > "$DIR"/log."$TESTCASE" 2>&1
+assert_link_exists "$ROOT"/link
+assert_file_eq "$ROOT"/link/file "$TESTCASE 1"
+# Restart with an empty root, which is cloned from the cache
+clean_root
+echo "$TESTCASE 2" > "$REPO"/file
+git -C "$REPO" commit -qam "$TESTCASE 2"
+GIT_SYNC \
+    --logtostderr \
+    --v=5 \
+    --repo="$REPO" \
+    --root="$ROOT" \
+    --dest="link" \
+    --cache-dir="$CACHE" \
+    --one-time >> "$DIR"/log."$TESTCASE" 2>&1
+assert_link_exists "$ROOT"/link
+assert_file_eq "$ROOT"/link/file "$TESTCASE 2"
+if ! grep -q "reusing cache" "$DIR"/log."$TESTCASE"; then
+    fail "the cache was not reused"
+fi
+# Wrap up
+pass
+
 echo "cleaning up $DIR"
 rm -rf "$DIR"